@@ -1,168 +1,188 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"sync/atomic"
 
+	"github.com/adi/sketo/config"
 	"github.com/adi/sketo/db"
+	"github.com/adi/sketo/tracing"
 	"github.com/gorilla/mux"
-	"go.elastic.co/apm"
 )
 
 // Check If a Request is Allowed
-func allowed(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func allowed(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		CntAllowRequestsSinceStart++
+		atomic.AddInt64(&CntAllowRequestsSinceStart, 1)
+		params := mux.Vars(r)
+		flavor := params["flavor"]
 		if r.Header.Get("Content-Type") != "application/json" {
-			CntAllowFailuresSinceStart++
-			rw.WriteHeader(400)
-			rw.Write([]byte(fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only "application/json" is valid)`, r.Header.Get("Content-Type"))))
+			atomic.AddInt64(&CntAllowFailuresSinceStart, 1)
+			countDecision(flavor, outcomeError)
+			writeError(rw, r, 400, fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only "application/json" is valid)`, r.Header.Get("Content-Type")))
 			return
 		}
-		params := mux.Vars(r)
-		flavor := params["flavor"]
 		var body oryAccessControlPolicyAllowedInput
 		jsonDec := json.NewDecoder(r.Body)
 		err := jsonDec.Decode(&body)
 		if err != nil {
-			CntAllowFailuresSinceStart++
-			rw.WriteHeader(400)
-			rw.Write([]byte("Couldn't decode body"))
+			atomic.AddInt64(&CntAllowFailuresSinceStart, 1)
+			countDecision(flavor, outcomeError)
+			writeError(rw, r, 400, "Couldn't decode body")
 			return
 		}
 
-		if body.Subject == "" || body.Resource == "" || body.Action == "" {
-			rw.Header().Set("Content-Type", "application/json")
-			rw.WriteHeader(200)
-			jsonEnc := json.NewEncoder(rw)
-			err := jsonEnc.Encode(authorizationResult{
-				Allowed: false,
-			})
-			if err != nil {
-				CntAllowFailuresSinceStart++
-				if err != nil {
-					rw.WriteHeader(500)
-					rw.Write([]byte("Server error"))
-					return
-				}
-			}
-			CntAllowRefusedSinceStart++
+		if !inputComplete(body) {
+			writeDecision(rw, r, flavor, false, false)
 			return
 		}
 
-		if flavor == "exact" {
-			err = acpDB.List(policyBasePrefix(flavor), policyFilter(body.Subject, body.Resource, body.Action), 0, -1, func(keys []string, values [][]byte) error {
-				rw.Header().Set("Content-Type", "application/json")
-				rw.WriteHeader(200)
-				allowed := false
-				for _, value := range values {
-					var item oryAccessControlPolicy
-					err := json.Unmarshal(value, &item)
-					if err != nil {
-						return err
-					}
-					if item.Effect == "deny" {
-						allowed = false
-						break
-					} else if item.Effect == "allow" {
-						allowed = true
-					}
-				}
-				jsonEnc := json.NewEncoder(rw)
-				err := jsonEnc.Encode(authorizationResult{
-					Allowed: allowed || JustAllow,
-				})
-				tran := apm.TransactionFromContext(r.Context())
-				tran.Context.SetLabel("allowed_computed", allowed)
-				tran.Context.SetLabel("allowed_returned", allowed || JustAllow)
-				if err != nil {
-					CntAllowFailuresSinceStart++
-					return err
-				}
-				if allowed {
-					CntAllowAcceptedSinceStart++
-				} else {
-					CntAllowRefusedSinceStart++
-				}
-				return nil
-			})
-			if err != nil {
-				rw.WriteHeader(500)
-				rw.Write([]byte("Server error"))
-				return
-			}
+		allowed, err := evaluate(r.Context(), acpDB, flavor, body)
+		if err != nil {
+			log.Printf("Error checking ACPs: %v\n", err)
+			atomic.AddInt64(&CntAllowFailuresSinceStart, 1)
+			countDecision(flavor, outcomeError)
+			writeError(rw, r, 500, "")
+			return
+		}
 
-		} else {
-			rw.Header().Add("Content-Type", "application/json")
-			rw.WriteHeader(200)
+		writeDecision(rw, r, flavor, allowed, true)
+	}
+}
 
-			allowed := false
+// inputComplete tells whether a check names a subject, a resource and an
+// action; incomplete checks are always denied
+func inputComplete(input oryAccessControlPolicyAllowedInput) bool {
+	return input.Subject != "" && input.Resource != "" && input.Action != ""
+}
 
-			err = acpDB.Enumerate(policyBasePrefix(flavor), func(key string, value []byte) (bool, error) {
-				var err error
+// evaluate checks input against the policies of flavor; a matching deny
+// policy overrides any matching allow policy
+func evaluate(ctx context.Context, acpDB db.Store, flavor string, input oryAccessControlPolicyAllowedInput) (bool, error) {
+	allowed := false
+	err := matchPolicies(ctx, acpDB, flavor, input, func(policy oryAccessControlPolicy) bool {
+		if policy.Effect == "deny" {
+			allowed = false
+			return false
+		} else if policy.Effect == "allow" {
+			allowed = true
+		}
+		return true
+	})
+	if err != nil {
+		return false, err
+	}
+	return allowed, nil
+}
+
+// matchPolicies calls fn with the policies of flavor matching input until it
+// returns false
+func matchPolicies(ctx context.Context, acpDB db.Store, flavor string, input oryAccessControlPolicyAllowedInput, fn func(policy oryAccessControlPolicy) bool) error {
+	var err error
+	if flavor == "exact" {
+		_, span := tracing.StartSpan(ctx, "store.list")
+		err = listIndex(acpDB, policyBasePrefix(flavor), policyIndex(input.Subject, input.Resource, input.Action), 0, -1, func(keys []string, values [][]byte) error {
+			span.SetAttribute("candidates", len(values))
+			for _, value := range values {
 				var item oryAccessControlPolicy
-				err = json.Unmarshal(value, &item)
-				if err != nil {
-					return false, err
-				}
-				var include bool
-				include, err = matchesAny(flavor, item.Subjects, body.Subject)
+				err := json.Unmarshal(value, &item)
 				if err != nil {
-					return false, err
-				}
-				if include {
-					include, err = matchesAny(flavor, item.Resources, body.Resource)
-					if err != nil {
-						return false, err
-					}
-				}
-				if include {
-					include, err = matchesAny(flavor, item.Actions, body.Action)
-					if err != nil {
-						return false, err
-					}
+					return err
 				}
-				if include {
-					if item.Effect == "deny" {
-						allowed = false
-						return false, nil
-					} else if item.Effect == "allow" {
-						allowed = true
-					}
+				if !fn(item) {
+					break
 				}
-				return true, nil
-			})
-			if err != nil {
-				log.Printf("Error checking ACPs: %v\n", err)
-				rw.WriteHeader(500)
-				rw.Write([]byte("Server error\n"))
-				return
 			}
+			return nil
+		})
+		span.End()
 
-			jsonEnc := json.NewEncoder(rw)
-			err := jsonEnc.Encode(authorizationResult{
-				Allowed: allowed || JustAllow,
-			})
-			tran := apm.TransactionFromContext(r.Context())
-			tran.Context.SetLabel("allowed_computed", allowed)
-			tran.Context.SetLabel("allowed_returned", allowed || JustAllow)
+	} else {
+		// Policies are matched by pattern as they are read, until fn stops
+		_, span := tracing.StartSpan(ctx, "match")
+		candidates := 0
+		err = acpDB.Enumerate(policyBasePrefix(flavor), func(key string, value []byte) (bool, error) {
+			candidates++
+			var item oryAccessControlPolicy
+			err := json.Unmarshal(value, &item)
 			if err != nil {
-				CntAllowFailuresSinceStart++
-				if err != nil {
-					log.Printf("Error checking ACPs: %v\n", err)
-					rw.WriteHeader(500)
-					rw.Write([]byte("Server error\n"))
-					return
-				}
+				return false, err
 			}
-			if allowed {
-				CntAllowAcceptedSinceStart++
-			} else {
-				CntAllowRefusedSinceStart++
+			include, err := policyMatches(flavor, item, input.Subject, input.Resource, input.Action)
+			if err != nil {
+				return false, err
+			}
+			if include {
+				return fn(item), nil
 			}
+			return true, nil
+		})
+		span.SetAttribute("candidates", candidates)
+		span.End()
+		candidatePolicies.WithLabelValues(flavor).Observe(float64(candidates))
+	}
+	return err
+}
+
+// returnedDecision returns the outcome of a check to send, allowing everything
+// in monitor mode unless the check was incomplete
+func returnedDecision(ctx context.Context, allowed bool, complete bool) bool {
+	returned := allowed || (complete && config.Get().MonitorMode)
+	if complete {
+		tracing.SetRequestAttribute(ctx, "allowed_computed", allowed)
+		tracing.SetRequestAttribute(ctx, "allowed_returned", returned)
+	}
+	return returned
+}
 
+// checkInput runs a check of a valid flavor outside of the allowed endpoint,
+// counting its outcome or failure the same way
+func checkInput(ctx context.Context, acpDB db.Store, flavor string, input oryAccessControlPolicyAllowedInput) (bool, error) {
+	allowed := false
+	complete := inputComplete(input)
+	if complete {
+		var err error
+		allowed, err = evaluate(ctx, acpDB, flavor, input)
+		if err != nil {
+			atomic.AddInt64(&CntAllowFailuresSinceStart, 1)
+			countDecision(flavor, outcomeError)
+			return false, err
 		}
 	}
+	returned := returnedDecision(ctx, allowed, complete)
+	countOutcome(flavor, allowed)
+	return returned, nil
+}
+
+// countOutcome counts a check by its computed outcome
+func countOutcome(flavor string, allowed bool) {
+	if allowed {
+		atomic.AddInt64(&CntAllowAcceptedSinceStart, 1)
+		countDecision(flavor, outcomeAllowed)
+	} else {
+		atomic.AddInt64(&CntAllowRefusedSinceStart, 1)
+		countDecision(flavor, outcomeDenied)
+	}
+}
+
+// writeDecision sends the outcome of a check and counts it
+func writeDecision(rw http.ResponseWriter, r *http.Request, flavor string, allowed bool, complete bool) {
+	returned := returnedDecision(r.Context(), allowed, complete)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(200)
+	jsonEnc := json.NewEncoder(rw)
+	err := jsonEnc.Encode(authorizationResult{
+		Allowed: returned,
+	})
+	if err != nil {
+		log.Printf("Error writing check result: %v\n", err)
+		atomic.AddInt64(&CntAllowFailuresSinceStart, 1)
+		countDecision(flavor, outcomeError)
+		return
+	}
+	countOutcome(flavor, allowed)
 }
@@ -1,30 +1,22 @@
 package api
 
 import (
-	"encoding/json"
-	"log"
+	"fmt"
 	"net/http"
-	"os"
-	"path"
+	"strings"
 
+	"github.com/adi/sketo/config"
 	"github.com/adi/sketo/db"
+	"github.com/adi/sketo/tracing"
 	"github.com/gorilla/mux"
-	"go.elastic.co/apm"
-	"go.elastic.co/apm/module/apmgorilla"
 )
 
-// JustAllow ..
-var JustAllow bool
-
 // Fix ..
 func Fix() error {
-	storageDir := path.Join(".", "storage")
-	if envVar := os.Getenv("STORAGE_DIR"); envVar != "" {
-		storageDir = envVar
-	}
+	storage := config.Get().Storage
 
 	// Start ACP DB
-	acpDB, err := db.NewDB(storageDir)
+	acpDB, err := db.NewDBWithOptions(storage.Dir, storage.Badger.Options())
 	if err != nil {
 		return err
 	}
@@ -32,17 +24,42 @@ func Fix() error {
 	return acpDB.Fix()
 }
 
-// Init sets up the sketo API HTTP endpoints
-func Init(apiMux *mux.Router) error {
+// openStore opens the configured storage backend; badgerDB is only set when
+// using the badger backend
+func openStore(storage config.StorageConfig) (acpDB db.Store, badgerDB *db.DB, err error) {
+	switch storage.Backend {
+	case "", "badger":
+		badgerDB, err = db.NewDBWithOptions(storage.Dir, storage.Badger.Options())
+		if err != nil {
+			return nil, nil, err
+		}
+		acpDB = badgerDB
+	case "memory":
+		acpDB = db.NewMemStore()
+	case "postgres":
+		acpDB, err = db.NewPostgresStore(storage.DSN)
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("invalid storage backend '%s' (expected badger, memory or postgres)", storage.Backend)
+	}
+	return acpDB, badgerDB, nil
+}
 
-	// Load from ENV the location of the DB
-	storageDir := path.Join(".", "storage")
-	if envVar := os.Getenv("STORAGE_DIR"); envVar != "" {
-		storageDir = envVar
+// Init sets up the sketo API HTTP endpoints: checks and reads on publicMux,
+// reads, writes and administration on adminMux, which can be the same router
+func Init(publicMux *mux.Router, adminMux *mux.Router) error {
+
+	routers := []*mux.Router{publicMux}
+	if adminMux != publicMux {
+		routers = append(routers, adminMux)
 	}
 
-	// Start ACP DB
-	acpDB, err := db.NewDB(storageDir)
+	cfg := config.Get()
+
+	// Start ACP DB with the configured backend
+	acpDB, badgerDB, err := openStore(cfg.Storage)
 	if err != nil {
 		return err
 	}
@@ -52,133 +69,151 @@ func Init(apiMux *mux.Router) error {
 	if err != nil {
 		return err
 	}
+	initReconcile(acpDB, cfg.Counters.ReconcileInterval)
+
+	// Give every request an ID and answer unknown routes with Keto's errors
+	for _, router := range routers {
+		router.Use(requestIDs)
+		router.NotFoundHandler = requestIDs(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			writeError(rw, r, 404, "No route for "+r.URL.Path)
+		}))
+		router.MethodNotAllowedHandler = requestIDs(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			writeError(rw, r, 405, r.Method+" not allowed on "+r.URL.Path)
+		}))
+	}
 
-	// Instrument for APM
-	tracer, err := apm.NewTracer(apm.DefaultTracer.Service.Name, apm.DefaultTracer.Service.Version)
+	// Record request metrics
+	BadgerDB = badgerDB
+	acpStore = acpDB
+	for _, router := range routers {
+		router.Use(instrument)
+	}
+
+	// Trace requests with the configured backend. Streaming imports aren't
+	// traced: APM would capture their body and hide the connection's full duplex
+	// support
+	tracer, err := tracing.Init(cfg.Tracing.Backend)
 	if err != nil {
 		return err
 	}
-	tracer.SetCaptureBody(apm.CaptureBodyAll)
-	apiMux.Use(apmgorilla.Middleware(apmgorilla.WithTracer(tracer)))
+	for _, router := range routers {
+		router.Use(tracer.Middleware(func(r *http.Request) bool {
+			return strings.HasSuffix(r.URL.Path, "/import")
+		}))
+	}
 
-	// Add endpoint for deleting everything
-	apiMux.HandleFunc("/engines/acp/ory", func(rw http.ResponseWriter, r *http.Request) {
-		err := acpDB.DelEverything()
-		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error"))
-			return
-		}
-		err = ReloadCounters(acpDB)
-		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error"))
-			return
-		}
-	}).Methods("DELETE")
+	// Require credentials with the scope of each route when enabled
+	err = initAuth()
+	if err != nil {
+		return err
+	}
+	for _, router := range routers {
+		router.Use(authenticate)
+	}
 
-	// Add endpoint for deleting everything
-	apiMux.HandleFunc("/engines/acp/ory/exact/reindex", func(rw http.ResponseWriter, r *http.Request) {
-		flavor := "exact"
-		err := acpDB.DelByPrefix(policyBasePrefix(flavor) + "s/")
-		if err != nil {
-			log.Printf("Error deleting by prefix of ACP indexes: %v\n", err)
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
-			return
-		}
-		i := 0
-		err = acpDB.Enumerate(policyBasePrefix(flavor), func(key string, value []byte) (bool, error) {
-			i++
-			if i%10000 == 0 {
-				log.Printf("Reindexed %d\n", i)
-			}
-			var err error
-			var item oryAccessControlPolicy
-			err = json.Unmarshal(value, &item)
-			if err != nil {
-				return false, err
-			}
-			id := item.ID
-
-			// Save indexes to doc
-			suffixes := make([]string, 0)
-			for _, subject := range item.Subjects {
-				for _, resource := range item.Resources {
-					for _, action := range item.Actions {
-						suffixes = append(suffixes, policySuffix(subject, resource, action, id))
-					}
-					suffixes = append(suffixes, policySuffix(subject, resource, "", id))
-				}
-				for _, action := range item.Actions {
-					suffixes = append(suffixes, policySuffix(subject, "", action, id))
-				}
-				suffixes = append(suffixes, policySuffix(subject, "", "", id))
-			}
-			for _, resource := range item.Resources {
-				for _, action := range item.Actions {
-					suffixes = append(suffixes, policySuffix("", resource, action, id))
-				}
-				suffixes = append(suffixes, policySuffix("", resource, "", id))
-			}
-			for _, action := range item.Actions {
-				suffixes = append(suffixes, policySuffix("", "", action, id))
-			}
-			suffixes = append(suffixes, policySuffix("", "", "", id))
-			err = acpDB.RefMany(policyBasePrefix(flavor), suffixes)
-			if err != nil {
-				return false, err
-			}
-
-			return true, nil
-		})
-		if err != nil {
-			log.Printf("Error listing ACPs: %v\n", err)
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
-			return
-		}
-		log.Printf("Done reindexing\n")
+	// Reject requests not conforming to the OpenAPI document
+	openAPIValidators, err = loadOpenAPI()
+	if err != nil {
+		return err
+	}
+	for _, router := range routers {
+		router.Use(validateRequests)
+	}
+
+	// Map the requests Envoy's ext_authz filter asks about to checks
+	err = initExtAuthz()
+	if err != nil {
+		return err
+	}
+	publicMux.PathPrefix(extAuthzPrefix + "/").HandlerFunc(extAuthz(acpDB))
+
+	// Map the sub-requests of nginx auth_request and Traefik ForwardAuth to
+	// checks
+	err = initForwardAuth()
+	if err != nil {
+		return err
+	}
+	publicMux.HandleFunc(forwardAuthPath, forwardAuth(acpDB)).Methods("GET")
+
+	// Authorize the requests of Kubernetes API servers
+	publicMux.HandleFunc(subjectAccessReviewPath, reviewSubjectAccess(acpDB)).Methods("POST")
+
+	// Set up raft clustered mode
+	err = initCluster(adminMux, badgerDB, cfg.Storage.Dir, cfg.Cluster)
+	if err != nil {
+		return err
+	}
+
+	// Set up leader/follower replication
+	err = initReplication(adminMux, badgerDB, cfg.Replication)
+	if err != nil {
+		return err
+	}
+
+	// Set up badger maintenance endpoints
+	initStorageAdmin(adminMux, badgerDB)
 
-	}).Methods("POST")
+	// Add endpoint for wiping policies and roles, which keeps writes out
+	adminMux.HandleFunc(wipePath, wipe(acpDB)).Methods("DELETE")
+	adminMux.Use(holdWriteBarrier)
+
+	// Add endpoints for reindexing in background jobs
+	adminMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/reindex", reindex(acpDB)).Methods("POST")
+	adminMux.HandleFunc("/engines/acp/ory/jobs", listJobs).Methods("GET")
+	adminMux.HandleFunc("/engines/acp/ory/jobs/{id}", getJob).Methods("GET")
+	adminMux.HandleFunc("/engines/acp/ory/jobs/{id}", cancelJob).Methods("DELETE")
+
+	// Set up comparison against an upstream Keto
+	compare, err := initCompare(cfg.Compare)
+	if err != nil {
+		return err
+	}
+
+	// Export endpoints
+	publicMux.HandleFunc("/engines/acp/ory/export", export(acpDB)).Methods("GET")
+	publicMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/export", export(acpDB)).Methods("GET")
+
+	// Streaming import endpoints
+	adminMux.HandleFunc("/engines/acp/ory/import", importNDJSON(acpDB)).Methods("POST")
+	adminMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/import", importNDJSON(acpDB)).Methods("POST")
 
 	// Policies endpoints
-	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/allowed", allowed(acpDB)).Methods("POST")
-	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/policies", listOryAccessControlPolicies(acpDB)).Methods("GET")
-	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/policies", upsertOryAccessControlPolicy(acpDB)).Methods("PUT")
-	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/policies/batch", upsertOryAccessControlPolicies(acpDB)).Methods("PUT")
-	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/policies/{id}", getOryAccessControlPolicy(acpDB)).Methods("GET")
-	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/policies/{id}", deleteOryAccessControlPolicy(acpDB)).Methods("DELETE")
+	publicMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/allowed", compare(allowed(acpDB))).Methods("POST")
+	adminMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/policies", upsertOryAccessControlPolicy(acpDB)).Methods("PUT")
+	adminMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/policies/batch", upsertOryAccessControlPolicies(acpDB)).Methods("PUT")
+	adminMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/policies/{id}", deleteOryAccessControlPolicy(acpDB)).Methods("DELETE")
 
 	// Roles endpoints
-	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/roles", listOryAccessControlPolicyRoles(acpDB)).Methods("GET")
-	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/roles", upsertOryAccessControlPolicyRole(acpDB)).Methods("PUT")
-	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/roles/batch", upsertOryAccessControlPolicyRoles(acpDB)).Methods("PUT")
-	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/roles/{id}", getOryAccessControlPolicyRole(acpDB)).Methods("GET")
-	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/roles/{id}", deleteOryAccessControlPolicyRole(acpDB)).Methods("DELETE")
+	adminMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/roles", upsertOryAccessControlPolicyRole(acpDB)).Methods("PUT")
+	adminMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/roles/batch", upsertOryAccessControlPolicyRoles(acpDB)).Methods("PUT")
+	adminMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/roles/{id}", deleteOryAccessControlPolicyRole(acpDB)).Methods("DELETE")
+
+	// Policies and roles can be read from every router, so that writes made
+	// through the admin router can be checked on it
+	for _, router := range routers {
+		router.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/policies", listOryAccessControlPolicies(acpDB)).Methods("GET")
+		router.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/policies/{id}", getOryAccessControlPolicy(acpDB)).Methods("GET")
+		router.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/roles", listOryAccessControlPolicyRoles(acpDB)).Methods("GET")
+		router.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/roles/{id}", getOryAccessControlPolicyRole(acpDB)).Methods("GET")
+	}
 
 	// Member endpoints
-	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/roles/{id}/members", addMembersToAccessControlPolicyRole(acpDB)).Methods("PUT")
-	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/roles/{id}/members/{member}", removeMemberFromAccessControlPolicyRole(acpDB)).Methods("DELETE")
-
-	// Health endpoints
-	apiMux.HandleFunc("/health/alive", alive(acpDB)).Methods("GET")
-	apiMux.HandleFunc("/health/ready", ready(acpDB)).Methods("GET")
-
-	// Get service version
-	apiMux.HandleFunc("/version", func(rw http.ResponseWriter, r *http.Request) {
-		rw.Header().Add("Content-Type", "application/json")
-		rw.WriteHeader(200)
-		jsonEnc := json.NewEncoder(rw)
-		err := jsonEnc.Encode(version{
-			Version: "v0.4.1",
-		})
-		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
-			return
-		}
-	}).Methods("GET")
+	adminMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/roles/{id}/members", addMembersToAccessControlPolicyRole(acpDB)).Methods("PUT")
+	adminMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/roles/{id}/members/{member}", removeMemberFromAccessControlPolicyRole(acpDB)).Methods("DELETE")
+
+	// Health, version and OpenAPI endpoints are served by every router
+	for _, router := range routers {
+		router.HandleFunc(openAPIPath, openAPI).Methods("GET")
+		router.HandleFunc("/health/alive", alive(acpDB)).Methods("GET")
+		router.HandleFunc("/health/ready", ready(acpDB)).Methods("GET")
+
+		// Get service version
+		router.HandleFunc("/version", func(rw http.ResponseWriter, r *http.Request) {
+			writeJSON(rw, 200, version{
+				Version: "v0.4.1",
+			})
+		}).Methods("GET")
+	}
 
 	return nil
 
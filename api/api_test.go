@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adi/sketo/config"
+	"github.com/gorilla/mux"
+)
+
+// newTestAPI initializes the API over memory storage with the default config,
+// changed by configure when not nil. Like main, it serves administration on
+// the public router unless the admin listener has an address
+func newTestAPI(t *testing.T, configure func(cfg *config.Config)) (*mux.Router, *mux.Router) {
+	cfg := config.Default()
+	cfg.Storage.Backend = "memory"
+	cfg.Tracing.Backend = "none"
+	cfg.Counters.ReconcileInterval = 0
+	if configure != nil {
+		configure(cfg)
+	}
+	config.Set(cfg)
+	t.Cleanup(func() {
+		config.ResetReloadHooks()
+		config.Set(nil)
+	})
+
+	publicMux := mux.NewRouter()
+	adminMux := publicMux
+	if cfg.Admin.Listen != "" {
+		adminMux = mux.NewRouter()
+	}
+	err := Init(publicMux, adminMux)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publicMux, adminMux
+}
+
+func TestInitSplitsPublicAndAdminRoutes(t *testing.T) {
+
+	publicMux, adminMux := newTestAPI(t, func(cfg *config.Config) {
+		cfg.Admin.Listen = ":4467"
+	})
+
+	for _, c := range []struct {
+		method string
+		url    string
+		public bool
+		admin  bool
+	}{
+		{"POST", "/engines/acp/ory/exact/allowed", true, false},
+		{"GET", "/engines/acp/ory/exact/policies", true, true},
+		{"GET", "/engines/acp/ory/glob/roles/r1", true, true},
+		{"PUT", "/engines/acp/ory/exact/policies", false, true},
+		{"DELETE", "/engines/acp/ory/exact/roles/r1", false, true},
+		{"DELETE", "/engines/acp/ory", false, true},
+		{"POST", "/engines/acp/ory/glob/reindex", false, true},
+		{"GET", "/engines/acp/ory/jobs/j1", false, true},
+		{"GET", "/health/ready", true, true},
+	} {
+		for _, router := range []struct {
+			name   string
+			mux    *mux.Router
+			served bool
+		}{
+			{"public", publicMux, c.public},
+			{"admin", adminMux, c.admin},
+		} {
+			var match mux.RouteMatch
+			served := router.mux.Match(httptest.NewRequest(c.method, c.url, nil), &match) && match.MatchErr == nil
+			if served != router.served {
+				t.Error(fmt.Errorf("%s %s served by the %s router: %v", c.method, c.url, router.name, served))
+			}
+		}
+	}
+
+}
+
+func TestReloadTogglesRequestMappings(t *testing.T) {
+
+	enable := func(cfg *config.Config, enabled bool) {
+		cfg.ExtAuthz.Enabled = enabled
+		cfg.ForwardAuth.Enabled = enabled
+		cfg.SubjectAccessReview.Enabled = enabled
+	}
+	apiMux, _ := newTestAPI(t, func(cfg *config.Config) { enable(cfg, true) })
+	check := func(enabled bool) {
+		for _, c := range []struct {
+			method string
+			url    string
+			body   interface{}
+		}{
+			{"GET", "/ext_authz/documents/1", nil},
+			{"GET", "/forward_auth", nil},
+			{"POST", "/k8s/subjectaccessreview", map[string]interface{}{"apiVersion": "authorization.k8s.io/v1", "kind": "SubjectAccessReview", "spec": map[string]interface{}{}}},
+		} {
+			rw := doJSON(apiMux, c.method, c.url, c.body)
+			if (rw.Code != 404) != enabled {
+				t.Error(fmt.Errorf("%s %s returned %d with mappings enabled: %v", c.method, c.url, rw.Code, enabled))
+			}
+		}
+	}
+	reload := func(enabled bool) {
+		err := config.Reload(func() (*config.Config, error) {
+			cfg := *config.Get()
+			enable(&cfg, enabled)
+			return &cfg, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check(true)
+	reload(false)
+	check(false)
+	reload(true)
+	check(true)
+
+}
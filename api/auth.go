@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/adi/sketo/auth"
+	"github.com/adi/sketo/config"
+)
+
+// authState is the authentication in effect, swapped on config reload
+type authState struct {
+	enabled        bool
+	anonymousCheck bool
+	authenticator  *auth.Authenticator
+}
+
+var currentAuth atomic.Pointer[authState]
+
+func newAuthState(cfg config.AuthConfig) (*authState, error) {
+	state := &authState{
+		enabled:        cfg.Enabled,
+		anonymousCheck: cfg.AnonymousCheck,
+	}
+	if !cfg.Enabled {
+		return state, nil
+	}
+	opts, err := cfg.Options()
+	if err != nil {
+		return nil, err
+	}
+	state.authenticator, err = auth.New(opts)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// initAuth sets up authentication from the config, and again on every reload
+func initAuth() error {
+	state, err := newAuthState(config.Get().Auth)
+	if err != nil {
+		return err
+	}
+	currentAuth.Store(state)
+	config.OnReload(func(cfg *config.Config) error {
+		state, err := newAuthState(cfg.Auth)
+		if err != nil {
+			return err
+		}
+		currentAuth.Store(state)
+		return nil
+	})
+	return nil
+}
+
+// requiredScope returns the scope needed to call the route of r, or "" for
+// public routes. Routes not listed here need the admin scope
+func requiredScope(r *http.Request) auth.Scope {
+	route := routeLabel(r)
+	switch {
+	case route == "/health/alive" || route == "/health/ready" || route == "/version" || route == openAPIPath:
+		return ""
+	case route == "/engines/acp/ory" || strings.HasSuffix(route, "/reindex") || strings.HasPrefix(route, "/engines/acp/ory/jobs"):
+		return auth.ScopeAdmin
+	case strings.HasPrefix(route, "/admin/") || strings.HasPrefix(route, "/cluster/") || strings.HasPrefix(route, "/replication/"):
+		return auth.ScopeAdmin
+	case strings.HasSuffix(route, "/allowed") || proxiedRoute(route) || route == subjectAccessReviewPath:
+		return auth.ScopeCheck
+	case !strings.HasPrefix(route, "/engines/acp/ory/"):
+		return auth.ScopeAdmin
+	case r.Method == "GET":
+		return auth.ScopeRead
+	}
+	return auth.ScopeWrite
+}
+
+// proxiedRoute tells whether proxies call route with the headers of the
+// request they ask about, whose Authorization header isn't meant for sketo;
+// they authenticate with X-API-Key or a client certificate
+func proxiedRoute(route string) bool {
+	return route == extAuthzPrefix+"/" || route == forwardAuthPath
+}
+
+// authenticate is a middleware rejecting requests whose caller lacks the scope
+// of the route, unless authentication is disabled
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		state := currentAuth.Load()
+		if state == nil || !state.enabled {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		scope := requiredScope(r)
+		if scope == "" {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		credentialed := r
+		if proxiedRoute(routeLabel(r)) {
+			credentialed = r.Clone(r.Context())
+			credentialed.Header.Del("Authorization")
+		}
+		principal, err := state.authenticator.Authenticate(credentialed)
+		if err == auth.ErrNoCredentials && scope == auth.ScopeCheck && state.anonymousCheck {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if err != nil {
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="sketo"`)
+			writeError(rw, r, 401, err.Error())
+			return
+		}
+		if !principal.Has(scope) {
+			writeError(rw, r, 403, fmt.Sprintf("%s lacks the %s scope", principal.Name, scope))
+			return
+		}
+		next.ServeHTTP(rw, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+)
+
+func TestAuthenticateRequiresRouteScopes(t *testing.T) {
+
+	state, err := newAuthState(config.AuthConfig{
+		Enabled:        true,
+		AnonymousCheck: true,
+		APIKeys: []config.APIKeyConfig{
+			{Name: "reader", Key: "r", Scopes: []string{"read"}},
+			{Name: "writer", Key: "w", Scopes: []string{"write"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentAuth.Store(state)
+	defer currentAuth.Store(nil)
+
+	apiMux := newTestRouter(db.NewMemStore())
+	apiMux.HandleFunc("/engines/acp/ory", func(rw http.ResponseWriter, r *http.Request) {}).Methods("DELETE")
+	apiMux.Use(authenticate)
+
+	// Requests let through reach the handlers, which reject the empty bodies
+	for _, c := range []struct {
+		method string
+		url    string
+		key    string
+		code   int
+	}{
+		{"POST", "/engines/acp/ory/exact/allowed", "", 400},
+		{"POST", "/engines/acp/ory/exact/allowed", "bad", 401},
+		{"GET", "/engines/acp/ory/exact/policies/p1", "", 401},
+		{"GET", "/engines/acp/ory/exact/policies/p1", "r", 404},
+		{"DELETE", "/engines/acp/ory/exact/policies/p1", "r", 403},
+		{"DELETE", "/engines/acp/ory/exact/policies/p1", "w", 204},
+		{"DELETE", "/engines/acp/ory", "w", 403},
+	} {
+		req := httptest.NewRequest(c.method, c.url, nil)
+		req.Header.Set("Content-Type", "application/json")
+		if c.key != "" {
+			req.Header.Set("X-API-Key", c.key)
+		}
+		rw := httptest.NewRecorder()
+		apiMux.ServeHTTP(rw, req)
+		if rw.Code != c.code {
+			t.Error(fmt.Errorf("%s %s with key '%s' returned %d instead of %d", c.method, c.url, c.key, rw.Code, c.code))
+		}
+	}
+
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"errors"
+	"log"
+
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+)
+
+// Statuses of an upserted doc
+const (
+	upsertInserted = "inserted"
+	upsertUpdated  = "updated"
+	upsertFailed   = "failed"
+)
+
+// upsertResult reports the outcome of upserting one doc of a batch
+type upsertResult struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchResult is the response of the batch endpoints; TotalImported counts
+// both inserted and updated docs
+type batchResult struct {
+	TotalImported int            `json:"total_imported"`
+	Inserted      int            `json:"inserted"`
+	Updated       int            `json:"updated"`
+	Failed        int            `json:"failed"`
+	Items         []upsertResult `json:"items"`
+}
+
+func newBatchResult(results []upsertResult) batchResult {
+	ret := batchResult{
+		Items: results,
+	}
+	for _, result := range results {
+		switch result.Status {
+		case upsertInserted:
+			ret.Inserted++
+		case upsertUpdated:
+			ret.Updated++
+		default:
+			ret.Failed++
+		}
+	}
+	ret.TotalImported = ret.Inserted + ret.Updated
+	return ret
+}
+
+// errBatchTooLarge is returned for atomic batches of more docs than the
+// configured max batch size
+var errBatchTooLarge = errors.New("batch too large")
+
+// upsertBatch writes the docs identified by ids with put, which reports
+// whether a doc is new. Atomic batches are written in one transaction and
+// can't hold more docs than the configured max batch size; other batches are
+// written in chunks of that size, a failed chunk being retried doc by doc so
+// that one bad doc doesn't fail the others. The returned error is only set
+// for atomic batches.
+func upsertBatch(acpDB db.Store, ids []string, atomic bool, put func(txn db.Txn, i int) (bool, error)) ([]upsertResult, error) {
+	results := make([]upsertResult, len(ids))
+	for i, id := range ids {
+		results[i].ID = id
+	}
+	inserted := make([]bool, len(ids))
+	maxSize := config.Get().Batch.MaxSize
+
+	if atomic && len(ids) > maxSize {
+		for i := range results {
+			results[i].Status = upsertFailed
+			results[i].Error = "Not written (batch too large)"
+		}
+		return results, errBatchTooLarge
+	}
+
+	for start := 0; start < len(ids); start += maxSize {
+		end := start + maxSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		culprit := -1
+		err := acpDB.Update(func(txn db.Txn) error {
+			for i := start; i < end; i++ {
+				var err error
+				inserted[i], err = put(txn, i)
+				if err != nil {
+					culprit = i
+					return err
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			for i := start; i < end; i++ {
+				results[i].Status = upsertUpdated
+				if inserted[i] {
+					results[i].Status = upsertInserted
+				}
+			}
+			continue
+		}
+
+		if atomic {
+			log.Printf("Error upserting batch: %v\n", err)
+			for i := range results {
+				results[i].Status = upsertFailed
+				results[i].Error = "Not written (batch rolled back)"
+				if culprit == -1 || culprit == i {
+					results[i].Error = "Server error"
+				}
+			}
+			return results, err
+		}
+
+		for i := start; i < end; i++ {
+			err := acpDB.Update(func(txn db.Txn) error {
+				var err error
+				inserted[i], err = put(txn, i)
+				return err
+			})
+			switch {
+			case err != nil:
+				log.Printf("Error upserting %s: %v\n", ids[i], err)
+				results[i].Status = upsertFailed
+				results[i].Error = "Server error"
+			case inserted[i]:
+				results[i].Status = upsertInserted
+			default:
+				results[i].Status = upsertUpdated
+			}
+		}
+	}
+	return results, nil
+}
+
+// countInserted returns the number of results that are new docs
+func countInserted(results []upsertResult) int64 {
+	cnt := int64(0)
+	for _, result := range results {
+		if result.Status == upsertInserted {
+			cnt++
+		}
+	}
+	return cnt
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+// failingStore fails every transaction that writes the doc of failID
+type failingStore struct {
+	db.Store
+	failID string
+}
+
+func (s *failingStore) Update(fn func(txn db.Txn) error) error {
+	return s.Store.Update(func(txn db.Txn) error {
+		return fn(&failingTxn{
+			Txn:    txn,
+			failID: s.failID,
+		})
+	})
+}
+
+type failingTxn struct {
+	db.Txn
+	failID string
+}
+
+func (t *failingTxn) Set(prefix string, key string, value interface{}) error {
+	if key == docSuffix(t.failID) {
+		return errors.New("write failed")
+	}
+	return t.Txn.Set(prefix, key, value)
+}
+
+func doBatch(t *testing.T, acpDB db.Store, url string, bodies []oryAccessControlPolicy) (int, batchResult) {
+	apiMux := mux.NewRouter()
+	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/policies/batch", upsertOryAccessControlPolicies(acpDB)).Methods("PUT")
+	rw := doJSON(apiMux, "PUT", url, bodies)
+	var result batchResult
+	err := json.NewDecoder(rw.Body).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rw.Code, result
+}
+
+func TestPolicyBatchResults(t *testing.T) {
+
+	acpDB := db.NewMemStore()
+	url := "/engines/acp/ory/exact/policies/batch"
+	policy := func(id string, resource string) oryAccessControlPolicy {
+		return oryAccessControlPolicy{
+			ID:        id,
+			Subjects:  []string{"users:alice"},
+			Resources: []string{resource},
+			Actions:   []string{"read"},
+			Effect:    "allow",
+		}
+	}
+
+	before := atomic.LoadInt64(&CntExactPolicies)
+	code, result := doBatch(t, acpDB, url, []oryAccessControlPolicy{policy("p1", "articles:1"), policy("p2", "articles:1")})
+	if code != http.StatusOK || result.Inserted != 2 || result.TotalImported != 2 {
+		t.Error(fmt.Errorf("first batch returned %d %+v", code, result))
+	}
+
+	// Updates don't count as new docs and drop the indexes of the old version
+	code, result = doBatch(t, acpDB, url, []oryAccessControlPolicy{policy("p1", "articles:2"), policy("p3", "articles:1")})
+	if code != http.StatusOK || result.Inserted != 1 || result.Updated != 1 || result.Items[0].Status != upsertUpdated {
+		t.Error(fmt.Errorf("second batch returned %d %+v", code, result))
+	}
+	if atomic.LoadInt64(&CntExactPolicies) != before+3 {
+		t.Error(fmt.Errorf("counter moved by %d instead of 3", atomic.LoadInt64(&CntExactPolicies)-before))
+	}
+	err := acpDB.List(policyBasePrefix("exact"), policyFilter("", "articles:1", ""), 0, -1, func(keys []string, values [][]byte) error {
+		if len(values) != 2 {
+			t.Error(fmt.Errorf("%d policies indexed under the old resource instead of 2", len(values)))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An atomic batch writes nothing when one doc fails
+	failing := &failingStore{
+		Store:  acpDB,
+		failID: "bad",
+	}
+	code, result = doBatch(t, failing, url+"?atomic=true", []oryAccessControlPolicy{policy("p4", "articles:1"), policy("bad", "articles:1")})
+	if code != http.StatusInternalServerError || result.Failed != 2 || result.TotalImported != 0 {
+		t.Error(fmt.Errorf("atomic batch returned %d %+v", code, result))
+	}
+	err = acpDB.Get(policyBasePrefix("exact"), docSuffix("p4"), func(value []byte) error { return nil })
+	if err != db.ErrKeyNotFound {
+		t.Error(fmt.Errorf("atomic batch partially written"))
+	}
+
+	// Otherwise the other docs are still written
+	code, result = doBatch(t, failing, url, []oryAccessControlPolicy{policy("p4", "articles:1"), policy("bad", "articles:1")})
+	if code != http.StatusOK || result.Inserted != 1 || result.Failed != 1 || result.Items[1].Status != upsertFailed {
+		t.Error(fmt.Errorf("non-atomic batch returned %d %+v", code, result))
+	}
+	if atomic.LoadInt64(&CntExactPolicies) != before+4 {
+		t.Error(fmt.Errorf("counter moved by %d instead of 4", atomic.LoadInt64(&CntExactPolicies)-before))
+	}
+
+}
+
+// countingStore counts transactions
+type countingStore struct {
+	db.Store
+	updates int
+}
+
+func (s *countingStore) Update(fn func(txn db.Txn) error) error {
+	s.updates++
+	return s.Store.Update(fn)
+}
+
+func TestBatchMaxSize(t *testing.T) {
+
+	cfg := config.Default()
+	cfg.Batch.MaxSize = 2
+	config.Set(cfg)
+	defer config.Set(nil)
+
+	acpDB := &countingStore{
+		Store: db.NewMemStore(),
+	}
+	url := "/engines/acp/ory/exact/policies/batch"
+	bodies := make([]oryAccessControlPolicy, 0)
+	for i := 0; i < 5; i++ {
+		bodies = append(bodies, oryAccessControlPolicy{ID: fmt.Sprintf("p%d", i), Effect: "allow"})
+	}
+
+	// Atomic batches are refused above the max size
+	code, _ := doBatch(t, acpDB, url+"?atomic=true", bodies)
+	if code != http.StatusRequestEntityTooLarge || acpDB.updates != 0 {
+		t.Error(fmt.Errorf("oversized atomic batch returned %d after %d transactions", code, acpDB.updates))
+	}
+
+	// Others are written in chunks of the max size
+	code, result := doBatch(t, acpDB, url, bodies)
+	if code != http.StatusOK || result.Inserted != 5 || acpDB.updates != 3 {
+		t.Error(fmt.Errorf("chunked batch returned %d %+v after %d transactions", code, result, acpDB.updates))
+	}
+
+}
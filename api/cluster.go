@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/adi/sketo/auth"
+	"github.com/adi/sketo/cluster"
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+	"github.com/adi/sketo/replication"
+	"github.com/gorilla/mux"
+)
+
+// ClusterNode is set when running in clustered mode
+var ClusterNode *cluster.Node
+
+// initCluster sets up the raft clustered mode configured in cfg (acpDB is nil
+// when not using the badger backend)
+func initCluster(apiMux *mux.Router, acpDB *db.DB, storageDir string, cfg config.ClusterConfig) error {
+	if cfg.NodeID == "" {
+		return nil
+	}
+	if acpDB == nil {
+		return fmt.Errorf("clustered mode requires the badger storage backend")
+	}
+
+	nodeCfg := cluster.Config{
+		NodeID:        cfg.NodeID,
+		RaftAddr:      cfg.RaftAddr,
+		RaftAdvertise: cfg.RaftAdvertise,
+		RaftDir:       cfg.RaftDir,
+		APIURL:        cfg.APIURL,
+		Bootstrap:     cfg.Bootstrap,
+		JoinURL:       cfg.Join,
+		// Writes are made on the leader, which keeps its own counters
+		OnFollowerApply: recountOnApply(acpDB),
+		WriteError:      writeError,
+	}
+	if nodeCfg.RaftDir == "" {
+		nodeCfg.RaftDir = storageDir + "-raft"
+	}
+	if cfg.APIKey != "" {
+		nodeCfg.Transport = auth.Transport(cfg.APIKey, nil)
+	}
+
+	var err error
+	ClusterNode, err = cluster.NewNode(nodeCfg, acpDB, func() error {
+		return ReloadCounters(acpDB)
+	})
+	if err != nil {
+		return err
+	}
+	ClusterNode.Register(apiMux)
+	apiMux.Use(ClusterNode.Middleware(replication.IsRead))
+	return nil
+}
+
+// recountOnApply returns a function recounting the documents in the
+// background; calls made while a recount is running add a single one after it
+func recountOnApply(acpDB db.Store) func() {
+	pending := make(chan struct{}, 1)
+	go func() {
+		for range pending {
+			err := ReloadCounters(acpDB)
+			if err != nil {
+				log.Printf("Error recounting documents after a replicated write: %v\n", err)
+			}
+		}
+	}()
+	return func() {
+		select {
+		case pending <- struct{}{}:
+		default:
+		}
+	}
+}
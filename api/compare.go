@@ -0,0 +1,249 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adi/sketo/config"
+	"github.com/gorilla/mux"
+)
+
+// Comparison mode state
+var (
+	CompareUpstreamURL   string
+	CntCompareRequests   = int64(0)
+	CntCompareMismatches = int64(0)
+	CntCompareErrors     = int64(0)
+)
+
+// activeComparator is the comparator set up by Init, nil when comparison is
+// disabled
+var activeComparator *comparator
+
+// comparator forwards allowed checks to an upstream Keto in parallel and records
+// the ones it decides differently
+type comparator struct {
+	upstreamURL string
+	useUpstream bool
+	client      *http.Client
+	mismatchMu  sync.Mutex
+	mismatchLog io.Writer
+	// wg tracks the comparisons still running after their response was sent
+	wg sync.WaitGroup
+}
+
+type compareMismatch struct {
+	Time     time.Time       `json:"time"`
+	Flavor   string          `json:"flavor"`
+	Request  json.RawMessage `json:"request"`
+	Sketo    bool            `json:"sketo"`
+	Upstream bool            `json:"upstream"`
+}
+
+// initCompare sets up the comparison mode configured in cfg and returns the
+// middleware wrapping the allowed handler
+func initCompare(cfg config.CompareConfig) (func(http.HandlerFunc) http.HandlerFunc, error) {
+	if cfg.UpstreamURL == "" {
+		activeComparator = nil
+		return func(h http.HandlerFunc) http.HandlerFunc {
+			return h
+		}, nil
+	}
+
+	var mismatchLog io.Writer
+	if cfg.MismatchLog != "" {
+		f, err := os.OpenFile(cfg.MismatchLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		mismatchLog = f
+	}
+
+	CompareUpstreamURL = cfg.UpstreamURL
+	c := newComparator(cfg.UpstreamURL, cfg.Answer == "upstream", mismatchLog)
+	c.client.Timeout = cfg.Timeout
+	activeComparator = c
+	return c.wrap, nil
+}
+
+// WaitComparisons blocks until the comparisons with the upstream Keto still
+// running are recorded, so that shutting down doesn't lose mismatches
+func WaitComparisons() {
+	if activeComparator != nil {
+		activeComparator.wg.Wait()
+	}
+}
+
+func newComparator(upstreamURL string, useUpstream bool, mismatchLog io.Writer) *comparator {
+	return &comparator{
+		upstreamURL: upstreamURL,
+		useUpstream: useUpstream,
+		client:      &http.Client{},
+		mismatchLog: mismatchLog,
+	}
+}
+
+// recordedResponse buffers what a handler writes
+type recordedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recordedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *recordedResponse) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = 200
+	}
+	return r.body.Write(p)
+}
+
+func (r *recordedResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recordedResponse) writeTo(rw http.ResponseWriter) {
+	for k, v := range r.header {
+		rw.Header()[k] = v
+	}
+	if r.status != 0 {
+		rw.WriteHeader(r.status)
+	}
+	rw.Write(r.body.Bytes())
+}
+
+// upstreamAllowed asks the upstream Keto for its decision
+func (c *comparator) upstreamAllowed(path string, body []byte) (bool, error) {
+	resp, err := c.client.Post(c.upstreamURL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("upstream returned %d", resp.StatusCode)
+	}
+	var result authorizationResult
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return false, err
+	}
+	return result.Allowed, nil
+}
+
+func (c *comparator) recordMismatch(mismatch compareMismatch) {
+	atomic.AddInt64(&CntCompareMismatches, 1)
+	line, err := json.Marshal(mismatch)
+	if err != nil {
+		log.Printf("Error encoding comparison mismatch: %v\n", err)
+		return
+	}
+	if c.mismatchLog == nil {
+		log.Printf("Comparison mismatch: %s\n", line)
+		return
+	}
+	c.mismatchMu.Lock()
+	defer c.mismatchMu.Unlock()
+	_, err = c.mismatchLog.Write(append(line, '\n'))
+	if err != nil {
+		log.Printf("Error writing comparison mismatch: %v\n", err)
+	}
+}
+
+// compare records a mismatch if the upstream decided differently from sketo
+func (c *comparator) compare(flavor string, body []byte, local *recordedResponse, upstream bool, upstreamErr error) {
+	atomic.AddInt64(&CntCompareRequests, 1)
+	if upstreamErr != nil {
+		atomic.AddInt64(&CntCompareErrors, 1)
+		log.Printf("Error comparing with upstream: %v\n", upstreamErr)
+		return
+	}
+	if local.status != http.StatusOK {
+		return
+	}
+	var result authorizationResult
+	err := json.Unmarshal(local.body.Bytes(), &result)
+	if err != nil {
+		atomic.AddInt64(&CntCompareErrors, 1)
+		return
+	}
+	if result.Allowed != upstream {
+		c.recordMismatch(compareMismatch{
+			Time:     time.Now().UTC(),
+			Flavor:   flavor,
+			Request:  json.RawMessage(body),
+			Sketo:    result.Allowed,
+			Upstream: upstream,
+		})
+	}
+}
+
+// wrap runs the allowed handler while asking the upstream Keto in parallel
+func (c *comparator) wrap(h http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(rw, r, 400, "Couldn't read body")
+			return
+		}
+		if !json.Valid(body) {
+			// Nothing worth comparing
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			h(rw, r)
+			return
+		}
+		flavor := mux.Vars(r)["flavor"]
+
+		type upstreamResult struct {
+			allowed bool
+			err     error
+		}
+		upstreamDone := make(chan upstreamResult, 1)
+		c.wg.Add(1)
+		go func() {
+			allowed, err := c.upstreamAllowed(r.URL.Path, body)
+			upstreamDone <- upstreamResult{allowed, err}
+		}()
+
+		local := &recordedResponse{
+			header: make(http.Header),
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		h(local, r)
+
+		if !c.useUpstream {
+			local.writeTo(rw)
+			go func() {
+				defer c.wg.Done()
+				result := <-upstreamDone
+				c.compare(flavor, body, local, result.allowed, result.err)
+			}()
+			return
+		}
+
+		defer c.wg.Done()
+		result := <-upstreamDone
+		c.compare(flavor, body, local, result.allowed, result.err)
+		if result.err != nil {
+			// Fall back to sketo's answer
+			local.writeTo(rw)
+			return
+		}
+		writeJSON(rw, 200, authorizationResult{
+			Allowed: result.allowed,
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+func TestCompareRecordsMismatches(t *testing.T) {
+
+	// Upstream Keto allows everything while sketo has no policies
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/engines/acp/ory/exact/allowed" {
+			t.Error(fmt.Errorf("upstream got unexpected path %s", r.URL.Path))
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(authorizationResult{
+			Allowed: true,
+		})
+	}))
+	defer upstream.Close()
+
+	input := oryAccessControlPolicyAllowedInput{
+		Subject:  "users:alice",
+		Resource: "articles:1",
+		Action:   "read",
+	}
+	for _, useUpstream := range []bool{false, true} {
+		mismatchLog := &bytes.Buffer{}
+		c := newComparator(upstream.URL, useUpstream, mismatchLog)
+		apiMux := mux.NewRouter()
+		apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/allowed", c.wrap(allowed(db.NewMemStore()))).Methods("POST")
+		mismatchesBefore := atomic.LoadInt64(&CntCompareMismatches)
+
+		rw := doJSON(apiMux, "POST", "/engines/acp/ory/exact/allowed", input)
+		c.wg.Wait()
+		var result authorizationResult
+		err := json.NewDecoder(rw.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed != useUpstream {
+			t.Error(fmt.Errorf("returned allowed=%v when answering with upstream=%v", result.Allowed, useUpstream))
+		}
+
+		if atomic.LoadInt64(&CntCompareMismatches) != mismatchesBefore+1 {
+			t.Error(fmt.Errorf("mismatch not counted"))
+		}
+		var mismatch compareMismatch
+		err = json.Unmarshal(mismatchLog.Bytes(), &mismatch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var loggedInput oryAccessControlPolicyAllowedInput
+		err = json.Unmarshal(mismatch.Request, &loggedInput)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mismatch.Flavor != "exact" || mismatch.Sketo || !mismatch.Upstream || loggedInput.Subject != input.Subject {
+			t.Error(fmt.Errorf("unexpected mismatch record %s", mismatchLog.String()))
+		}
+	}
+
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/adi/sketo/util"
+	"github.com/google/uuid"
+)
+
+// genericError is the error body returned by Keto
+type genericError struct {
+	Code    int                      `json:"code"`
+	Status  string                   `json:"status,omitempty"`
+	Message string                   `json:"message"`
+	Reason  string                   `json:"reason,omitempty"`
+	Request string                   `json:"request,omitempty"`
+	Details []map[string]interface{} `json:"details,omitempty"`
+}
+
+// errorMessages are the messages Keto returns for each status code
+var errorMessages = map[int]string{
+	400: "The request was malformed or contained invalid parameters",
+	401: "The request could not be authorized",
+	403: "The requested action was forbidden",
+	404: "The requested resource could not be found",
+	409: "The request could not be completed due to a conflict",
+	413: "The request was too large",
+	500: "An internal server error occurred, please contact the system administrator",
+	503: "The service is temporarily unavailable",
+}
+
+// requestIDHeader carries the ID of a request, either set by the caller or a
+// proxy or generated by sketo
+const requestIDHeader = "X-Request-Id"
+
+type requestStateKey struct{}
+
+// requestState is kept in the context of every request so errors can tell
+// which request they belong to and whether a response was already started
+type requestState struct {
+	id       string
+	recorder *util.StatusRecorder
+}
+
+// requestIDs is a middleware giving every request an ID, returned in the
+// X-Request-Id header and in error bodies
+func requestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = uuid.New().String()
+		}
+		rw.Header().Set(requestIDHeader, id)
+		sr := &util.StatusRecorder{
+			ResponseWriter: rw,
+		}
+		ctx := context.WithValue(r.Context(), requestStateKey{}, &requestState{
+			id:       id,
+			recorder: sr,
+		})
+		next.ServeHTTP(sr, r.WithContext(ctx))
+	})
+}
+
+// requestID returns the ID of r, or "" outside of the requestIDs middleware
+func requestID(r *http.Request) string {
+	state, ok := r.Context().Value(requestStateKey{}).(*requestState)
+	if !ok {
+		return ""
+	}
+	return state.id
+}
+
+// writeError sends a genericError with code, explaining its cause in reason.
+// When the response was already started the error can only be logged
+func writeError(rw http.ResponseWriter, r *http.Request, code int, reason string) {
+	writeErrorDetails(rw, r, code, reason, nil)
+}
+
+// writeErrorDetails is writeError with details about the cause
+func writeErrorDetails(rw http.ResponseWriter, r *http.Request, code int, reason string, details []map[string]interface{}) {
+	e := genericError{
+		Code:    code,
+		Status:  http.StatusText(code),
+		Message: errorMessages[code],
+		Reason:  reason,
+		Request: requestID(r),
+		Details: details,
+	}
+	if e.Message == "" {
+		e.Message = e.Status
+	}
+	if state, ok := r.Context().Value(requestStateKey{}).(*requestState); ok && state.recorder.Code != 0 {
+		log.Printf("Error after the response of request %s was started: %d %s\n", e.Request, code, reason)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	jsonEnc := json.NewEncoder(rw)
+	err := jsonEnc.Encode(e)
+	if err != nil {
+		log.Printf("Error writing error response: %v\n", err)
+	}
+}
+
+// writeJSON sends value with the status code
+func writeJSON(rw http.ResponseWriter, code int, value interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	jsonEnc := json.NewEncoder(rw)
+	err := jsonEnc.Encode(value)
+	if err != nil {
+		log.Printf("Error writing response: %v\n", err)
+	}
+}
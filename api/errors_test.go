@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorsAreKetoGenericErrors(t *testing.T) {
+
+	handler := requestIDs(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/started" {
+			writeJSON(rw, 200, authorizationResult{Allowed: true})
+		}
+		writeError(rw, r, 400, "Invalid limit query param")
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	var e genericError
+	err := json.NewDecoder(rw.Body).Decode(&e)
+	if err != nil || rw.Code != 400 || rw.Header().Get("Content-Type") != "application/json" {
+		t.Fatal(fmt.Errorf("error returned %d %s (%v)", rw.Code, rw.Header().Get("Content-Type"), err))
+	}
+	if e.Code != 400 || e.Status != "Bad Request" || e.Message == "" || e.Reason != "Invalid limit query param" || e.Request != "req-1" {
+		t.Error(fmt.Errorf("unexpected error %+v", e))
+	}
+	if rw.Header().Get("X-Request-Id") != "req-1" {
+		t.Error(fmt.Errorf("request ID wasn't returned"))
+	}
+
+	// Request IDs are generated when missing
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Header().Get("X-Request-Id") == "" {
+		t.Error(fmt.Errorf("request ID wasn't generated"))
+	}
+
+	// Errors can't replace a response already started
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/started", nil))
+	var result authorizationResult
+	err = json.NewDecoder(rw.Body).Decode(&result)
+	if err != nil || rw.Code != 200 || !result.Allowed || rw.Body.Len() != 0 {
+		t.Error(fmt.Errorf("error written after the response was started (%d, %v)", rw.Code, err))
+	}
+
+}
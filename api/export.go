@@ -0,0 +1,188 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+	"github.com/adi/sketo/tracing"
+	"github.com/gorilla/mux"
+)
+
+var allFlavors = []string{"exact", "glob", "regex"}
+
+// ExportOptions ..
+type ExportOptions struct {
+	Flavors []string
+	// Kind restricts the export to "policies" or "roles" when set
+	Kind string
+	// Subject and Resource filter policies, Member filters roles
+	Subject  string
+	Resource string
+	Member   string
+}
+
+// validate checks the kind and flavors selected
+func (opts ExportOptions) validate() error {
+	if opts.Kind != "" && opts.Kind != "policies" && opts.Kind != "roles" {
+		return fmt.Errorf("invalid kind '%s' (expected policies or roles)", opts.Kind)
+	}
+	for _, flavor := range opts.Flavors {
+		if flavor != "exact" && flavor != "glob" && flavor != "regex" {
+			return fmt.Errorf("invalid flavor '%s'", flavor)
+		}
+	}
+	return nil
+}
+
+func exportMatches(flavor string, alternatives []string, item string) (bool, error) {
+	if flavor != "exact" {
+		return matchesAny(flavor, alternatives, item)
+	}
+	if item == "" {
+		return true, nil
+	}
+	for _, alternative := range alternatives {
+		if alternative == item {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// exportDocs writes the documents selected by opts to w as NDJSON, all read
+// from a single consistent view of the store
+func exportDocs(ctx context.Context, acpDB db.Store, w io.Writer, opts ExportOptions) error {
+	jsonEnc := json.NewEncoder(w)
+	return acpDB.View(func(txn db.Txn) error {
+		for _, flavor := range opts.Flavors {
+			if opts.Kind == "" || opts.Kind == "policies" {
+				_, span := tracing.StartSpan(ctx, "store.enumerate")
+				span.SetAttribute("flavor", flavor)
+				span.SetAttribute("kind", "policies")
+				err := txn.Enumerate(policyBasePrefix(flavor)+"i/", func(key string, value []byte) (bool, error) {
+					var item oryAccessControlPolicy
+					err := json.Unmarshal(value, &item)
+					if err != nil {
+						return false, err
+					}
+					include, err := exportMatches(flavor, item.Subjects, opts.Subject)
+					if err != nil || !include {
+						return err == nil, err
+					}
+					include, err = exportMatches(flavor, item.Resources, opts.Resource)
+					if err != nil || !include {
+						return err == nil, err
+					}
+					return true, jsonEnc.Encode(ndjsonLine{
+						Flavor: flavor,
+						Policy: &item,
+					})
+				})
+				span.End()
+				if err != nil {
+					return err
+				}
+			}
+			if opts.Kind == "" || opts.Kind == "roles" {
+				_, span := tracing.StartSpan(ctx, "store.enumerate")
+				span.SetAttribute("flavor", flavor)
+				span.SetAttribute("kind", "roles")
+				err := txn.Enumerate(roleBasePrefix(flavor)+"i/", func(key string, value []byte) (bool, error) {
+					var item oryAccessControlPolicyRole
+					err := json.Unmarshal(value, &item)
+					if err != nil {
+						return false, err
+					}
+					include, err := exportMatches(flavor, item.Members, opts.Member)
+					if err != nil || !include {
+						return err == nil, err
+					}
+					return true, jsonEnc.Encode(ndjsonLine{
+						Flavor: flavor,
+						Role:   &item,
+					})
+				})
+				span.End()
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Export writes the documents selected by opts from the store selected by the
+// same config as the API server to w, gzipped if asked to
+func Export(w io.Writer, compress bool, opts ExportOptions) error {
+	err := opts.validate()
+	if err != nil {
+		return err
+	}
+	acpDB, _, err := openStore(config.Get().Storage)
+	if err != nil {
+		return err
+	}
+	defer acpDB.Close()
+
+	if compress {
+		gzw := gzip.NewWriter(w)
+		err = exportDocs(context.Background(), acpDB, gzw, opts)
+		if err != nil {
+			return err
+		}
+		return gzw.Close()
+	}
+	return exportDocs(context.Background(), acpDB, w, opts)
+}
+
+// Export documents as NDJSON
+func export(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		opts := ExportOptions{
+			Flavors:  allFlavors,
+			Kind:     r.FormValue("kind"),
+			Subject:  r.FormValue("subject"),
+			Resource: r.FormValue("resource"),
+			Member:   r.FormValue("member"),
+		}
+		if flavor, ok := params["flavor"]; ok {
+			opts.Flavors = []string{flavor}
+		}
+		err := opts.validate()
+		if err != nil {
+			writeError(rw, r, 400, err.Error())
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/x-ndjson")
+		var w io.Writer = rw
+		var gzw *gzip.Writer
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			rw.Header().Set("Content-Encoding", "gzip")
+			gzw = gzip.NewWriter(rw)
+			w = gzw
+		}
+		rw.WriteHeader(200)
+
+		// The status is already sent, so failures can only cut the stream short
+		// (leaving any gzip stream unterminated)
+		err = exportDocs(r.Context(), acpDB, w, opts)
+		if err != nil {
+			log.Printf("Error exporting ACPs: %v\n", err)
+			return
+		}
+		if gzw != nil {
+			gzw.Close()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+func readNDJSON(t *testing.T, r io.Reader) []ndjsonLine {
+	lines := make([]ndjsonLine, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var line ndjsonLine
+		err := json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if scanner.Err() != nil {
+		t.Fatal(scanner.Err())
+	}
+	return lines
+}
+
+func TestExport(t *testing.T) {
+
+	acpDB := db.NewMemStore()
+	_, err := upsertPolicies(acpDB, "exact", []oryAccessControlPolicy{
+		{ID: "p1", Subjects: []string{"users:alice"}, Resources: []string{"articles:1"}, Actions: []string{"read"}, Effect: "allow"},
+		{ID: "p2", Subjects: []string{"users:bob"}, Resources: []string{"articles:1"}, Actions: []string{"read"}, Effect: "allow"},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = upsertPolicies(acpDB, "glob", []oryAccessControlPolicy{
+		{ID: "g1", Subjects: []string{"users:*"}, Resources: []string{"articles:*"}, Actions: []string{"read"}, Effect: "allow"},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = upsertRoles(acpDB, "exact", []oryAccessControlPolicyRole{
+		{ID: "admins", Members: []string{"users:alice"}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiMux := mux.NewRouter()
+	apiMux.HandleFunc("/engines/acp/ory/export", export(acpDB)).Methods("GET")
+	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/export", export(acpDB)).Methods("GET")
+
+	rw := httptest.NewRecorder()
+	apiMux.ServeHTTP(rw, httptest.NewRequest("GET", "/engines/acp/ory/export", nil))
+	lines := readNDJSON(t, rw.Body)
+	if len(lines) != 4 {
+		t.Error(fmt.Errorf("exported %d documents instead of 4", len(lines)))
+	}
+
+	// Filters apply to their own kind, exactly or by pattern depending on the flavor
+	for url, expected := range map[string][]string{
+		"/engines/acp/ory/exact/export?subject=users:alice&kind=policies": {"p1"},
+		"/engines/acp/ory/glob/export?subject=users:carol":                {"g1"},
+		"/engines/acp/ory/exact/export?member=users:bob&kind=roles":       {},
+	} {
+		rw := httptest.NewRecorder()
+		apiMux.ServeHTTP(rw, httptest.NewRequest("GET", url, nil))
+		lines := readNDJSON(t, rw.Body)
+		ids := make([]string, 0)
+		for _, line := range lines {
+			if line.Policy != nil {
+				ids = append(ids, line.Policy.ID)
+			} else if line.Role != nil {
+				ids = append(ids, line.Role.ID)
+			}
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(expected) {
+			t.Error(fmt.Errorf("%s exported %v instead of %v", url, ids, expected))
+		}
+	}
+
+	rw = httptest.NewRecorder()
+	apiMux.ServeHTTP(rw, httptest.NewRequest("GET", "/engines/acp/ory/exact/export?kind=policy", nil))
+	if rw.Code != 400 {
+		t.Error(fmt.Errorf("export of an invalid kind returned %d", rw.Code))
+	}
+	err = Export(io.Discard, false, ExportOptions{Flavors: allFlavors, Kind: "policy"})
+	if err == nil {
+		t.Error(fmt.Errorf("Export accepted an invalid kind"))
+	}
+
+	req := httptest.NewRequest("GET", "/engines/acp/ory/exact/export", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rw = httptest.NewRecorder()
+	apiMux.ServeHTTP(rw, req)
+	if rw.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal(fmt.Errorf("export wasn't gzipped"))
+	}
+	gzr, err := gzip.NewReader(rw.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines = readNDJSON(t, gzr)
+	if len(lines) != 3 || lines[0].Flavor != "exact" {
+		t.Error(fmt.Errorf("unexpected gzipped export %v", lines))
+	}
+
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+	"github.com/adi/sketo/sketopb/extauthzpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// extAuthzPrefix is the path_prefix Envoy's HTTP ext_authz service is
+// configured with; the path of the request asked about follows it
+const extAuthzPrefix = "/ext_authz"
+
+// extAuthzState is the ext_authz mapping in effect, swapped on config reload
+type extAuthzState struct {
+	enabled bool
+	mapping *requestMapping
+}
+
+var currentExtAuthz atomic.Pointer[extAuthzState]
+
+func newExtAuthzState(cfg config.ExtAuthzConfig) (*extAuthzState, error) {
+	mapping, err := newRequestMapping("ext_authz", cfg.RequestMappingConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &extAuthzState{
+		enabled: cfg.Enabled,
+		mapping: mapping,
+	}, nil
+}
+
+// initExtAuthz sets up the ext_authz mapping from the config, and again on
+// every reload
+func initExtAuthz() error {
+	state, err := newExtAuthzState(config.Get().ExtAuthz)
+	if err != nil {
+		return err
+	}
+	currentExtAuthz.Store(state)
+	config.OnReload(func(cfg *config.Config) error {
+		state, err := newExtAuthzState(cfg.ExtAuthz)
+		if err != nil {
+			return err
+		}
+		currentExtAuthz.Store(state)
+		return nil
+	})
+	return nil
+}
+
+// decideExtAuthz checks a request Envoy asks about, with the subject of its
+// configured header
+func decideExtAuthz(acpDB db.Store, r *http.Request) mappingDecision {
+	state := currentExtAuthz.Load()
+	if state == nil || !state.enabled {
+		return mappingDecision{code: 404, reason: "ext_authz is disabled"}
+	}
+	header := state.mapping.cfg.SubjectHeader
+	subject := r.Header.Get(header)
+	if subject == "" {
+		return state.mapping.unauthenticated("Missing the " + header + " header")
+	}
+	return state.mapping.decide(acpDB, r, subject)
+}
+
+// extAuthz answers Envoy's HTTP ext_authz service, which sends the method and
+// headers of the request it asks about to its path under extAuthzPrefix
+func extAuthz(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		original := r.Clone(r.Context())
+		original.URL.Path = strings.TrimPrefix(r.URL.Path, extAuthzPrefix)
+		original.URL.RawPath = ""
+		decision := decideExtAuthz(acpDB, original)
+		if decision.code != 200 {
+			writeError(rw, r, decision.code, decision.reason)
+			return
+		}
+		decision.setHeaders(rw.Header())
+		rw.WriteHeader(200)
+	}
+}
+
+// extAuthzServer implements Envoy's gRPC ext_authz service
+type extAuthzServer struct {
+	extauthzpb.UnimplementedAuthorizationServer
+	acpDB db.Store
+}
+
+// extAuthzCodes are the gRPC codes Envoy expects with each denial status
+var extAuthzCodes = map[int]codes.Code{
+	401: codes.Unauthenticated,
+	403: codes.PermissionDenied,
+	500: codes.Internal,
+}
+
+func (s *extAuthzServer) Check(ctx context.Context, req *extauthzpb.CheckRequest) (*extauthzpb.CheckResponse, error) {
+	attributes := req.GetAttributes().GetRequest().GetHttp()
+	u, err := url.ParseRequestURI(attributes.GetPath())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path '%s'", attributes.GetPath())
+	}
+	r := (&http.Request{
+		Method: attributes.GetMethod(),
+		URL:    u,
+		Host:   attributes.GetHost(),
+		Header: make(http.Header),
+	}).WithContext(ctx)
+	for name, value := range attributes.GetHeaders() {
+		r.Header.Set(name, value)
+	}
+	decision := decideExtAuthz(s.acpDB, r)
+	if decision.code == 404 {
+		return nil, status.Error(codes.Unimplemented, decision.reason)
+	}
+	if decision.code == 200 {
+		return &extauthzpb.CheckResponse{
+			Status: &extauthzpb.Status{Code: int32(codes.OK)},
+			HttpResponse: &extauthzpb.CheckResponse_OkResponse{
+				OkResponse: &extauthzpb.OkHttpResponse{
+					Headers: []*extauthzpb.HeaderValueOption{
+						overwriteHeader(subjectHeader, decision.input.Subject),
+						overwriteHeader(resourceHeader, decision.input.Resource),
+						overwriteHeader(actionHeader, decision.input.Action),
+					},
+				},
+			},
+		}, nil
+	}
+
+	// Denied requests get the error body the HTTP variant would send
+	e := genericError{
+		Code:    decision.code,
+		Status:  http.StatusText(decision.code),
+		Message: errorMessages[decision.code],
+		Reason:  decision.reason,
+		Request: attributes.GetId(),
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, internalError("Error encoding ext_authz denial", err)
+	}
+	return &extauthzpb.CheckResponse{
+		Status: &extauthzpb.Status{
+			Code:    int32(extAuthzCodes[decision.code]),
+			Message: decision.reason,
+		},
+		HttpResponse: &extauthzpb.CheckResponse_DeniedResponse{
+			DeniedResponse: &extauthzpb.DeniedHttpResponse{
+				Status: &extauthzpb.HttpStatus{Code: extauthzpb.StatusCode(decision.code)},
+				Headers: []*extauthzpb.HeaderValueOption{
+					overwriteHeader("Content-Type", "application/json"),
+				},
+				Body: string(body),
+			},
+		},
+	}, nil
+}
+
+// overwriteHeader returns a header replacing any header of the same name
+func overwriteHeader(key string, value string) *extauthzpb.HeaderValueOption {
+	return &extauthzpb.HeaderValueOption{
+		Header: &extauthzpb.HeaderValue{
+			Key:   key,
+			Value: value,
+		},
+		AppendAction: extauthzpb.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/sketopb/extauthzpb"
+	"google.golang.org/grpc/codes"
+)
+
+func TestExtAuthzMapsRequestsToChecks(t *testing.T) {
+
+	apiMux, _ := newTestAPI(t, func(cfg *config.Config) {
+		cfg.ExtAuthz.Enabled = true
+		cfg.ExtAuthz.Resources = []config.ResourceTemplateConfig{
+			{Path: "/documents/{id}", Resource: "documents:{id}"},
+			{Path: "/documents", Resource: "documents"},
+		}
+		cfg.ExtAuthz.Actions = map[string]string{"GET": "read"}
+	})
+	rw := doJSON(apiMux, "PUT", "/engines/acp/ory/exact/policies", map[string]interface{}{
+		"id": "p1", "subjects": []string{"alice"}, "resources": []string{"documents:1"}, "actions": []string{"read", "delete"}, "effect": "allow",
+	})
+	if rw.Code != 200 {
+		t.Fatal(fmt.Errorf("policy upsert returned %d", rw.Code))
+	}
+
+	// The HTTP variant gets the method, path and headers of the request
+	for _, c := range []struct {
+		method  string
+		path    string
+		subject string
+		code    int
+	}{
+		{"GET", "/documents/1?fields=title", "alice", 200},
+		{"DELETE", "/documents/1", "alice", 200},
+		{"POST", "/documents/1", "alice", 403},
+		{"GET", "/documents/1", "bob", 403},
+		{"GET", "/documents", "alice", 403},
+		{"GET", "/folders/1", "alice", 403},
+		{"GET", "/documents/1", "", 401},
+	} {
+		req := httptest.NewRequest(c.method, "/ext_authz"+c.path, nil)
+		if c.subject != "" {
+			req.Header.Set("X-User-Id", c.subject)
+		}
+		rw := httptest.NewRecorder()
+		apiMux.ServeHTTP(rw, req)
+		if rw.Code != c.code {
+			t.Error(fmt.Errorf("%s %s as '%s' returned %d, expected %d", c.method, c.path, c.subject, rw.Code, c.code))
+		}
+		if c.code == 200 && rw.Header().Get("X-Sketo-Resource") != "documents:1" {
+			t.Error(fmt.Errorf("%s %s returned resource header '%s'", c.method, c.path, rw.Header().Get("X-Sketo-Resource")))
+		}
+	}
+
+	srv, err := NewGRPCServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := extauthzpb.NewAuthorizationClient(serveGRPC(t, srv))
+	check := func(subject string) *extauthzpb.CheckResponse {
+		resp, err := client.Check(context.Background(), &extauthzpb.CheckRequest{
+			Attributes: &extauthzpb.AttributeContext{
+				Request: &extauthzpb.AttributeContext_Request{
+					Http: &extauthzpb.AttributeContext_HttpRequest{
+						Id:      "req-1",
+						Method:  "GET",
+						Path:    "/documents/1?fields=title",
+						Headers: map[string]string{"x-user-id": subject},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := check("alice")
+	ok := resp.GetOkResponse()
+	if resp.Status.Code != int32(codes.OK) || ok == nil || len(ok.Headers) != 3 || ok.Headers[2].Header.Value != "read" {
+		t.Error(fmt.Errorf("gRPC check of an allowed request returned %v", resp))
+	}
+	resp = check("bob")
+	denied := resp.GetDeniedResponse()
+	if resp.Status.Code != int32(codes.PermissionDenied) || denied == nil || denied.Status.Code != extauthzpb.StatusCode_Forbidden {
+		t.Fatal(fmt.Errorf("gRPC check of a denied request returned %v", resp))
+	}
+	var e genericError
+	err = json.Unmarshal([]byte(denied.Body), &e)
+	if err != nil || e.Code != 403 || e.Request != "req-1" {
+		t.Error(fmt.Errorf("gRPC denial had body %s (%v)", denied.Body, err))
+	}
+
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/adi/sketo/auth"
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+)
+
+// forwardAuthPath is the address of nginx auth_request and Traefik
+// ForwardAuth sub-requests
+const forwardAuthPath = "/forward_auth"
+
+// forwardAuthState is the forward auth mapping in effect, swapped on config
+// reload
+type forwardAuthState struct {
+	enabled      bool
+	mapping      *requestMapping
+	subjectClaim string
+	// tokens verifies bearer tokens when the subject is one of their claims
+	tokens *auth.TokenVerifier
+}
+
+var currentForwardAuth atomic.Pointer[forwardAuthState]
+
+func newForwardAuthState(cfg config.ForwardAuthConfig) (*forwardAuthState, error) {
+	state := &forwardAuthState{
+		enabled:      cfg.Enabled,
+		subjectClaim: cfg.SubjectClaim,
+	}
+	var err error
+	state.mapping, err = newRequestMapping("forward auth", cfg.RequestMappingConfig)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Enabled && cfg.SubjectClaim != "" {
+		state.tokens, err = auth.NewTokenVerifier(cfg.JWT.Options())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// initForwardAuth sets up the forward auth mapping from the config, and again
+// on every reload
+func initForwardAuth() error {
+	state, err := newForwardAuthState(config.Get().ForwardAuth)
+	if err != nil {
+		return err
+	}
+	currentForwardAuth.Store(state)
+	config.OnReload(func(cfg *config.Config) error {
+		state, err := newForwardAuthState(cfg.ForwardAuth)
+		if err != nil {
+			return err
+		}
+		currentForwardAuth.Store(state)
+		return nil
+	})
+	return nil
+}
+
+// originalHeader returns the first of the headers nginx and Traefik pass the
+// original request in that is set
+func originalHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if value := r.Header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// subject returns who sent the request of a sub-request, or why it's unknown
+func (state *forwardAuthState) subject(r *http.Request) (string, string) {
+	if state.subjectClaim == "" {
+		header := state.mapping.cfg.SubjectHeader
+		if subject := r.Header.Get(header); subject != "" {
+			return subject, ""
+		}
+		return "", "Missing the " + header + " header"
+	}
+	claims, err := state.tokens.Claims(r)
+	if err != nil {
+		return "", err.Error()
+	}
+	if subject, ok := claims[state.subjectClaim].(string); ok && subject != "" {
+		return subject, ""
+	}
+	return "", "Missing the " + state.subjectClaim + " claim"
+}
+
+// forwardAuth answers the sub-requests of nginx auth_request, which needs
+// X-Original-URI and X-Original-Method set, and of Traefik ForwardAuth. Allowed
+// requests get 200 with the X-Sketo-* headers, others 401 or 403; neither has
+// a body. nginx must send the sub-requests with proxy_method GET
+func forwardAuth(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		state := currentForwardAuth.Load()
+		if state == nil || !state.enabled {
+			writeError(rw, r, 404, "Forward auth is disabled")
+			return
+		}
+		uri := originalHeader(r, "X-Original-URI", "X-Forwarded-Uri")
+		method := originalHeader(r, "X-Original-Method", "X-Forwarded-Method")
+		if uri == "" || method == "" {
+			writeError(rw, r, 400, "Missing the X-Original-URI and X-Original-Method or X-Forwarded-Uri and X-Forwarded-Method headers")
+			return
+		}
+		u, err := url.ParseRequestURI(uri)
+		if err != nil {
+			writeError(rw, r, 400, "Invalid original URI '"+uri+"'")
+			return
+		}
+		original := r.Clone(r.Context())
+		original.Method = method
+		original.URL = u
+
+		var decision mappingDecision
+		if subject, reason := state.subject(r); subject == "" {
+			decision = state.mapping.unauthenticated(reason)
+		} else {
+			decision = state.mapping.decide(acpDB, original, subject)
+		}
+		if decision.code == 200 {
+			decision.setHeaders(rw.Header())
+		}
+		rw.WriteHeader(decision.code)
+	}
+}
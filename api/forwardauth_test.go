@@ -0,0 +1,78 @@
+package api
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adi/sketo/config"
+)
+
+func TestForwardAuthMapsSubRequestsToChecks(t *testing.T) {
+
+	apiMux, _ := newTestAPI(t, func(cfg *config.Config) {
+		cfg.Auth.Enabled = true
+		cfg.Auth.AnonymousCheck = false
+		cfg.Auth.APIKeys = []config.APIKeyConfig{{Name: "proxy", Key: "proxy-secret", Scopes: []string{"check", "write"}}}
+		cfg.ForwardAuth.Enabled = true
+		cfg.ForwardAuth.Resources = []config.ResourceTemplateConfig{
+			{Path: "/documents/{id}", Resource: "documents:{id}"},
+		}
+	})
+	policy := `{"id":"p1","subjects":["alice"],"resources":["documents:1"],"actions":["get"],"effect":"allow"}`
+	req := httptest.NewRequest("PUT", "/engines/acp/ory/exact/policies", strings.NewReader(policy))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "proxy-secret")
+	rw := httptest.NewRecorder()
+	apiMux.ServeHTTP(rw, req)
+	if rw.Code != 200 {
+		t.Fatal(fmt.Errorf("policy upsert returned %d", rw.Code))
+	}
+
+	for _, c := range []struct {
+		headers map[string]string
+		code    int
+	}{
+		// nginx auth_request
+		{map[string]string{"X-Original-URI": "/documents/1?fields=title", "X-Original-Method": "GET", "X-User-Id": "alice"}, 200},
+		{map[string]string{"X-Original-URI": "/documents/1", "X-Original-Method": "DELETE", "X-User-Id": "alice"}, 403},
+		{map[string]string{"X-Original-URI": "/documents/2", "X-Original-Method": "GET", "X-User-Id": "alice"}, 403},
+		{map[string]string{"X-Original-URI": "/documents/1", "X-Original-Method": "GET"}, 401},
+		// Traefik ForwardAuth
+		{map[string]string{"X-Forwarded-Uri": "/documents/1", "X-Forwarded-Method": "GET", "X-User-Id": "alice"}, 200},
+		{map[string]string{"X-Forwarded-Uri": "/documents/1", "X-Forwarded-Method": "GET", "X-User-Id": "bob"}, 403},
+		{map[string]string{"X-User-Id": "alice"}, 400},
+	} {
+		req := httptest.NewRequest("GET", "/forward_auth", nil)
+		req.Header.Set("X-API-Key", "proxy-secret")
+		// The bearer token of the original request isn't sketo's
+		req.Header.Set("Authorization", "Bearer user-token")
+		for name, value := range c.headers {
+			req.Header.Set(name, value)
+		}
+		rw := httptest.NewRecorder()
+		apiMux.ServeHTTP(rw, req)
+		if rw.Code != c.code {
+			t.Error(fmt.Errorf("forward auth of %v returned %d, expected %d", c.headers, rw.Code, c.code))
+		}
+		if c.code == 200 && (rw.Header().Get("X-Sketo-Resource") != "documents:1" || rw.Body.Len() != 0) {
+			t.Error(fmt.Errorf("forward auth of %v returned resource header '%s' and body %s", c.headers, rw.Header().Get("X-Sketo-Resource"), rw.Body.String()))
+		}
+		if c.code == 403 && rw.Body.Len() != 0 {
+			t.Error(fmt.Errorf("forward auth denial of %v has body %s", c.headers, rw.Body.String()))
+		}
+	}
+
+	// Proxies still need credentials with the check scope
+	req = httptest.NewRequest("GET", "/forward_auth", nil)
+	req.Header.Set("X-Original-URI", "/documents/1")
+	req.Header.Set("X-Original-Method", "GET")
+	req.Header.Set("X-User-Id", "alice")
+	rw = httptest.NewRecorder()
+	apiMux.ServeHTTP(rw, req)
+	if rw.Code != 401 || rw.Body.Len() == 0 {
+		t.Error(fmt.Errorf("forward auth without credentials returned %d", rw.Code))
+	}
+
+}
@@ -0,0 +1,699 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/adi/sketo/auth"
+	"github.com/adi/sketo/cluster"
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+	"github.com/adi/sketo/replication"
+	"github.com/adi/sketo/sketopb"
+	"github.com/adi/sketo/sketopb/extauthzpb"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// acpStore is the store opened by Init, also served by the gRPC API
+var acpStore db.Store
+
+// grpcScopes are the scopes needed to call the methods of the gRPC API.
+// Health checking and reflection are public; methods not listed here need the
+// admin scope
+var grpcScopes = map[string]auth.Scope{
+	sketopb.Sketo_Check_FullMethodName:        auth.ScopeCheck,
+	sketopb.Sketo_BatchCheck_FullMethodName:   auth.ScopeCheck,
+	sketopb.Sketo_GetPolicy_FullMethodName:    auth.ScopeRead,
+	sketopb.Sketo_ListPolicies_FullMethodName: auth.ScopeRead,
+	sketopb.Sketo_UpsertPolicy_FullMethodName: auth.ScopeWrite,
+	sketopb.Sketo_DeletePolicy_FullMethodName: auth.ScopeWrite,
+	sketopb.Sketo_GetRole_FullMethodName:      auth.ScopeRead,
+	sketopb.Sketo_ListRoles_FullMethodName:    auth.ScopeRead,
+	sketopb.Sketo_UpsertRole_FullMethodName:   auth.ScopeWrite,
+	sketopb.Sketo_DeleteRole_FullMethodName:   auth.ScopeWrite,
+	sketopb.Sketo_Watch_FullMethodName:        auth.ScopeRead,
+
+	extauthzpb.Authorization_Check_FullMethodName: auth.ScopeCheck,
+}
+
+// grpcScope returns the scope needed to call method, or "" for public methods
+func grpcScope(method string) auth.Scope {
+	if strings.HasPrefix(method, "/grpc.health.v1.") || strings.HasPrefix(method, "/grpc.reflection.") {
+		return ""
+	}
+	if scope, ok := grpcScopes[method]; ok {
+		return scope
+	}
+	return auth.ScopeAdmin
+}
+
+// grpcRequest returns an HTTP request carrying the credentials of the gRPC
+// call of ctx, for the authenticator
+func grpcRequest(ctx context.Context) *http.Request {
+	r := &http.Request{
+		Header: make(http.Header),
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, name := range []string{"authorization", "x-api-key"} {
+		for _, value := range md.Get(name) {
+			r.Header.Add(name, value)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state := info.State
+			r.TLS = &state
+		}
+	}
+	return r.WithContext(ctx)
+}
+
+// authorizeGRPC checks that the caller of method has its scope, returning the
+// context to call it with, unless authentication is disabled
+func authorizeGRPC(ctx context.Context, method string) (context.Context, error) {
+	state := currentAuth.Load()
+	if state == nil || !state.enabled {
+		return ctx, nil
+	}
+	scope := grpcScope(method)
+	if scope == "" {
+		return ctx, nil
+	}
+	principal, err := state.authenticator.Authenticate(grpcRequest(ctx))
+	if err == auth.ErrNoCredentials && scope == auth.ScopeCheck && state.anonymousCheck {
+		return ctx, nil
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if !principal.Has(scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s lacks the %s scope", principal.Name, scope)
+	}
+	return auth.WithPrincipal(ctx, principal), nil
+}
+
+// grpcStream overrides the context of a server stream
+type grpcStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcStream) Context() context.Context {
+	return s.ctx
+}
+
+// unaryInterceptor authorizes and records the latency of unary calls; writes
+// hold the write barrier like those of the HTTP API
+func unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	ctx, err := authorizeGRPC(ctx, info.FullMethod)
+	var resp interface{}
+	if err == nil {
+		if grpcScope(info.FullMethod) == auth.ScopeWrite {
+			writeBarrier.RLock()
+			defer writeBarrier.RUnlock()
+		}
+		resp, err = handler(ctx, req)
+	}
+	grpcRequestDuration.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// streamInterceptor authorizes and records the duration of streaming calls
+func streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, err := authorizeGRPC(ss.Context(), info.FullMethod)
+	if err == nil {
+		err = handler(srv, &grpcStream{
+			ServerStream: ss,
+			ctx:          ctx,
+		})
+	}
+	grpcRequestDuration.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// NewGRPCServer creates a gRPC server for the store opened by Init, with the
+// sketo service, Envoy's ext_authz service, health checking and reflection
+func NewGRPCServer(opts ...grpc.ServerOption) (*grpc.Server, error) {
+	if acpStore == nil {
+		return nil, fmt.Errorf("the API must be initialized before the gRPC server")
+	}
+	opts = append(opts, grpc.ChainUnaryInterceptor(unaryInterceptor), grpc.ChainStreamInterceptor(streamInterceptor))
+	srv := grpc.NewServer(opts...)
+	sketopb.RegisterSketoServer(srv, &grpcServer{
+		acpDB:       acpStore,
+		badgerDB:    BadgerDB,
+		follower:    ReplicationFollower,
+		clusterNode: ClusterNode,
+	})
+	extauthzpb.RegisterAuthorizationServer(srv, &extAuthzServer{
+		acpDB: acpStore,
+	})
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(srv, healthServer)
+	go reportGRPCHealth(healthServer)
+	reflection.Register(srv)
+	return srv, nil
+}
+
+// reportGRPCHealth keeps the health of the gRPC server in line with
+// /health/ready
+func reportGRPCHealth(healthServer *health.Server) {
+	for ; ; time.Sleep(1 * time.Second) {
+		servingStatus := healthpb.HealthCheckResponse_SERVING
+		if len(notReady()) > 0 {
+			servingStatus = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		healthServer.SetServingStatus("", servingStatus)
+		healthServer.SetServingStatus(sketopb.Sketo_ServiceDesc.ServiceName, servingStatus)
+	}
+}
+
+// grpcServer implements the gRPC API with the code of the HTTP handlers
+type grpcServer struct {
+	sketopb.UnimplementedSketoServer
+	acpDB db.Store
+	// badgerDB is set when using the badger backend, which Watch needs
+	badgerDB *db.DB
+	// follower or clusterNode are set when writes may go to a leader
+	follower    *replication.Follower
+	clusterNode *cluster.Node
+}
+
+// checkFlavor rejects flavors other than exact, glob and regex
+func checkFlavor(flavor string) error {
+	if flavor != "exact" && flavor != "glob" && flavor != "regex" {
+		return status.Errorf(codes.InvalidArgument, "invalid flavor '%s' (expected exact, glob or regex)", flavor)
+	}
+	return nil
+}
+
+// leaderForwarder sends requests to the API of the leader
+type leaderForwarder interface {
+	LeaderRequest(ctx context.Context, method string, path string, header http.Header, body io.Reader) (*http.Response, error)
+}
+
+// writeTarget returns where writes go like the middlewares of the HTTP API:
+// nil when they are made locally, the leader to forward them to otherwise.
+// Read-only replicas not forwarding writes reject them
+func (s *grpcServer) writeTarget() (leaderForwarder, error) {
+	if s.follower != nil {
+		if !config.Get().Replication.ForwardWrites {
+			return nil, status.Error(codes.Unavailable, "Read-only replica; send writes to the leader")
+		}
+		return s.follower, nil
+	}
+	if s.clusterNode != nil && !s.clusterNode.IsLeader() {
+		return s.clusterNode, nil
+	}
+	return nil, nil
+}
+
+// forwardWrite sends a write to the same endpoint of the HTTP API of the
+// leader, with the credentials of the gRPC call, decoding the response into
+// ret unless nil
+func forwardWrite(ctx context.Context, leader leaderForwarder, method string, path string, body interface{}, ret interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return internalError("Error encoding write", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	resp, err := leader.LeaderRequest(ctx, method, path, grpcRequest(ctx).Header, reqBody)
+	if err != nil {
+		log.Printf("Error forwarding write to the leader: %v\n", err)
+		return status.Error(codes.Unavailable, "Couldn't forward the write to the leader")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var genErr genericError
+		json.NewDecoder(resp.Body).Decode(&genErr)
+		msg := genErr.Reason
+		if msg == "" {
+			msg = fmt.Sprintf("The leader answered %d", resp.StatusCode)
+		}
+		code := codes.Internal
+		switch resp.StatusCode {
+		case 400:
+			code = codes.InvalidArgument
+		case 401:
+			code = codes.Unauthenticated
+		case 403:
+			code = codes.PermissionDenied
+		case 409:
+			code = codes.Aborted
+		case 502, 503:
+			code = codes.Unavailable
+		}
+		return status.Error(code, msg)
+	}
+	if ret != nil {
+		err = json.NewDecoder(resp.Body).Decode(ret)
+		if err != nil {
+			return internalError("Error decoding the response of the leader", err)
+		}
+	}
+	return nil
+}
+
+// internalError logs err and hides it from the caller
+func internalError(msg string, err error) error {
+	log.Printf("%s: %v\n", msg, err)
+	return status.Error(codes.Internal, "An internal server error occurred, please contact the system administrator")
+}
+
+// check runs a check like the allowed endpoint, counting it the same way
+func (s *grpcServer) check(ctx context.Context, req *sketopb.CheckRequest) (bool, error) {
+	atomic.AddInt64(&CntAllowRequestsSinceStart, 1)
+	err := checkFlavor(req.Flavor)
+	if err != nil {
+		atomic.AddInt64(&CntAllowFailuresSinceStart, 1)
+		return false, err
+	}
+	allowed, err := checkInput(ctx, s.acpDB, req.Flavor, oryAccessControlPolicyAllowedInput{
+		Subject:  req.Subject,
+		Resource: req.Resource,
+		Action:   req.Action,
+		Context:  req.Context.AsMap(),
+	})
+	if err != nil {
+		return false, internalError("Error checking ACPs", err)
+	}
+	return allowed, nil
+}
+
+func (s *grpcServer) Check(ctx context.Context, req *sketopb.CheckRequest) (*sketopb.CheckResponse, error) {
+	allowed, err := s.check(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &sketopb.CheckResponse{
+		Allowed: allowed,
+	}, nil
+}
+
+func (s *grpcServer) BatchCheck(ctx context.Context, req *sketopb.BatchCheckRequest) (*sketopb.BatchCheckResponse, error) {
+	if maxSize := config.Get().Batch.MaxSize; len(req.Checks) > maxSize {
+		return nil, status.Errorf(codes.InvalidArgument, "Too many checks (%d, at most %d)", len(req.Checks), maxSize)
+	}
+	resp := &sketopb.BatchCheckResponse{
+		Results: make([]*sketopb.CheckResponse, len(req.Checks)),
+	}
+	for i, check := range req.Checks {
+		allowed, err := s.check(ctx, check)
+		if err != nil {
+			return nil, err
+		}
+		resp.Results[i] = &sketopb.CheckResponse{
+			Allowed: allowed,
+		}
+	}
+	return resp, nil
+}
+
+func policyToProto(policy oryAccessControlPolicy) (*sketopb.Policy, error) {
+	conditions, err := structpb.NewStruct(policy.Conditions)
+	if err != nil {
+		return nil, err
+	}
+	return &sketopb.Policy{
+		Id:          policy.ID,
+		Description: policy.Description,
+		Subjects:    policy.Subjects,
+		Resources:   policy.Resources,
+		Actions:     policy.Actions,
+		Effect:      policy.Effect,
+		Conditions:  conditions,
+	}, nil
+}
+
+func policyFromProto(policy *sketopb.Policy) oryAccessControlPolicy {
+	return oryAccessControlPolicy{
+		ID:          policy.Id,
+		Description: policy.Description,
+		Subjects:    policy.Subjects,
+		Resources:   policy.Resources,
+		Actions:     policy.Actions,
+		Effect:      policy.Effect,
+		Conditions:  policy.Conditions.AsMap(),
+	}
+}
+
+func roleToProto(role oryAccessControlPolicyRole) *sketopb.Role {
+	return &sketopb.Role{
+		Id:          role.ID,
+		Description: role.Description,
+		Members:     role.Members,
+	}
+}
+
+func roleFromProto(role *sketopb.Role) oryAccessControlPolicyRole {
+	return oryAccessControlPolicyRole{
+		ID:          role.Id,
+		Description: role.Description,
+		Members:     role.Members,
+	}
+}
+
+// listLimit maps the default limit of 0 to the one of the HTTP API
+func listLimit(limit int64) int64 {
+	if limit == 0 {
+		return -1
+	}
+	return limit
+}
+
+// getDoc decodes the doc of id under prefix into doc
+func getDoc(acpDB db.Store, prefix string, id string, doc interface{}) error {
+	err := acpDB.Get(prefix, docSuffix(id), func(value []byte) error {
+		return json.Unmarshal(value, doc)
+	})
+	if err == db.ErrKeyNotFound {
+		return status.Error(codes.NotFound, "Not found")
+	}
+	if err != nil {
+		return internalError("Error getting doc", err)
+	}
+	return nil
+}
+
+func (s *grpcServer) GetPolicy(ctx context.Context, req *sketopb.GetPolicyRequest) (*sketopb.Policy, error) {
+	err := checkFlavor(req.Flavor)
+	if err != nil {
+		return nil, err
+	}
+	var policy oryAccessControlPolicy
+	err = getDoc(s.acpDB, policyBasePrefix(req.Flavor), req.Id, &policy)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := policyToProto(policy)
+	if err != nil {
+		return nil, internalError("Error encoding ACP", err)
+	}
+	return ret, nil
+}
+
+func (s *grpcServer) ListPolicies(ctx context.Context, req *sketopb.ListPoliciesRequest) (*sketopb.ListPoliciesResponse, error) {
+	err := checkFlavor(req.Flavor)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := listPolicies(ctx, s.acpDB, req.Flavor, req.Subject, req.Resource, req.Action, req.Offset, listLimit(req.Limit))
+	if err != nil {
+		return nil, internalError("Error listing ACPs", err)
+	}
+	resp := &sketopb.ListPoliciesResponse{
+		Policies: make([]*sketopb.Policy, len(policies)),
+	}
+	for i, policy := range policies {
+		resp.Policies[i], err = policyToProto(policy)
+		if err != nil {
+			return nil, internalError("Error encoding ACP", err)
+		}
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) UpsertPolicy(ctx context.Context, req *sketopb.UpsertPolicyRequest) (*sketopb.Policy, error) {
+	err := checkFlavor(req.Flavor)
+	if err != nil {
+		return nil, err
+	}
+	leader, err := s.writeTarget()
+	if err != nil {
+		return nil, err
+	}
+	if req.Policy == nil {
+		return nil, status.Error(codes.InvalidArgument, "Missing policy")
+	}
+	policy := policyFromProto(req.Policy)
+	if policy.Effect != "allow" && policy.Effect != "deny" {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid effect '%s'", policy.Effect)
+	}
+	for _, patterns := range [][]string{policy.Subjects, policy.Resources, policy.Actions} {
+		err = validatePatterns(req.Flavor, patterns)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if policy.ID == "" {
+		genID, err := uuid.NewUUID()
+		if err != nil {
+			return nil, internalError("Error generating ID", err)
+		}
+		policy.ID = genID.String()
+	}
+
+	if leader != nil {
+		err = forwardWrite(ctx, leader, "PUT", "/engines/acp/ory/"+req.Flavor+"/policies", policy, &policy)
+	} else {
+		_, err = upsertPolicies(s.acpDB, req.Flavor, []oryAccessControlPolicy{policy}, true)
+		if err != nil {
+			err = internalError("Error upserting ACP", err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret, err := policyToProto(policy)
+	if err != nil {
+		return nil, internalError("Error encoding ACP", err)
+	}
+	return ret, nil
+}
+
+func (s *grpcServer) DeletePolicy(ctx context.Context, req *sketopb.DeletePolicyRequest) (*emptypb.Empty, error) {
+	err := checkFlavor(req.Flavor)
+	if err != nil {
+		return nil, err
+	}
+	leader, err := s.writeTarget()
+	if err != nil {
+		return nil, err
+	}
+	if leader != nil {
+		err = forwardWrite(ctx, leader, "DELETE", "/engines/acp/ory/"+req.Flavor+"/policies/"+url.PathEscape(req.Id), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &emptypb.Empty{}, nil
+	}
+	_, err = deletePolicy(s.acpDB, req.Flavor, req.Id)
+	if err != nil {
+		return nil, internalError("Error deleting ACP", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *grpcServer) GetRole(ctx context.Context, req *sketopb.GetRoleRequest) (*sketopb.Role, error) {
+	err := checkFlavor(req.Flavor)
+	if err != nil {
+		return nil, err
+	}
+	var role oryAccessControlPolicyRole
+	err = getDoc(s.acpDB, roleBasePrefix(req.Flavor), req.Id, &role)
+	if err != nil {
+		return nil, err
+	}
+	return roleToProto(role), nil
+}
+
+func (s *grpcServer) ListRoles(ctx context.Context, req *sketopb.ListRolesRequest) (*sketopb.ListRolesResponse, error) {
+	err := checkFlavor(req.Flavor)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := listRoles(ctx, s.acpDB, req.Flavor, req.Member, req.Offset, listLimit(req.Limit))
+	if err != nil {
+		return nil, internalError("Error listing Roles", err)
+	}
+	resp := &sketopb.ListRolesResponse{
+		Roles: make([]*sketopb.Role, len(roles)),
+	}
+	for i, role := range roles {
+		resp.Roles[i] = roleToProto(role)
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) UpsertRole(ctx context.Context, req *sketopb.UpsertRoleRequest) (*sketopb.Role, error) {
+	err := checkFlavor(req.Flavor)
+	if err != nil {
+		return nil, err
+	}
+	leader, err := s.writeTarget()
+	if err != nil {
+		return nil, err
+	}
+	if req.Role == nil {
+		return nil, status.Error(codes.InvalidArgument, "Missing role")
+	}
+	role := roleFromProto(req.Role)
+	if role.ID == "" {
+		genID, err := uuid.NewUUID()
+		if err != nil {
+			return nil, internalError("Error generating ID", err)
+		}
+		role.ID = genID.String()
+	}
+
+	if leader != nil {
+		err = forwardWrite(ctx, leader, "PUT", "/engines/acp/ory/"+req.Flavor+"/roles", role, &role)
+	} else {
+		_, err = upsertRoles(s.acpDB, req.Flavor, []oryAccessControlPolicyRole{role}, true)
+		if err != nil {
+			err = internalError("Error upserting Role", err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return roleToProto(role), nil
+}
+
+func (s *grpcServer) DeleteRole(ctx context.Context, req *sketopb.DeleteRoleRequest) (*emptypb.Empty, error) {
+	err := checkFlavor(req.Flavor)
+	if err != nil {
+		return nil, err
+	}
+	leader, err := s.writeTarget()
+	if err != nil {
+		return nil, err
+	}
+	if leader != nil {
+		err = forwardWrite(ctx, leader, "DELETE", "/engines/acp/ory/"+req.Flavor+"/roles/"+url.PathEscape(req.Id), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &emptypb.Empty{}, nil
+	}
+	_, err = deleteRole(s.acpDB, req.Flavor, req.Id)
+	if err != nil {
+		return nil, internalError("Error deleting Role", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// watchEvent decodes a change to the doc of a policy or role into an event,
+// returning nil for other keys, such as indexes
+func watchEvent(change db.Change) (*sketopb.WatchEvent, error) {
+	parts := strings.SplitN(string(change.Key), "/", 4)
+	if len(parts) != 4 || !strings.HasSuffix(parts[3], "/") {
+		return nil, nil
+	}
+	flavor, kind := parts[0], parts[1]
+	if checkFlavor(flavor) != nil || parts[2] != "i" {
+		return nil, nil
+	}
+	event := &sketopb.WatchEvent{
+		Flavor:  flavor,
+		Id:      strings.TrimSuffix(parts[3], "/"),
+		Deleted: change.Deleted,
+		Version: change.Version,
+	}
+	switch kind {
+	case "po":
+		event.Kind = "policies"
+		if !change.Deleted {
+			var policy oryAccessControlPolicy
+			err := json.Unmarshal(change.Value, &policy)
+			if err != nil {
+				return nil, err
+			}
+			ret, err := policyToProto(policy)
+			if err != nil {
+				return nil, err
+			}
+			event.Document = &sketopb.WatchEvent_Policy{
+				Policy: ret,
+			}
+		}
+	case "ro":
+		event.Kind = "roles"
+		if !change.Deleted {
+			var role oryAccessControlPolicyRole
+			err := json.Unmarshal(change.Value, &role)
+			if err != nil {
+				return nil, err
+			}
+			event.Document = &sketopb.WatchEvent_Role{
+				Role: roleToProto(role),
+			}
+		}
+	default:
+		return nil, nil
+	}
+	return event, nil
+}
+
+func (s *grpcServer) Watch(req *sketopb.WatchRequest, stream sketopb.Sketo_WatchServer) error {
+	if s.badgerDB == nil {
+		return status.Error(codes.Unimplemented, "Watching needs the badger storage backend")
+	}
+	flavors := make(map[string]bool)
+	for _, flavor := range req.Flavors {
+		err := checkFlavor(flavor)
+		if err != nil {
+			return err
+		}
+		flavors[flavor] = true
+	}
+
+	err := s.badgerDB.Subscribe(stream.Context(), func(version uint64) error {
+		// Let the caller know the writes from now on are streamed
+		return stream.SendHeader(metadata.Pairs("x-sketo-watch-version", fmt.Sprint(version)))
+	}, func(changes []db.Change) error {
+		for _, change := range changes {
+			event, err := watchEvent(change)
+			if err != nil {
+				log.Printf("Error decoding change to %s: %v\n", change.Key, err)
+				continue
+			}
+			if event == nil || (len(flavors) > 0 && !flavors[event.Flavor]) {
+				continue
+			}
+			err = stream.Send(event)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if stream.Context().Err() != nil {
+		return status.FromContextError(stream.Context().Err()).Err()
+	}
+	if err == db.ErrDropped {
+		return status.Error(codes.Aborted, "Database dropped; watch again after reading the policies and roles")
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return internalError("Error watching changes", err)
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+	"github.com/adi/sketo/replication"
+	"github.com/adi/sketo/sketopb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serveGRPC serves srv in memory until the test ends, returning a connection
+// to it
+func serveGRPC(t *testing.T, srv *grpc.Server) *grpc.ClientConn {
+	ln := bufconn.Listen(1 << 20)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.DialContext(context.Background(), "bufconn", grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		return ln.DialContext(ctx)
+	}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCSharesTheHTTPAPI(t *testing.T) {
+
+	apiMux, _ := newTestAPI(t, nil)
+	srv, err := NewGRPCServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := serveGRPC(t, srv)
+	client := sketopb.NewSketoClient(conn)
+	ctx := context.Background()
+
+	for _, policy := range []*sketopb.Policy{
+		{Id: "p1", Subjects: []string{"alice", "bob"}, Resources: []string{"doc"}, Actions: []string{"read"}, Effect: "allow"},
+		{Id: "p2", Subjects: []string{"bob"}, Resources: []string{"doc"}, Actions: []string{"read"}, Effect: "deny"},
+	} {
+		_, err = client.UpsertPolicy(ctx, &sketopb.UpsertPolicyRequest{Flavor: "exact", Policy: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Policies written over gRPC are checked by the HTTP API and vice versa
+	rw := doJSON(apiMux, "POST", "/engines/acp/ory/exact/allowed", map[string]string{"subject": "alice", "resource": "doc", "action": "read"})
+	if rw.Code != 200 || rw.Body.String() != "{\"allowed\":true}\n" {
+		t.Error(fmt.Errorf("HTTP check of a gRPC policy returned %d %s", rw.Code, rw.Body.String()))
+	}
+	rw = doJSON(apiMux, "PUT", "/engines/acp/ory/exact/roles", map[string]interface{}{"id": "r1", "members": []string{"alice"}})
+	if rw.Code != 200 {
+		t.Fatal(fmt.Errorf("role upsert returned %d", rw.Code))
+	}
+	role, err := client.GetRole(ctx, &sketopb.GetRoleRequest{Flavor: "exact", Id: "r1"})
+	if err != nil || len(role.Members) != 1 || role.Members[0] != "alice" {
+		t.Error(fmt.Errorf("GetRole returned %v (%v)", role, err))
+	}
+
+	batch, err := client.BatchCheck(ctx, &sketopb.BatchCheckRequest{
+		Checks: []*sketopb.CheckRequest{
+			{Flavor: "exact", Subject: "alice", Resource: "doc", Action: "read"},
+			{Flavor: "exact", Subject: "bob", Resource: "doc", Action: "read"},
+			{Flavor: "exact", Subject: "alice", Resource: "doc"},
+		},
+	})
+	if err != nil || len(batch.Results) != 3 || !batch.Results[0].Allowed || batch.Results[1].Allowed || batch.Results[2].Allowed {
+		t.Error(fmt.Errorf("BatchCheck returned %v (%v)", batch, err))
+	}
+
+	list, err := client.ListPolicies(ctx, &sketopb.ListPoliciesRequest{Flavor: "exact", Subject: "alice"})
+	if err != nil || len(list.Policies) != 1 || list.Policies[0].Id != "p1" {
+		t.Error(fmt.Errorf("ListPolicies returned %v (%v)", list, err))
+	}
+
+	_, err = client.DeletePolicy(ctx, &sketopb.DeletePolicyRequest{Flavor: "exact", Id: "p2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check, err := client.Check(ctx, &sketopb.CheckRequest{Flavor: "exact", Subject: "bob", Resource: "doc", Action: "read"})
+	if err != nil || !check.Allowed {
+		t.Error(fmt.Errorf("Check after deleting the deny policy returned %v (%v)", check, err))
+	}
+
+	for _, c := range []struct {
+		call func() error
+		code codes.Code
+	}{
+		{func() error { _, err := client.Check(ctx, &sketopb.CheckRequest{Flavor: "fuzzy"}); return err }, codes.InvalidArgument},
+		{func() error {
+			_, err := client.GetPolicy(ctx, &sketopb.GetPolicyRequest{Flavor: "exact", Id: "p2"})
+			return err
+		}, codes.NotFound},
+		{func() error {
+			_, err := client.UpsertPolicy(ctx, &sketopb.UpsertPolicyRequest{Flavor: "exact", Policy: &sketopb.Policy{Effect: "maybe"}})
+			return err
+		}, codes.InvalidArgument},
+		{func() error {
+			stream, err := client.Watch(ctx, &sketopb.WatchRequest{})
+			if err == nil {
+				_, err = stream.Recv()
+			}
+			return err
+		}, codes.Unimplemented},
+	} {
+		err := c.call()
+		if status.Code(err) != c.code {
+			t.Error(fmt.Errorf("expected %s, got %v", c.code, err))
+		}
+	}
+
+	health := healthpb.NewHealthClient(conn)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: "sketo.v1.Sketo"})
+		if err == nil && resp.Status == healthpb.HealthCheckResponse_SERVING {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(fmt.Errorf("gRPC health returned %v (%v)", resp, err))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+}
+
+func TestGRPCBatchCheckMaxSize(t *testing.T) {
+
+	newTestAPI(t, func(cfg *config.Config) {
+		cfg.Batch.MaxSize = 2
+	})
+	srv, err := NewGRPCServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := sketopb.NewSketoClient(serveGRPC(t, srv))
+
+	checks := make([]*sketopb.CheckRequest, 0)
+	for i := 0; i < 3; i++ {
+		checks = append(checks, &sketopb.CheckRequest{Flavor: "exact", Subject: fmt.Sprintf("s%d", i), Resource: "doc", Action: "read"})
+	}
+	_, err = client.BatchCheck(context.Background(), &sketopb.BatchCheckRequest{Checks: checks})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Error(fmt.Errorf("oversized BatchCheck returned %v", err))
+	}
+	batch, err := client.BatchCheck(context.Background(), &sketopb.BatchCheckRequest{Checks: checks[:2]})
+	if err != nil || len(batch.Results) != 2 {
+		t.Error(fmt.Errorf("BatchCheck of the max size returned %v (%v)", batch, err))
+	}
+
+}
+
+func TestGRPCForwardsWritesOfReplicas(t *testing.T) {
+
+	newTestAPI(t, nil)
+	var requests []string
+	leader := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("X-Api-Key"))
+		if r.Method == "DELETE" {
+			writeError(rw, r, 403, "Not yours")
+			return
+		}
+		var body oryAccessControlPolicy
+		json.NewDecoder(r.Body).Decode(&body)
+		body.Description = "written by the leader"
+		writeJSON(rw, 200, body)
+	}))
+	defer leader.Close()
+	follower, err := replication.NewFollower(nil, leader.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &grpcServer{
+		acpDB:    acpStore,
+		follower: follower,
+	}
+
+	// Replicas not forwarding writes refuse them
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "secret"))
+	policy := &sketopb.Policy{Id: "p1", Subjects: []string{"alice"}, Resources: []string{"doc"}, Actions: []string{"read"}, Effect: "allow"}
+	_, err = srv.UpsertPolicy(ctx, &sketopb.UpsertPolicyRequest{Flavor: "exact", Policy: policy})
+	if status.Code(err) != codes.Unavailable || len(requests) != 0 {
+		t.Error(fmt.Errorf("write on a read-only replica returned %v after %d requests", err, len(requests)))
+	}
+
+	// Others send them to the leader with the credentials of the call
+	config.Get().Replication.ForwardWrites = true
+	ret, err := srv.UpsertPolicy(ctx, &sketopb.UpsertPolicyRequest{Flavor: "exact", Policy: policy})
+	if err != nil || ret.Description != "written by the leader" {
+		t.Error(fmt.Errorf("forwarded UpsertPolicy returned %v (%v)", ret, err))
+	}
+	_, err = srv.DeletePolicy(ctx, &sketopb.DeletePolicyRequest{Flavor: "exact", Id: "p 1"})
+	if status.Code(err) != codes.PermissionDenied || status.Convert(err).Message() != "Not yours" {
+		t.Error(fmt.Errorf("refused forwarded DeletePolicy returned %v", err))
+	}
+	expected := []string{"PUT /engines/acp/ory/exact/policies secret", "DELETE /engines/acp/ory/exact/policies/p 1 secret"}
+	if fmt.Sprint(requests) != fmt.Sprint(expected) {
+		t.Error(fmt.Errorf("leader got %v, expected %v", requests, expected))
+	}
+	_, err = srv.GetPolicy(ctx, &sketopb.GetPolicyRequest{Flavor: "exact", Id: "p1"})
+	if status.Code(err) != codes.NotFound {
+		t.Error(fmt.Errorf("forwarded write was stored locally (%v)", err))
+	}
+
+}
+
+func TestGRPCWatchStreamsWrites(t *testing.T) {
+
+	badgerDB, err := db.NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer badgerDB.Close()
+	srv := grpc.NewServer()
+	sketopb.RegisterSketoServer(srv, &grpcServer{
+		acpDB:    badgerDB,
+		badgerDB: badgerDB,
+	})
+	client := sketopb.NewSketoClient(serveGRPC(t, srv))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := client.Watch(ctx, &sketopb.WatchRequest{Flavors: []string{"glob"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Writes are streamed once the header is received
+	_, err = stream.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.UpsertPolicy(ctx, &sketopb.UpsertPolicyRequest{Flavor: "exact", Policy: &sketopb.Policy{Id: "p1", Effect: "allow"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.UpsertRole(ctx, &sketopb.UpsertRoleRequest{Flavor: "glob", Role: &sketopb.Role{Id: "r1", Members: []string{"a*"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.DeleteRole(ctx, &sketopb.DeleteRoleRequest{Flavor: "glob", Id: "r1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := stream.Recv()
+	if err != nil || event.Flavor != "glob" || event.Kind != "roles" || event.Id != "r1" || event.Deleted || event.GetRole().GetMembers()[0] != "a*" {
+		t.Fatal(fmt.Errorf("expected the glob role written, got %v (%v)", event, err))
+	}
+	event, err = stream.Recv()
+	if err != nil || event.Id != "r1" || !event.Deleted || event.Document != nil {
+		t.Fatal(fmt.Errorf("expected the glob role deleted, got %v (%v)", event, err))
+	}
+
+}
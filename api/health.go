@@ -1,49 +1,58 @@
 package api
 
 import (
-	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/adi/sketo/config"
 	"github.com/adi/sketo/db"
 )
 
-func alive(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func alive(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		rw.Header().Add("Content-Type", "application/json")
-		jsonEnc := json.NewEncoder(rw)
-		rw.WriteHeader(200)
-		err := jsonEnc.Encode(healthStatus{
+		writeJSON(rw, 200, healthStatus{
 			Status: "ok",
 		})
 		// Alternative return for future use:
-		// rw.WriteHeader(500)
-		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
-			return
-		}
+		// writeError(rw, r, 500, "")
 	}
 }
 
-func ready(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func ready(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		rw.Header().Add("Content-Type", "application/json")
-		jsonEnc := json.NewEncoder(rw)
-		rw.WriteHeader(200)
-		err := jsonEnc.Encode(healthStatus{
+		if errors := notReady(); len(errors) > 0 {
+			writeJSON(rw, 503, healthNotReadyStatus{
+				Errors: errors,
+			})
+			return
+		}
+		writeJSON(rw, 200, healthStatus{
 			Status: "ok",
 		})
 		// Alternative return for future use:
-		// rw.WriteHeader(503)
-		// err := jsonEnc.Encode(healthNotReadyStatus{
+		// writeJSON(rw, 503, healthNotReadyStatus{
 		// 	Errors: map[string]string{
 		// 		"database": "Unreachable",
 		// 	},
 		// })
-		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
-			return
+	}
+}
+
+// notReady returns why this node can't serve requests yet, by component
+func notReady() map[string]string {
+	if ClusterNode != nil && !ClusterNode.HasLeader() {
+		return map[string]string{
+			"cluster": "No cluster leader",
+		}
+	}
+	if ReplicationFollower != nil {
+		lag, caughtUp := ReplicationFollower.Lag()
+		if !caughtUp || lag > config.Get().Replication.MaxLag {
+			return map[string]string{
+				"replication": fmt.Sprintf("Lagging behind leader by %s", lag.Round(time.Second)),
+			}
 		}
 	}
+	return nil
 }
@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/adi/sketo/db"
 	"github.com/gobwas/glob"
 )
 
@@ -21,6 +22,18 @@ func policyFilter(subject, resource, action string) string {
 	return fmt.Sprintf("s/%s/r/%s/a/%s/", subject, resource, action)
 }
 
+// policyIndex is the lookup of policyFilter in the exact indexes
+func policyIndex(subject, resource, action string) indexFilter {
+	return indexFilter{
+		key: policyFilter(subject, resource, action),
+		fields: db.IndexFilter{
+			Subject:  subject,
+			Resource: resource,
+			Action:   action,
+		},
+	}
+}
+
 func policySuffix(subject, resource, action, id string) string {
 	return fmt.Sprintf("s/%s/r/%s/a/%s/i/%s/", subject, resource, action, id)
 }
@@ -33,6 +46,16 @@ func roleFilter(member string) string {
 	return fmt.Sprintf("m/%s/", member)
 }
 
+// roleIndex is the lookup of roleFilter in the exact indexes
+func roleIndex(member string) indexFilter {
+	return indexFilter{
+		key: roleFilter(member),
+		fields: db.IndexFilter{
+			Member: member,
+		},
+	}
+}
+
 func roleSuffix(member, id string) string {
 	return fmt.Sprintf("m/%s/i/%s/", member, id)
 }
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+)
+
+// ImportOptions ..
+type ImportOptions struct {
+	// KetoURL is the base URL of the Keto server to page through
+	KetoURL string
+	// File is a JSON dump to read instead, shaped as
+	// {"<flavor>": {"policies": [...], "roles": [...]}}
+	File string
+	// Flavors to import
+	Flavors []string
+	// PageSize is the number of items fetched and written at a time
+	PageSize int64
+	// StateFile records how far each flavor got, so an import can be resumed
+	StateFile string
+	// Resume continues from the offsets recorded in StateFile
+	Resume bool
+	// DryRun reads everything without writing to the store or the state file
+	DryRun bool
+}
+
+// importSource is where Keto data gets read from, a page at a time
+type importSource interface {
+	policies(flavor string, offset int64, limit int64) ([]oryAccessControlPolicy, error)
+	roles(flavor string, offset int64, limit int64) ([]oryAccessControlPolicyRole, error)
+}
+
+type ketoSource struct {
+	url    string
+	client *http.Client
+}
+
+func (s *ketoSource) page(flavor string, kind string, offset int64, limit int64, items interface{}) error {
+	url := fmt.Sprintf("%s/engines/acp/ory/%s/%s?offset=%d&limit=%d", strings.TrimSuffix(s.url, "/"), flavor, kind, offset, limit)
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("GET %s returned %d: %s", url, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(items)
+}
+
+func (s *ketoSource) policies(flavor string, offset int64, limit int64) ([]oryAccessControlPolicy, error) {
+	var items []oryAccessControlPolicy
+	err := s.page(flavor, "policies", offset, limit, &items)
+	return items, err
+}
+
+func (s *ketoSource) roles(flavor string, offset int64, limit int64) ([]oryAccessControlPolicyRole, error) {
+	var items []oryAccessControlPolicyRole
+	err := s.page(flavor, "roles", offset, limit, &items)
+	return items, err
+}
+
+type ketoDump map[string]struct {
+	Policies []oryAccessControlPolicy     `json:"policies"`
+	Roles    []oryAccessControlPolicyRole `json:"roles"`
+}
+
+func pageBounds(total int, offset int64, limit int64) (int64, int64) {
+	if offset > int64(total) {
+		offset = int64(total)
+	}
+	end := offset + limit
+	if end > int64(total) {
+		end = int64(total)
+	}
+	return offset, end
+}
+
+func (d ketoDump) policies(flavor string, offset int64, limit int64) ([]oryAccessControlPolicy, error) {
+	items := d[flavor].Policies
+	start, end := pageBounds(len(items), offset, limit)
+	return items[start:end], nil
+}
+
+func (d ketoDump) roles(flavor string, offset int64, limit int64) ([]oryAccessControlPolicyRole, error) {
+	items := d[flavor].Roles
+	start, end := pageBounds(len(items), offset, limit)
+	return items[start:end], nil
+}
+
+// importState maps "<flavor>/policies" and "<flavor>/roles" to the number of
+// items already imported
+type importState map[string]int64
+
+func loadImportState(stateFile string) (importState, error) {
+	state := make(importState)
+	content, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &state)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode import state '%s': %w", stateFile, err)
+	}
+	return state, nil
+}
+
+func (s importState) save(stateFile string) error {
+	content, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(stateFile+".tmp", content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(stateFile+".tmp", stateFile)
+}
+
+// Import copies policies and roles from Keto into the store selected by the
+// same config as the API server, which shouldn't be running
+func Import(opts ImportOptions) error {
+	acpDB, _, err := openStore(config.Get().Storage)
+	if err != nil {
+		return err
+	}
+	defer acpDB.Close()
+
+	return importInto(acpDB, opts)
+}
+
+func importInto(acpDB db.Store, opts ImportOptions) error {
+	var source importSource
+	switch {
+	case opts.KetoURL != "" && opts.File != "":
+		return fmt.Errorf("import from either a Keto URL or a file, not both")
+	case opts.KetoURL != "":
+		source = &ketoSource{
+			url: opts.KetoURL,
+			client: &http.Client{
+				Timeout: 60 * time.Second,
+			},
+		}
+	case opts.File != "":
+		content, err := ioutil.ReadFile(opts.File)
+		if err != nil {
+			return err
+		}
+		var dump ketoDump
+		err = json.Unmarshal(content, &dump)
+		if err != nil {
+			return fmt.Errorf("can't decode dump '%s': %w", opts.File, err)
+		}
+		source = dump
+	default:
+		return fmt.Errorf("nothing to import from (set a Keto URL or a file)")
+	}
+	if opts.PageSize <= 0 {
+		return fmt.Errorf("page size must be positive")
+	}
+	if maxSize := config.Get().Batch.MaxSize; opts.PageSize > int64(maxSize) {
+		return fmt.Errorf("page size can't exceed the max batch size (%d)", maxSize)
+	}
+
+	state := make(importState)
+	if opts.Resume {
+		var err error
+		state, err = loadImportState(opts.StateFile)
+		if err != nil {
+			return err
+		}
+	}
+	saveState := func() error {
+		if opts.DryRun || opts.StateFile == "" {
+			return nil
+		}
+		return state.save(opts.StateFile)
+	}
+
+	for _, flavor := range opts.Flavors {
+		if flavor != "exact" && flavor != "glob" && flavor != "regex" {
+			return fmt.Errorf("invalid flavor '%s'", flavor)
+		}
+
+		key := flavor + "/policies"
+		for {
+			page, err := source.policies(flavor, state[key], opts.PageSize)
+			if err != nil {
+				return fmt.Errorf("can't read %s at offset %d: %w", key, state[key], err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			for _, item := range page {
+				if item.ID == "" {
+					return fmt.Errorf("%s has a policy without an ID", key)
+				}
+			}
+			if !opts.DryRun {
+				_, err = upsertPolicies(acpDB, flavor, page, true)
+				if err != nil {
+					return err
+				}
+			}
+			state[key] += int64(len(page))
+			err = saveState()
+			if err != nil {
+				return err
+			}
+		}
+		log.Printf("Read %d %s\n", state[key], key)
+
+		key = flavor + "/roles"
+		for {
+			page, err := source.roles(flavor, state[key], opts.PageSize)
+			if err != nil {
+				return fmt.Errorf("can't read %s at offset %d: %w", key, state[key], err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			for _, item := range page {
+				if item.ID == "" {
+					return fmt.Errorf("%s has a role without an ID", key)
+				}
+			}
+			if !opts.DryRun {
+				_, err = upsertRoles(acpDB, flavor, page, true)
+				if err != nil {
+					return err
+				}
+			}
+			state[key] += int64(len(page))
+			err = saveState()
+			if err != nil {
+				return err
+			}
+		}
+		log.Printf("Read %d %s\n", state[key], key)
+	}
+
+	// Compare the totals of the source, read over every run, with what the
+	// store holds
+	mismatches := make([]string, 0)
+	for _, flavor := range opts.Flavors {
+		policies, roles := flavor+"/policies", flavor+"/roles"
+		var storedPolicies, storedRoles int64
+		err := acpDB.Count(policyBasePrefix(flavor), "i/", func(cnt int64) error {
+			storedPolicies = cnt
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = acpDB.Count(roleBasePrefix(flavor), "i/", func(cnt int64) error {
+			storedRoles = cnt
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Flavor %s: Keto holds %d policies, sketo %d; Keto holds %d roles, sketo %d\n",
+			flavor, state[policies], storedPolicies, state[roles], storedRoles)
+		if storedPolicies != state[policies] || storedRoles != state[roles] {
+			mismatches = append(mismatches, flavor)
+		}
+	}
+	if len(mismatches) > 0 && !opts.DryRun {
+		return fmt.Errorf("counts differ for flavors %s", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
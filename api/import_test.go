@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+// fakeKeto serves policies and roles the way Keto pages them, optionally
+// failing once when asked for failAt and capping limits to maxLimit
+type fakeKeto struct {
+	mu       sync.Mutex
+	policies []oryAccessControlPolicy
+	roles    []oryAccessControlPolicyRole
+	failAt   int64
+	maxLimit int64
+	requests map[string]int
+}
+
+func (k *fakeKeto) handler() http.Handler {
+	page := func(rw http.ResponseWriter, r *http.Request, total int) (int, int, bool) {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		offset, _ := strconv.ParseInt(r.FormValue("offset"), 10, 64)
+		limit, _ := strconv.ParseInt(r.FormValue("limit"), 10, 64)
+		k.requests[r.URL.String()]++
+		if k.failAt > 0 && offset == k.failAt {
+			k.failAt = 0
+			rw.WriteHeader(500)
+			return 0, 0, false
+		}
+		if k.maxLimit > 0 && limit > k.maxLimit {
+			limit = k.maxLimit
+		}
+		start, end := pageBounds(total, offset, limit)
+		rw.Header().Set("Content-Type", "application/json")
+		return int(start), int(end), true
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/engines/acp/ory/exact/policies", func(rw http.ResponseWriter, r *http.Request) {
+		if start, end, ok := page(rw, r, len(k.policies)); ok {
+			json.NewEncoder(rw).Encode(k.policies[start:end])
+		}
+	})
+	router.HandleFunc("/engines/acp/ory/exact/roles", func(rw http.ResponseWriter, r *http.Request) {
+		if start, end, ok := page(rw, r, len(k.roles)); ok {
+			json.NewEncoder(rw).Encode(k.roles[start:end])
+		}
+	})
+	return router
+}
+
+func newFakeKeto() *fakeKeto {
+	k := &fakeKeto{
+		requests: make(map[string]int),
+	}
+	for i := 0; i < 250; i++ {
+		k.policies = append(k.policies, oryAccessControlPolicy{
+			ID:        fmt.Sprintf("p%03d", i),
+			Subjects:  []string{fmt.Sprintf("users:%d", i)},
+			Resources: []string{"articles:1"},
+			Actions:   []string{"read"},
+			Effect:    "allow",
+		})
+	}
+	k.roles = []oryAccessControlPolicyRole{
+		{ID: "admins", Members: []string{"users:1"}},
+	}
+	return k
+}
+
+func TestImportFromKetoWithResume(t *testing.T) {
+
+	keto := newFakeKeto()
+	keto.failAt = 200
+	srv := httptest.NewServer(keto.handler())
+	defer srv.Close()
+
+	acpDB := db.NewMemStore()
+	opts := ImportOptions{
+		KetoURL:   srv.URL,
+		Flavors:   []string{"exact"},
+		PageSize:  100,
+		StateFile: filepath.Join(t.TempDir(), "state"),
+	}
+	err := importInto(acpDB, opts)
+	if err == nil {
+		t.Fatal(fmt.Errorf("import didn't report the Keto failure"))
+	}
+
+	opts.Resume = true
+	err = importInto(acpDB, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keto.requests["/engines/acp/ory/exact/policies?offset=0&limit=100"] != 1 {
+		t.Error(fmt.Errorf("resumed import started over"))
+	}
+
+	err = acpDB.Count(policyBasePrefix("exact"), "i/", func(cnt int64) error {
+		if cnt != 250 {
+			t.Error(fmt.Errorf("imported %d policies instead of 250", cnt))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	err = acpDB.List(roleBasePrefix("exact"), roleFilter("users:1"), 0, -1, func(keys []string, values [][]byte) error {
+		if len(keys) != 1 {
+			t.Error(fmt.Errorf("imported role isn't indexed by member"))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+}
+
+func TestImportDryRun(t *testing.T) {
+
+	keto := newFakeKeto()
+	srv := httptest.NewServer(keto.handler())
+	defer srv.Close()
+
+	acpDB := db.NewMemStore()
+	err := importInto(acpDB, ImportOptions{
+		KetoURL:  srv.URL,
+		Flavors:  []string{"exact"},
+		PageSize: 100,
+		DryRun:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = acpDB.Count(policyBasePrefix("exact"), "i/", func(cnt int64) error {
+		if cnt != 0 {
+			t.Error(fmt.Errorf("dry run wrote %d policies", cnt))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+}
+
+func TestImportFollowsShortPages(t *testing.T) {
+
+	keto := newFakeKeto()
+	keto.maxLimit = 40
+	srv := httptest.NewServer(keto.handler())
+	defer srv.Close()
+
+	acpDB := db.NewMemStore()
+	err := importInto(acpDB, ImportOptions{
+		KetoURL:  srv.URL,
+		Flavors:  []string{"exact"},
+		PageSize: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = acpDB.Count(policyBasePrefix("exact"), "i/", func(cnt int64) error {
+		if cnt != 250 {
+			t.Error(fmt.Errorf("store holds %d policies instead of 250", cnt))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// The store must hold as many docs as the source, not only those written
+	_, err = upsertPolicies(acpDB, "exact", []oryAccessControlPolicy{{ID: "local", Effect: "allow"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = importInto(acpDB, ImportOptions{
+		KetoURL:  srv.URL,
+		Flavors:  []string{"exact"},
+		PageSize: 100,
+	})
+	if err == nil {
+		t.Error(fmt.Errorf("import didn't report the store holding more policies than Keto"))
+	}
+
+}
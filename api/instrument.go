@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adi/sketo/db"
+	"github.com/adi/sketo/util"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BadgerDB is set when using the badger backend, for its size and GC metrics
+var BadgerDB *db.DB
+
+// Outcomes of an allowed decision
+const (
+	outcomeAllowed = "allowed"
+	outcomeDenied  = "denied"
+	outcomeError   = "error"
+)
+
+var (
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sketo_http_request_duration_seconds",
+		Help:    "Latency of API requests by route, flavor, method and status code.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2.5, 12),
+	}, []string{"route", "flavor", "method", "code"})
+	grpcRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sketo_grpc_request_duration_seconds",
+		Help:    "Latency of gRPC calls by method and status code.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2.5, 12),
+	}, []string{"method", "code"})
+	allowedDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sketo_allowed_decisions_total",
+		Help: "Allowed decisions by flavor and outcome (allowed, denied or error).",
+	}, []string{"flavor", "outcome"})
+	candidatePolicies = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sketo_allowed_candidate_policies",
+		Help:    "Policies evaluated against the input of a glob or regex allowed decision.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"flavor"})
+)
+
+// Collectors returns the collectors of the metrics recorded by the API
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{requestDuration, grpcRequestDuration, allowedDecisions, candidatePolicies}
+}
+
+// countDecision records the outcome of an allowed decision
+func countDecision(flavor string, outcome string) {
+	allowedDecisions.WithLabelValues(flavor, outcome).Inc()
+}
+
+var routePatternRegex = regexp.MustCompile(`\{(\w+):[^}]*\}`)
+
+// routeLabels caches the route label of every path template
+var routeLabels sync.Map
+
+// routeLabel returns the path template of the matched route without the
+// patterns of its variables, e.g. /engines/acp/ory/{flavor}/allowed
+func routeLabel(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	tpl, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	if label, ok := routeLabels.Load(tpl); ok {
+		return label.(string)
+	}
+	label := routePatternRegex.ReplaceAllString(tpl, "{$1}")
+	routeLabels.Store(tpl, label)
+	return label
+}
+
+// methodLabel returns the method of r, or "other" for non-standard methods,
+// which ext_authz checks pass through from the requests they ask about
+func methodLabel(r *http.Request) string {
+	switch r.Method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "CONNECT", "OPTIONS", "TRACE":
+		return r.Method
+	}
+	return "other"
+}
+
+// instrument is a middleware recording the latency of every matched route
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &util.StatusRecorder{
+			ResponseWriter: rw,
+		}
+		next.ServeHTTP(sr, r)
+		requestDuration.WithLabelValues(routeLabel(r), mux.Vars(r)["flavor"], methodLabel(r), strconv.Itoa(sr.Status())).Observe(time.Since(start).Seconds())
+	})
+}
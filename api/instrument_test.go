@@ -0,0 +1,99 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func sampleCount(t *testing.T, observer prometheus.Observer) uint64 {
+	var m dto.Metric
+	err := observer.(prometheus.Metric).Write(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestInstrumentRecordsRoutes(t *testing.T) {
+
+	apiMux := newTestRouter(db.NewMemStore())
+	apiMux.Use(instrument)
+	latency := requestDuration.WithLabelValues("/engines/acp/ory/{flavor}/allowed", "glob", "POST", "200")
+	candidates := candidatePolicies.WithLabelValues("glob")
+	latencyBefore := sampleCount(t, latency)
+	candidatesBefore := sampleCount(t, candidates)
+	denied := allowedDecisions.WithLabelValues("glob", outcomeDenied)
+	var m dto.Metric
+	denied.Write(&m)
+	deniedBefore := m.GetCounter().GetValue()
+
+	isAllowed(t, apiMux, "glob", oryAccessControlPolicyAllowedInput{
+		Subject:  "users:alice",
+		Resource: "articles:1",
+		Action:   "read",
+	})
+
+	if sampleCount(t, latency) != latencyBefore+1 {
+		t.Error(fmt.Errorf("request latency not recorded under its route"))
+	}
+	if sampleCount(t, candidates) != candidatesBefore+1 {
+		t.Error(fmt.Errorf("candidate policies not recorded"))
+	}
+	denied.Write(&m)
+	if m.GetCounter().GetValue() != deniedBefore+1 {
+		t.Error(fmt.Errorf("denied decision not counted"))
+	}
+
+}
+
+func TestInstrumentBoundsMethods(t *testing.T) {
+
+	apiMux := mux.NewRouter()
+	apiMux.PathPrefix("/ext_authz/").HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+	apiMux.Use(instrument)
+	latency := requestDuration.WithLabelValues("/ext_authz/", "", "other", "200")
+	latencyBefore := sampleCount(t, latency)
+
+	apiMux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("X-RANDOM-1", "/ext_authz/articles", nil))
+	if sampleCount(t, latency) != latencyBefore+1 {
+		t.Error(fmt.Errorf("non-standard method not recorded as other"))
+	}
+
+}
+
+func TestChecksStopAtTheFirstDeny(t *testing.T) {
+
+	apiMux := newTestRouter(db.NewMemStore())
+	for _, id := range []string{"a", "b", "c", "d"} {
+		effect := "allow"
+		if id == "a" {
+			effect = "deny"
+		}
+		rw := doJSON(apiMux, "PUT", "/engines/acp/ory/glob/policies", oryAccessControlPolicy{
+			ID: id, Subjects: []string{"users:*"}, Resources: []string{"articles:*"}, Actions: []string{"read"}, Effect: effect,
+		})
+		if rw.Code != 200 {
+			t.Fatal(fmt.Errorf("policy upsert returned %d", rw.Code))
+		}
+	}
+	var m dto.Metric
+	candidates := candidatePolicies.WithLabelValues("glob").(prometheus.Metric)
+	candidates.Write(&m)
+	before := m.GetHistogram().GetSampleSum()
+
+	if isAllowed(t, apiMux, "glob", oryAccessControlPolicyAllowedInput{Subject: "users:alice", Resource: "articles:1", Action: "read"}) {
+		t.Error(fmt.Errorf("a check matching a deny policy was allowed"))
+	}
+	candidates.Write(&m)
+	if evaluated := m.GetHistogram().GetSampleSum() - before; evaluated != 1 {
+		t.Error(fmt.Errorf("evaluated %v policies instead of stopping at the first deny", evaluated))
+	}
+
+}
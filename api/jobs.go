@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Job statuses
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCanceled  = "canceled"
+)
+
+// maxFinishedJobs is how many finished jobs are kept for status queries
+const maxFinishedJobs = 100
+
+// job is a background operation on the policies and/or roles of a flavor
+type job struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Flavor is empty for jobs on every flavor
+	Flavor string `json:"flavor"`
+	// Kind is "policies", "roles" or empty for both
+	Kind   string `json:"kind,omitempty"`
+	Status string `json:"status"`
+	Phase  string `json:"phase,omitempty"`
+	// Total is the number of documents to go through, Done those already
+	// processed
+	Total int64 `json:"total"`
+	Done  int64 `json:"done"`
+	// Added and Removed count the index refs changed by the job
+	Added      int64      `json:"added"`
+	Removed    int64      `json:"removed"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+// conflicts tells whether j and other can't run at the same time: jobs of
+// the same type, or a wipe and any job, on overlapping documents
+func (j *job) conflicts(other *job) bool {
+	return (j.Type == other.Type || j.Type == "wipe" || other.Type == "wipe") &&
+		(j.Flavor == "" || other.Flavor == "" || j.Flavor == other.Flavor) &&
+		(j.Kind == "" || other.Kind == "" || j.Kind == other.Kind)
+}
+
+var (
+	jobsMu sync.Mutex
+	jobs   = make(map[string]*job)
+	// jobIDs keeps the job IDs in creation order
+	jobIDs = make([]string, 0)
+)
+
+// startJob runs j in the background with run, unless a conflicting job is
+// running; it returns a snapshot of j or the conflicting job. run returns
+// context.Canceled when it stops because the job was canceled
+func startJob(j *job, run func(ctx context.Context, j *job) error) (*job, *job, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for _, other := range jobs {
+		if other.Status == jobRunning && j.conflicts(other) {
+			return nil, other.snapshot(), nil
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	j.ID = hex.EncodeToString(b)
+	j.Status = jobRunning
+	j.CreatedAt = time.Now().UTC()
+	j.cancel = cancel
+	j.finished = make(chan struct{})
+	jobs[j.ID] = j
+	jobIDs = append(jobIDs, j.ID)
+	pruneJobs()
+
+	go func() {
+		defer cancel()
+		err := run(ctx, j)
+		updateJob(j, func(j *job) {
+			switch {
+			case err == context.Canceled:
+				j.Status = jobCanceled
+			case err != nil:
+				j.Status = jobFailed
+				j.Error = err.Error()
+			default:
+				j.Status = jobSucceeded
+			}
+			finishedAt := time.Now().UTC()
+			j.FinishedAt = &finishedAt
+		})
+		close(j.finished)
+		if err != nil && err != context.Canceled {
+			log.Printf("Error running %s job %s: %v\n", j.Type, j.ID, err)
+		}
+	}()
+
+	return j.snapshot(), nil, nil
+}
+
+// pruneJobs drops the oldest finished jobs beyond maxFinishedJobs; jobsMu
+// must be held
+func pruneJobs() {
+	finished := 0
+	for _, id := range jobIDs {
+		if jobs[id].Status != jobRunning {
+			finished++
+		}
+	}
+	kept := jobIDs[:0]
+	for _, id := range jobIDs {
+		if finished > maxFinishedJobs && jobs[id].Status != jobRunning {
+			delete(jobs, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	jobIDs = kept
+}
+
+// updateJob changes j with fn while holding jobsMu
+func updateJob(j *job, fn func(j *job)) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	fn(j)
+}
+
+// snapshot copies j; jobsMu must be held
+func (j *job) snapshot() *job {
+	s := *j
+	return &s
+}
+
+// findJob returns a snapshot of the job with id, or nil
+func findJob(id string) *job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j, ok := jobs[id]
+	if !ok {
+		return nil
+	}
+	return j.snapshot()
+}
+
+// listJobs lists the running and recently finished jobs, oldest first
+func listJobs(rw http.ResponseWriter, r *http.Request) {
+	jobsMu.Lock()
+	ret := make([]*job, 0, len(jobIDs))
+	for _, id := range jobIDs {
+		ret = append(ret, jobs[id].snapshot())
+	}
+	jobsMu.Unlock()
+	writeJSON(rw, 200, ret)
+}
+
+// getJob returns the status and progress of a job
+func getJob(rw http.ResponseWriter, r *http.Request) {
+	j := findJob(mux.Vars(r)["id"])
+	if j == nil {
+		writeError(rw, r, 404, "Job not found")
+		return
+	}
+	writeJSON(rw, 200, j)
+}
+
+// cancelJob stops a running job, leaving the data as it was before the job
+func cancelJob(rw http.ResponseWriter, r *http.Request) {
+	jobsMu.Lock()
+	j, ok := jobs[mux.Vars(r)["id"]]
+	if !ok {
+		jobsMu.Unlock()
+		writeError(rw, r, 404, "Job not found")
+		return
+	}
+	if j.Status != jobRunning {
+		jobsMu.Unlock()
+		writeError(rw, r, 409, fmt.Sprintf("Job already %s", j.Status))
+		return
+	}
+	j.cancel()
+	finished := j.finished
+	jobsMu.Unlock()
+
+	// The job stops at its next checkpoint
+	<-finished
+	writeJSON(rw, 200, findJob(j.ID))
+}
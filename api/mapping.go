@@ -0,0 +1,119 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+// Headers telling the upstream of an allowed request who was checked
+const (
+	subjectHeader  = "X-Sketo-Subject"
+	resourceHeader = "X-Sketo-Resource"
+	actionHeader   = "X-Sketo-Action"
+)
+
+// resourceTemplate maps the paths matching route to resource
+type resourceTemplate struct {
+	route    *mux.Route
+	resource string
+}
+
+// requestMapping maps the requests a proxy asks about to checks
+type requestMapping struct {
+	cfg       config.RequestMappingConfig
+	resources []resourceTemplate
+}
+
+// newRequestMapping parses the path templates of cfg, naming the mapping in
+// errors
+func newRequestMapping(name string, cfg config.RequestMappingConfig) (*requestMapping, error) {
+	mapping := &requestMapping{
+		cfg: cfg,
+	}
+	router := mux.NewRouter()
+	for _, template := range cfg.Resources {
+		route := router.NewRoute().Path(template.Path)
+		if err := route.GetError(); err != nil {
+			return nil, fmt.Errorf("invalid %s path template '%s': %v", name, template.Path, err)
+		}
+		mapping.resources = append(mapping.resources, resourceTemplate{
+			route:    route,
+			resource: template.Resource,
+		})
+	}
+	return mapping, nil
+}
+
+// resource returns the resource of the first template matching the path of r
+func (m *requestMapping) resource(r *http.Request) (string, bool) {
+	for _, template := range m.resources {
+		var match mux.RouteMatch
+		if !template.route.Match(r, &match) {
+			continue
+		}
+		resource := template.resource
+		for name, value := range match.Vars {
+			resource = strings.ReplaceAll(resource, "{"+name+"}", value)
+		}
+		return resource, true
+	}
+	return "", false
+}
+
+// mappingDecision is the answer to a request a proxy asks about: 200 when it
+// is allowed, else the status to deny it with and why
+type mappingDecision struct {
+	code   int
+	reason string
+	input  oryAccessControlPolicyAllowedInput
+}
+
+// setHeaders adds the check of an allowed request to header, for the upstream
+func (d mappingDecision) setHeaders(header http.Header) {
+	header.Set(subjectHeader, d.input.Subject)
+	header.Set(resourceHeader, d.input.Resource)
+	header.Set(actionHeader, d.input.Action)
+}
+
+// unauthenticated denies a request without a subject, counting it like a
+// denied check
+func (m *requestMapping) unauthenticated(reason string) mappingDecision {
+	atomic.AddInt64(&CntAllowRequestsSinceStart, 1)
+	countOutcome(m.cfg.Flavor, false)
+	return mappingDecision{code: 401, reason: reason}
+}
+
+// decide checks whether subject may send r, which only needs its method and
+// URL, on the configured flavor
+func (m *requestMapping) decide(acpDB db.Store, r *http.Request, subject string) mappingDecision {
+	atomic.AddInt64(&CntAllowRequestsSinceStart, 1)
+	input := oryAccessControlPolicyAllowedInput{
+		Subject: subject,
+		Action:  m.cfg.Actions[r.Method],
+	}
+	if input.Action == "" {
+		input.Action = strings.ToLower(r.Method)
+	}
+	resource, ok := m.resource(r)
+	if !ok {
+		countOutcome(m.cfg.Flavor, false)
+		return mappingDecision{code: 403, reason: "No resource template matches " + r.URL.Path}
+	}
+	input.Resource = resource
+	allowed, err := checkInput(r.Context(), acpDB, m.cfg.Flavor, input)
+	if err != nil {
+		log.Printf("Error checking ACPs: %v\n", err)
+		return mappingDecision{code: 500}
+	}
+	if !allowed {
+		return mappingDecision{code: 403, reason: fmt.Sprintf("%s may not %s %s", input.Subject, input.Action, input.Resource), input: input}
+	}
+	return mappingDecision{code: 200, input: input}
+}
@@ -9,11 +9,10 @@ import (
 	"github.com/gorilla/mux"
 )
 
-func addMembersToAccessControlPolicyRole(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func addMembersToAccessControlPolicyRole(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("Content-Type") != "application/json" {
-			rw.WriteHeader(400)
-			rw.Write([]byte(fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only "application/json" is valid)`, r.Header.Get("Content-Type"))))
+			writeError(rw, r, 400, fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only "application/json" is valid)`, r.Header.Get("Content-Type")))
 			return
 		}
 		params := mux.Vars(r)
@@ -24,8 +23,7 @@ func addMembersToAccessControlPolicyRole(acpDB *db.DB) func(rw http.ResponseWrit
 		jsonDec := json.NewDecoder(r.Body)
 		err := jsonDec.Decode(&bodyx)
 		if err != nil {
-			rw.WriteHeader(400)
-			rw.Write([]byte("Couldn't decode body\n"))
+			writeError(rw, r, 400, "Couldn't decode body")
 			return
 		}
 
@@ -40,12 +38,12 @@ func addMembersToAccessControlPolicyRole(acpDB *db.DB) func(rw http.ResponseWrit
 			}
 			for _, m := range tmpBody.Members {
 				mbytes := []byte(m)
-				mcopy := make([]byte, 0, len(mbytes))
+				mcopy := make([]byte, len(mbytes))
 				copy(mcopy, mbytes)
 				members = append(members, string(mcopy))
 			}
 			dbytes := []byte(tmpBody.Description)
-			dcopy := make([]byte, 0, len(dbytes))
+			dcopy := make([]byte, len(dbytes))
 			copy(dcopy, dbytes)
 			description = string(dcopy)
 			return nil
@@ -77,8 +75,7 @@ func addMembersToAccessControlPolicyRole(acpDB *db.DB) func(rw http.ResponseWrit
 		docPrefix := docSuffix(id)
 		err = acpDB.Set(roleBasePrefix(flavor), docPrefix, doc)
 		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
+			writeError(rw, r, 500, "")
 			return
 		}
 
@@ -87,31 +84,23 @@ func addMembersToAccessControlPolicyRole(acpDB *db.DB) func(rw http.ResponseWrit
 		for _, member := range newMembers {
 			suffixes = append(suffixes, roleSuffix(member, id))
 		}
-		err = acpDB.RefMany(roleBasePrefix(flavor), suffixes)
+		err = acpDB.Update(func(txn db.Txn) error {
+			return updateRefs(txn, roleBasePrefix(flavor), nil, suffixes)
+		})
 		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
+			writeError(rw, r, 500, "")
 			return
 		}
 
-		rw.Header().Add("Content-Type", "application/json")
-		jsonEnc := json.NewEncoder(rw)
-		rw.WriteHeader(200)
-		err = jsonEnc.Encode(doc)
-		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
-			return
-		}
+		writeJSON(rw, 200, doc)
 
 	}
 }
 
-func removeMemberFromAccessControlPolicyRole(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func removeMemberFromAccessControlPolicyRole(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("Content-Type") != "application/json" {
-			rw.WriteHeader(400)
-			rw.Write([]byte(fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only "application/json" is valid)`, r.Header.Get("Content-Type"))))
+			writeError(rw, r, 400, fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only "application/json" is valid)`, r.Header.Get("Content-Type")))
 			return
 		}
 		params := mux.Vars(r)
@@ -130,12 +119,12 @@ func removeMemberFromAccessControlPolicyRole(acpDB *db.DB) func(rw http.Response
 			}
 			for _, m := range tmpBody.Members {
 				mbytes := []byte(m)
-				mcopy := make([]byte, 0, len(mbytes))
+				mcopy := make([]byte, len(mbytes))
 				copy(mcopy, mbytes)
 				members = append(members, string(mcopy))
 			}
 			dbytes := []byte(tmpBody.Description)
-			dcopy := make([]byte, 0, len(dbytes))
+			dcopy := make([]byte, len(dbytes))
 			copy(dcopy, dbytes)
 			description = string(dcopy)
 			return nil
@@ -161,8 +150,7 @@ func removeMemberFromAccessControlPolicyRole(acpDB *db.DB) func(rw http.Response
 		docPrefix := docSuffix(id)
 		err = acpDB.Set(roleBasePrefix(flavor), docPrefix, doc)
 		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
+			writeError(rw, r, 500, "")
 			return
 		}
 
@@ -171,22 +159,15 @@ func removeMemberFromAccessControlPolicyRole(acpDB *db.DB) func(rw http.Response
 		for _, member := range removedMembers {
 			suffixes = append(suffixes, roleSuffix(member, id))
 		}
-		err = acpDB.DelManyRefs(roleBasePrefix(flavor), suffixes)
+		err = acpDB.Update(func(txn db.Txn) error {
+			return updateRefs(txn, roleBasePrefix(flavor), suffixes, nil)
+		})
 		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
+			writeError(rw, r, 500, "")
 			return
 		}
 
-		rw.Header().Add("Content-Type", "application/json")
-		jsonEnc := json.NewEncoder(rw)
-		rw.WriteHeader(200)
-		err = jsonEnc.Encode(doc)
-		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
-			return
-		}
+		writeJSON(rw, 200, doc)
 
 	}
 }
@@ -1,8 +1,14 @@
 package api
 
-import "github.com/adi/sketo/db"
+import (
+	"log"
+	"sync/atomic"
+	"time"
 
-// Counters for metrics
+	"github.com/adi/sketo/db"
+)
+
+// Counters for metrics, only accessed atomically
 var (
 	CntRegexPolicies           = int64(0)
 	CntGlobPolicies            = int64(0)
@@ -14,51 +20,93 @@ var (
 	CntAllowAcceptedSinceStart = int64(0)
 	CntAllowRefusedSinceStart  = int64(0)
 	CntAllowFailuresSinceStart = int64(0)
+	// CntReconciliations counts the periodic recounts of the documents, and
+	// CntDriftCorrected sums how far off the counters were found by them
+	CntReconciliations = int64(0)
+	CntDriftCorrected  = int64(0)
 )
 
-// ReloadCounters ..
-func ReloadCounters(acpDB *db.DB) error {
-	err := acpDB.Count(policyBasePrefix("regex"), policyFilter("", "", ""), func(cnt int64) error {
-		CntRegexPolicies = cnt
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-	err = acpDB.Count(policyBasePrefix("glob"), policyFilter("", "", ""), func(cnt int64) error {
-		CntGlobPolicies = cnt
-		return nil
-	})
-	if err != nil {
-		return err
+// reloadCounters recounts the documents of every flavor from storage and
+// returns how far off the counters were in total
+func reloadCounters(acpDB db.Store) (int64, error) {
+	drift := int64(0)
+	for _, counter := range []struct {
+		prefix string
+		cnt    *int64
+	}{
+		{policyBasePrefix("regex"), &CntRegexPolicies},
+		{policyBasePrefix("glob"), &CntGlobPolicies},
+		{policyBasePrefix("exact"), &CntExactPolicies},
+		{roleBasePrefix("regex"), &CntRegexRoles},
+		{roleBasePrefix("glob"), &CntGlobRoles},
+		{roleBasePrefix("exact"), &CntExactRoles},
+	} {
+		err := acpDB.Count(counter.prefix, "i/", func(cnt int64) error {
+			diff := atomic.SwapInt64(counter.cnt, cnt) - cnt
+			if diff < 0 {
+				diff = -diff
+			}
+			drift += diff
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
 	}
-	err = acpDB.Count(policyBasePrefix("exact"), policyFilter("", "", ""), func(cnt int64) error {
-		CntExactPolicies = cnt
-		return nil
-	})
-	if err != nil {
-		return err
+	return drift, nil
+}
+
+// ReloadCounters ..
+func ReloadCounters(acpDB db.Store) error {
+	_, err := reloadCounters(acpDB)
+	return err
+}
+
+// reconcileCounters recounts the documents every interval, correcting counters
+// that drifted because of writes racing with each other or with a recount
+func reconcileCounters(acpDB db.Store, interval time.Duration) {
+	for range time.Tick(interval) {
+		drift, err := reloadCounters(acpDB)
+		if err != nil {
+			log.Printf("Error reconciling counters: %v\n", err)
+			continue
+		}
+		atomic.AddInt64(&CntReconciliations, 1)
+		if drift != 0 {
+			atomic.AddInt64(&CntDriftCorrected, drift)
+			log.Printf("Corrected a drift of %d in the document counters\n", drift)
+		}
 	}
-	err = acpDB.Count(roleBasePrefix("regex"), roleFilter(""), func(cnt int64) error {
-		CntRegexRoles = cnt
-		return nil
-	})
-	if err != nil {
-		return err
+}
+
+// initReconcile starts recounting the documents periodically unless the
+// interval is 0
+func initReconcile(acpDB db.Store, interval time.Duration) {
+	if interval > 0 {
+		go reconcileCounters(acpDB, interval)
 	}
-	err = acpDB.Count(roleBasePrefix("glob"), roleFilter(""), func(cnt int64) error {
-		CntGlobRoles = cnt
-		return nil
-	})
-	if err != nil {
-		return err
+}
+
+// addPolicyCount adjusts the policy counter of flavor by delta
+func addPolicyCount(flavor string, delta int64) {
+	switch flavor {
+	case "regex":
+		atomic.AddInt64(&CntRegexPolicies, delta)
+	case "glob":
+		atomic.AddInt64(&CntGlobPolicies, delta)
+	case "exact":
+		atomic.AddInt64(&CntExactPolicies, delta)
 	}
-	err = acpDB.Count(roleBasePrefix("exact"), roleFilter(""), func(cnt int64) error {
-		CntExactRoles = cnt
-		return nil
-	})
-	if err != nil {
-		return err
+}
+
+// addRoleCount adjusts the role counter of flavor by delta
+func addRoleCount(flavor string, delta int64) {
+	switch flavor {
+	case "regex":
+		atomic.AddInt64(&CntRegexRoles, delta)
+	case "glob":
+		atomic.AddInt64(&CntGlobRoles, delta)
+	case "exact":
+		atomic.AddInt64(&CntExactRoles, delta)
 	}
-	return nil
 }
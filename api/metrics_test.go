@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/adi/sketo/db"
+)
+
+func TestCountersFollowStorage(t *testing.T) {
+
+	acpDB := db.NewMemStore()
+	apiMux := newTestRouter(acpDB)
+	err := ReloadCounters(acpDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := oryAccessControlPolicy{
+		ID:        "p1",
+		Subjects:  []string{"users:*"},
+		Resources: []string{"articles:*"},
+		Actions:   []string{"read"},
+		Effect:    "allow",
+	}
+	for i := 0; i < 2; i++ {
+		if rw := doJSON(apiMux, "PUT", "/engines/acp/ory/glob/policies", policy); rw.Code != http.StatusOK {
+			t.Fatal(fmt.Errorf("upsert returned status %d", rw.Code))
+		}
+	}
+	if cnt := atomic.LoadInt64(&CntGlobPolicies); cnt != 1 {
+		t.Error(fmt.Errorf("counted %d policies after upserting the same one twice", cnt))
+	}
+
+	// Reloading counts glob documents too
+	atomic.StoreInt64(&CntGlobPolicies, 5)
+	drift, err := reloadCounters(acpDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drift != 4 || atomic.LoadInt64(&CntGlobPolicies) != 1 {
+		t.Error(fmt.Errorf("reload corrected a drift of %d to %d policies", drift, atomic.LoadInt64(&CntGlobPolicies)))
+	}
+
+	for i := 0; i < 2; i++ {
+		if rw := doJSON(apiMux, "DELETE", "/engines/acp/ory/glob/policies/p1", nil); rw.Code != http.StatusNoContent {
+			t.Fatal(fmt.Errorf("delete returned status %d", rw.Code))
+		}
+	}
+	if cnt := atomic.LoadInt64(&CntGlobPolicies); cnt != 0 {
+		t.Error(fmt.Errorf("counted %d policies after deleting the only one twice", cnt))
+	}
+
+}
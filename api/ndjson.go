@@ -0,0 +1,244 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/adi/sketo/db"
+	"github.com/gobwas/glob"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Limits applied by the NDJSON import
+const (
+	DefaultImportBatchSize = 1000
+	MaxImportBatchSize     = 10000
+	MaxImportLineSize      = 1024 * 1024
+)
+
+// ndjsonLine is one document of an NDJSON export or import; exactly one of
+// Policy and Role is set
+type ndjsonLine struct {
+	Flavor string                      `json:"flavor,omitempty"`
+	Policy *oryAccessControlPolicy     `json:"policy,omitempty"`
+	Role   *oryAccessControlPolicyRole `json:"role,omitempty"`
+}
+
+// ndjsonResult reports the outcome of one imported line
+type ndjsonResult struct {
+	Line   int    `json:"line"`
+	ID     string `json:"id,omitempty"`
+	OK     bool   `json:"ok"`
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func validatePatterns(flavor string, patterns []string) error {
+	for _, pattern := range patterns {
+		var err error
+		switch flavor {
+		case "glob":
+			_, err = glob.Compile(pattern, ':')
+		case "regex":
+			_, err = ketoRegexStringPartsToMatchers([]rune(pattern), ketoRegexStringToParts(pattern))
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s pattern '%s': %w", flavor, pattern, err)
+		}
+	}
+	return nil
+}
+
+// validateLine checks a decoded line and fills in its flavor and a generated
+// ID when missing
+func validateLine(line *ndjsonLine, urlFlavor string) error {
+	if urlFlavor != "" {
+		if line.Flavor != "" && line.Flavor != urlFlavor {
+			return fmt.Errorf("flavor '%s' doesn't match the URL's", line.Flavor)
+		}
+		line.Flavor = urlFlavor
+	}
+	if line.Flavor != "exact" && line.Flavor != "glob" && line.Flavor != "regex" {
+		return fmt.Errorf("invalid flavor '%s'", line.Flavor)
+	}
+	if (line.Policy == nil) == (line.Role == nil) {
+		return errors.New("exactly one of policy and role must be set")
+	}
+
+	var id *string
+	if line.Role != nil {
+		id = &line.Role.ID
+	} else {
+		id = &line.Policy.ID
+		if line.Policy.Effect != "allow" && line.Policy.Effect != "deny" {
+			return fmt.Errorf("invalid effect '%s'", line.Policy.Effect)
+		}
+		for _, patterns := range [][]string{line.Policy.Subjects, line.Policy.Resources, line.Policy.Actions} {
+			err := validatePatterns(line.Flavor, patterns)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if *id == "" {
+		genID, err := uuid.NewUUID()
+		if err != nil {
+			return err
+		}
+		*id = genID.String()
+	}
+	return nil
+}
+
+// ndjsonBatch buffers the lines of a write batch, in order
+type ndjsonBatch struct {
+	lines   []*ndjsonLine
+	results []ndjsonResult
+}
+
+func (b *ndjsonBatch) add(lineNo int, line *ndjsonLine, err error) {
+	result := ndjsonResult{
+		Line: lineNo,
+		OK:   err == nil,
+	}
+	if err != nil {
+		result.Error = err.Error()
+		line = nil
+	} else if line.Policy != nil {
+		result.ID = line.Policy.ID
+	} else {
+		result.ID = line.Role.ID
+	}
+	b.lines = append(b.lines, line)
+	b.results = append(b.results, result)
+}
+
+// flush writes the valid lines grouped by flavor and kind, then reports every
+// buffered line's outcome
+func (b *ndjsonBatch) flush(acpDB db.Store, jsonEnc *json.Encoder) error {
+	type group struct {
+		policies []oryAccessControlPolicy
+		roles    []oryAccessControlPolicyRole
+		indexes  []int
+	}
+	groups := make(map[string]*group)
+	order := make([]string, 0)
+	for i, line := range b.lines {
+		if line == nil {
+			continue
+		}
+		key := line.Flavor + "/policies"
+		if line.Role != nil {
+			key = line.Flavor + "/roles"
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &group{}
+			groups[key] = g
+			order = append(order, key)
+		}
+		if line.Policy != nil {
+			g.policies = append(g.policies, *line.Policy)
+		} else {
+			g.roles = append(g.roles, *line.Role)
+		}
+		g.indexes = append(g.indexes, i)
+	}
+
+	for _, key := range order {
+		g := groups[key]
+		flavor := b.lines[g.indexes[0]].Flavor
+		var results []upsertResult
+		if len(g.policies) > 0 {
+			results, _ = upsertPolicies(acpDB, flavor, g.policies, false)
+		} else {
+			results, _ = upsertRoles(acpDB, flavor, g.roles, false)
+		}
+		for k, i := range g.indexes {
+			b.results[i].Status = results[k].Status
+			if results[k].Status == upsertFailed {
+				b.results[i].OK = false
+				b.results[i].Error = results[k].Error
+			}
+		}
+	}
+
+	for _, result := range b.results {
+		err := jsonEnc.Encode(result)
+		if err != nil {
+			return err
+		}
+	}
+	b.lines = b.lines[:0]
+	b.results = b.results[:0]
+	return nil
+}
+
+// Import NDJSON documents, streaming back one result per line
+func importNDJSON(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		urlFlavor := mux.Vars(r)["flavor"]
+		batchSize := DefaultImportBatchSize
+		if batchSizeStr := r.FormValue("batch_size"); batchSizeStr != "" {
+			var err error
+			batchSize, err = strconv.Atoi(batchSizeStr)
+			if err != nil || batchSize <= 0 || batchSize > MaxImportBatchSize {
+				writeError(rw, r, 400, fmt.Sprintf("Invalid batch_size query param (expected 1 to %d)", MaxImportBatchSize))
+				return
+			}
+		}
+
+		// Results are streamed while the body is still being read
+		http.NewResponseController(rw).EnableFullDuplex()
+		flusher, _ := rw.(http.Flusher)
+		rw.Header().Set("Content-Type", "application/x-ndjson")
+		rw.WriteHeader(200)
+		jsonEnc := json.NewEncoder(rw)
+
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), MaxImportLineSize)
+		batch := &ndjsonBatch{}
+		lineNo := 0
+		for scanner.Scan() {
+			lineNo++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			line := &ndjsonLine{}
+			err := json.Unmarshal(scanner.Bytes(), line)
+			if err == nil {
+				err = validateLine(line, urlFlavor)
+			}
+			batch.add(lineNo, line, err)
+
+			if len(batch.lines) >= batchSize {
+				err = batch.flush(acpDB, jsonEnc)
+				if err != nil {
+					log.Printf("Error writing import results: %v\n", err)
+					return
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+		}
+		err := batch.flush(acpDB, jsonEnc)
+		if err != nil {
+			log.Printf("Error writing import results: %v\n", err)
+			return
+		}
+		if scanner.Err() != nil {
+			// Reading can't go on past an oversized line
+			jsonEnc.Encode(ndjsonResult{
+				Line:  lineNo + 1,
+				Error: fmt.Sprintf("Couldn't read line: %v", scanner.Err()),
+			})
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+func TestImportNDJSONStreamsResults(t *testing.T) {
+
+	acpDB := db.NewMemStore()
+	apiMux := mux.NewRouter()
+	apiMux.HandleFunc("/engines/acp/ory/import", importNDJSON(acpDB)).Methods("POST")
+	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/import", importNDJSON(acpDB)).Methods("POST")
+	srv := httptest.NewServer(apiMux)
+	defer srv.Close()
+
+	firstBatch := []string{
+		`{"policy": {"id": "p1", "subjects": ["users:alice"], "resources": ["articles:1"], "actions": ["read"], "effect": "allow"}}`,
+		`{"policy": {"id": "p2", "subjects": ["users:bob"], "effect": "maybe"}}`,
+	}
+	secondBatch := []string{
+		`not json`,
+		`{"flavor": "glob", "role": {"id": "r1", "members": ["users:alice"]}}`,
+		``,
+		`{"role": {"id": "r2", "members": ["users:bob"]}}`,
+	}
+
+	bodyReader, bodyWriter := io.Pipe()
+	req, err := http.NewRequest("POST", srv.URL+"/engines/acp/ory/exact/import?batch_size=2", bodyReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	// The first batch's results must come back while the body is still open
+	go func() {
+		for _, line := range firstBatch {
+			fmt.Fprintln(bodyWriter, line)
+		}
+	}()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	results := make([]ndjsonResult, 0)
+	readResult := func() {
+		if !scanner.Scan() {
+			t.Fatal(fmt.Errorf("missing result: %v", scanner.Err()))
+		}
+		var result ndjsonResult
+		err := json.Unmarshal(scanner.Bytes(), &result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+	readResult()
+	readResult()
+
+	go func() {
+		for _, line := range secondBatch {
+			fmt.Fprintln(bodyWriter, line)
+		}
+		bodyWriter.Close()
+	}()
+	for scanner.Scan() {
+		var result ndjsonResult
+		err := json.Unmarshal(scanner.Bytes(), &result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+
+	expected := []struct {
+		line int
+		id   string
+		ok   bool
+	}{
+		{1, "p1", true},
+		{2, "", false},
+		{3, "", false},
+		{4, "", false},
+		{6, "r2", true},
+	}
+	if len(results) != len(expected) {
+		t.Fatal(fmt.Errorf("got %d results instead of %d: %v", len(results), len(expected), results))
+	}
+	for i, e := range expected {
+		if results[i].Line != e.line || results[i].ID != e.id || results[i].OK != e.ok || (e.ok == (results[i].Error != "")) {
+			t.Error(fmt.Errorf("unexpected result %+v for line %d", results[i], e.line))
+		}
+	}
+
+	err = acpDB.Get(policyBasePrefix("exact"), docSuffix("p1"), func(value []byte) error { return nil })
+	if err != nil {
+		t.Error(fmt.Errorf("p1 not imported: %w", err))
+	}
+	err = acpDB.List(roleBasePrefix("exact"), roleFilter("users:bob"), 0, -1, func(keys []string, values [][]byte) error {
+		if len(keys) != 1 {
+			t.Error(fmt.Errorf("r2 not indexed by member"))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+}
@@ -0,0 +1,370 @@
+package api
+
+import (
+	"bytes"
+	_ "embed" // for the OpenAPI document
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adi/sketo/config"
+	"github.com/gorilla/mux"
+	"github.com/santhosh-tekuri/jsonschema"
+	"github.com/santhosh-tekuri/jsonschema/formats"
+)
+
+// openAPISpec describes every route set up by Init
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIPath is where the OpenAPI document is served
+const openAPIPath = "/.well-known/openapi.json"
+
+type openAPIParameter struct {
+	Ref      string          `json:"$ref"`
+	Name     string          `json:"name"`
+	In       string          `json:"in"`
+	Required bool            `json:"required"`
+	Schema   json.RawMessage `json:"schema"`
+}
+
+type openAPIMediaType struct {
+	Schema json.RawMessage `json:"schema"`
+}
+
+type openAPIOperation struct {
+	Parameters  []openAPIParameter `json:"parameters"`
+	RequestBody *struct {
+		Required bool                        `json:"required"`
+		Content  map[string]openAPIMediaType `json:"content"`
+	} `json:"requestBody"`
+}
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Parameters map[string]openAPIParameter `json:"parameters"`
+		Schemas    map[string]interface{}      `json:"schemas"`
+	} `json:"components"`
+}
+
+// paramValidator checks a path or query param against its schema
+type paramValidator struct {
+	name     string
+	in       string
+	required bool
+	// typ is the JSON type the param is converted to before being checked
+	typ    string
+	schema *jsonschema.Schema
+}
+
+// operationValidator checks the requests of an operation
+type operationValidator struct {
+	params       []paramValidator
+	bodyRequired bool
+	// bodies has the schema of every accepted media type, nil for bodies
+	// streamed to the handler unchecked
+	bodies map[string]*jsonschema.Schema
+}
+
+// decodedBodies are the operations whose handlers decode their body into a
+// struct themselves; checks are too hot to validate the body twice
+var decodedBodies = map[string]bool{
+	"POST /engines/acp/ory/{flavor}/allowed": true,
+}
+
+// openAPIValidators has the validator of every operation by "<METHOD> <path>"
+var openAPIValidators map[string]*operationValidator
+
+// toJSONSchema rewrites the OpenAPI 3.0 keywords of a schema that JSON schema
+// spells differently, and drops the formats it doesn't know
+func toJSONSchema(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			ret[key] = toJSONSchema(value)
+		}
+		if nullable, _ := ret["nullable"].(bool); nullable {
+			if typ, ok := ret["type"].(string); ok {
+				ret["type"] = []interface{}{typ, "null"}
+			}
+		}
+		delete(ret, "nullable")
+		if format, ok := ret["format"].(string); ok && !formats.IsFormat(format) {
+			// e.g. byte or binary
+			delete(ret, "format")
+		}
+		for _, bound := range []string{"Minimum", "Maximum"} {
+			limit := strings.ToLower(bound)
+			if exclusive, ok := ret["exclusive"+bound].(bool); ok {
+				delete(ret, "exclusive"+bound)
+				if exclusive {
+					ret["exclusive"+bound] = ret[limit]
+					delete(ret, limit)
+				}
+			}
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, value := range v {
+			ret[i] = toJSONSchema(value)
+		}
+		return ret
+	}
+	return v
+}
+
+// openAPICompiler compiles the schemas of the OpenAPI document, each along
+// with the components it may refer to
+type openAPICompiler struct {
+	compiler   *jsonschema.Compiler
+	components interface{}
+	compiled   int
+}
+
+func (c *openAPICompiler) compile(raw json.RawMessage) (*jsonschema.Schema, string, error) {
+	var schema map[string]interface{}
+	err := json.Unmarshal(raw, &schema)
+	if err != nil {
+		return nil, "", err
+	}
+	typ, _ := schema["type"].(string)
+	resource := toJSONSchema(schema).(map[string]interface{})
+	resource["components"] = c.components
+	encoded, err := json.Marshal(resource)
+	if err != nil {
+		return nil, "", err
+	}
+	c.compiled++
+	url := fmt.Sprintf("openapi-%d.json", c.compiled)
+	err = c.compiler.AddResource(url, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, "", err
+	}
+	compiled, err := c.compiler.Compile(url)
+	return compiled, typ, err
+}
+
+// loadOpenAPI compiles the validators of the operations of the OpenAPI document
+func loadOpenAPI() (map[string]*operationValidator, error) {
+	var doc openAPIDocument
+	err := json.Unmarshal(openAPISpec, &doc)
+	if err != nil {
+		return nil, err
+	}
+	c := &openAPICompiler{
+		compiler: jsonschema.NewCompiler(),
+		components: map[string]interface{}{
+			"schemas": toJSONSchema(doc.Components.Schemas),
+		},
+	}
+	param := func(p openAPIParameter) (paramValidator, error) {
+		if ref := p.Ref; ref != "" {
+			var ok bool
+			p, ok = doc.Components.Parameters[strings.TrimPrefix(ref, "#/components/parameters/")]
+			if !ok {
+				return paramValidator{}, fmt.Errorf("unknown parameter %s", ref)
+			}
+		}
+		schema, typ, err := c.compile(p.Schema)
+		return paramValidator{
+			name:     p.Name,
+			in:       p.In,
+			required: p.Required,
+			typ:      typ,
+			schema:   schema,
+		}, err
+	}
+
+	validators := make(map[string]*operationValidator)
+	for path, item := range doc.Paths {
+		var common []openAPIParameter
+		if raw, ok := item["parameters"]; ok {
+			err = json.Unmarshal(raw, &common)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for method, raw := range item {
+			if method == "parameters" {
+				continue
+			}
+			var op openAPIOperation
+			err = json.Unmarshal(raw, &op)
+			if err != nil {
+				return nil, err
+			}
+			v := &operationValidator{}
+			for _, p := range append(append([]openAPIParameter(nil), common...), op.Parameters...) {
+				pv, err := param(p)
+				if err != nil {
+					return nil, fmt.Errorf("%s %s: %v", method, path, err)
+				}
+				v.params = append(v.params, pv)
+			}
+			if op.RequestBody != nil {
+				v.bodyRequired = op.RequestBody.Required
+				v.bodies = make(map[string]*jsonschema.Schema)
+				for mediaType, content := range op.RequestBody.Content {
+					if mediaType != "application/json" || decodedBodies[strings.ToUpper(method)+" "+path] {
+						v.bodies[mediaType] = nil
+						continue
+					}
+					v.bodies[mediaType], _, err = c.compile(content.Schema)
+					if err != nil {
+						return nil, fmt.Errorf("%s %s: %v", method, path, err)
+					}
+				}
+			}
+			validators[strings.ToUpper(method)+" "+path] = v
+		}
+	}
+	return validators, nil
+}
+
+// paramValue converts a param to the JSON type of its schema
+func paramValue(value string, typ string) (interface{}, error) {
+	switch typ {
+	case "integer":
+		_, err := strconv.ParseInt(value, 10, 64)
+		return json.Number(value), err
+	case "number":
+		_, err := strconv.ParseFloat(value, 64)
+		return json.Number(value), err
+	case "boolean":
+		return strconv.ParseBool(value)
+	}
+	return value, nil
+}
+
+// validationDetails flattens a validation error into the details of a
+// genericError
+func validationDetails(in string, err error) []map[string]interface{} {
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return []map[string]interface{}{{"in": in, "message": err.Error()}}
+	}
+	if len(ve.Causes) == 0 {
+		return []map[string]interface{}{{"in": in, "pointer": ve.InstancePtr, "message": ve.Message}}
+	}
+	details := make([]map[string]interface{}, 0, len(ve.Causes))
+	for _, cause := range ve.Causes {
+		details = append(details, validationDetails(in, cause)...)
+	}
+	return details
+}
+
+// validate checks r against the operation of the OpenAPI document, returning
+// the status code, reason and details of a failure
+func (v *operationValidator) validate(rw http.ResponseWriter, r *http.Request, vars map[string]string) (int, string, []map[string]interface{}) {
+	query := r.URL.Query()
+	for _, p := range v.params {
+		var value string
+		var ok bool
+		switch p.in {
+		case "path":
+			value, ok = vars[p.name]
+		case "query":
+			value, ok = query.Get(p.name), query.Get(p.name) != ""
+		case "header":
+			value, ok = r.Header.Get(p.name), r.Header.Get(p.name) != ""
+		default:
+			continue
+		}
+		if !ok {
+			if p.required {
+				return 400, fmt.Sprintf("Missing %s %s param", p.name, p.in), nil
+			}
+			continue
+		}
+		converted, err := paramValue(value, p.typ)
+		if err == nil {
+			err = p.schema.ValidateInterface(converted)
+		}
+		if err != nil {
+			return 400, fmt.Sprintf("Invalid %s %s param", p.name, p.in), validationDetails(p.in+":"+p.name, err)
+		}
+	}
+
+	if v.bodies == nil {
+		return 0, "", nil
+	}
+	if r.ContentLength == 0 && r.Header.Get("Content-Type") == "" {
+		if v.bodyRequired {
+			return 400, "Missing body", nil
+		}
+		return 0, "", nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	schema, ok := v.bodies[mediaType]
+	if !ok {
+		accepted := make([]string, 0, len(v.bodies))
+		for mediaType := range v.bodies {
+			accepted = append(accepted, `"`+mediaType+`"`)
+		}
+		return 400, fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only %s is valid)`, r.Header.Get("Content-Type"), strings.Join(accepted, " or ")), nil
+	}
+	if mediaType != "application/json" {
+		return 0, "", nil
+	}
+
+	// JSON bodies are read whole, by the handler when it decodes them itself
+	maxBodySize := config.Get().Batch.MaxBodySize
+	if r.ContentLength > maxBodySize {
+		return 413, fmt.Sprintf("Bodies can't hold more than %d bytes", maxBodySize), nil
+	}
+	r.Body = http.MaxBytesReader(rw, r.Body, maxBodySize)
+	if schema == nil {
+		return 0, "", nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return 413, fmt.Sprintf("Bodies can't hold more than %d bytes", maxBodySize), nil
+	} else if err != nil {
+		return 400, "Couldn't read body", nil
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	doc, err := jsonschema.DecodeJSON(bytes.NewReader(body))
+	if err != nil {
+		return 400, "Couldn't decode body", nil
+	}
+	err = schema.ValidateInterface(doc)
+	if err != nil {
+		return 400, "Body doesn't match the schema of the endpoint", validationDetails("body", err)
+	}
+	return 0, "", nil
+}
+
+// validateRequests is a middleware rejecting the requests not conforming to
+// the OpenAPI document before their handler runs
+func validateRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		v, ok := openAPIValidators[r.Method+" "+routeLabel(r)]
+		if !ok {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		code, reason, details := v.validate(rw, r, mux.Vars(r))
+		if code != 0 {
+			writeErrorDetails(rw, r, code, reason, details)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// openAPI serves the OpenAPI document
+func openAPI(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(200)
+	rw.Write(openAPISpec)
+}
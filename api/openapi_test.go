@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adi/sketo/config"
+	"github.com/gorilla/mux"
+)
+
+func TestOpenAPIDescribesAndValidatesRoutes(t *testing.T) {
+
+	apiMux, _ := newTestAPI(t, func(cfg *config.Config) {
+		cfg.Batch.MaxBodySize = 1024
+	})
+
+	// Every route has an operation in the document
+	apiMux.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		path := routePatternRegex.ReplaceAllString(tpl, "{$1}")
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			if _, ok := openAPIValidators[method+" "+path]; !ok {
+				t.Error(fmt.Errorf("%s %s isn't in the OpenAPI document", method, path))
+			}
+		}
+		if len(methods) == 0 && route.GetHandler() != nil {
+			// Routes of any method need at least one operation
+			described := false
+			for operation := range openAPIValidators {
+				described = described || strings.HasSuffix(operation, " "+path)
+			}
+			if !described {
+				t.Error(fmt.Errorf("%s isn't in the OpenAPI document", path))
+			}
+		}
+		return nil
+	})
+
+	rw := doJSON(apiMux, "GET", openAPIPath, nil)
+	var doc map[string]interface{}
+	err := json.NewDecoder(rw.Body).Decode(&doc)
+	if err != nil || rw.Code != http.StatusOK || doc["openapi"] != "3.0.3" {
+		t.Error(fmt.Errorf("OpenAPI document returned %d (%v)", rw.Code, err))
+	}
+
+	for _, c := range []struct {
+		method string
+		url    string
+		body   interface{}
+		code   int
+	}{
+		{"GET", "/engines/acp/ory/exact/policies?offset=-1", nil, 400},
+		{"GET", "/engines/acp/ory/exact/policies?limit=ten", nil, 400},
+		{"GET", "/engines/acp/ory/exact/policies?offset=0&limit=10", nil, 200},
+		{"PUT", "/engines/acp/ory/exact/policies", map[string]interface{}{"id": "p1", "subjects": "alice"}, 400},
+		{"PUT", "/engines/acp/ory/exact/policies", map[string]interface{}{"id": "p1", "subjects": nil, "effect": "allow"}, 200},
+		{"POST", "/engines/acp/ory/exact/allowed", map[string]interface{}{"subject": 1}, 400},
+		{"POST", "/engines/acp/ory/exact/allowed", map[string]interface{}{"subject": "alice", "resource": "r", "action": "read"}, 200},
+		{"POST", "/engines/acp/ory/exact/allowed", map[string]interface{}{"subject": strings.Repeat("a", 1024), "resource": "r", "action": "read"}, 413},
+		{"PUT", "/engines/acp/ory/exact/policies", map[string]interface{}{"id": "p2", "subjects": []string{strings.Repeat("a", 1024)}, "effect": "allow"}, 413},
+		{"POST", "/engines/acp/ory/exact/reindex?kind=documents", nil, 400},
+		{"DELETE", "/engines/acp/ory?dryRun=maybe", nil, 400},
+	} {
+		rw := doJSON(apiMux, c.method, c.url, c.body)
+		if rw.Code != c.code {
+			t.Error(fmt.Errorf("%s %s returned %d instead of %d: %s", c.method, c.url, rw.Code, c.code, strings.TrimSpace(rw.Body.String())))
+			continue
+		}
+		if c.code >= 400 {
+			var e genericError
+			err := json.NewDecoder(rw.Body).Decode(&e)
+			if err != nil || e.Code != c.code || e.Reason == "" {
+				t.Error(fmt.Errorf("%s %s returned error %+v (%v)", c.method, c.url, e, err))
+			}
+		}
+	}
+
+}
+
+func TestOpenAPILimitsStreamedBodies(t *testing.T) {
+
+	apiMux, _ := newTestAPI(t, func(cfg *config.Config) {
+		cfg.Batch.MaxBodySize = 1024
+	})
+
+	// Bodies of unknown length are cut at the limit while they're read
+	body := `{"id": "p1", "subjects": ["` + strings.Repeat("a", 1024) + `"], "effect": "allow"}`
+	req := httptest.NewRequest("PUT", "/engines/acp/ory/exact/policies", ioutil.NopCloser(strings.NewReader(body)))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "application/json")
+	rw := httptest.NewRecorder()
+	apiMux.ServeHTTP(rw, req)
+	if rw.Code != http.StatusRequestEntityTooLarge {
+		t.Error(fmt.Errorf("streamed body over the limit returned %d: %s", rw.Code, strings.TrimSpace(rw.Body.String())))
+	}
+
+}
@@ -1,18 +1,21 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 
+	"github.com/adi/sketo/config"
 	"github.com/adi/sketo/db"
+	"github.com/adi/sketo/tracing"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
-func listOryAccessControlPolicies(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func listOryAccessControlPolicies(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		flavor := params["flavor"]
@@ -23,8 +26,7 @@ func listOryAccessControlPolicies(acpDB *db.DB) func(rw http.ResponseWriter, r *
 		if offsetStr != "" {
 			offset, err = strconv.ParseInt(offsetStr, 10, 64)
 			if err != nil {
-				rw.WriteHeader(400)
-				rw.Write([]byte("Invalid offset query param\n"))
+				writeError(rw, r, 400, "Invalid offset query param")
 				return
 			}
 		}
@@ -33,8 +35,7 @@ func listOryAccessControlPolicies(acpDB *db.DB) func(rw http.ResponseWriter, r *
 		if limitStr != "" {
 			limit, err = strconv.ParseInt(limitStr, 10, 64)
 			if err != nil {
-				rw.WriteHeader(400)
-				rw.Write([]byte("Invalid limit query param\n"))
+				writeError(rw, r, 400, "Invalid limit query param")
 				return
 			}
 		}
@@ -42,89 +43,21 @@ func listOryAccessControlPolicies(acpDB *db.DB) func(rw http.ResponseWriter, r *
 		resource := r.FormValue("resource")
 		action := r.FormValue("action")
 
-		if flavor == "exact" {
-			err = acpDB.List(policyBasePrefix(flavor), policyFilter(subject, resource, action), offset, limit, func(keys []string, values [][]byte) error {
-				rw.Header().Add("Content-Type", "application/json")
-				rw.WriteHeader(200)
-				ret := make([]oryAccessControlPolicy, 0, len(values))
-				for _, value := range values {
-					var item oryAccessControlPolicy
-					err := json.Unmarshal(value, &item)
-					if err != nil {
-						return err
-					}
-					ret = append(ret, item)
-				}
-				jsonEnc := json.NewEncoder(rw)
-				return jsonEnc.Encode(ret)
-			})
-
-			if err != nil {
-				log.Printf("Error listing ACPs: %v\n", err)
-				rw.WriteHeader(500)
-				rw.Write([]byte("Server error\n"))
-				return
-			}
-
-		} else {
-			rw.Header().Add("Content-Type", "application/json")
-			rw.WriteHeader(200)
-
-			ret := make([]oryAccessControlPolicy, 0)
-			err = acpDB.Enumerate(policyBasePrefix(flavor), func(key string, value []byte) (bool, error) {
-				var err error
-				var item oryAccessControlPolicy
-				err = json.Unmarshal(value, &item)
-				if err != nil {
-					return false, err
-				}
-				var include bool
-				include, err = matchesAny(flavor, item.Subjects, subject)
-				if err != nil {
-					return false, err
-				}
-				if include {
-					include, err = matchesAny(flavor, item.Resources, resource)
-					if err != nil {
-						return false, err
-					}
-				}
-				if include {
-					include, err = matchesAny(flavor, item.Actions, action)
-					if err != nil {
-						return false, err
-					}
-				}
-				if include {
-					ret = append(ret, item)
-				}
-				return true, nil
-			})
-			if err != nil {
-				log.Printf("Error listing ACPs: %v\n", err)
-				rw.WriteHeader(500)
-				rw.Write([]byte("Server error\n"))
-				return
-			}
-
-			jsonEnc := json.NewEncoder(rw)
-			err := jsonEnc.Encode(ret)
-			if err != nil {
-				log.Printf("Error listing ACPs: %v\n", err)
-				rw.WriteHeader(500)
-				rw.Write([]byte("Server error\n"))
-				return
-			}
-
+		ret, err := listPolicies(r.Context(), acpDB, flavor, subject, resource, action, offset, limit)
+		if err != nil {
+			log.Printf("Error listing ACPs: %v\n", err)
+			writeError(rw, r, 500, "")
+			return
 		}
+
+		writeJSON(rw, 200, ret)
 	}
 }
 
-func upsertOryAccessControlPolicy(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func upsertOryAccessControlPolicy(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("Content-Type") != "application/json" {
-			rw.WriteHeader(400)
-			rw.Write([]byte(fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only "application/json" is valid)`, r.Header.Get("Content-Type"))))
+			writeError(rw, r, 400, fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only "application/json" is valid)`, r.Header.Get("Content-Type")))
 			return
 		}
 		params := mux.Vars(r)
@@ -133,102 +66,53 @@ func upsertOryAccessControlPolicy(acpDB *db.DB) func(rw http.ResponseWriter, r *
 		jsonDec := json.NewDecoder(r.Body)
 		err := jsonDec.Decode(&body)
 		if err != nil {
-			rw.WriteHeader(400)
-			rw.Write([]byte("Couldn't decode body\n"))
+			writeError(rw, r, 400, "Couldn't decode body")
 			return
 		}
 
 		if body.ID == "" {
 			genID, err := uuid.NewUUID()
 			if err != nil {
-				rw.WriteHeader(500)
-				rw.Write([]byte("Couldn't generate ID\n"))
+				writeError(rw, r, 500, "Couldn't generate ID")
 				return
 			}
 			body.ID = genID.String()
 		}
 
-		id := body.ID
-
-		// Save doc
-		docPrefix := docSuffix(id)
-		err = acpDB.Set(policyBasePrefix(flavor), docPrefix, body)
+		// Save doc along with its indexes
+		_, err = upsertPolicies(acpDB, flavor, []oryAccessControlPolicy{body}, true)
 		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
+			writeError(rw, r, 500, "")
 			return
 		}
 
-		if flavor == "exact" {
-			// Save indexes to doc
-			suffixes := make([]string, 0)
-			for _, subject := range body.Subjects {
-				for _, resource := range body.Resources {
-					for _, action := range body.Actions {
-						suffixes = append(suffixes, policySuffix(subject, resource, action, id))
-					}
-					suffixes = append(suffixes, policySuffix(subject, resource, "", id))
-				}
-				for _, action := range body.Actions {
-					suffixes = append(suffixes, policySuffix(subject, "", action, id))
-				}
-				suffixes = append(suffixes, policySuffix(subject, "", "", id))
-			}
-			for _, resource := range body.Resources {
-				for _, action := range body.Actions {
-					suffixes = append(suffixes, policySuffix("", resource, action, id))
-				}
-				suffixes = append(suffixes, policySuffix("", resource, "", id))
-			}
-			for _, action := range body.Actions {
-				suffixes = append(suffixes, policySuffix("", "", action, id))
-			}
-			suffixes = append(suffixes, policySuffix("", "", "", id))
-			err = acpDB.RefMany(policyBasePrefix(flavor), suffixes)
-			if err != nil {
-				rw.WriteHeader(500)
-				rw.Write([]byte("Server error\n"))
-				return
-			}
-		}
-
-		rw.Header().Add("Content-Type", "application/json")
-		jsonEnc := json.NewEncoder(rw)
-		rw.WriteHeader(200)
-		err = jsonEnc.Encode(body)
-		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
-			return
-		}
-
-		switch flavor {
-		case "regex":
-			CntRegexPolicies++
-		case "glob":
-			CntGlobPolicies++
-		case "exact":
-			CntExactPolicies++
-		}
+		writeJSON(rw, 200, body)
 
 	}
 }
 
-func upsertOryAccessControlPolicies(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func upsertOryAccessControlPolicies(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("Content-Type") != "application/json" {
-			rw.WriteHeader(400)
-			rw.Write([]byte(fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only "application/json" is valid)`, r.Header.Get("Content-Type"))))
+			writeError(rw, r, 400, fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only "application/json" is valid)`, r.Header.Get("Content-Type")))
 			return
 		}
 		params := mux.Vars(r)
 		flavor := params["flavor"]
+		atomic := false
+		if atomicStr := r.FormValue("atomic"); atomicStr != "" {
+			var err error
+			atomic, err = strconv.ParseBool(atomicStr)
+			if err != nil {
+				writeError(rw, r, 400, "Invalid atomic query param")
+				return
+			}
+		}
 		var bodies []oryAccessControlPolicy
 		jsonDec := json.NewDecoder(r.Body)
 		err := jsonDec.Decode(&bodies)
 		if err != nil {
-			rw.WriteHeader(400)
-			rw.Write([]byte("Couldn't decode body\n"))
+			writeError(rw, r, 400, "Couldn't decode body")
 			return
 		}
 
@@ -237,225 +121,267 @@ func upsertOryAccessControlPolicies(acpDB *db.DB) func(rw http.ResponseWriter, r
 			if bodies[i].ID == "" {
 				genID, err := uuid.NewUUID()
 				if err != nil {
-					rw.WriteHeader(500)
-					rw.Write([]byte("Couldn't generate ID\n"))
+					writeError(rw, r, 500, "Couldn't generate ID")
 					return
 				}
 				bodies[i].ID = genID.String()
 			}
 		}
 
-		// Group docs
-		bodiesLen := len(bodies)
-		abstractBodies := make([]interface{}, bodiesLen)
-		docSuffixes := make([]string, bodiesLen)
-		for i, body := range bodies {
-			abstractBodies[i] = body
-			docSuffixes[i] = docSuffix(body.ID)
-		}
-
-		// Save docs
-		err = acpDB.SetMany(policyBasePrefix(flavor), docSuffixes, abstractBodies)
-		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
+		results, err := upsertPolicies(acpDB, flavor, bodies, atomic)
+		if err == errBatchTooLarge {
+			writeError(rw, r, 413, fmt.Sprintf("Atomic batches can't hold more than %d docs", config.Get().Batch.MaxSize))
 			return
 		}
-
-		if flavor == "exact" {
-			// Group indexes to doc
-			suffixes := make([]string, 0)
-			for _, body := range bodies {
-				id := body.ID
-				for _, subject := range body.Subjects {
-					for _, resource := range body.Resources {
-						for _, action := range body.Actions {
-							suffixes = append(suffixes, policySuffix(subject, resource, action, id))
-						}
-						suffixes = append(suffixes, policySuffix(subject, resource, "", id))
-					}
-					for _, action := range body.Actions {
-						suffixes = append(suffixes, policySuffix(subject, "", action, id))
-					}
-					suffixes = append(suffixes, policySuffix(subject, "", "", id))
-				}
-				for _, resource := range body.Resources {
-					for _, action := range body.Actions {
-						suffixes = append(suffixes, policySuffix("", resource, action, id))
-					}
-					suffixes = append(suffixes, policySuffix("", resource, "", id))
-				}
-				for _, action := range body.Actions {
-					suffixes = append(suffixes, policySuffix("", "", action, id))
-				}
-				suffixes = append(suffixes, policySuffix("", "", "", id))
-			}
-
-			// Save indexes to doc
-			err = acpDB.RefMany(policyBasePrefix(flavor), suffixes)
-			if err != nil {
-				rw.WriteHeader(500)
-				rw.Write([]byte("Server error\n"))
-				return
-			}
-
-		}
-
-		switch flavor {
-		case "regex":
-			CntRegexPolicies += int64(bodiesLen)
-		case "glob":
-			CntGlobPolicies += int64(bodiesLen)
-		case "exact":
-			CntExactPolicies += int64(bodiesLen)
-		}
-
-		rw.Header().Add("Content-Type", "application/json")
-		jsonEnc := json.NewEncoder(rw)
-		rw.WriteHeader(200)
-		err = jsonEnc.Encode(map[string]interface{}{
-			"total_imported": len(bodies),
-		})
+		status := 200
 		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
-			return
+			// Nothing was written
+			status = 500
 		}
+		writeJSON(rw, status, newBatchResult(results))
 
 	}
 }
 
-func getOryAccessControlPolicy(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func getOryAccessControlPolicy(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		flavor := params["flavor"]
 		id := params["id"]
 
+		_, span := tracing.StartSpan(r.Context(), "store.get")
 		err := acpDB.Get(policyBasePrefix(flavor), docSuffix(id), func(value []byte) error {
 			rw.Header().Add("Content-Type", "application/json")
 			rw.WriteHeader(200)
 			rw.Write(value)
 			return nil
 		})
+		span.End()
 		if err != nil {
 			if err == db.ErrKeyNotFound {
-				rw.WriteHeader(404)
-				rw.Write([]byte("Not found\n"))
+				writeError(rw, r, 404, "Not found")
 				return
 			}
 			log.Printf("Error getting ACP: %v\n", err)
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
+			writeError(rw, r, 500, "")
 			return
 		}
 
 	}
 }
 
-func deleteOryAccessControlPolicy(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func deleteOryAccessControlPolicy(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		flavor := params["flavor"]
 		id := params["id"]
 
-		// Get doc
-		var subjects []string
-		var resources []string
-		var actions []string
-		err := acpDB.Get(policyBasePrefix(flavor), docSuffix(id), func(value []byte) error {
-			var tmpBody oryAccessControlPolicy
-			err := json.Unmarshal(value, &tmpBody)
-			if err != nil {
-				return fmt.Errorf("Couldn't decode body: %w", err)
-			}
-			for _, s := range tmpBody.Subjects {
-				sbytes := []byte(s)
-				scopy := make([]byte, 0, len(sbytes))
-				copy(scopy, sbytes)
-				subjects = append(subjects, string(scopy))
-			}
-			for _, r := range tmpBody.Resources {
-				rbytes := []byte(r)
-				rcopy := make([]byte, 0, len(rbytes))
-				copy(rcopy, rbytes)
-				resources = append(resources, string(rcopy))
-			}
-			for _, a := range tmpBody.Actions {
-				abytes := []byte(a)
-				acopy := make([]byte, 0, len(abytes))
-				copy(acopy, abytes)
-				actions = append(actions, string(acopy))
+		_, err := deletePolicy(acpDB, flavor, id)
+		if err != nil {
+			log.Printf("Error deleting ACP: %v\n", err)
+			writeError(rw, r, 500, "")
+			return
+		}
+
+		rw.WriteHeader(204)
+
+	}
+}
+
+// listPolicies returns the policies of flavor matching the subject, resource
+// and action given, any of them matching when empty
+func listPolicies(ctx context.Context, acpDB db.Store, flavor string, subject string, resource string, action string, offset int64, limit int64) ([]oryAccessControlPolicy, error) {
+	ret := make([]oryAccessControlPolicy, 0)
+	if flavor == "exact" {
+		_, span := tracing.StartSpan(ctx, "store.list")
+		defer span.End()
+		err := listIndex(acpDB, policyBasePrefix(flavor), policyIndex(subject, resource, action), offset, limit, func(keys []string, values [][]byte) error {
+			for _, value := range values {
+				var item oryAccessControlPolicy
+				err := json.Unmarshal(value, &item)
+				if err != nil {
+					return err
+				}
+				ret = append(ret, item)
 			}
 			return nil
 		})
-		objectFound := true
 		if err != nil {
-			if err == db.ErrKeyNotFound {
-				objectFound = false
-			} else {
-				log.Printf("Error getting ACP: %v\n", err)
-				rw.WriteHeader(500)
-				rw.Write([]byte("Server error\n"))
-				return
-			}
+			return nil, err
 		}
+		return ret, nil
+	}
 
-		// Delete doc
-		idPrefix := docSuffix(id)
-		err = acpDB.Del(policyBasePrefix(flavor), idPrefix)
+	policies, err := enumeratePolicies(ctx, acpDB, flavor)
+	if err != nil {
+		return nil, err
+	}
+	_, span := tracing.StartSpan(ctx, "match")
+	defer span.End()
+	span.SetAttribute("candidates", len(policies))
+	for _, policy := range policies {
+		include, err := policyMatches(flavor, policy, subject, resource, action)
 		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
-			return
+			return nil, err
 		}
+		if include {
+			ret = append(ret, policy)
+		}
+	}
+	return ret, nil
+}
 
-		if flavor == "exact" {
-			if objectFound {
-				// Delete indexes to doc
-				suffixes := make([]string, 0)
-				for _, subject := range subjects {
-					for _, resource := range resources {
-						for _, action := range actions {
-							suffixes = append(suffixes, policySuffix(subject, resource, action, id))
-						}
-						suffixes = append(suffixes, policySuffix(subject, resource, "", id))
-					}
-					for _, action := range actions {
-						suffixes = append(suffixes, policySuffix(subject, "", action, id))
-					}
-					suffixes = append(suffixes, policySuffix(subject, "", "", id))
-				}
-				for _, resource := range resources {
-					for _, action := range actions {
-						suffixes = append(suffixes, policySuffix("", resource, action, id))
-					}
-					suffixes = append(suffixes, policySuffix("", resource, "", id))
-				}
-				for _, action := range actions {
-					suffixes = append(suffixes, policySuffix("", "", action, id))
-				}
-				suffixes = append(suffixes, policySuffix("", "", "", id))
-				err = acpDB.DelManyRefs(policyBasePrefix(flavor), suffixes)
-				if err != nil {
-					rw.WriteHeader(500)
-					rw.Write([]byte("Server error\n"))
-					return
-				}
-			}
+// enumeratePolicies reads every policy of a flavor matching by pattern
+func enumeratePolicies(ctx context.Context, acpDB db.Store, flavor string) ([]oryAccessControlPolicy, error) {
+	_, span := tracing.StartSpan(ctx, "store.enumerate")
+	defer span.End()
+	ret := make([]oryAccessControlPolicy, 0)
+	err := acpDB.Enumerate(policyBasePrefix(flavor), func(key string, value []byte) (bool, error) {
+		var item oryAccessControlPolicy
+		err := json.Unmarshal(value, &item)
+		if err != nil {
+			return false, err
 		}
+		ret = append(ret, item)
+		return true, nil
+	})
+	span.SetAttribute("candidates", len(ret))
+	return ret, err
+}
 
-		if objectFound {
-			switch flavor {
-			case "regex":
-				CntRegexPolicies--
-			case "glob":
-				CntGlobPolicies--
-			case "exact":
-				CntExactPolicies--
+// policyMatches reports whether a policy of a flavor matching by pattern
+// matches the subject, resource and action given, any of them matching when
+// empty
+func policyMatches(flavor string, policy oryAccessControlPolicy, subject string, resource string, action string) (bool, error) {
+	include, err := matchesAny(flavor, policy.Subjects, subject)
+	if err != nil || !include {
+		return false, err
+	}
+	include, err = matchesAny(flavor, policy.Resources, resource)
+	if err != nil || !include {
+		return false, err
+	}
+	return matchesAny(flavor, policy.Actions, action)
+}
+
+// deletePolicy deletes a policy along with its indexes; it reports whether the
+// policy existed
+func deletePolicy(acpDB db.Store, flavor string, id string) (bool, error) {
+	var found bool
+	err := acpDB.Update(func(txn db.Txn) error {
+		var err error
+		found, err = dropPolicy(txn, flavor, id)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if found {
+		addPolicyCount(flavor, -1)
+	}
+	return found, nil
+}
+
+// policySuffixes returns the exact flavor indexes to a policy
+func policySuffixes(body oryAccessControlPolicy) []string {
+	id := body.ID
+	suffixes := make([]string, 0)
+	for _, subject := range body.Subjects {
+		for _, resource := range body.Resources {
+			for _, action := range body.Actions {
+				suffixes = append(suffixes, policySuffix(subject, resource, action, id))
 			}
+			suffixes = append(suffixes, policySuffix(subject, resource, "", id))
+		}
+		for _, action := range body.Actions {
+			suffixes = append(suffixes, policySuffix(subject, "", action, id))
+		}
+		suffixes = append(suffixes, policySuffix(subject, "", "", id))
+	}
+	for _, resource := range body.Resources {
+		for _, action := range body.Actions {
+			suffixes = append(suffixes, policySuffix("", resource, action, id))
 		}
+		suffixes = append(suffixes, policySuffix("", resource, "", id))
+	}
+	for _, action := range body.Actions {
+		suffixes = append(suffixes, policySuffix("", "", action, id))
+	}
+	suffixes = append(suffixes, policySuffix("", "", "", id))
+	return suffixes
+}
 
-		rw.WriteHeader(204)
+// putPolicy saves a doc along with its exact flavor indexes, dropping the
+// indexes of the version it replaces; it reports whether the doc is new
+func putPolicy(txn db.Txn, flavor string, body oryAccessControlPolicy) (bool, error) {
+	var old *oryAccessControlPolicy
+	err := txn.Get(policyBasePrefix(flavor), docSuffix(body.ID), func(value []byte) error {
+		old = &oryAccessControlPolicy{}
+		return json.Unmarshal(value, old)
+	})
+	if err != nil && err != db.ErrKeyNotFound {
+		return false, err
+	}
+
+	err = txn.Set(policyBasePrefix(flavor), docSuffix(body.ID), body)
+	if err != nil {
+		return false, err
+	}
+
+	if flavor == "exact" {
+		var removed []string
+		if old != nil {
+			removed = policySuffixes(*old)
+		}
+		err = updateRefs(txn, policyBasePrefix(flavor), removed, policySuffixes(body))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return old == nil, nil
+}
+
+// dropPolicy deletes a doc along with its exact flavor indexes; it reports
+// whether the doc existed
+func dropPolicy(txn db.Txn, flavor string, id string) (bool, error) {
+	var old *oryAccessControlPolicy
+	err := txn.Get(policyBasePrefix(flavor), docSuffix(id), func(value []byte) error {
+		old = &oryAccessControlPolicy{}
+		return json.Unmarshal(value, old)
+	})
+	if err == db.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = txn.Del(policyBasePrefix(flavor), docSuffix(id))
+	if err != nil {
+		return false, err
+	}
+
+	if flavor == "exact" {
+		err = updateRefs(txn, policyBasePrefix(flavor), policySuffixes(*old), nil)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
 
+// upsertPolicies saves docs and reports each one's outcome, keeping the
+// policy counter in line with the docs actually inserted
+func upsertPolicies(acpDB db.Store, flavor string, bodies []oryAccessControlPolicy, atomic bool) ([]upsertResult, error) {
+	ids := make([]string, len(bodies))
+	for i, body := range bodies {
+		ids[i] = body.ID
 	}
+	results, err := upsertBatch(acpDB, ids, atomic, func(txn db.Txn, i int) (bool, error) {
+		return putPolicy(txn, flavor, bodies[i])
+	})
+	addPolicyCount(flavor, countInserted(results))
+	return results, err
 }
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+func newTestRouter(acpDB db.Store) *mux.Router {
+	apiMux := mux.NewRouter()
+	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/allowed", allowed(acpDB)).Methods("POST")
+	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/policies", upsertOryAccessControlPolicy(acpDB)).Methods("PUT")
+	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/policies/{id}", getOryAccessControlPolicy(acpDB)).Methods("GET")
+	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/policies/{id}", deleteOryAccessControlPolicy(acpDB)).Methods("DELETE")
+	return apiMux
+}
+
+func doJSON(apiMux *mux.Router, method string, url string, body interface{}) *httptest.ResponseRecorder {
+	var encoded []byte
+	if body != nil {
+		encoded, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, url, bytes.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/json")
+	rw := httptest.NewRecorder()
+	apiMux.ServeHTTP(rw, req)
+	return rw
+}
+
+func isAllowed(t *testing.T, apiMux *mux.Router, flavor string, input oryAccessControlPolicyAllowedInput) bool {
+	rw := doJSON(apiMux, "POST", "/engines/acp/ory/"+flavor+"/allowed", input)
+	if rw.Code != http.StatusOK {
+		t.Fatal(fmt.Errorf("[%s] allowed returned status %d", flavor, rw.Code))
+	}
+	var result authorizationResult
+	err := json.NewDecoder(rw.Body).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Allowed
+}
+
+func TestPolicyLifecycleOnMemStore(t *testing.T) {
+
+	for _, flavor := range []string{"exact", "glob"} {
+		apiMux := newTestRouter(db.NewMemStore())
+		input := oryAccessControlPolicyAllowedInput{
+			Subject:  "users:alice",
+			Resource: "articles:1",
+			Action:   "read",
+		}
+
+		if isAllowed(t, apiMux, flavor, input) {
+			t.Error(fmt.Errorf("[%s] allowed without any policy", flavor))
+		}
+
+		rw := doJSON(apiMux, "PUT", "/engines/acp/ory/"+flavor+"/policies", oryAccessControlPolicy{
+			ID:        "p1",
+			Subjects:  []string{"users:alice"},
+			Resources: []string{"articles:1"},
+			Actions:   []string{"read"},
+			Effect:    "allow",
+		})
+		if rw.Code != http.StatusOK {
+			t.Fatal(fmt.Errorf("[%s] upsert returned status %d", flavor, rw.Code))
+		}
+		if rw := doJSON(apiMux, "GET", "/engines/acp/ory/"+flavor+"/policies/p1", nil); rw.Code != http.StatusOK {
+			t.Error(fmt.Errorf("[%s] get returned status %d", flavor, rw.Code))
+		}
+		if !isAllowed(t, apiMux, flavor, input) {
+			t.Error(fmt.Errorf("[%s] not allowed by matching policy", flavor))
+		}
+
+		if rw := doJSON(apiMux, "DELETE", "/engines/acp/ory/"+flavor+"/policies/p1", nil); rw.Code != http.StatusNoContent {
+			t.Error(fmt.Errorf("[%s] delete returned status %d", flavor, rw.Code))
+		}
+		if isAllowed(t, apiMux, flavor, input) {
+			t.Error(fmt.Errorf("[%s] still allowed after deleting the policy", flavor))
+		}
+	}
+
+}
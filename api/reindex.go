@@ -0,0 +1,505 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+// reindexBatchSize is how many docs a reindex job indexes in a transaction
+const reindexBatchSize = 1000
+
+// reindexKind describes how the documents of a kind are indexed
+type reindexKind struct {
+	name       string
+	basePrefix func(flavor string) string
+	// refs is the prefix of the index refs under the base prefix
+	refs string
+	// index returns the ID of a doc and its exact flavor indexes
+	index func(value []byte) (string, []string, error)
+}
+
+var reindexKinds = []reindexKind{
+	{
+		name:       "policies",
+		basePrefix: policyBasePrefix,
+		refs:       "s/",
+		index: func(value []byte) (string, []string, error) {
+			var item oryAccessControlPolicy
+			err := json.Unmarshal(value, &item)
+			if err != nil {
+				return "", nil, err
+			}
+			return item.ID, policySuffixes(item), nil
+		},
+	},
+	{
+		name:       "roles",
+		basePrefix: roleBasePrefix,
+		refs:       "m/",
+		index: func(value []byte) (string, []string, error) {
+			var item oryAccessControlPolicyRole
+			err := json.Unmarshal(value, &item)
+			if err != nil {
+				return "", nil, err
+			}
+			return item.ID, roleSuffixes(item), nil
+		},
+	},
+}
+
+// generationsPrefix holds, for each base prefix, where its exact indexes are
+const generationsPrefix = "indexes/"
+
+// indexGenerations tells where the exact indexes of a base prefix are. Checks
+// read the current generation; while a reindex job builds the next one,
+// writes maintain both, and the job swaps it in by changing Current.
+// Generation 0 has its refs right under the base prefix
+type indexGenerations struct {
+	Current int64 `json:"current"`
+	Next    int64 `json:"next,omitempty"`
+}
+
+// generationPrefix returns the prefix of the refs of a generation under the
+// base prefix
+func generationPrefix(gen int64) string {
+	if gen == 0 {
+		return ""
+	}
+	return fmt.Sprintf("g/%d/", gen)
+}
+
+// maintained returns the generations writes keep up to date
+func (g indexGenerations) maintained() []int64 {
+	if g.Next == 0 {
+		return []int64{g.Current}
+	}
+	return []int64{g.Current, g.Next}
+}
+
+// readGenerations reads the index generations of basePrefix in txn
+func readGenerations(txn db.Txn, basePrefix string) (indexGenerations, error) {
+	var gens indexGenerations
+	err := txn.Get(generationsPrefix, basePrefix, func(value []byte) error {
+		return json.Unmarshal(value, &gens)
+	})
+	if err == db.ErrKeyNotFound {
+		err = nil
+	}
+	return gens, err
+}
+
+// updateRefs removes and adds exact index refs of basePrefix in every
+// generation writes maintain
+func updateRefs(txn db.Txn, basePrefix string, removed []string, added []string) error {
+	gens, err := readGenerations(txn, basePrefix)
+	if err != nil {
+		return err
+	}
+	for _, gen := range gens.maintained() {
+		for _, suffix := range removed {
+			err = txn.Del(basePrefix, generationPrefix(gen)+suffix)
+			if err != nil {
+				return err
+			}
+		}
+		for _, suffix := range added {
+			err = txn.Ref(basePrefix, generationPrefix(gen)+suffix)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// currentGeneration returns the prefix of the exact indexes of basePrefix
+// checks read. The postgres backend doesn't store indexes
+func currentGeneration(acpDB db.Store, basePrefix string) (string, error) {
+	if _, ok := acpDB.(*db.PostgresStore); ok {
+		return "", nil
+	}
+	var gens indexGenerations
+	err := acpDB.Get(generationsPrefix, basePrefix, func(value []byte) error {
+		return json.Unmarshal(value, &gens)
+	})
+	if err != nil && err != db.ErrKeyNotFound {
+		return "", err
+	}
+	return generationPrefix(gens.Current), nil
+}
+
+// readIndex calls read with the prefix of the current exact indexes of
+// basePrefix, again if a reindex swapped them meanwhile: the refs of the
+// generation swapped out are only deleted after the swap
+func readIndex(acpDB db.Store, basePrefix string, read func(gen string) error) error {
+	gen, err := currentGeneration(acpDB, basePrefix)
+	if err != nil {
+		return err
+	}
+	for {
+		err = read(gen)
+		if err != nil {
+			return err
+		}
+		after, err := currentGeneration(acpDB, basePrefix)
+		if err != nil || after == gen {
+			return err
+		}
+		gen = after
+	}
+}
+
+// indexFilter is a lookup in the exact indexes: the filter of its refs, and
+// its values for the postgres backend, which joins tables instead
+type indexFilter struct {
+	key    string
+	fields db.IndexFilter
+}
+
+// listIndex lists the docs the refs of the current exact indexes of
+// basePrefix under filter point to, like List
+func listIndex(acpDB db.Store, basePrefix string, filter indexFilter, offset int64, limit int64, valuesProcessor func(keys []string, values [][]byte) error) error {
+	if pgStore, ok := acpDB.(*db.PostgresStore); ok {
+		return pgStore.ListFiltered(basePrefix, filter.fields, offset, limit, valuesProcessor)
+	}
+	var keys []string
+	var values [][]byte
+	err := readIndex(acpDB, basePrefix, func(gen string) error {
+		return acpDB.List(basePrefix, gen+filter.key, offset, limit, func(foundKeys []string, foundValues [][]byte) error {
+			keys = foundKeys
+			values = make([][]byte, len(foundValues))
+			for i, value := range foundValues {
+				values[i] = append([]byte(nil), value...)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return valuesProcessor(keys, values)
+}
+
+// reindex starts a job rebuilding the indexes of the policies and/or roles
+// (kind query param) of a flavor. Checks keep using the current indexes until
+// the rebuilt ones are swapped in at once. With wait=true the response is sent
+// when the job finishes
+func reindex(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		flavor := vars["flavor"]
+		kind := r.FormValue("kind")
+		if kind != "" && kind != "policies" && kind != "roles" {
+			writeError(rw, r, 400, "Invalid kind query param")
+			return
+		}
+		if _, ok := acpDB.(*db.PostgresStore); ok {
+			writeError(rw, r, 400, "The postgres backend doesn't store indexes")
+			return
+		}
+
+		j, conflict, err := startJob(&job{
+			Type:   "reindex",
+			Flavor: flavor,
+			Kind:   kind,
+		}, func(ctx context.Context, j *job) error {
+			return runReindex(ctx, acpDB, j)
+		})
+		if err != nil {
+			log.Printf("Error starting reindex job: %v\n", err)
+			writeError(rw, r, 500, "")
+			return
+		}
+		if conflict != nil {
+			writeError(rw, r, 409, "Conflicting job "+conflict.ID+" is running")
+			return
+		}
+		log.Printf("Started reindex job %s\n", j.ID)
+
+		rw.Header().Set("Location", "/engines/acp/ory/jobs/"+j.ID)
+		if r.FormValue("wait") != "true" {
+			writeJSON(rw, 202, j)
+			return
+		}
+		select {
+		case <-j.finished:
+		case <-r.Context().Done():
+			return
+		}
+		j = findJob(j.ID)
+		status := 200
+		if j.Status != jobSucceeded {
+			status = 500
+		}
+		writeJSON(rw, status, j)
+	}
+}
+
+// runReindex rebuilds the indexes of the job's kinds into a new generation,
+// then swaps it in and deletes the generation it replaces
+func runReindex(ctx context.Context, acpDB db.Store, j *job) error {
+	kinds := make([]reindexKind, 0, len(reindexKinds))
+	for _, kind := range reindexKinds {
+		if j.Kind == "" || j.Kind == kind.name {
+			kinds = append(kinds, kind)
+		}
+	}
+
+	for _, kind := range kinds {
+		err := acpDB.Count(kind.basePrefix(j.Flavor), "i/", func(cnt int64) error {
+			updateJob(j, func(j *job) {
+				j.Total += cnt
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	updateJob(j, func(j *job) {
+		j.Phase = "build"
+	})
+	next := make([]int64, len(kinds))
+	defer func() {
+		for i, kind := range kinds {
+			if next[i] == 0 {
+				continue
+			}
+			err := dropGeneration(acpDB, kind.basePrefix(j.Flavor), next[i])
+			if err != nil {
+				log.Printf("Error deleting indexes of reindex job %s: %v\n", j.ID, err)
+			}
+		}
+	}()
+	for i, kind := range kinds {
+		var err error
+		next[i], err = startGeneration(acpDB, kind.basePrefix(j.Flavor))
+		if err != nil {
+			return err
+		}
+		err = buildGeneration(ctx, acpDB, j, kind, next[i])
+		if err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	updateJob(j, func(j *job) {
+		j.Phase = "swap"
+	})
+	var added, removed int64
+	for i, kind := range kinds {
+		basePrefix := kind.basePrefix(j.Flavor)
+		old, err := swapGeneration(acpDB, basePrefix, next[i])
+		if err != nil {
+			return err
+		}
+		swapped := next[i]
+		next[i] = 0
+		a, r, err := diffGenerations(acpDB, basePrefix, kind.refs, old, swapped)
+		if err != nil {
+			return err
+		}
+		added += a
+		removed += r
+		oldPrefix := basePrefix + generationPrefix(old)
+		if old == 0 {
+			oldPrefix += kind.refs
+		}
+		err = acpDB.DelByPrefix(oldPrefix)
+		if err != nil {
+			return err
+		}
+	}
+	updateJob(j, func(j *job) {
+		j.Added = added
+		j.Removed = removed
+	})
+	log.Printf("Done reindexing %s (%d refs added, %d removed)\n", j.Flavor, added, removed)
+	return nil
+}
+
+// startGeneration makes writes maintain a new generation of the indexes of
+// basePrefix and returns it. The generation a previous job left behind is
+// deleted
+func startGeneration(acpDB db.Store, basePrefix string) (int64, error) {
+	var next, leftover int64
+	err := acpDB.Update(func(txn db.Txn) error {
+		gens, err := readGenerations(txn, basePrefix)
+		if err != nil {
+			return err
+		}
+		leftover = gens.Next
+		next = gens.Current + 1
+		if gens.Next >= next {
+			next = gens.Next + 1
+		}
+		gens.Next = next
+		return txn.Set(generationsPrefix, basePrefix, gens)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if leftover != 0 {
+		err = acpDB.DelByPrefix(basePrefix + generationPrefix(leftover))
+	}
+	return next, err
+}
+
+// dropGeneration stops maintaining a generation of the indexes of basePrefix
+// which wasn't swapped in, and deletes it
+func dropGeneration(acpDB db.Store, basePrefix string, gen int64) error {
+	err := acpDB.Update(func(txn db.Txn) error {
+		gens, err := readGenerations(txn, basePrefix)
+		if err != nil || gens.Next != gen {
+			return err
+		}
+		gens.Next = 0
+		return txn.Set(generationsPrefix, basePrefix, gens)
+	})
+	if err != nil {
+		return err
+	}
+	return acpDB.DelByPrefix(basePrefix + generationPrefix(gen))
+}
+
+// swapGeneration makes checks read the generation gen of the indexes of
+// basePrefix and returns the one it replaces
+func swapGeneration(acpDB db.Store, basePrefix string, gen int64) (int64, error) {
+	var old int64
+	err := acpDB.Update(func(txn db.Txn) error {
+		gens, err := readGenerations(txn, basePrefix)
+		if err != nil {
+			return err
+		}
+		if gens.Next != gen {
+			return fmt.Errorf("indexes of %s were replaced during the reindex", basePrefix)
+		}
+		old = gens.Current
+		return txn.Set(generationsPrefix, basePrefix, indexGenerations{
+			Current: gen,
+		})
+	})
+	return old, err
+}
+
+// buildGeneration writes the indexes of every doc of kind to the generation
+// gen, reindexBatchSize docs at a time. Each batch is a transaction reading
+// the current version of its docs, so writes made since they were enumerated
+// aren't undone. Only the exact flavor is indexed, so the other flavors get
+// empty indexes
+func buildGeneration(ctx context.Context, acpDB db.Store, j *job, kind reindexKind, gen int64) error {
+	basePrefix := kind.basePrefix(j.Flavor)
+	ids := make([]string, 0, reindexBatchSize)
+	flush := func() error {
+		if len(ids) == 0 || j.Flavor != "exact" {
+			ids = ids[:0]
+			return nil
+		}
+		err := acpDB.Update(func(txn db.Txn) error {
+			for _, id := range ids {
+				err := txn.Get(basePrefix, docSuffix(id), func(value []byte) error {
+					_, suffixes, err := kind.index(value)
+					if err != nil {
+						return err
+					}
+					for _, suffix := range suffixes {
+						err = txn.Ref(basePrefix, generationPrefix(gen)+suffix)
+						if err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				if err != nil && err != db.ErrKeyNotFound {
+					return err
+				}
+			}
+			return nil
+		})
+		ids = ids[:0]
+		return err
+	}
+
+	// Enumerate doesn't report the errors of every backend, so they're kept here
+	var buildErr error
+	err := acpDB.Enumerate(basePrefix+"i/", func(key string, value []byte) (bool, error) {
+		if ctx.Err() != nil {
+			buildErr = ctx.Err()
+			return false, buildErr
+		}
+		id, _, err := kind.index(value)
+		if err != nil {
+			buildErr = err
+			return false, err
+		}
+		ids = append(ids, id)
+		if len(ids) >= reindexBatchSize {
+			err = flush()
+			if err != nil {
+				buildErr = err
+				return false, err
+			}
+		}
+		var done int64
+		updateJob(j, func(j *job) {
+			j.Done++
+			done = j.Done
+		})
+		if done%10000 == 0 {
+			log.Printf("Reindexed %d\n", done)
+		}
+		return true, nil
+	})
+	if err == nil {
+		err = buildErr
+	}
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// diffGenerations counts the refs of kind the generation gen has and old
+// hasn't, and those old has and gen hasn't
+func diffGenerations(acpDB db.Store, basePrefix string, refs string, old int64, gen int64) (int64, int64, error) {
+	suffixes := func(gen int64) (map[string]bool, error) {
+		prefix := basePrefix + generationPrefix(gen) + refs
+		ret := make(map[string]bool)
+		err := acpDB.Enumerate(prefix, func(key string, value []byte) (bool, error) {
+			ret[strings.TrimPrefix(key, prefix)] = true
+			return true, nil
+		})
+		return ret, err
+	}
+	before, err := suffixes(old)
+	if err != nil {
+		return 0, 0, err
+	}
+	after, err := suffixes(gen)
+	if err != nil {
+		return 0, 0, err
+	}
+	var added, removed int64
+	for suffix := range after {
+		if !before[suffix] {
+			added++
+		}
+	}
+	for suffix := range before {
+		if !after[suffix] {
+			removed++
+		}
+	}
+	return added, removed, nil
+}
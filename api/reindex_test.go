@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+func TestReindexJobSwapsRebuiltIndexes(t *testing.T) {
+
+	acpDB := db.NewMemStore()
+	_, err := upsertPolicies(acpDB, "exact", []oryAccessControlPolicy{
+		{ID: "p1", Subjects: []string{"alice"}, Resources: []string{"r"}, Actions: []string{"read"}, Effect: "allow"},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = upsertRoles(acpDB, "exact", []oryAccessControlPolicyRole{{ID: "admins", Members: []string{"alice"}}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Lose a ref and leave a stale one behind
+	err = acpDB.DelManyRefs(policyBasePrefix("exact"), []string{policySuffix("alice", "r", "read", "p1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = acpDB.RefMany(roleBasePrefix("exact"), []string{roleSuffix("bob", "admins")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiMux := mux.NewRouter()
+	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/reindex", reindex(acpDB)).Methods("POST")
+	apiMux.HandleFunc("/engines/acp/ory/jobs/{id}", getJob).Methods("GET")
+	apiMux.HandleFunc("/engines/acp/ory/jobs/{id}", cancelJob).Methods("DELETE")
+
+	rw := doJSON(apiMux, "POST", "/engines/acp/ory/exact/reindex?wait=true", nil)
+	var j job
+	err = json.NewDecoder(rw.Body).Decode(&j)
+	if err != nil || rw.Code != http.StatusOK {
+		t.Fatal(fmt.Errorf("reindex returned %d (%v)", rw.Code, err))
+	}
+	if j.Status != jobSucceeded || j.Total != 2 || j.Done != 2 || j.Added != 1 || j.Removed != 1 {
+		t.Error(fmt.Errorf("unexpected reindex job %+v", j))
+	}
+	found := 0
+	listIndex(acpDB, policyBasePrefix("exact"), policyIndex("alice", "r", "read"), 0, -1, func(keys []string, values [][]byte) error {
+		found = len(keys)
+		return nil
+	})
+	if found != 1 {
+		t.Error(fmt.Errorf("lost ref wasn't rebuilt"))
+	}
+	listIndex(acpDB, roleBasePrefix("exact"), roleIndex("bob"), 0, -1, func(keys []string, values [][]byte) error {
+		found = len(keys)
+		return nil
+	})
+	if found != 0 {
+		t.Error(fmt.Errorf("stale ref wasn't removed"))
+	}
+	acpDB.Count(policyBasePrefix("exact"), "s/", func(cnt int64) error {
+		found = int(cnt)
+		return nil
+	})
+	if found != 0 {
+		t.Error(fmt.Errorf("swapped out indexes weren't deleted"))
+	}
+
+	rw = doJSON(apiMux, "GET", "/engines/acp/ory/jobs/"+j.ID, nil)
+	if rw.Code != http.StatusOK {
+		t.Error(fmt.Errorf("job status returned %d", rw.Code))
+	}
+	rw = doJSON(apiMux, "DELETE", "/engines/acp/ory/jobs/"+j.ID, nil)
+	if rw.Code != http.StatusConflict {
+		t.Error(fmt.Errorf("canceling a finished job returned %d", rw.Code))
+	}
+
+	// Jobs on the same flavor and kind can't run at the same time
+	running := &job{Type: "reindex", Flavor: "glob", Kind: "roles", Status: jobRunning}
+	jobsMu.Lock()
+	jobs["running"] = running
+	jobsMu.Unlock()
+	defer func() {
+		jobsMu.Lock()
+		delete(jobs, "running")
+		jobsMu.Unlock()
+	}()
+	rw = doJSON(apiMux, "POST", "/engines/acp/ory/glob/reindex", nil)
+	if rw.Code != http.StatusConflict {
+		t.Error(fmt.Errorf("conflicting reindex returned %d", rw.Code))
+	}
+	rw = doJSON(apiMux, "POST", "/engines/acp/ory/glob/reindex?kind=policies&wait=true", nil)
+	if rw.Code != http.StatusOK {
+		t.Error(fmt.Errorf("reindex of other documents returned %d", rw.Code))
+	}
+
+}
+
+// checkingStore counts the indexed policies of alice after every transaction
+type checkingStore struct {
+	db.Store
+	seen []int64
+}
+
+func (s *checkingStore) Update(fn func(txn db.Txn) error) error {
+	err := s.Store.Update(fn)
+	basePrefix := policyBasePrefix("exact")
+	readIndex(s.Store, basePrefix, func(gen string) error {
+		return s.Store.Count(basePrefix, gen+policyFilter("alice", "", ""), func(cnt int64) error {
+			s.seen = append(s.seen, cnt)
+			return nil
+		})
+	})
+	return err
+}
+
+func TestReindexSwapsAtomically(t *testing.T) {
+
+	acpDB := &checkingStore{
+		Store: db.NewMemStore(),
+	}
+	policies := make([]oryAccessControlPolicy, 0)
+	for i := 0; i < reindexBatchSize+10; i++ {
+		policy := oryAccessControlPolicy{ID: fmt.Sprintf("p%d", i), Subjects: []string{"alice"}, Effect: "allow"}
+		_, err := upsertPolicies(acpDB.Store, "exact", []oryAccessControlPolicy{policy}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		policies = append(policies, policy)
+	}
+	err := acpDB.DelByPrefix(policyBasePrefix("exact") + "s/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiMux := mux.NewRouter()
+	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/reindex", reindex(acpDB)).Methods("POST")
+	rw := doJSON(apiMux, "POST", "/engines/acp/ory/exact/reindex?kind=policies&wait=true", nil)
+	var j job
+	err = json.NewDecoder(rw.Body).Decode(&j)
+	if err != nil || rw.Code != http.StatusOK {
+		t.Fatal(fmt.Errorf("reindex returned %d (%v)", rw.Code, err))
+	}
+
+	// Checks see either none or all of the rebuilt refs
+	for _, cnt := range acpDB.seen {
+		if cnt != 0 && cnt != int64(len(policies)) {
+			t.Fatal(fmt.Errorf("checks saw %d of the %d rebuilt refs", cnt, len(policies)))
+		}
+	}
+	if len(acpDB.seen) == 0 || acpDB.seen[len(acpDB.seen)-1] != int64(len(policies)) {
+		t.Error(fmt.Errorf("rebuilt refs weren't swapped in"))
+	}
+
+	// Writes made while the indexes are rebuilt go to both generations
+	_, err = startGeneration(acpDB.Store, policyBasePrefix("exact"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = upsertPolicies(acpDB.Store, "exact", []oryAccessControlPolicy{{ID: "new", Subjects: []string{"bob"}, Effect: "allow"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnt := 0
+	acpDB.Enumerate(policyBasePrefix("exact")+"g/", func(key string, value []byte) (bool, error) {
+		if strings.Contains(key, "/s/bob/") {
+			cnt++
+		}
+		return true, nil
+	})
+	if cnt != 2 {
+		t.Error(fmt.Errorf("write during a rebuild added %d refs instead of one per generation", cnt))
+	}
+
+}
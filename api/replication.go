@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/adi/sketo/auth"
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+	"github.com/adi/sketo/replication"
+	"github.com/gorilla/mux"
+)
+
+// Replication state (at most one of them is set)
+var (
+	ReplicationLeader   *replication.Leader
+	ReplicationFollower *replication.Follower
+)
+
+// initReplication sets up the replication role configured in cfg (acpDB is
+// nil when not using the badger backend)
+func initReplication(apiMux *mux.Router, acpDB *db.DB, cfg config.ReplicationConfig) error {
+	if cfg.Role != "" && acpDB == nil {
+		return fmt.Errorf("replication requires the badger storage backend")
+	}
+	switch cfg.Role {
+	case "leader":
+		ReplicationLeader = replication.NewLeader(acpDB, cfg.LogSize)
+		ReplicationLeader.WriteError = writeError
+		ReplicationLeader.Register(apiMux)
+		go ReplicationLeader.Run(context.Background())
+	case "follower":
+		var err error
+		ReplicationFollower, err = replication.NewFollower(acpDB, cfg.LeaderURL)
+		if err != nil {
+			return err
+		}
+		if cfg.APIKey != "" {
+			ReplicationFollower.SetTransport(auth.Transport(cfg.APIKey, nil))
+		}
+		ReplicationFollower.OnBootstrap = func() error {
+			return ReloadCounters(acpDB)
+		}
+		ReplicationFollower.WriteError = writeError
+		apiMux.Use(ReplicationFollower.Middleware(cfg.ForwardWrites))
+		go ReplicationFollower.Run(context.Background())
+	}
+	return nil
+}
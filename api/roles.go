@@ -1,18 +1,21 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 
+	"github.com/adi/sketo/config"
 	"github.com/adi/sketo/db"
+	"github.com/adi/sketo/tracing"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
-func listOryAccessControlPolicyRoles(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func listOryAccessControlPolicyRoles(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		flavor := params["flavor"]
@@ -23,8 +26,7 @@ func listOryAccessControlPolicyRoles(acpDB *db.DB) func(rw http.ResponseWriter,
 		if offsetStr != "" {
 			offset, err = strconv.ParseInt(offsetStr, 10, 64)
 			if err != nil {
-				rw.WriteHeader(400)
-				rw.Write([]byte("Invalid offset query param\n"))
+				writeError(rw, r, 400, "Invalid offset query param")
 				return
 			}
 		}
@@ -33,86 +35,28 @@ func listOryAccessControlPolicyRoles(acpDB *db.DB) func(rw http.ResponseWriter,
 		if limitStr != "" {
 			limit, err = strconv.ParseInt(limitStr, 10, 64)
 			if err != nil {
-				rw.WriteHeader(400)
-				rw.Write([]byte("Invalid limit query param\n"))
+				writeError(rw, r, 400, "Invalid limit query param")
 				return
 			}
 		}
 		member := r.FormValue("member")
 
-		if flavor == "exact" {
-
-			err = acpDB.List(roleBasePrefix(flavor), roleFilter(member), offset, limit, func(keys []string, values [][]byte) error {
-				rw.Header().Add("Content-Type", "application/json")
-				rw.WriteHeader(200)
-				ret := make([]oryAccessControlPolicyRole, 0, len(values))
-				for _, value := range values {
-					var item oryAccessControlPolicyRole
-					err := json.Unmarshal(value, &item)
-					if err != nil {
-						return err
-					}
-					ret = append(ret, item)
-				}
-				jsonEnc := json.NewEncoder(rw)
-				return jsonEnc.Encode(ret)
-			})
-
-			if err != nil {
-				log.Printf("Error listing Roles: %v\n", err)
-				rw.WriteHeader(500)
-				rw.Write([]byte("Server error\n"))
-				return
-			}
-
-		} else {
-			rw.Header().Add("Content-Type", "application/json")
-			rw.WriteHeader(200)
-
-			ret := make([]oryAccessControlPolicyRole, 0)
-			err = acpDB.Enumerate(policyBasePrefix(flavor), func(key string, value []byte) (bool, error) {
-				var err error
-				var item oryAccessControlPolicyRole
-				err = json.Unmarshal(value, &item)
-				if err != nil {
-					return false, err
-				}
-				var include bool
-				include, err = matchesAny(flavor, item.Members, member)
-				if err != nil {
-					return false, err
-				}
-				if include {
-					ret = append(ret, item)
-				}
-				return true, nil
-			})
-			if err != nil {
-				log.Printf("Error listing Roles: %v\n", err)
-				rw.WriteHeader(500)
-				rw.Write([]byte("Server error\n"))
-				return
-			}
-
-			jsonEnc := json.NewEncoder(rw)
-			err := jsonEnc.Encode(ret)
-			if err != nil {
-				log.Printf("Error listing Roles: %v\n", err)
-				rw.WriteHeader(500)
-				rw.Write([]byte("Server error\n"))
-				return
-			}
-
+		ret, err := listRoles(r.Context(), acpDB, flavor, member, offset, limit)
+		if err != nil {
+			log.Printf("Error listing Roles: %v\n", err)
+			writeError(rw, r, 500, "")
+			return
 		}
 
+		writeJSON(rw, 200, ret)
+
 	}
 }
 
-func upsertOryAccessControlPolicyRole(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func upsertOryAccessControlPolicyRole(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("Content-Type") != "application/json" {
-			rw.WriteHeader(400)
-			rw.Write([]byte(fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only "application/json" is valid)`, r.Header.Get("Content-Type"))))
+			writeError(rw, r, 400, fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only "application/json" is valid)`, r.Header.Get("Content-Type")))
 			return
 		}
 		params := mux.Vars(r)
@@ -121,84 +65,53 @@ func upsertOryAccessControlPolicyRole(acpDB *db.DB) func(rw http.ResponseWriter,
 		jsonDec := json.NewDecoder(r.Body)
 		err := jsonDec.Decode(&body)
 		if err != nil {
-			rw.WriteHeader(400)
-			rw.Write([]byte("Couldn't decode body\n"))
+			writeError(rw, r, 400, "Couldn't decode body")
 			return
 		}
 
 		if body.ID == "" {
 			genID, err := uuid.NewUUID()
 			if err != nil {
-				rw.WriteHeader(500)
-				rw.Write([]byte("Couldn't generate ID\n"))
+				writeError(rw, r, 500, "Couldn't generate ID")
 				return
 			}
 			body.ID = genID.String()
 		}
 
-		id := body.ID
-
-		// Save doc
-		docPrefix := docSuffix(id)
-		err = acpDB.Set(roleBasePrefix(flavor), docPrefix, body)
-		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
-			return
-		}
-
-		if flavor == "exact" {
-			// Save indexes to doc
-			suffixes := make([]string, 0)
-			for _, member := range body.Members {
-				suffixes = append(suffixes, roleSuffix(member, id))
-			}
-			suffixes = append(suffixes, roleSuffix("", id))
-			err = acpDB.RefMany(roleBasePrefix(flavor), suffixes)
-			if err != nil {
-				rw.WriteHeader(500)
-				rw.Write([]byte("Server error\n"))
-				return
-			}
-		}
-
-		rw.Header().Add("Content-Type", "application/json")
-		jsonEnc := json.NewEncoder(rw)
-		rw.WriteHeader(200)
-		err = jsonEnc.Encode(body)
+		// Save doc along with its indexes
+		_, err = upsertRoles(acpDB, flavor, []oryAccessControlPolicyRole{body}, true)
 		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
+			writeError(rw, r, 500, "")
 			return
 		}
 
-		switch flavor {
-		case "regex":
-			CntRegexRoles++
-		case "glob":
-			CntGlobRoles++
-		case "exact":
-			CntExactRoles++
-		}
+		writeJSON(rw, 200, body)
 
 	}
 }
 
-func upsertOryAccessControlPolicyRoles(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func upsertOryAccessControlPolicyRoles(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("Content-Type") != "application/json" {
-			rw.WriteHeader(400)
-			rw.Write([]byte(fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only "application/json" is valid)`, r.Header.Get("Content-Type"))))
+			writeError(rw, r, 400, fmt.Sprintf(`Bad request (content type "%s" not allowed on this endpoint; only "application/json" is valid)`, r.Header.Get("Content-Type")))
 			return
 		}
 		params := mux.Vars(r)
 		flavor := params["flavor"]
+		atomic := false
+		if atomicStr := r.FormValue("atomic"); atomicStr != "" {
+			var err error
+			atomic, err = strconv.ParseBool(atomicStr)
+			if err != nil {
+				writeError(rw, r, 400, "Invalid atomic query param")
+				return
+			}
+		}
 		var bodies []oryAccessControlPolicyRole
 		jsonDec := json.NewDecoder(r.Body)
 		err := jsonDec.Decode(&bodies)
 		if err != nil {
-			rw.WriteHeader(400)
-			rw.Write([]byte("Couldn't decode body\n"))
+			writeError(rw, r, 400, "Couldn't decode body")
 			return
 		}
 
@@ -207,175 +120,232 @@ func upsertOryAccessControlPolicyRoles(acpDB *db.DB) func(rw http.ResponseWriter
 			if bodies[i].ID == "" {
 				genID, err := uuid.NewUUID()
 				if err != nil {
-					rw.WriteHeader(500)
-					rw.Write([]byte("Couldn't generate ID\n"))
+					writeError(rw, r, 500, "Couldn't generate ID")
 					return
 				}
 				bodies[i].ID = genID.String()
 			}
 		}
 
-		// Group docs
-		bodiesLen := len(bodies)
-		abstractBodies := make([]interface{}, bodiesLen)
-		docSuffixes := make([]string, bodiesLen)
-		for i, body := range bodies {
-			abstractBodies[i] = body
-			docSuffixes[i] = docSuffix(body.ID)
-		}
-
-		// Save docs
-		err = acpDB.SetMany(roleBasePrefix(flavor), docSuffixes, abstractBodies)
-		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
+		results, err := upsertRoles(acpDB, flavor, bodies, atomic)
+		if err == errBatchTooLarge {
+			writeError(rw, r, 413, fmt.Sprintf("Atomic batches can't hold more than %d docs", config.Get().Batch.MaxSize))
 			return
 		}
-
-		if flavor == "exact" {
-			// Group indexes to doc
-			suffixes := make([]string, 0)
-			for _, body := range bodies {
-				id := body.ID
-				for _, member := range body.Members {
-					suffixes = append(suffixes, roleSuffix(member, id))
-				}
-				suffixes = append(suffixes, roleSuffix("", id))
-			}
-
-			// Save indexes to doc
-			err = acpDB.RefMany(roleBasePrefix(flavor), suffixes)
-			if err != nil {
-				rw.WriteHeader(500)
-				rw.Write([]byte("Server error\n"))
-				return
-			}
-
-		}
-
-		switch flavor {
-		case "regex":
-			CntRegexRoles += int64(bodiesLen)
-		case "glob":
-			CntGlobRoles += int64(bodiesLen)
-		case "exact":
-			CntExactRoles += int64(bodiesLen)
-		}
-
-		rw.Header().Add("Content-Type", "application/json")
-		jsonEnc := json.NewEncoder(rw)
-		rw.WriteHeader(200)
-		err = jsonEnc.Encode(map[string]interface{}{
-			"total_imported": len(bodies),
-		})
+		status := 200
 		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
-			return
+			// Nothing was written
+			status = 500
 		}
+		writeJSON(rw, status, newBatchResult(results))
 
 	}
 }
 
-func getOryAccessControlPolicyRole(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func getOryAccessControlPolicyRole(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		flavor := params["flavor"]
 		id := params["id"]
 
+		_, span := tracing.StartSpan(r.Context(), "store.get")
 		err := acpDB.Get(roleBasePrefix(flavor), docSuffix(id), func(value []byte) error {
 			rw.Header().Add("Content-Type", "application/json")
 			rw.WriteHeader(200)
 			rw.Write(value)
 			return nil
 		})
+		span.End()
 		if err != nil {
 			if err == db.ErrKeyNotFound {
-				rw.WriteHeader(404)
-				rw.Write([]byte("Not found\n"))
+				writeError(rw, r, 404, "Not found")
 				return
 			}
 			log.Printf("Error getting ACP: %v\n", err)
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
+			writeError(rw, r, 500, "")
 			return
 		}
 
 	}
 }
 
-func deleteOryAccessControlPolicyRole(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+func deleteOryAccessControlPolicyRole(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		flavor := params["flavor"]
 		id := params["id"]
 
-		// Get doc
-		var members []string
-		err := acpDB.Get(roleBasePrefix(flavor), docSuffix(id), func(value []byte) error {
-			var tmpBody oryAccessControlPolicyRole
-			err := json.Unmarshal(value, &tmpBody)
-			if err != nil {
-				return fmt.Errorf("Couldn't decode body: %w", err)
-			}
-			for _, m := range tmpBody.Members {
-				mbytes := []byte(m)
-				mcopy := make([]byte, 0, len(mbytes))
-				copy(mcopy, mbytes)
-				members = append(members, string(mcopy))
+		_, err := deleteRole(acpDB, flavor, id)
+		if err != nil {
+			log.Printf("Error deleting ACP: %v\n", err)
+			writeError(rw, r, 500, "")
+			return
+		}
+
+		rw.WriteHeader(204)
+
+	}
+}
+
+// listRoles returns the roles of flavor matching member, any of them
+// matching when empty
+func listRoles(ctx context.Context, acpDB db.Store, flavor string, member string, offset int64, limit int64) ([]oryAccessControlPolicyRole, error) {
+	ret := make([]oryAccessControlPolicyRole, 0)
+	if flavor == "exact" {
+		_, span := tracing.StartSpan(ctx, "store.list")
+		defer span.End()
+		err := listIndex(acpDB, roleBasePrefix(flavor), roleIndex(member), offset, limit, func(keys []string, values [][]byte) error {
+			for _, value := range values {
+				var item oryAccessControlPolicyRole
+				err := json.Unmarshal(value, &item)
+				if err != nil {
+					return err
+				}
+				ret = append(ret, item)
 			}
 			return nil
 		})
-		objectFound := true
 		if err != nil {
-			if err == db.ErrKeyNotFound {
-				objectFound = false
-			} else {
-				log.Printf("Error getting ACP: %v\n", err)
-				rw.WriteHeader(500)
-				rw.Write([]byte("Server error\n"))
-				return
-			}
+			return nil, err
 		}
+		return ret, nil
+	}
 
-		// Delete doc
-		idPrefix := docSuffix(id)
-		err = acpDB.Del(roleBasePrefix(flavor), idPrefix)
+	roles, err := enumerateRoles(ctx, acpDB, flavor)
+	if err != nil {
+		return nil, err
+	}
+	_, span := tracing.StartSpan(ctx, "match")
+	defer span.End()
+	span.SetAttribute("candidates", len(roles))
+	for _, role := range roles {
+		include, err := matchesAny(flavor, role.Members, member)
 		if err != nil {
-			rw.WriteHeader(500)
-			rw.Write([]byte("Server error\n"))
-			return
+			return nil, err
+		}
+		if include {
+			ret = append(ret, role)
 		}
+	}
+	return ret, nil
+}
 
-		if flavor == "exact" {
-			if objectFound {
-				// Delete indexes to doc
-				suffixes := make([]string, 0)
-				for _, member := range members {
-					suffixes = append(suffixes, roleSuffix(member, id))
-				}
-				suffixes = append(suffixes, roleSuffix("", id))
-				err = acpDB.DelManyRefs(roleBasePrefix(flavor), suffixes)
-				if err != nil {
-					rw.WriteHeader(500)
-					rw.Write([]byte("Server error\n"))
-					return
-				}
-			}
+// enumerateRoles reads every role of a flavor matching by pattern
+func enumerateRoles(ctx context.Context, acpDB db.Store, flavor string) ([]oryAccessControlPolicyRole, error) {
+	_, span := tracing.StartSpan(ctx, "store.enumerate")
+	defer span.End()
+	ret := make([]oryAccessControlPolicyRole, 0)
+	err := acpDB.Enumerate(roleBasePrefix(flavor), func(key string, value []byte) (bool, error) {
+		var item oryAccessControlPolicyRole
+		err := json.Unmarshal(value, &item)
+		if err != nil {
+			return false, err
 		}
+		ret = append(ret, item)
+		return true, nil
+	})
+	span.SetAttribute("candidates", len(ret))
+	return ret, err
+}
 
-		if objectFound {
-			switch flavor {
-			case "regex":
-				CntRegexRoles--
-			case "glob":
-				CntGlobRoles--
-			case "exact":
-				CntExactRoles--
-			}
+// deleteRole deletes a role along with its indexes; it reports whether the
+// role existed
+func deleteRole(acpDB db.Store, flavor string, id string) (bool, error) {
+	var found bool
+	err := acpDB.Update(func(txn db.Txn) error {
+		var err error
+		found, err = dropRole(txn, flavor, id)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if found {
+		addRoleCount(flavor, -1)
+	}
+	return found, nil
+}
+
+// roleSuffixes returns the exact flavor indexes to a role
+func roleSuffixes(body oryAccessControlPolicyRole) []string {
+	suffixes := make([]string, 0, len(body.Members)+1)
+	for _, member := range body.Members {
+		suffixes = append(suffixes, roleSuffix(member, body.ID))
+	}
+	return append(suffixes, roleSuffix("", body.ID))
+}
+
+// putRole saves a doc along with its exact flavor indexes, dropping the
+// indexes of the version it replaces; it reports whether the doc is new
+func putRole(txn db.Txn, flavor string, body oryAccessControlPolicyRole) (bool, error) {
+	var old *oryAccessControlPolicyRole
+	err := txn.Get(roleBasePrefix(flavor), docSuffix(body.ID), func(value []byte) error {
+		old = &oryAccessControlPolicyRole{}
+		return json.Unmarshal(value, old)
+	})
+	if err != nil && err != db.ErrKeyNotFound {
+		return false, err
+	}
+
+	err = txn.Set(roleBasePrefix(flavor), docSuffix(body.ID), body)
+	if err != nil {
+		return false, err
+	}
+
+	if flavor == "exact" {
+		var removed []string
+		if old != nil {
+			removed = roleSuffixes(*old)
+		}
+		err = updateRefs(txn, roleBasePrefix(flavor), removed, roleSuffixes(body))
+		if err != nil {
+			return false, err
 		}
+	}
 
-		rw.WriteHeader(204)
+	return old == nil, nil
+}
+
+// dropRole deletes a doc along with its exact flavor indexes; it reports
+// whether the doc existed
+func dropRole(txn db.Txn, flavor string, id string) (bool, error) {
+	var old *oryAccessControlPolicyRole
+	err := txn.Get(roleBasePrefix(flavor), docSuffix(id), func(value []byte) error {
+		old = &oryAccessControlPolicyRole{}
+		return json.Unmarshal(value, old)
+	})
+	if err == db.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = txn.Del(roleBasePrefix(flavor), docSuffix(id))
+	if err != nil {
+		return false, err
+	}
+
+	if flavor == "exact" {
+		err = updateRefs(txn, roleBasePrefix(flavor), roleSuffixes(*old), nil)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
 
+// upsertRoles saves docs and reports each one's outcome, keeping the role
+// counter in line with the docs actually inserted
+func upsertRoles(acpDB db.Store, flavor string, bodies []oryAccessControlPolicyRole, atomic bool) ([]upsertResult, error) {
+	ids := make([]string, len(bodies))
+	for i, body := range bodies {
+		ids[i] = body.ID
 	}
+	results, err := upsertBatch(acpDB, ids, atomic, func(txn db.Txn, i int) (bool, error) {
+		return putRole(txn, flavor, bodies[i])
+	})
+	addRoleCount(flavor, countInserted(results))
+	return results, err
 }
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+// initStorageAdmin adds endpoints running badger maintenance on demand (acpDB is
+// nil when not using the badger backend)
+func initStorageAdmin(apiMux *mux.Router, acpDB *db.DB) {
+	if acpDB == nil {
+		return
+	}
+	apiMux.HandleFunc("/admin/storage/gc", runStorageGC(acpDB)).Methods("POST")
+	apiMux.HandleFunc("/admin/storage/flatten", flattenStorage(acpDB)).Methods("POST")
+}
+
+// runStorageGC runs a value log GC round with the discard ratio of the
+// discardRatio query param, or the configured one
+func runStorageGC(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		discardRatio := acpDB.GCDiscardRatio()
+		if param := r.URL.Query().Get("discardRatio"); param != "" {
+			var err error
+			discardRatio, err = strconv.ParseFloat(param, 64)
+			if err != nil || discardRatio <= 0 || discardRatio >= 1 {
+				writeError(rw, r, 400, "Invalid discardRatio query param")
+				return
+			}
+		}
+		result, err := acpDB.RunGC(discardRatio)
+		if err != nil {
+			log.Printf("Error running value log GC: %v\n", err)
+			writeError(rw, r, 500, "")
+			return
+		}
+		log.Printf("Value log GC rewrote %d files, reclaiming %d bytes\n", result.Rewrites, result.ReclaimedBytes)
+		rw.Header().Set("Content-Type", "application/json")
+		jsonEnc := json.NewEncoder(rw)
+		err = jsonEnc.Encode(result)
+		if err != nil {
+			log.Printf("Error writing GC result: %v\n", err)
+		}
+	}
+}
+
+// flattenStorage compacts the LSM tree into a single level using the number of
+// workers of the workers query param
+func flattenStorage(acpDB *db.DB) func(rw http.ResponseWriter, r *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		workers := 2
+		if param := r.URL.Query().Get("workers"); param != "" {
+			var err error
+			workers, err = strconv.Atoi(param)
+			if err != nil || workers <= 0 {
+				writeError(rw, r, 400, "Invalid workers query param")
+				return
+			}
+		}
+		err := acpDB.Flatten(workers)
+		if err != nil {
+			log.Printf("Error flattening storage: %v\n", err)
+			writeError(rw, r, 500, "")
+			return
+		}
+		rw.WriteHeader(204)
+	}
+}
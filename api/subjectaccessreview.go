@@ -0,0 +1,247 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+	"github.com/adi/sketo/tracing"
+)
+
+// subjectAccessReviewPath is the address of Kubernetes' webhook authorizer
+const subjectAccessReviewPath = "/k8s/subjectaccessreview"
+
+// subjectAccessReview is an authorization.k8s.io/v1 SubjectAccessReview
+type subjectAccessReview struct {
+	APIVersion string                     `json:"apiVersion"`
+	Kind       string                     `json:"kind"`
+	Spec       subjectAccessReviewSpec    `json:"spec"`
+	Status     *subjectAccessReviewStatus `json:"status,omitempty"`
+}
+
+type subjectAccessReviewSpec struct {
+	ResourceAttributes    *resourceAttributes    `json:"resourceAttributes,omitempty"`
+	NonResourceAttributes *nonResourceAttributes `json:"nonResourceAttributes,omitempty"`
+	User                  string                 `json:"user,omitempty"`
+	Groups                []string               `json:"groups,omitempty"`
+	Extra                 map[string][]string    `json:"extra,omitempty"`
+	UID                   string                 `json:"uid,omitempty"`
+}
+
+type resourceAttributes struct {
+	Namespace   string `json:"namespace,omitempty"`
+	Verb        string `json:"verb,omitempty"`
+	Group       string `json:"group,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
+type nonResourceAttributes struct {
+	Path string `json:"path,omitempty"`
+	Verb string `json:"verb,omitempty"`
+}
+
+// subjectAccessReviewStatus is the decision: requests neither allowed nor
+// denied are left to the next authorizer
+type subjectAccessReviewStatus struct {
+	Allowed         bool   `json:"allowed"`
+	Denied          bool   `json:"denied,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+	EvaluationError string `json:"evaluationError,omitempty"`
+}
+
+// reviewInput maps the request of spec to a check, without its subject
+func reviewInput(cfg config.SubjectAccessReviewConfig, spec subjectAccessReviewSpec) oryAccessControlPolicyAllowedInput {
+	var input oryAccessControlPolicyAllowedInput
+	var verb string
+	if attrs := spec.ResourceAttributes; attrs != nil {
+		verb = attrs.Verb
+		resource := attrs.Resource
+		if attrs.Subresource != "" {
+			resource += "/" + attrs.Subresource
+		}
+		input.Resource = strings.NewReplacer(
+			"{namespace}", attrs.Namespace,
+			"{group}", attrs.Group,
+			"{resource}", resource,
+			"{name}", attrs.Name,
+		).Replace(cfg.Resource)
+	} else if attrs := spec.NonResourceAttributes; attrs != nil {
+		verb = attrs.Verb
+		input.Resource = attrs.Path
+	}
+	input.Action = verb
+	if action, ok := cfg.Actions[verb]; ok {
+		input.Action = action
+	}
+	return input
+}
+
+// reviewSubjects returns the subjects checked for the user of spec: the user
+// and its groups, prefixed, and the IDs of the roles any of them is a member
+// of
+func reviewSubjects(ctx context.Context, acpDB db.Store, cfg config.SubjectAccessReviewConfig, spec subjectAccessReviewSpec) ([]string, error) {
+	members := []string{cfg.UserPrefix + spec.User}
+	for _, group := range spec.Groups {
+		members = append(members, cfg.GroupPrefix+group)
+	}
+	roles, err := memberRoleIDs(ctx, acpDB, cfg.Flavor, members)
+	if err != nil {
+		return nil, err
+	}
+	return append(members, roles...), nil
+}
+
+// memberRoleIDs returns the sorted IDs of the roles of flavor any of members
+// is a member of. Unlike listRoles it isn't capped by the list limits
+func memberRoleIDs(ctx context.Context, acpDB db.Store, flavor string, members []string) ([]string, error) {
+	found := make(map[string]bool)
+	if flavor == "exact" {
+		_, span := tracing.StartSpan(ctx, "store.enumerate")
+		defer span.End()
+		err := readIndex(acpDB, roleBasePrefix(flavor), func(gen string) error {
+			found = make(map[string]bool)
+			for _, member := range members {
+				prefix := roleBasePrefix(flavor) + gen + roleFilter(member) + "i/"
+				err := acpDB.Enumerate(prefix, func(key string, value []byte) (bool, error) {
+					found[strings.TrimSuffix(strings.TrimPrefix(key, prefix), "/")] = true
+					return true, nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		roles, err := enumerateRoles(ctx, acpDB, flavor)
+		if err != nil {
+			return nil, err
+		}
+		_, span := tracing.StartSpan(ctx, "match")
+		defer span.End()
+		span.SetAttribute("candidates", len(roles))
+		for _, role := range roles {
+			for _, member := range members {
+				include, err := matchesAny(flavor, role.Members, member)
+				if err != nil {
+					return nil, err
+				}
+				if include {
+					found[role.ID] = true
+					break
+				}
+			}
+		}
+	}
+	ids := make([]string, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// review checks input for every subject, returning the IDs of the matching
+// allow policies and of the first matching deny policies found
+func review(ctx context.Context, acpDB db.Store, flavor string, subjects []string, input oryAccessControlPolicyAllowedInput) (allowedBy []string, deniedBy []string, err error) {
+	allowing := make(map[string]bool)
+	for _, subject := range subjects {
+		input.Subject = subject
+		err = matchPolicies(ctx, acpDB, flavor, input, func(policy oryAccessControlPolicy) bool {
+			if policy.Effect == "deny" {
+				deniedBy = append(deniedBy, policy.ID)
+				return false
+			} else if policy.Effect == "allow" {
+				allowing[policy.ID] = true
+			}
+			return true
+		})
+		if err != nil || len(deniedBy) > 0 {
+			return nil, deniedBy, err
+		}
+	}
+	for id := range allowing {
+		allowedBy = append(allowedBy, id)
+	}
+	sort.Strings(allowedBy)
+	return allowedBy, nil, nil
+}
+
+// reviewSubjectAccess answers the SubjectAccessReviews of Kubernetes' webhook
+// authorizer, with the policies that decided in the reason
+func reviewSubjectAccess(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		cfg := config.Get().SubjectAccessReview
+		if !cfg.Enabled {
+			writeError(rw, r, 404, "SubjectAccessReviews are disabled")
+			return
+		}
+		atomic.AddInt64(&CntAllowRequestsSinceStart, 1)
+		var body subjectAccessReview
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil || body.APIVersion != "authorization.k8s.io/v1" || body.Kind != "SubjectAccessReview" {
+			atomic.AddInt64(&CntAllowFailuresSinceStart, 1)
+			countDecision(cfg.Flavor, outcomeError)
+			writeError(rw, r, 400, "Expected an authorization.k8s.io/v1 SubjectAccessReview")
+			return
+		}
+
+		input := reviewInput(cfg, body.Spec)
+		input.Subject = cfg.UserPrefix + body.Spec.User
+		complete := body.Spec.User != "" && inputComplete(input)
+		status := &subjectAccessReviewStatus{
+			Reason: "incomplete review",
+		}
+		if complete {
+			subjects, err := reviewSubjects(r.Context(), acpDB, cfg, body.Spec)
+			var allowedBy, deniedBy []string
+			if err == nil {
+				allowedBy, deniedBy, err = review(r.Context(), acpDB, cfg.Flavor, subjects, input)
+			}
+			if err != nil {
+				log.Printf("Error reviewing subject access: %v\n", err)
+				atomic.AddInt64(&CntAllowFailuresSinceStart, 1)
+				countDecision(cfg.Flavor, outcomeError)
+				// Left to the next authorizer, like Kubernetes' own ones do
+				body.Status = &subjectAccessReviewStatus{
+					EvaluationError: "sketo couldn't evaluate its policies",
+				}
+				writeJSON(rw, 200, body)
+				return
+			}
+			switch {
+			case len(deniedBy) > 0:
+				status.Denied = true
+				status.Reason = fmt.Sprintf("denied by sketo policy %s", strings.Join(deniedBy, ", "))
+			case len(allowedBy) > 0:
+				status.Allowed = true
+				status.Reason = fmt.Sprintf("allowed by sketo policies %s", strings.Join(allowedBy, ", "))
+			default:
+				status.Reason = fmt.Sprintf("no sketo policy lets %s %s %s", input.Subject, input.Action, input.Resource)
+			}
+		}
+		allowed := status.Allowed
+		if returnedDecision(r.Context(), allowed, complete) && !allowed {
+			status.Allowed = true
+			status.Denied = false
+			status.Reason += " (allowed in monitor mode)"
+		}
+		countOutcome(cfg.Flavor, allowed)
+
+		body.Status = status
+		writeJSON(rw, 200, body)
+	}
+}
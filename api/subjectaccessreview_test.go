@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+)
+
+// failingEnumerateStore fails every enumeration
+type failingEnumerateStore struct {
+	db.Store
+}
+
+func (s failingEnumerateStore) Enumerate(prefix string, enumProcessor func(key string, value []byte) (bool, error)) error {
+	return errors.New("enumeration failed")
+}
+
+func TestSubjectAccessReviewsEvaluatePolicies(t *testing.T) {
+
+	apiMux, _ := newTestAPI(t, func(cfg *config.Config) {
+		cfg.SubjectAccessReview.Enabled = true
+	})
+	for _, policy := range []map[string]interface{}{
+		{"id": "p1", "subjects": []string{"devs"}, "resources": []string{"default/pods/web"}, "actions": []string{"get"}, "effect": "allow"},
+		{"id": "p2", "subjects": []string{"log-readers"}, "resources": []string{"default/pods/log/web"}, "actions": []string{"get"}, "effect": "allow"},
+		{"id": "p3", "subjects": []string{"mallory"}, "resources": []string{"default/pods/web"}, "actions": []string{"get"}, "effect": "deny"},
+		{"id": "p4", "subjects": []string{"devs"}, "resources": []string{"/healthz"}, "actions": []string{"get"}, "effect": "allow"},
+		{"id": "p5", "subjects": []string{"db-readers"}, "resources": []string{"default/pods/db"}, "actions": []string{"get"}, "effect": "allow"},
+		{"id": "p6", "subjects": []string{"carol-100"}, "resources": []string{"default/pods/web"}, "actions": []string{"get"}, "effect": "allow"},
+	} {
+		rw := doJSON(apiMux, "PUT", "/engines/acp/ory/exact/policies", policy)
+		if rw.Code != 200 {
+			t.Fatal(fmt.Errorf("policy upsert returned %d", rw.Code))
+		}
+	}
+	roles := []map[string]interface{}{
+		{"id": "log-readers", "members": []string{"bob"}},
+		{"id": "db-readers", "members": []string{"ops"}},
+	}
+	// Users can be members of more roles than a list returns
+	for i := 0; i <= 100; i++ {
+		roles = append(roles, map[string]interface{}{"id": fmt.Sprintf("carol-%03d", i), "members": []string{"carol"}})
+	}
+	rw := doJSON(apiMux, "PUT", "/engines/acp/ory/exact/roles/batch", roles)
+	if rw.Code != 200 {
+		t.Fatal(fmt.Errorf("role batch returned %d", rw.Code))
+	}
+
+	for _, c := range []struct {
+		spec    map[string]interface{}
+		allowed bool
+		denied  bool
+		reason  string
+	}{
+		// Groups are subjects
+		{map[string]interface{}{"user": "alice", "groups": []string{"devs"}, "resourceAttributes": map[string]string{"namespace": "default", "verb": "get", "resource": "pods", "name": "web"}}, true, false, "p1"},
+		// Roles of the user are subjects, and subresources are part of the resource
+		{map[string]interface{}{"user": "bob", "resourceAttributes": map[string]string{"namespace": "default", "verb": "get", "resource": "pods", "subresource": "log", "name": "web"}}, true, false, "p2"},
+		// Roles of the groups are subjects too, and roles aren't capped
+		{map[string]interface{}{"user": "alice", "groups": []string{"ops"}, "resourceAttributes": map[string]string{"namespace": "default", "verb": "get", "resource": "pods", "name": "db"}}, true, false, "p5"},
+		{map[string]interface{}{"user": "carol", "resourceAttributes": map[string]string{"namespace": "default", "verb": "get", "resource": "pods", "name": "web"}}, true, false, "p6"},
+		{map[string]interface{}{"user": "mallory", "groups": []string{"devs"}, "resourceAttributes": map[string]string{"namespace": "default", "verb": "get", "resource": "pods", "name": "web"}}, false, true, "p3"},
+		// Requests no policy matches are left to other authorizers
+		{map[string]interface{}{"user": "alice", "groups": []string{"devs"}, "resourceAttributes": map[string]string{"namespace": "default", "verb": "delete", "resource": "pods", "name": "web"}}, false, false, "no sketo policy"},
+		{map[string]interface{}{"user": "alice", "groups": []string{"devs"}, "nonResourceAttributes": map[string]string{"path": "/healthz", "verb": "get"}}, true, false, "p4"},
+		{map[string]interface{}{"groups": []string{"devs"}, "nonResourceAttributes": map[string]string{"path": "/healthz", "verb": "get"}}, false, false, "incomplete"},
+	} {
+		rw := doJSON(apiMux, "POST", "/k8s/subjectaccessreview", map[string]interface{}{
+			"apiVersion": "authorization.k8s.io/v1",
+			"kind":       "SubjectAccessReview",
+			"spec":       c.spec,
+		})
+		var result subjectAccessReview
+		err := json.NewDecoder(rw.Body).Decode(&result)
+		if err != nil || rw.Code != 200 || result.Status == nil {
+			t.Fatal(fmt.Errorf("review of %v returned %d (%v)", c.spec, rw.Code, err))
+		}
+		if result.Kind != "SubjectAccessReview" || result.Status.Allowed != c.allowed || result.Status.Denied != c.denied || !strings.Contains(result.Status.Reason, c.reason) {
+			t.Error(fmt.Errorf("review of %v returned %+v", c.spec, result.Status))
+		}
+	}
+
+	rw = doJSON(apiMux, "POST", "/k8s/subjectaccessreview", map[string]interface{}{
+		"apiVersion": "authorization.k8s.io/v1beta1",
+		"kind":       "SubjectAccessReview",
+		"spec":       map[string]interface{}{"user": "alice"},
+	})
+	if rw.Code != 400 {
+		t.Error(fmt.Errorf("v1beta1 review returned %d", rw.Code))
+	}
+
+	// Reviews that can't be evaluated are left to other authorizers
+	data, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": "authorization.k8s.io/v1",
+		"kind":       "SubjectAccessReview",
+		"spec":       map[string]interface{}{"user": "alice", "nonResourceAttributes": map[string]string{"path": "/healthz", "verb": "get"}},
+	})
+	rec := httptest.NewRecorder()
+	reviewSubjectAccess(failingEnumerateStore{db.NewMemStore()})(rec, httptest.NewRequest("POST", "/k8s/subjectaccessreview", bytes.NewReader(data)))
+	var result subjectAccessReview
+	err := json.NewDecoder(rec.Body).Decode(&result)
+	if err != nil || rec.Code != 200 || result.Status == nil || result.Status.Allowed || result.Status.Denied || result.Status.EvaluationError == "" {
+		t.Error(fmt.Errorf("failed review returned %d %+v (%v)", rec.Code, result.Status, err))
+	}
+
+}
@@ -0,0 +1,332 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+	"github.com/adi/sketo/replication"
+)
+
+// wipePath is the path of the wipe endpoint
+const wipePath = "/engines/acp/ory"
+
+// wipeTokenTTL is how long the confirmation token of a dry run stays valid
+const wipeTokenTTL = 5 * time.Minute
+
+// wipeScope selects the documents of a wipe
+type wipeScope struct {
+	Flavors []string `json:"flavors"`
+	// Kind is "policies", "roles" or empty for both
+	Kind string `json:"kind,omitempty"`
+}
+
+func (s wipeScope) String() string {
+	kind := s.Kind
+	if kind == "" {
+		kind = "all"
+	}
+	return fmt.Sprintf("%v/%s", s.Flavors, kind)
+}
+
+// prefixes returns the prefixes holding the documents and refs of the scope
+func (s wipeScope) prefixes() []string {
+	prefixes := make([]string, 0)
+	for _, flavor := range s.Flavors {
+		if s.Kind == "" || s.Kind == "policies" {
+			prefixes = append(prefixes, policyBasePrefix(flavor))
+		}
+		if s.Kind == "" || s.Kind == "roles" {
+			prefixes = append(prefixes, roleBasePrefix(flavor))
+		}
+	}
+	return prefixes
+}
+
+// wipeReport tells what a wipe deletes
+type wipeReport struct {
+	Scope    wipeScope        `json:"scope"`
+	Policies map[string]int64 `json:"policies,omitempty"`
+	Roles    map[string]int64 `json:"roles,omitempty"`
+	// ConfirmationToken is returned by dry runs, to be passed as the confirm
+	// query param to execute the wipe
+	ConfirmationToken string     `json:"confirmationToken,omitempty"`
+	ExpiresAt         *time.Time `json:"expiresAt,omitempty"`
+	// Backup is the file the documents were saved to before being deleted
+	Backup string `json:"backup,omitempty"`
+}
+
+type wipeToken struct {
+	scope     string
+	expiresAt time.Time
+}
+
+// wipeTokens are kept in memory, so they can't confirm a wipe on another node
+// or after a restart
+var (
+	wipeTokensMu sync.Mutex
+	wipeTokens   = make(map[string]wipeToken)
+)
+
+// newWipeToken returns a single use token confirming a wipe of scope
+func newWipeToken(scope wipeScope) (string, *time.Time, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", nil, err
+	}
+	token := hex.EncodeToString(b)
+	expiresAt := time.Now().Add(wipeTokenTTL)
+
+	wipeTokensMu.Lock()
+	defer wipeTokensMu.Unlock()
+	for t, issued := range wipeTokens {
+		if time.Now().After(issued.expiresAt) {
+			delete(wipeTokens, t)
+		}
+	}
+	wipeTokens[token] = wipeToken{
+		scope:     scope.String(),
+		expiresAt: expiresAt,
+	}
+	return token, &expiresAt, nil
+}
+
+// useWipeToken tells whether token confirms a wipe of scope, consuming it
+func useWipeToken(token string, scope wipeScope) bool {
+	wipeTokensMu.Lock()
+	defer wipeTokensMu.Unlock()
+	issued, ok := wipeTokens[token]
+	if !ok || time.Now().After(issued.expiresAt) || issued.scope != scope.String() {
+		return false
+	}
+	delete(wipeTokens, token)
+	return true
+}
+
+// countWipe counts the documents of scope
+func countWipe(acpDB db.Store, scope wipeScope) (wipeReport, error) {
+	report := wipeReport{
+		Scope: scope,
+	}
+	for _, flavor := range scope.Flavors {
+		if scope.Kind == "" || scope.Kind == "policies" {
+			err := acpDB.Count(policyBasePrefix(flavor), "i/", func(cnt int64) error {
+				if report.Policies == nil {
+					report.Policies = make(map[string]int64)
+				}
+				report.Policies[flavor] = cnt
+				return nil
+			})
+			if err != nil {
+				return report, err
+			}
+		}
+		if scope.Kind == "" || scope.Kind == "roles" {
+			err := acpDB.Count(roleBasePrefix(flavor), "i/", func(cnt int64) error {
+				if report.Roles == nil {
+					report.Roles = make(map[string]int64)
+				}
+				report.Roles[flavor] = cnt
+				return nil
+			})
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// backupWipe saves the documents of scope as gzipped NDJSON in the backup
+// folder and returns the file written; an incomplete file is removed
+func backupWipe(ctx context.Context, acpDB db.Store, scope wipeScope) (string, error) {
+	dir := config.Get().Storage.BackupDir
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, fmt.Sprintf("wipe-%s.ndjson.gz", time.Now().UTC().Format("20060102T150405.000000000")))
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	gzw := gzip.NewWriter(f)
+	err = exportDocs(ctx, acpDB, gzw, ExportOptions{
+		Flavors: scope.Flavors,
+		Kind:    scope.Kind,
+	})
+	if err == nil {
+		err = gzw.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		return "", err
+	}
+	return file, nil
+}
+
+// writeBarrier keeps the writes of the API out of wipes: write requests hold
+// it shared, and a wipe holds it exclusively from its backup to the deletion
+// of the documents
+var writeBarrier sync.RWMutex
+
+// holdWriteBarrier runs the write requests, other than wipes, holding the
+// write barrier
+func holdWriteBarrier(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if replication.IsRead(r) || (r.Method == "DELETE" && r.URL.Path == wipePath) {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		writeBarrier.RLock()
+		defer writeBarrier.RUnlock()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// runWipe backs the documents of scope up and deletes them while holding the
+// write barrier, filling in report
+func runWipe(ctx context.Context, acpDB db.Store, j *job, scope wipeScope, report *wipeReport) error {
+	writeBarrier.Lock()
+	defer writeBarrier.Unlock()
+
+	counted, err := countWipe(acpDB, scope)
+	if err != nil {
+		return err
+	}
+	report.Policies = counted.Policies
+	report.Roles = counted.Roles
+	updateJob(j, func(j *job) {
+		j.Phase = "backup"
+		for _, cnt := range counted.Policies {
+			j.Total += cnt
+		}
+		for _, cnt := range counted.Roles {
+			j.Total += cnt
+		}
+	})
+	report.Backup, err = backupWipe(ctx, acpDB, scope)
+	if err != nil {
+		return err
+	}
+
+	// The documents are backed up, so the job can't be canceled anymore
+	updateJob(j, func(j *job) {
+		j.Phase = "delete"
+	})
+	for _, prefix := range scope.prefixes() {
+		err = acpDB.DelByPrefix(prefix)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = ReloadCounters(acpDB)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't wipe %s (backed up to %s): %w", scope, report.Backup, err)
+	}
+	updateJob(j, func(j *job) {
+		j.Done = j.Total
+	})
+	return nil
+}
+
+// wipe deletes the policies and/or roles of a flavor (all flavors when
+// unset). Without dryRun=true it needs the confirm token returned by a dry run
+// of the same scope, and runs as a job backing the documents up before
+// deleting them. Tokens are only known to the node that issued them: in
+// clustered or replicated mode, the dry run and the wipe must be sent to the
+// same node
+func wipe(acpDB db.Store) func(rw http.ResponseWriter, r *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		scope := wipeScope{
+			Flavors: allFlavors,
+			Kind:    r.FormValue("kind"),
+		}
+		flavor := r.FormValue("flavor")
+		if flavor != "" {
+			if flavor != "exact" && flavor != "glob" && flavor != "regex" {
+				writeError(rw, r, 400, "Invalid flavor query param")
+				return
+			}
+			scope.Flavors = []string{flavor}
+		}
+		if scope.Kind != "" && scope.Kind != "policies" && scope.Kind != "roles" {
+			writeError(rw, r, 400, "Invalid kind query param")
+			return
+		}
+
+		var report wipeReport
+		if r.FormValue("dryRun") == "true" {
+			var err error
+			report, err = countWipe(acpDB, scope)
+			if err != nil {
+				log.Printf("Error counting documents to wipe: %v\n", err)
+				writeError(rw, r, 500, "")
+				return
+			}
+			report.ConfirmationToken, report.ExpiresAt, err = newWipeToken(scope)
+			if err != nil {
+				log.Printf("Error creating wipe confirmation token: %v\n", err)
+				writeError(rw, r, 500, "")
+				return
+			}
+			writeJSON(rw, 200, report)
+			return
+		}
+
+		if !useWipeToken(r.FormValue("confirm"), scope) {
+			writeError(rw, r, 400, "Missing or expired confirm token (get one from a dry run of the same wipe with dryRun=true)")
+			return
+		}
+		report.Scope = scope
+		j, conflict, err := startJob(&job{
+			Type:   "wipe",
+			Flavor: flavor,
+			Kind:   scope.Kind,
+		}, func(ctx context.Context, j *job) error {
+			return runWipe(ctx, acpDB, j, scope, &report)
+		})
+		if err != nil {
+			log.Printf("Error starting wipe job: %v\n", err)
+			writeError(rw, r, 500, "")
+			return
+		}
+		if conflict != nil {
+			writeError(rw, r, 409, "Conflicting job "+conflict.ID+" is running")
+			return
+		}
+
+		// The wipe goes on if the client disconnects, since the token is used
+		<-j.finished
+		j = findJob(j.ID)
+		switch j.Status {
+		case jobSucceeded:
+			log.Printf("Wiped %s, backed up to %s\n", scope, report.Backup)
+			writeJSON(rw, 200, report)
+		case jobCanceled:
+			writeError(rw, r, 409, "Wipe job "+j.ID+" was canceled")
+		default:
+			writeError(rw, r, 500, "")
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+func TestWipeNeedsConfirmationAndBacksUp(t *testing.T) {
+
+	cfg := config.Default()
+	cfg.Storage.BackupDir = t.TempDir()
+	config.Set(cfg)
+	defer config.Set(nil)
+
+	acpDB := db.NewMemStore()
+	for _, flavor := range []string{"exact", "glob"} {
+		_, err := upsertPolicies(acpDB, flavor, []oryAccessControlPolicy{
+			{ID: "p1", Subjects: []string{"alice"}, Resources: []string{"r"}, Actions: []string{"read"}, Effect: "allow"},
+			{ID: "p2", Subjects: []string{"bob"}, Resources: []string{"r"}, Actions: []string{"read"}, Effect: "allow"},
+		}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := upsertRoles(acpDB, "exact", []oryAccessControlPolicyRole{{ID: "admins", Members: []string{"alice"}}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiMux := mux.NewRouter()
+	apiMux.HandleFunc("/engines/acp/ory", wipe(acpDB)).Methods("DELETE")
+	count := func(prefix string) int64 {
+		var n int64
+		acpDB.Count(prefix, "i/", func(cnt int64) error {
+			n = cnt
+			return nil
+		})
+		return n
+	}
+
+	rw := doJSON(apiMux, "DELETE", "/engines/acp/ory?flavor=exact&kind=policies", nil)
+	if rw.Code != 400 || count(policyBasePrefix("exact")) != 2 {
+		t.Error(fmt.Errorf("wipe without confirmation returned %d", rw.Code))
+	}
+
+	rw = doJSON(apiMux, "DELETE", "/engines/acp/ory?flavor=exact&kind=policies&dryRun=true", nil)
+	var report wipeReport
+	err = json.NewDecoder(rw.Body).Decode(&report)
+	if err != nil || rw.Code != http.StatusOK {
+		t.Fatal(fmt.Errorf("dry run returned %d (%v)", rw.Code, err))
+	}
+	if report.Policies["exact"] != 2 || report.Roles != nil || report.ConfirmationToken == "" {
+		t.Error(fmt.Errorf("unexpected dry run report %+v", report))
+	}
+
+	// The token only confirms the scope of its dry run
+	rw = doJSON(apiMux, "DELETE", "/engines/acp/ory?kind=policies&confirm="+report.ConfirmationToken, nil)
+	if rw.Code != 400 {
+		t.Error(fmt.Errorf("wipe of another scope returned %d", rw.Code))
+	}
+	rw = doJSON(apiMux, "DELETE", "/engines/acp/ory?flavor=exact&kind=policies&confirm="+report.ConfirmationToken, nil)
+	var done wipeReport
+	err = json.NewDecoder(rw.Body).Decode(&done)
+	if err != nil || rw.Code != http.StatusOK {
+		t.Fatal(fmt.Errorf("confirmed wipe returned %d (%v)", rw.Code, err))
+	}
+	if count(policyBasePrefix("exact")) != 0 || count(policyBasePrefix("glob")) != 2 || count(roleBasePrefix("exact")) != 1 {
+		t.Error(fmt.Errorf("wipe deleted outside of its scope"))
+	}
+	rw = doJSON(apiMux, "DELETE", "/engines/acp/ory?flavor=exact&kind=policies&confirm="+report.ConfirmationToken, nil)
+	if rw.Code != 400 {
+		t.Error(fmt.Errorf("confirmation token was reused"))
+	}
+
+	f, err := os.Open(done.Backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := 0
+	for scanner := bufio.NewScanner(gzr); scanner.Scan(); lines++ {
+	}
+	if lines != 2 {
+		t.Error(fmt.Errorf("backed up %d documents instead of 2", lines))
+	}
+
+}
+
+// failingViewStore fails every read transaction
+type failingViewStore struct {
+	db.Store
+}
+
+func (s failingViewStore) View(fn func(txn db.Txn) error) error {
+	return errors.New("read failed")
+}
+
+func TestFailedWipeBackupIsRemoved(t *testing.T) {
+
+	cfg := config.Default()
+	cfg.Storage.BackupDir = t.TempDir()
+	config.Set(cfg)
+	defer config.Set(nil)
+
+	_, err := backupWipe(context.Background(), failingViewStore{db.NewMemStore()}, wipeScope{Flavors: allFlavors})
+	if err == nil {
+		t.Fatal(fmt.Errorf("failed backup didn't report its error"))
+	}
+	entries, err := os.ReadDir(cfg.Storage.BackupDir)
+	if err != nil || len(entries) != 0 {
+		t.Error(fmt.Errorf("failed backup left %d files (%v)", len(entries), err))
+	}
+
+}
+
+func TestWipeConflictsWithJobs(t *testing.T) {
+
+	cfg := config.Default()
+	cfg.Storage.BackupDir = t.TempDir()
+	config.Set(cfg)
+	defer config.Set(nil)
+
+	acpDB := db.NewMemStore()
+	apiMux := mux.NewRouter()
+	apiMux.HandleFunc("/engines/acp/ory", wipe(acpDB)).Methods("DELETE")
+	apiMux.HandleFunc("/engines/acp/ory/{flavor:regex|glob|exact}/reindex", reindex(acpDB)).Methods("POST")
+	confirm := func(query string) string {
+		rw := doJSON(apiMux, "DELETE", "/engines/acp/ory?dryRun=true&"+query, nil)
+		var report wipeReport
+		err := json.NewDecoder(rw.Body).Decode(&report)
+		if err != nil || rw.Code != http.StatusOK {
+			t.Fatal(fmt.Errorf("dry run returned %d (%v)", rw.Code, err))
+		}
+		return "/engines/acp/ory?confirm=" + report.ConfirmationToken + "&" + query
+	}
+
+	// A wipe of every flavor conflicts with a reindex of any of them
+	running := &job{Type: "reindex", Flavor: "glob", Kind: "roles", Status: jobRunning}
+	jobsMu.Lock()
+	jobs["running"] = running
+	jobsMu.Unlock()
+	rw := doJSON(apiMux, "DELETE", confirm("kind=roles"), nil)
+	if rw.Code != http.StatusConflict {
+		t.Error(fmt.Errorf("wipe during a reindex returned %d", rw.Code))
+	}
+	rw = doJSON(apiMux, "DELETE", confirm("kind=policies"), nil)
+	if rw.Code != http.StatusOK {
+		t.Error(fmt.Errorf("wipe of other documents returned %d", rw.Code))
+	}
+	jobsMu.Lock()
+	delete(jobs, "running")
+	jobsMu.Unlock()
+
+	// A running wipe refuses reindexes of its documents
+	running = &job{Type: "wipe", Kind: "roles", Status: jobRunning}
+	jobsMu.Lock()
+	jobs["running"] = running
+	jobsMu.Unlock()
+	defer func() {
+		jobsMu.Lock()
+		delete(jobs, "running")
+		jobsMu.Unlock()
+	}()
+	rw = doJSON(apiMux, "POST", "/engines/acp/ory/exact/reindex?kind=roles", nil)
+	if rw.Code != http.StatusConflict {
+		t.Error(fmt.Errorf("reindex during a wipe returned %d", rw.Code))
+	}
+
+	// Wipes are listed as jobs
+	found := false
+	jobsMu.Lock()
+	for _, j := range jobs {
+		found = found || (j.Type == "wipe" && j.Kind == "policies" && j.Status == jobSucceeded)
+	}
+	jobsMu.Unlock()
+	if !found {
+		t.Error(fmt.Errorf("wipe wasn't recorded as a job"))
+	}
+
+}
+
+func TestWritesWaitForWipes(t *testing.T) {
+
+	handled := make(chan string, 2)
+	handler := holdWriteBarrier(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		handled <- r.Method
+	}))
+
+	writeBarrier.Lock()
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/engines/acp/ory/exact/policies", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/engines/acp/ory/exact/policies", nil))
+	if method := <-handled; method != "GET" {
+		t.Error(fmt.Errorf("write ran during a wipe"))
+	}
+	select {
+	case <-handled:
+		t.Error(fmt.Errorf("write ran during a wipe"))
+	case <-time.After(50 * time.Millisecond):
+	}
+	writeBarrier.Unlock()
+	if method := <-handled; method != "PUT" {
+		t.Error(fmt.Errorf("write didn't run after the wipe"))
+	}
+
+}
@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Scope is a permission granted to a caller
+type Scope string
+
+// Scopes, admin implying all the others
+const (
+	ScopeCheck Scope = "check"
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+	ScopeAdmin Scope = "admin"
+)
+
+// ParseScope ..
+func ParseScope(s string) (Scope, error) {
+	switch scope := Scope(s); scope {
+	case ScopeCheck, ScopeRead, ScopeWrite, ScopeAdmin:
+		return scope, nil
+	}
+	return "", fmt.Errorf("invalid scope '%s' (expected check, read, write or admin)", s)
+}
+
+// ErrNoCredentials is returned when a request carries no credentials
+var ErrNoCredentials = errors.New("No credentials")
+
+// ErrInvalidCredentials is returned when a request carries credentials that
+// can't be verified
+var ErrInvalidCredentials = errors.New("Invalid credentials")
+
+// Principal is an authenticated caller
+type Principal struct {
+	Name   string
+	Scopes []Scope
+}
+
+// Has tells whether the principal was granted scope
+func (p *Principal) Has(scope Scope) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey is a static key granting scopes
+type APIKey struct {
+	Name string
+	// Key is the key itself, or empty when only its hex SHA-256 is given in
+	// KeySHA256
+	Key       string
+	KeySHA256 string
+	Scopes    []Scope
+}
+
+// CertIdentity maps the subject of a verified client certificate, either its
+// common name or its full distinguished name, to a caller
+type CertIdentity struct {
+	Subject string
+	Name    string
+	Scopes  []Scope
+}
+
+// Options ..
+type Options struct {
+	APIKeys            []APIKey
+	ClientCertificates []CertIdentity
+	// JWKSFile enables JWT bearer tokens signed by its keys
+	JWKSFile string
+	// Issuer and Audience are checked when set
+	Issuer   string
+	Audience string
+	// ScopeClaim holds the scopes of a token, space separated or as an array
+	ScopeClaim string
+}
+
+// Validate checks options without reading the JWKS file
+func (opts Options) Validate() error {
+	if len(opts.APIKeys) == 0 && opts.JWKSFile == "" && len(opts.ClientCertificates) == 0 {
+		return fmt.Errorf("authentication needs API keys, a JWKS file or client certificates")
+	}
+	for _, cert := range opts.ClientCertificates {
+		if cert.Subject == "" || len(cert.Scopes) == 0 {
+			return fmt.Errorf("client certificates need a subject and scopes")
+		}
+	}
+	for _, key := range opts.APIKeys {
+		if key.Name == "" {
+			return fmt.Errorf("API keys need a name")
+		}
+		if (key.Key == "") == (key.KeySHA256 == "") {
+			return fmt.Errorf("API key %s needs either a key or its SHA-256", key.Name)
+		}
+		if key.KeySHA256 != "" {
+			sum, err := hex.DecodeString(key.KeySHA256)
+			if err != nil || len(sum) != sha256.Size {
+				return fmt.Errorf("invalid SHA-256 for API key %s", key.Name)
+			}
+		}
+		if len(key.Scopes) == 0 {
+			return fmt.Errorf("API key %s grants no scope", key.Name)
+		}
+	}
+	return nil
+}
+
+type keyEntry struct {
+	sum       []byte
+	principal *Principal
+}
+
+// Authenticator verifies the credentials of requests
+type Authenticator struct {
+	keys  []keyEntry
+	jwt   *jwtVerifier
+	certs map[string]*Principal
+}
+
+// New creates an authenticator, reading the JWKS file if any
+func New(opts Options) (*Authenticator, error) {
+	err := opts.Validate()
+	if err != nil {
+		return nil, err
+	}
+	a := &Authenticator{
+		certs: make(map[string]*Principal),
+	}
+	for _, cert := range opts.ClientCertificates {
+		name := cert.Name
+		if name == "" {
+			name = cert.Subject
+		}
+		a.certs[cert.Subject] = &Principal{
+			Name:   name,
+			Scopes: cert.Scopes,
+		}
+	}
+	for _, key := range opts.APIKeys {
+		var sum []byte
+		if key.Key != "" {
+			hashed := sha256.Sum256([]byte(key.Key))
+			sum = hashed[:]
+		} else {
+			sum, _ = hex.DecodeString(key.KeySHA256)
+		}
+		a.keys = append(a.keys, keyEntry{
+			sum: sum,
+			principal: &Principal{
+				Name:   key.Name,
+				Scopes: key.Scopes,
+			},
+		})
+	}
+	if opts.JWKSFile != "" {
+		a.jwt, err = newJWTVerifier(opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// credentials returns the token of the Authorization bearer or X-API-Key header
+func credentials(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return bearer(r)
+}
+
+// bearer returns the token of the Authorization bearer header
+func bearer(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// certPrincipal returns the caller identified by the verified client
+// certificate of r, if any
+func (a *Authenticator) certPrincipal(r *http.Request) (*Principal, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if principal, ok := a.certs[subject.String()]; ok {
+		return principal, true
+	}
+	principal, ok := a.certs[subject.CommonName]
+	return principal, ok
+}
+
+// Authenticate returns the caller of r, ErrNoCredentials when r has no
+// credentials or ErrInvalidCredentials. A token takes precedence over the
+// client certificate
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := credentials(r)
+	if token == "" {
+		if principal, ok := a.certPrincipal(r); ok {
+			return principal, nil
+		}
+		return nil, ErrNoCredentials
+	}
+	hashed := sha256.Sum256([]byte(token))
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare(hashed[:], key.sum) == 1 {
+			return key.principal, nil
+		}
+	}
+	if a.jwt != nil && strings.Count(token, ".") == 2 {
+		principal, err := a.jwt.verify(token)
+		if err == nil {
+			return principal, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// TokenVerifier verifies the JWT bearer tokens of the users requests are
+// checked on behalf of, rather than of sketo's callers
+type TokenVerifier struct {
+	jwt *jwtVerifier
+}
+
+// NewTokenVerifier creates a verifier of tokens signed by the keys of
+// opts.JWKSFile, checking opts.Issuer and opts.Audience when set
+func NewTokenVerifier(opts Options) (*TokenVerifier, error) {
+	if opts.JWKSFile == "" {
+		return nil, fmt.Errorf("verifying tokens needs a JWKS file")
+	}
+	v, err := newJWTVerifier(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenVerifier{
+		jwt: v,
+	}, nil
+}
+
+// Claims returns the claims of the Authorization bearer token of r,
+// ErrNoCredentials when r has none or ErrInvalidCredentials
+func (v *TokenVerifier) Claims(r *http.Request) (map[string]interface{}, error) {
+	token := bearer(r)
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+	claims, err := v.jwt.claims(token)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return claims, nil
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal carried by ctx, or nil for anonymous
+// requests
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// Transport adds key to the requests sent through base, or
+// http.DefaultTransport when nil
+func Transport(key string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &keyTransport{
+		key:  key,
+		base: base,
+	}
+}
+
+type keyTransport struct {
+	key  string
+	base http.RoundTripper
+}
+
+func (t *keyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+t.key)
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeJWKS(t *testing.T, key *rsa.PublicKey) string {
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	content, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	err := os.WriteFile(file, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func authenticate(a *Authenticator, token string) (*Principal, error) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return a.Authenticate(req)
+}
+
+func TestAuthenticate(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("ops-secret"))
+	a, err := New(Options{
+		APIKeys: []APIKey{
+			{Name: "ci", Key: "ci-secret", Scopes: []Scope{ScopeRead, ScopeWrite}},
+			{Name: "ops", KeySHA256: hex.EncodeToString(sum[:]), Scopes: []Scope{ScopeAdmin}},
+		},
+		JWKSFile: writeJWKS(t, &key.PublicKey),
+		Issuer:   "https://issuer",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	principal, err := authenticate(a, "ci-secret")
+	if err != nil || principal.Name != "ci" || !principal.Has(ScopeWrite) || principal.Has(ScopeAdmin) {
+		t.Error(fmt.Errorf("unexpected principal %+v (%v) for the ci key", principal, err))
+	}
+	principal, err = authenticate(a, "ops-secret")
+	if err != nil || !principal.Has(ScopeCheck) {
+		t.Error(fmt.Errorf("admin key doesn't imply other scopes (%v)", err))
+	}
+	_, err = authenticate(a, "")
+	if err != ErrNoCredentials {
+		t.Error(fmt.Errorf("expected no credentials, got %v", err))
+	}
+
+	_, err = authenticate(a, "wrong")
+	if err != ErrInvalidCredentials {
+		t.Error(fmt.Errorf("expected invalid credentials, got %v", err))
+	}
+
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	principal, err = authenticate(a, sign(jwt.MapClaims{
+		"sub":   "svc",
+		"iss":   "https://issuer",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"scope": "check openid",
+	}))
+	if err != nil || principal.Name != "svc" || !principal.Has(ScopeCheck) || principal.Has(ScopeRead) {
+		t.Error(fmt.Errorf("unexpected principal %+v (%v) for the token", principal, err))
+	}
+	_, err = authenticate(a, sign(jwt.MapClaims{
+		"sub": "svc",
+		"iss": "https://other",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}))
+	if err != ErrInvalidCredentials {
+		t.Error(fmt.Errorf("token of another issuer accepted"))
+	}
+	_, err = authenticate(a, sign(jwt.MapClaims{
+		"sub": "svc",
+		"iss": "https://issuer",
+		"exp": time.Now().Add(-time.Minute).Unix(),
+	}))
+	if err != ErrInvalidCredentials {
+		t.Error(fmt.Errorf("expired token accepted"))
+	}
+
+	// Verified client certificates map to callers by subject
+	certs, err := New(Options{
+		ClientCertificates: []CertIdentity{
+			{Subject: "CN=ops,O=Acme", Name: "ops", Scopes: []Scope{ScopeAdmin}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "ops", Organization: []string{"Acme"}}}}},
+	}
+	principal, err = certs.Authenticate(req)
+	if err != nil || principal.Name != "ops" || !principal.Has(ScopeAdmin) {
+		t.Error(fmt.Errorf("unexpected principal %+v (%v) for the client certificate", principal, err))
+	}
+	req.TLS.VerifiedChains = nil
+	req.TLS.PeerCertificates = []*x509.Certificate{{Subject: pkix.Name{CommonName: "ops", Organization: []string{"Acme"}}}}
+	_, err = certs.Authenticate(req)
+	if err != ErrNoCredentials {
+		t.Error(fmt.Errorf("an unverified client certificate was accepted"))
+	}
+
+}
+
+func TestTokenVerifierClaims(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewTokenVerifier(Options{
+		JWKSFile: writeJWKS(t, &key.PublicKey),
+		Audience: "app",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func(token string) (map[string]interface{}, error) {
+		req := httptest.NewRequest("GET", "/", nil)
+		// API keys of sketo's callers aren't tokens of users
+		req.Header.Set("X-API-Key", "ci-secret")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return v.Claims(req)
+	}
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	got, err := claims(sign(jwt.MapClaims{
+		"email": "alice@example.com",
+		"aud":   "app",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}))
+	if err != nil || got["email"] != "alice@example.com" {
+		t.Error(fmt.Errorf("unexpected claims %v (%v)", got, err))
+	}
+	_, err = claims(sign(jwt.MapClaims{
+		"aud": "other",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}))
+	if err != ErrInvalidCredentials {
+		t.Error(fmt.Errorf("token for another audience accepted"))
+	}
+	_, err = claims("")
+	if err != ErrNoCredentials {
+		t.Error(fmt.Errorf("expected no credentials, got %v", err))
+	}
+
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwk is a public key of a JWKS
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+}
+
+// jwtVerifier verifies bearer tokens signed by the keys of a local JWKS
+type jwtVerifier struct {
+	keys       map[string]interface{}
+	parser     *jwt.Parser
+	scopeClaim string
+}
+
+func newJWTVerifier(opts Options) (*jwtVerifier, error) {
+	content, err := os.ReadFile(opts.JWKSFile)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.Unmarshal(content, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse JWKS %s: %w", opts.JWKSFile, err)
+	}
+	v := &jwtVerifier{
+		keys:       make(map[string]interface{}),
+		scopeClaim: opts.ScopeClaim,
+	}
+	if v.scopeClaim == "" {
+		v.scopeClaim = "scope"
+	}
+	for _, key := range jwks.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key '%s' in JWKS %s: %w", key.Kid, opts.JWKSFile, err)
+		}
+		v.keys[key.Kid] = publicKey
+	}
+	if len(v.keys) == 0 {
+		return nil, fmt.Errorf("no signing key in JWKS %s", opts.JWKSFile)
+	}
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithExpirationRequired(),
+	}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+	v.parser = jwt.NewParser(parserOpts...)
+	return v, nil
+}
+
+// claims returns the claims of token once verified
+func (v *jwtVerifier) claims(token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if key, ok := v.keys[kid]; ok {
+			return key, nil
+		}
+		// Tokens without kid are accepted when there's a single key
+		if kid == "" && len(v.keys) == 1 {
+			for _, key := range v.keys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key '%s'", kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *jwtVerifier) verify(token string) (*Principal, error) {
+	claims, err := v.claims(token)
+	if err != nil {
+		return nil, err
+	}
+	subject, _ := claims.GetSubject()
+	principal := &Principal{
+		Name: subject,
+	}
+	var granted []string
+	switch scopes := claims[v.scopeClaim].(type) {
+	case string:
+		granted = strings.Fields(scopes)
+	case []interface{}:
+		for _, scope := range scopes {
+			if s, ok := scope.(string); ok {
+				granted = append(granted, s)
+			}
+		}
+	}
+	for _, s := range granted {
+		// Scopes meant for other services are ignored
+		if scope, err := ParseScope(s); err == nil {
+			principal.Scopes = append(principal.Scopes, scope)
+		}
+	}
+	return principal, nil
+}
@@ -0,0 +1,322 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+type testNode struct {
+	node  *Node
+	acpDB *db.DB
+	srv   *httptest.Server
+	// followerApplies counts the writes of other nodes applied locally
+	followerApplies int64
+}
+
+// increment adds one to a counter stored in the database, reading it first
+func increment(acpDB *db.DB) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		err := acpDB.Update(func(txn db.Txn) error {
+			cnt := 0
+			err := txn.Get("test/", "counter", func(value []byte) error {
+				return json.Unmarshal(value, &cnt)
+			})
+			if err != nil && err != db.ErrKeyNotFound {
+				return err
+			}
+			return txn.Set("test/", "counter", cnt+1)
+		})
+		if err != nil {
+			rw.WriteHeader(500)
+			return
+		}
+		rw.WriteHeader(204)
+	}
+}
+
+func readCounter(acpDB *db.DB) int {
+	cnt := 0
+	acpDB.Get("test/", "counter", func(value []byte) error {
+		return json.Unmarshal(value, &cnt)
+	})
+	return cnt
+}
+
+func startTestNode(t *testing.T, dir string, id string, joinURL string) *testNode {
+	err := os.MkdirAll(filepath.Join(dir, id), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acpDB, err := db.NewDB(filepath.Join(dir, id, "storage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	srv := httptest.NewServer(router)
+	tn := &testNode{
+		acpDB: acpDB,
+		srv:   srv,
+	}
+	node, err := NewNode(Config{
+		NodeID:           id,
+		RaftAddr:         "127.0.0.1:0",
+		RaftDir:          filepath.Join(dir, id, "raft"),
+		APIURL:           srv.URL,
+		Bootstrap:        joinURL == "",
+		JoinURL:          joinURL,
+		HeartbeatTimeout: 200 * time.Millisecond,
+		ElectionTimeout:  200 * time.Millisecond,
+		OnFollowerApply: func() {
+			atomic.AddInt64(&tn.followerApplies, 1)
+		},
+	}, acpDB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Register(router)
+	router.HandleFunc("/counter", increment(acpDB)).Methods("POST")
+	router.Use(node.Middleware(func(r *http.Request) bool {
+		return r.Method == "GET"
+	}))
+	tn.node = node
+	return tn
+}
+
+func (tn *testNode) stop() {
+	tn.srv.CloseClientConnections()
+	tn.srv.Close()
+	tn.node.Shutdown()
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(15 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func hasKey(acpDB *db.DB, key string) bool {
+	return acpDB.Get("exact/po/", key, func(value []byte) error { return nil }) == nil
+}
+
+func TestThreeNodeCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sketo-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	nodes := []*testNode{startTestNode(t, dir, "n1", "")}
+	waitFor(t, "bootstrap leader", nodes[0].node.IsLeader)
+	nodes = append(nodes, startTestNode(t, dir, "n2", nodes[0].srv.URL))
+	nodes = append(nodes, startTestNode(t, dir, "n3", nodes[1].srv.URL))
+	defer func() {
+		for _, tn := range nodes {
+			if tn != nil {
+				tn.stop()
+			}
+		}
+	}()
+	waitFor(t, "members", func() bool {
+		members, err := nodes[0].node.members()
+		return err == nil && len(members) == 3
+	})
+
+	// Writes on any node go through the leader and reach every node
+	for i, tn := range nodes {
+		key := fmt.Sprintf("i/from-n%d/", i+1)
+		err = tn.acpDB.Set("exact/po/", key, map[string]string{"id": key})
+		if err != nil {
+			t.Fatalf("write on n%d: %v", i+1, err)
+		}
+		if !hasKey(tn.acpDB, key) {
+			t.Fatalf("write on n%d not readable right after it returned", i+1)
+		}
+	}
+	for i, tn := range nodes {
+		waitFor(t, fmt.Sprintf("replicated writes on n%d", i+1), func() bool {
+			return hasKey(tn.acpDB, "i/from-n1/") && hasKey(tn.acpDB, "i/from-n2/") && hasKey(tn.acpDB, "i/from-n3/")
+		})
+	}
+
+	// Losing the leader elects a new one among the survivors
+	leader := -1
+	for i, tn := range nodes {
+		if tn.node.IsLeader() {
+			leader = i
+		}
+	}
+	if leader == -1 {
+		t.Fatal("no leader")
+	}
+	nodes[leader].stop()
+	nodes[leader] = nil
+	var survivors []*testNode
+	for _, tn := range nodes {
+		if tn != nil {
+			survivors = append(survivors, tn)
+		}
+	}
+	waitFor(t, "new leader", func() bool {
+		return survivors[0].node.IsLeader() || survivors[1].node.IsLeader()
+	})
+	waitFor(t, "write after failover", func() bool {
+		return survivors[0].acpDB.Set("exact/po/", "i/after-failover/", map[string]string{}) == nil
+	})
+	for _, tn := range survivors {
+		waitFor(t, "replicated write after failover", func() bool {
+			return hasKey(tn.acpDB, "i/after-failover/")
+		})
+	}
+}
+
+func TestWritesRunOnTheLeader(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sketo-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	nodes := []*testNode{startTestNode(t, dir, "n1", "")}
+	waitFor(t, "bootstrap leader", nodes[0].node.IsLeader)
+	nodes = append(nodes, startTestNode(t, dir, "n2", nodes[0].srv.URL))
+	nodes = append(nodes, startTestNode(t, dir, "n3", nodes[0].srv.URL))
+	defer func() {
+		for _, tn := range nodes {
+			tn.stop()
+		}
+	}()
+	waitFor(t, "members", func() bool {
+		members, err := nodes[0].node.members()
+		return err == nil && len(members) == 3
+	})
+	for _, tn := range nodes {
+		atomic.StoreInt64(&tn.followerApplies, 0)
+	}
+
+	// Increments sent to every node at once read the leader's state, so none
+	// of them is lost, and each node reads its own increments once answered
+	var wg sync.WaitGroup
+	errs := make(chan error, 30)
+	for i := 0; i < 30; i++ {
+		tn := nodes[i%len(nodes)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(tn.srv.URL+"/counter", "application/json", nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 204 {
+				errs <- fmt.Errorf("increment returned %d", resp.StatusCode)
+				return
+			}
+			if readCounter(tn.acpDB) == 0 {
+				errs <- fmt.Errorf("increment not readable right after it returned")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	for i, tn := range nodes {
+		waitFor(t, fmt.Sprintf("increments on n%d", i+1), func() bool {
+			return readCounter(tn.acpDB) == 30
+		})
+	}
+
+	// Only followers are told about the writes they applied
+	if applies := atomic.LoadInt64(&nodes[0].followerApplies); applies != 0 {
+		t.Error(fmt.Errorf("leader was told about %d applied writes", applies))
+	}
+	for i, tn := range nodes[1:] {
+		if atomic.LoadInt64(&tn.followerApplies) == 0 {
+			t.Error(fmt.Errorf("n%d wasn't told about the applied writes", i+2))
+		}
+	}
+
+}
+
+func TestMembershipSurvivesDrop(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sketo-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tn := startTestNode(t, dir, "n1", "")
+	defer tn.stop()
+	waitFor(t, "bootstrap leader", tn.node.IsLeader)
+	err = tn.acpDB.Set(apiURLPrefix, "n2", "http://n2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tn.acpDB.DelEverything()
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiURL, err := tn.node.memberAPIURL("n2")
+	if err != nil || apiURL != "http://n2" {
+		t.Error(fmt.Errorf("member API URL after drop is '%s' (%v)", apiURL, err))
+	}
+
+	// Followers only forward API writes
+	for _, body := range []string{
+		`{"type":"drop"}`,
+		`{"type":"delprefix","prefix":""}`,
+		`{"type":"set","prefix":"` + apiURLPrefix + `","keys":["n3"],"values":["Imh0dHA6Ly9uMyI="]}`,
+		`{"type":"batch","ops":[{"type":"delprefix","prefix":"exact/"}]}`,
+	} {
+		resp, err := http.Post(tn.srv.URL+"/cluster/apply", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Error(fmt.Errorf("forwarding %s returned %d", body, resp.StatusCode))
+		}
+	}
+	resp, err := http.Post(tn.srv.URL+"/cluster/apply", "application/json", strings.NewReader(`{"type":"set","prefix":"exact/po/","keys":["i/p1/"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || !hasKey(tn.acpDB, "i/p1/") {
+		t.Error(fmt.Errorf("forwarding a set returned %d", resp.StatusCode))
+	}
+
+	// Refusals are answered by the error writer
+	var reason string
+	tn.node.cfg.WriteError = func(rw http.ResponseWriter, r *http.Request, code int, msg string) {
+		reason = msg
+		rw.WriteHeader(code)
+	}
+	rw := httptest.NewRecorder()
+	tn.node.handleApply(rw, httptest.NewRequest("POST", "/cluster/apply", strings.NewReader(`{"type":"drop"}`)))
+	if rw.Code != 400 || !strings.HasPrefix(reason, "Refused forwarded write") {
+		t.Error(fmt.Errorf("refusal returned %d with reason '%s'", rw.Code, reason))
+	}
+
+}
@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"sync/atomic"
+
+	"github.com/adi/sketo/db"
+	"github.com/hashicorp/raft"
+)
+
+// fsm applies committed raft log entries to the local database
+type fsm struct {
+	acpDB           *db.DB
+	onRestore       func() error
+	onFollowerApply func()
+	// raft is set once the node started, to tell whether it leads
+	raft atomic.Pointer[raft.Raft]
+	// applied is the index of the last entry applied to acpDB, which raft's own
+	// AppliedIndex may run ahead of
+	applied uint64
+}
+
+// Apply applies a committed write operation; the returned error is handed back to the proposer
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	var op db.Op
+	err := json.Unmarshal(l.Data, &op)
+	if err != nil {
+		atomic.StoreUint64(&f.applied, l.Index)
+		return err
+	}
+	err = f.acpDB.ApplyOp(&op)
+	atomic.StoreUint64(&f.applied, l.Index)
+	if f.onFollowerApply != nil {
+		r := f.raft.Load()
+		if r == nil || r.State() != raft.Leader {
+			f.onFollowerApply()
+		}
+	}
+	return err
+}
+
+// Snapshot captures the applied index followed by a badger backup of the
+// database. Writes applied while the backup streams may end up in it too;
+// replaying them afterwards is harmless since every operation is idempotent.
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	return &fsmSnapshot{
+		acpDB:   f.acpDB,
+		applied: atomic.LoadUint64(&f.applied),
+	}, nil
+}
+
+// Restore replaces the database with a snapshot
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	var applied uint64
+	err := binary.Read(rc, binary.BigEndian, &applied)
+	if err != nil {
+		return err
+	}
+	err = f.acpDB.Restore(rc)
+	if err != nil {
+		return err
+	}
+	atomic.StoreUint64(&f.applied, applied)
+	if f.onRestore != nil {
+		return f.onRestore()
+	}
+	return nil
+}
+
+type fsmSnapshot struct {
+	acpDB   *db.DB
+	applied uint64
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := binary.Write(sink, binary.BigEndian, s.applied)
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+	err = s.acpDB.Backup(sink)
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {}
@@ -0,0 +1,630 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/raft"
+)
+
+// apiURLPrefix stores, inside the replicated database, the API URL of every
+// member; it is in the reserved keyspace so dropping the database keeps it
+const apiURLPrefix = db.ReservedPrefix + "cluster/api/"
+
+const applyTimeout = 10 * time.Second
+
+// IndexHeader carries, on the responses of the leader to forwarded writes, the
+// raft index it had applied when answering
+const IndexHeader = "X-Sketo-Cluster-Index"
+
+// ErrNoLeader is returned when a write can't be routed because no leader is known
+var ErrNoLeader = errors.New("cluster has no leader")
+
+// Config holds the settings of a cluster node
+type Config struct {
+	// NodeID uniquely identifies the node in the cluster
+	NodeID string
+	// RaftAddr is the address raft traffic is bound to
+	RaftAddr string
+	// RaftAdvertise is the address other nodes use for raft traffic (defaults to RaftAddr)
+	RaftAdvertise string
+	// RaftDir holds the raft log and snapshots
+	RaftDir string
+	// APIURL is the base URL other nodes use to reach this node's API
+	APIURL string
+	// Bootstrap makes this node form a new single-node cluster if it has no state yet
+	Bootstrap bool
+	// JoinURL is the API URL of a member to ask for joining the cluster
+	JoinURL string
+	// HeartbeatTimeout and ElectionTimeout override the raft defaults when non-zero
+	HeartbeatTimeout time.Duration
+	ElectionTimeout  time.Duration
+	// Transport sends the requests to other members' APIs (defaults to
+	// http.DefaultTransport)
+	Transport http.RoundTripper
+	// OnFollowerApply is called after a write committed by another node was
+	// applied to the local database
+	OnFollowerApply func()
+	// WriteError sends the error responses of the cluster endpoints, explaining
+	// their cause in reason (defaults to plain text)
+	WriteError func(rw http.ResponseWriter, r *http.Request, code int, reason string)
+}
+
+// member describes a cluster member on the membership endpoints
+type member struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	APIURL   string `json:"api_url,omitempty"`
+	Suffrage string `json:"suffrage,omitempty"`
+	Leader   bool   `json:"leader"`
+}
+
+type applyResult struct {
+	Index uint64 `json:"index"`
+}
+
+// Node is a member of a raft cluster replicating all writes of a database
+type Node struct {
+	cfg       Config
+	acpDB     *db.DB
+	raft      *raft.Raft
+	fsm       *fsm
+	store     *logStore
+	transport *raft.NetworkTransport
+	client    *http.Client
+	done      chan struct{}
+}
+
+// NewNode starts a cluster node and routes all writes of acpDB through it;
+// onRestore is called after the database was replaced with a snapshot
+func NewNode(cfg Config, acpDB *db.DB, onRestore func() error) (*Node, error) {
+	if cfg.NodeID == "" || cfg.RaftAddr == "" || cfg.APIURL == "" {
+		return nil, errors.New("cluster node needs a node ID, a raft address and an API URL")
+	}
+	err := os.MkdirAll(cfg.RaftDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := newLogStore(filepath.Join(cfg.RaftDir, "log"))
+	if err != nil {
+		return nil, err
+	}
+	snaps, err := raft.NewFileSnapshotStore(cfg.RaftDir, 2, os.Stderr)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	var advertise net.Addr
+	if cfg.RaftAdvertise != "" {
+		advertise, err = net.ResolveTCPAddr("tcp", cfg.RaftAdvertise)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	transport, err := raft.NewTCPTransport(cfg.RaftAddr, advertise, 3, applyTimeout, os.Stderr)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(cfg.NodeID)
+	if cfg.HeartbeatTimeout != 0 {
+		conf.HeartbeatTimeout = cfg.HeartbeatTimeout
+		conf.LeaderLeaseTimeout = cfg.HeartbeatTimeout / 2
+	}
+	if cfg.ElectionTimeout != 0 {
+		conf.ElectionTimeout = cfg.ElectionTimeout
+	}
+
+	f := &fsm{
+		acpDB:           acpDB,
+		onRestore:       onRestore,
+		onFollowerApply: cfg.OnFollowerApply,
+	}
+	r, err := raft.NewRaft(conf, f, store, store, snaps, transport)
+	if err != nil {
+		transport.Close()
+		store.Close()
+		return nil, err
+	}
+	f.raft.Store(r)
+
+	n := &Node{
+		cfg:       cfg,
+		acpDB:     acpDB,
+		fsm:       f,
+		raft:      r,
+		store:     store,
+		transport: transport,
+		client:    &http.Client{Timeout: applyTimeout, Transport: cfg.Transport},
+		done:      make(chan struct{}),
+	}
+
+	if cfg.Bootstrap {
+		hasState, err := raft.HasExistingState(store, store, snaps)
+		if err != nil {
+			n.Shutdown()
+			return nil, err
+		}
+		if !hasState {
+			err = r.BootstrapCluster(raft.Configuration{
+				Servers: []raft.Server{{
+					ID:      conf.LocalID,
+					Address: transport.LocalAddr(),
+				}},
+			}).Error()
+			if err != nil {
+				n.Shutdown()
+				return nil, err
+			}
+		}
+	}
+
+	acpDB.SetProposer(n)
+	go n.announceLeadership()
+	if cfg.JoinURL != "" {
+		go n.join()
+	}
+	return n, nil
+}
+
+// Shutdown stops the node
+func (n *Node) Shutdown() error {
+	close(n.done)
+	err := n.raft.Shutdown().Error()
+	n.transport.Close()
+	n.store.Close()
+	return err
+}
+
+// IsLeader tells whether this node currently leads the cluster
+func (n *Node) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// HasLeader tells whether this node knows a leader
+func (n *Node) HasLeader() bool {
+	_, id := n.raft.LeaderWithID()
+	return id != ""
+}
+
+// AppliedIndex returns the last raft index applied to the local database
+func (n *Node) AppliedIndex() uint64 {
+	return atomic.LoadUint64(&n.fsm.applied)
+}
+
+// Register adds the cluster endpoints to the API router
+func (n *Node) Register(apiMux *mux.Router) {
+	apiMux.HandleFunc("/cluster/apply", n.handleApply).Methods("POST")
+	apiMux.HandleFunc("/cluster/members", n.listMembers).Methods("GET")
+	apiMux.HandleFunc("/cluster/members", n.addMember).Methods("POST")
+	apiMux.HandleFunc("/cluster/members/{id}", n.removeMember).Methods("DELETE")
+}
+
+// Propose sends a write through the raft log and returns once it is applied locally
+func (n *Node) Propose(op *db.Op) error {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	if n.IsLeader() {
+		_, err = n.apply(data)
+		return err
+	}
+	index, err := n.forward(data)
+	if err != nil {
+		return err
+	}
+	return n.waitApplied(index)
+}
+
+func (n *Node) apply(data []byte) (uint64, error) {
+	future := n.raft.Apply(data, applyTimeout)
+	err := future.Error()
+	if err != nil {
+		return 0, err
+	}
+	if err, ok := future.Response().(error); ok && err != nil {
+		return 0, err
+	}
+	return future.Index(), nil
+}
+
+func (n *Node) forward(data []byte) (uint64, error) {
+	leaderURL, err := n.leaderAPIURL()
+	if err != nil {
+		return 0, err
+	}
+	resp, err := n.client.Post(strings.TrimSuffix(leaderURL, "/")+"/cluster/apply", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return 0, fmt.Errorf("leader refused write with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	var result applyResult
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return 0, err
+	}
+	return result.Index, nil
+}
+
+// LeaderRequest sends a request with header to the API of the leader, with a
+// JSON body unless nil, and waits until the local database caught up with the
+// leader's answer
+func (n *Node) LeaderRequest(ctx context.Context, method string, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	leaderURL, err := n.leaderAPIURL()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(leaderURL, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	index, err := strconv.ParseUint(resp.Header.Get(IndexHeader), 10, 64)
+	if err == nil {
+		err = n.waitApplied(index)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// waitApplied blocks until the local database caught up with a write committed by the leader
+func (n *Node) waitApplied(index uint64) error {
+	deadline := time.Now().Add(applyTimeout)
+	for n.AppliedIndex() < index {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for index %d to be applied", index)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	return nil
+}
+
+func (n *Node) memberAPIURL(id string) (string, error) {
+	if id == n.cfg.NodeID {
+		return n.cfg.APIURL, nil
+	}
+	var apiURL string
+	err := n.acpDB.Get(apiURLPrefix, id, func(value []byte) error {
+		return json.Unmarshal(value, &apiURL)
+	})
+	return apiURL, err
+}
+
+func (n *Node) leaderAPIURL() (string, error) {
+	_, id := n.raft.LeaderWithID()
+	if id == "" {
+		return "", ErrNoLeader
+	}
+	apiURL, err := n.memberAPIURL(string(id))
+	if err != nil {
+		return "", fmt.Errorf("couldn't find API URL of leader %s: %w", id, err)
+	}
+	return apiURL, nil
+}
+
+// announceLeadership records this node's API URL whenever it becomes leader
+func (n *Node) announceLeadership() {
+	for {
+		select {
+		case <-n.done:
+			return
+		case isLeader := <-n.raft.LeaderCh():
+			if !isLeader {
+				continue
+			}
+			err := n.acpDB.Set(apiURLPrefix, n.cfg.NodeID, n.cfg.APIURL)
+			if err != nil {
+				log.Printf("Couldn't announce API URL of new leader: %v\n", err)
+			}
+		}
+	}
+}
+
+// join asks an existing member to add this node until it succeeds
+func (n *Node) join() {
+	body, err := json.Marshal(member{
+		ID:      n.cfg.NodeID,
+		Address: string(n.transport.LocalAddr()),
+		APIURL:  n.cfg.APIURL,
+	})
+	if err != nil {
+		log.Printf("Couldn't encode join request: %v\n", err)
+		return
+	}
+	for {
+		resp, err := n.client.Post(strings.TrimSuffix(n.cfg.JoinURL, "/")+"/cluster/members", "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == 200 {
+				log.Printf("Joined cluster through %s\n", n.cfg.JoinURL)
+				return
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		log.Printf("Couldn't join cluster through %s: %v\n", n.cfg.JoinURL, err)
+		select {
+		case <-n.done:
+			return
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// forwardToLeader proxies a request to the leader's API and waits until the
+// local database applied what the leader had when answering; it returns false
+// if this node is the leader
+func (n *Node) forwardToLeader(rw http.ResponseWriter, r *http.Request) bool {
+	if n.IsLeader() {
+		return false
+	}
+	leaderURL, err := n.leaderAPIURL()
+	if err != nil {
+		n.writeError(rw, r, 503, err.Error())
+		return true
+	}
+	target, err := url.Parse(leaderURL)
+	if err != nil {
+		log.Printf("Invalid API URL of the cluster leader: %v\n", err)
+		n.writeError(rw, r, 500, "")
+		return true
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		index, err := strconv.ParseUint(resp.Header.Get(IndexHeader), 10, 64)
+		if err != nil {
+			return nil
+		}
+		return n.waitApplied(index)
+	}
+	proxy.ErrorHandler = func(rw http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("Error forwarding request to the cluster leader: %v\n", err)
+		n.writeError(rw, r, 502, "Couldn't forward the request to the cluster leader")
+	}
+	proxy.ServeHTTP(rw, r)
+	return true
+}
+
+// Middleware sends the requests isRead refuses to the leader, so that writes
+// read and modify the state every other write was applied to; the cluster
+// endpoints route their requests themselves
+func (n *Node) Middleware(isRead func(r *http.Request) bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if isRead(r) || strings.HasPrefix(r.URL.Path, "/cluster/") {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			if n.forwardToLeader(rw, r) {
+				return
+			}
+			next.ServeHTTP(&indexWriter{ResponseWriter: rw, node: n}, r)
+		})
+	}
+}
+
+// indexWriter adds the applied index to the headers of a response
+type indexWriter struct {
+	http.ResponseWriter
+	node        *Node
+	wroteHeader bool
+}
+
+func (iw *indexWriter) WriteHeader(code int) {
+	if !iw.wroteHeader {
+		iw.wroteHeader = true
+		iw.Header().Set(IndexHeader, strconv.FormatUint(iw.node.AppliedIndex(), 10))
+	}
+	iw.ResponseWriter.WriteHeader(code)
+}
+
+func (iw *indexWriter) Write(b []byte) (int, error) {
+	if !iw.wroteHeader {
+		iw.WriteHeader(http.StatusOK)
+	}
+	return iw.ResponseWriter.Write(b)
+}
+
+func (iw *indexWriter) Flush() {
+	if flusher, ok := iw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (iw *indexWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
+
+// writeError sends an error response with the configured writer
+func (n *Node) writeError(rw http.ResponseWriter, r *http.Request, code int, reason string) {
+	if n.cfg.WriteError != nil {
+		n.cfg.WriteError(rw, r, code, reason)
+		return
+	}
+	if reason == "" {
+		reason = http.StatusText(code)
+	}
+	http.Error(rw, reason, code)
+}
+
+func (n *Node) handleApply(rw http.ResponseWriter, r *http.Request) {
+	if !n.IsLeader() {
+		n.writeError(rw, r, 503, "Not the cluster leader")
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		n.writeError(rw, r, 400, "Couldn't read body")
+		return
+	}
+	var op db.Op
+	err = json.Unmarshal(data, &op)
+	if err == nil {
+		err = checkForwarded(&op)
+	}
+	if err != nil {
+		n.writeError(rw, r, 400, "Refused forwarded write: "+err.Error())
+		return
+	}
+	index, err := n.apply(data)
+	if err != nil {
+		log.Printf("Error applying forwarded write: %v\n", err)
+		n.writeError(rw, r, 500, "")
+		return
+	}
+	rw.Header().Add("Content-Type", "application/json")
+	rw.WriteHeader(200)
+	json.NewEncoder(rw).Encode(applyResult{
+		Index: index,
+	})
+}
+
+// checkForwarded allows the writes followers forward for the API: sets and
+// deletions outside the reserved keyspace, alone or in a batch
+func checkForwarded(op *db.Op) error {
+	switch op.Type {
+	case db.OpSet, db.OpDel:
+		for _, key := range op.Keys {
+			if strings.HasPrefix(op.Prefix+key, db.ReservedPrefix) {
+				return fmt.Errorf("key '%s' is reserved", op.Prefix+key)
+			}
+		}
+	case db.OpDelPrefix:
+		if strings.HasPrefix(op.Prefix, db.ReservedPrefix) || strings.HasPrefix(db.ReservedPrefix, op.Prefix) {
+			return fmt.Errorf("prefix '%s' covers reserved keys", op.Prefix)
+		}
+	case db.OpBatch:
+		for _, batched := range op.Ops {
+			if batched.Type != db.OpSet && batched.Type != db.OpDel {
+				return fmt.Errorf("operation '%s' can't be batched", batched.Type)
+			}
+			err := checkForwarded(batched)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("operation '%s' can't be forwarded", op.Type)
+	}
+	return nil
+}
+
+func (n *Node) members() ([]member, error) {
+	future := n.raft.GetConfiguration()
+	err := future.Error()
+	if err != nil {
+		return nil, err
+	}
+	_, leaderID := n.raft.LeaderWithID()
+	ret := make([]member, 0)
+	for _, server := range future.Configuration().Servers {
+		apiURL, _ := n.memberAPIURL(string(server.ID))
+		ret = append(ret, member{
+			ID:       string(server.ID),
+			Address:  string(server.Address),
+			APIURL:   apiURL,
+			Suffrage: server.Suffrage.String(),
+			Leader:   server.ID == leaderID,
+		})
+	}
+	return ret, nil
+}
+
+func (n *Node) writeMembers(rw http.ResponseWriter, r *http.Request) {
+	ret, err := n.members()
+	if err != nil {
+		log.Printf("Error listing cluster members: %v\n", err)
+		n.writeError(rw, r, 500, "")
+		return
+	}
+	rw.Header().Add("Content-Type", "application/json")
+	rw.WriteHeader(200)
+	json.NewEncoder(rw).Encode(ret)
+}
+
+func (n *Node) listMembers(rw http.ResponseWriter, r *http.Request) {
+	n.writeMembers(rw, r)
+}
+
+func (n *Node) addMember(rw http.ResponseWriter, r *http.Request) {
+	if n.forwardToLeader(rw, r) {
+		return
+	}
+	var body member
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.ID == "" || body.Address == "" || body.APIURL == "" {
+		n.writeError(rw, r, 400, "Couldn't decode body (id, address and api_url are required)")
+		return
+	}
+	err = n.acpDB.Set(apiURLPrefix, body.ID, body.APIURL)
+	if err != nil {
+		log.Printf("Error recording API URL of member %s: %v\n", body.ID, err)
+		n.writeError(rw, r, 500, "")
+		return
+	}
+	err = n.raft.AddVoter(raft.ServerID(body.ID), raft.ServerAddress(body.Address), 0, applyTimeout).Error()
+	if err != nil {
+		log.Printf("Error adding member %s: %v\n", body.ID, err)
+		n.writeError(rw, r, 500, "")
+		return
+	}
+	n.writeMembers(rw, r)
+}
+
+func (n *Node) removeMember(rw http.ResponseWriter, r *http.Request) {
+	if n.forwardToLeader(rw, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	err := n.raft.RemoveServer(raft.ServerID(id), 0, applyTimeout).Error()
+	if err != nil {
+		log.Printf("Error removing member %s: %v\n", id, err)
+		n.writeError(rw, r, 500, "")
+		return
+	}
+	err = n.acpDB.Del(apiURLPrefix, id)
+	if err != nil {
+		log.Printf("Error forgetting API URL of member %s: %v\n", id, err)
+		n.writeError(rw, r, 500, "")
+		return
+	}
+	n.writeMembers(rw, r)
+}
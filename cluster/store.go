@@ -0,0 +1,170 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/hashicorp/raft"
+)
+
+// errNotFound has the exact text raft expects from a StableStore for missing keys
+var errNotFound = errors.New("not found")
+
+var (
+	logPrefix    = []byte("l/")
+	stablePrefix = []byte("s/")
+)
+
+// logStore is a raft LogStore and StableStore backed by its own badger database
+type logStore struct {
+	b *badger.DB
+}
+
+func newLogStore(dataDir string) (*logStore, error) {
+	opts := badger.DefaultOptions(dataDir)
+	b, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &logStore{
+		b: b,
+	}, nil
+}
+
+func (s *logStore) Close() error {
+	return s.b.Close()
+}
+
+func logKey(index uint64) []byte {
+	key := make([]byte, len(logPrefix)+8)
+	copy(key, logPrefix)
+	binary.BigEndian.PutUint64(key[len(logPrefix):], index)
+	return key
+}
+
+func (s *logStore) boundIndex(reverse bool) (uint64, error) {
+	var index uint64
+	err := s.b.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Reverse = reverse
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+		seek := logPrefix
+		if reverse {
+			seek = append(append([]byte{}, logPrefix...), 0xff)
+		}
+		iter.Seek(seek)
+		if iter.ValidForPrefix(logPrefix) {
+			index = binary.BigEndian.Uint64(iter.Item().Key()[len(logPrefix):])
+		}
+		return nil
+	})
+	return index, err
+}
+
+// FirstIndex returns the first index written, 0 for no entries
+func (s *logStore) FirstIndex() (uint64, error) {
+	return s.boundIndex(false)
+}
+
+// LastIndex returns the last index written, 0 for no entries
+func (s *logStore) LastIndex() (uint64, error) {
+	return s.boundIndex(true)
+}
+
+// GetLog gets a log entry at a given index
+func (s *logStore) GetLog(index uint64, log *raft.Log) error {
+	return s.b.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(logKey(index))
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return raft.ErrLogNotFound
+			}
+			return err
+		}
+		return item.Value(func(value []byte) error {
+			return json.Unmarshal(value, log)
+		})
+	})
+}
+
+// StoreLog stores a log entry
+func (s *logStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores multiple log entries
+func (s *logStore) StoreLogs(logs []*raft.Log) error {
+	wb := s.b.NewWriteBatch()
+	defer wb.Cancel()
+	for _, log := range logs {
+		encoded, err := json.Marshal(log)
+		if err != nil {
+			return err
+		}
+		err = wb.Set(logKey(log.Index), encoded)
+		if err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// DeleteRange deletes an inclusive range of log entries
+func (s *logStore) DeleteRange(min, max uint64) error {
+	wb := s.b.NewWriteBatch()
+	defer wb.Cancel()
+	for index := min; index <= max; index++ {
+		err := wb.Delete(logKey(index))
+		if err != nil {
+			return err
+		}
+		if index == max { // guard against overflow on max == MaxUint64
+			break
+		}
+	}
+	return wb.Flush()
+}
+
+// Set stores a key in the stable store
+func (s *logStore) Set(key []byte, val []byte) error {
+	return s.b.Update(func(txn *badger.Txn) error {
+		return txn.Set(append(append([]byte{}, stablePrefix...), key...), val)
+	})
+}
+
+// Get returns a key from the stable store
+func (s *logStore) Get(key []byte) ([]byte, error) {
+	var val []byte
+	err := s.b.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(append(append([]byte{}, stablePrefix...), key...))
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return errNotFound
+			}
+			return err
+		}
+		val, err = item.ValueCopy(nil)
+		return err
+	})
+	return val, err
+}
+
+// SetUint64 stores an integer in the stable store
+func (s *logStore) SetUint64(key []byte, val uint64) error {
+	encoded := make([]byte, 8)
+	binary.BigEndian.PutUint64(encoded, val)
+	return s.Set(key, encoded)
+}
+
+// GetUint64 returns an integer from the stable store
+func (s *logStore) GetUint64(key []byte) (uint64, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
@@ -0,0 +1,782 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/adi/sketo/auth"
+	"github.com/adi/sketo/db"
+	"github.com/adi/sketo/util"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings of sketo. Each setting is taken from, by order of
+// precedence: a command line flag, an environment variable, the YAML config
+// file and the default. Settings of List, Batch, MonitorMode, Logging, Auth,
+// ExtAuthz, ForwardAuth and SubjectAccessReview are applied again on reload;
+// the others need a restart.
+type Config struct {
+	// API serves checks and reads, and also writes and administration unless
+	// Admin has its own listen address. GRPC serves the gRPC API when it has a
+	// listen address
+	API         ListenConfig      `yaml:"api"`
+	Admin       ListenConfig      `yaml:"admin"`
+	GRPC        ListenConfig      `yaml:"grpc"`
+	Metrics     ListenConfig      `yaml:"metrics"`
+	Storage     StorageConfig     `yaml:"storage"`
+	List        ListConfig        `yaml:"list"`
+	Batch       BatchConfig       `yaml:"batch"`
+	Counters    CountersConfig    `yaml:"counters"`
+	MonitorMode bool              `yaml:"monitor_mode"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Auth        AuthConfig        `yaml:"auth"`
+	ExtAuthz    ExtAuthzConfig    `yaml:"ext_authz"`
+	ForwardAuth ForwardAuthConfig `yaml:"forward_auth"`
+	// SubjectAccessReview authorizes the requests of Kubernetes API servers
+	SubjectAccessReview SubjectAccessReviewConfig `yaml:"subject_access_review"`
+	// Cluster and Replication are exclusive ways of running several nodes
+	Cluster     ClusterConfig     `yaml:"cluster"`
+	Replication ReplicationConfig `yaml:"replication"`
+	Compare     CompareConfig     `yaml:"compare"`
+}
+
+// ListenConfig ..
+type ListenConfig struct {
+	Listen string    `yaml:"listen"`
+	TLS    TLSConfig `yaml:"tls"`
+	// Timeouts are disabled when 0
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// MaxConnections limits the connections served at once when positive
+	MaxConnections int `yaml:"max_connections"`
+}
+
+// ServerOptions returns the options to start the listener's server with,
+// loading its TLS certificate
+func (cfg ListenConfig) ServerOptions() (util.ServerOptions, error) {
+	tlsConfig, err := util.NewTLSConfig(cfg.TLS.Options())
+	if err != nil {
+		return util.ServerOptions{}, err
+	}
+	return util.ServerOptions{
+		Addr:              cfg.Listen,
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxConnections:    cfg.MaxConnections,
+	}, nil
+}
+
+// validate checks a listener, named for errors
+func (cfg ListenConfig) validate(name string) error {
+	if cfg.ReadTimeout < 0 || cfg.ReadHeaderTimeout < 0 || cfg.WriteTimeout < 0 || cfg.IdleTimeout < 0 || cfg.MaxConnections < 0 {
+		return fmt.Errorf("%s timeouts and connection limit can't be negative", name)
+	}
+	err := cfg.TLS.Options().Validate()
+	if err != nil {
+		return fmt.Errorf("%s TLS: %w", name, err)
+	}
+	return nil
+}
+
+// TLSConfig enables TLS when a certificate is set; its files are reloaded
+// when they change
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientAuth is none, request (verify certificates when given) or require
+	ClientAuth string `yaml:"client_auth"`
+}
+
+// Options returns the options to serve TLS with
+func (cfg TLSConfig) Options() util.TLSOptions {
+	return util.TLSOptions{
+		CertFile:     cfg.CertFile,
+		KeyFile:      cfg.KeyFile,
+		ClientCAFile: cfg.ClientCAFile,
+		ClientAuth:   cfg.ClientAuth,
+	}
+}
+
+// StorageConfig ..
+type StorageConfig struct {
+	// Backend is badger, memory or postgres
+	Backend string `yaml:"backend"`
+	Dir     string `yaml:"dir"`
+	DSN     string `yaml:"dsn"`
+	// BackupDir receives the documents backed up before a wipe
+	BackupDir string       `yaml:"backup_dir"`
+	Badger    BadgerConfig `yaml:"badger"`
+}
+
+// BadgerConfig tunes the badger backend, sizes being in bytes
+type BadgerConfig struct {
+	SyncWrites     bool          `yaml:"sync_writes"`
+	MemTableSize   int64         `yaml:"mem_table_size"`
+	ValueThreshold int           `yaml:"value_threshold"`
+	Compression    string        `yaml:"compression"`
+	BlockCacheSize int64         `yaml:"block_cache_size"`
+	IndexCacheSize int64         `yaml:"index_cache_size"`
+	GCInterval     time.Duration `yaml:"gc_interval"`
+	GCDiscardRatio float64       `yaml:"gc_discard_ratio"`
+}
+
+// Options returns the badger options to open the database with
+func (cfg BadgerConfig) Options() db.Options {
+	return db.Options{
+		SyncWrites:     cfg.SyncWrites,
+		MemTableSize:   cfg.MemTableSize,
+		ValueThreshold: cfg.ValueThreshold,
+		Compression:    cfg.Compression,
+		BlockCacheSize: cfg.BlockCacheSize,
+		IndexCacheSize: cfg.IndexCacheSize,
+		GCInterval:     cfg.GCInterval,
+		GCDiscardRatio: cfg.GCDiscardRatio,
+	}
+}
+
+// ListConfig bounds the pages returned by list endpoints
+type ListConfig struct {
+	MaxOffset int64 `yaml:"max_offset"`
+	MaxLimit  int64 `yaml:"max_limit"`
+}
+
+// BatchConfig ..
+type BatchConfig struct {
+	// MaxSize is the most docs an atomic batch can hold; other batches are
+	// written in chunks of that size
+	MaxSize int `yaml:"max_size"`
+	// MaxBodySize is the most bytes a JSON request body can hold; NDJSON imports
+	// are streamed and have no limit
+	MaxBodySize int64 `yaml:"max_body_size"`
+}
+
+// CountersConfig ..
+type CountersConfig struct {
+	// ReconcileInterval is how often the document counters are recounted from
+	// storage; 0 disables recounting
+	ReconcileInterval time.Duration `yaml:"reconcile_interval"`
+}
+
+// ClusterConfig runs the node as a member of a raft cluster when NodeID is set
+type ClusterConfig struct {
+	NodeID   string `yaml:"node_id"`
+	RaftAddr string `yaml:"raft_addr"`
+	// RaftAdvertise is the address other nodes use for raft traffic, RaftAddr
+	// when empty
+	RaftAdvertise string `yaml:"raft_advertise"`
+	// RaftDir defaults to the storage dir with a "-raft" suffix
+	RaftDir string `yaml:"raft_dir"`
+	// APIURL is the base URL other nodes use to reach the API of this one
+	APIURL string `yaml:"api_url"`
+	// Bootstrap forms a new cluster if the node has no raft state yet, Join is
+	// the API URL of a member to join through otherwise
+	Bootstrap bool   `yaml:"bootstrap"`
+	Join      string `yaml:"join"`
+	// APIKey is the admin API key sent to members requiring authentication
+	APIKey string `yaml:"api_key"`
+}
+
+// ReplicationConfig makes the node a replication leader or follower when Role
+// is set
+type ReplicationConfig struct {
+	// Role is leader or follower
+	Role string `yaml:"role"`
+	// LogSize is the number of changes a leader keeps for followers to catch up
+	LogSize int `yaml:"log_size"`
+	// The other settings are for followers. ForwardWrites sends writes to the
+	// leader instead of rejecting them, and MaxLag is the lag above which
+	// readiness fails
+	LeaderURL     string        `yaml:"leader_url"`
+	ForwardWrites bool          `yaml:"forward_writes"`
+	MaxLag        time.Duration `yaml:"max_lag"`
+	// APIKey is the admin API key sent to a leader requiring authentication
+	APIKey string `yaml:"api_key"`
+}
+
+// CompareConfig forwards checks to an upstream Keto when UpstreamURL is set,
+// recording the ones it decides differently
+type CompareConfig struct {
+	UpstreamURL string `yaml:"upstream_url"`
+	// Answer is sketo or upstream, whose answer is returned
+	Answer string `yaml:"answer"`
+	// MismatchLog is a file mismatches are appended to as JSON lines instead
+	// of the log
+	MismatchLog string        `yaml:"mismatch_log"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+// LoggingConfig ..
+type LoggingConfig struct {
+	// File is appended to instead of stderr when set, and reopened on reload
+	File string `yaml:"file"`
+	UTC  bool   `yaml:"utc"`
+}
+
+// TracingConfig ..
+type TracingConfig struct {
+	// Backend is elastic, otel or none
+	Backend string `yaml:"backend"`
+}
+
+// AuthConfig protects the API; when disabled every request is allowed
+type AuthConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// AnonymousCheck lets requests without credentials call the allowed
+	// endpoints
+	AnonymousCheck bool           `yaml:"anonymous_check"`
+	APIKeys        []APIKeyConfig `yaml:"api_keys"`
+	JWT            JWTConfig      `yaml:"jwt"`
+	// ClientCertificates identifies callers by the subject of their verified
+	// TLS client certificate
+	ClientCertificates []ClientCertificateConfig `yaml:"client_certificates"`
+}
+
+// RequestMappingConfig maps the requests a proxy asks about to checks on
+// Flavor: the subject is read from a header, the resource from the first
+// resource template whose path matches and the action from the method
+type RequestMappingConfig struct {
+	Flavor        string                   `yaml:"flavor"`
+	SubjectHeader string                   `yaml:"subject_header"`
+	Resources     []ResourceTemplateConfig `yaml:"resources"`
+	// Actions maps methods to actions; other methods are lowercased
+	Actions map[string]string `yaml:"actions"`
+}
+
+// ExtAuthzConfig maps the requests Envoy's ext_authz filter asks about
+type ExtAuthzConfig struct {
+	Enabled              bool `yaml:"enabled"`
+	RequestMappingConfig `yaml:",inline"`
+}
+
+// ForwardAuthConfig maps the sub-requests of nginx auth_request and Traefik
+// ForwardAuth. SubjectClaim takes the subject from a claim of the bearer token
+// verified with JWT instead of SubjectHeader
+type ForwardAuthConfig struct {
+	Enabled              bool `yaml:"enabled"`
+	RequestMappingConfig `yaml:",inline"`
+	SubjectClaim         string    `yaml:"subject_claim"`
+	JWT                  JWTConfig `yaml:"jwt"`
+}
+
+// SubjectAccessReviewConfig maps the SubjectAccessReviews of Kubernetes'
+// webhook authorizer to checks on Flavor. The subjects checked are the user
+// and its groups, prefixed, and the roles the user is a member of. The
+// resource is Resource with {namespace}, {group}, {resource} and {name}
+// replaced, {resource} including any subresource as in RBAC, or the path of
+// non-resource requests; the action is the verb
+type SubjectAccessReviewConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	Flavor      string `yaml:"flavor"`
+	UserPrefix  string `yaml:"user_prefix"`
+	GroupPrefix string `yaml:"group_prefix"`
+	Resource    string `yaml:"resource"`
+	// Actions maps verbs to actions; other verbs are kept
+	Actions map[string]string `yaml:"actions"`
+}
+
+// ResourceTemplateConfig maps the paths matching a template such as
+// /documents/{id} to a resource such as documents:{id}, where the variables of
+// the path are replaced
+type ResourceTemplateConfig struct {
+	Path     string `yaml:"path"`
+	Resource string `yaml:"resource"`
+}
+
+// APIKeyConfig ..
+type APIKeyConfig struct {
+	Name      string   `yaml:"name"`
+	Key       string   `yaml:"key"`
+	KeySHA256 string   `yaml:"key_sha256"`
+	Scopes    []string `yaml:"scopes"`
+}
+
+// ClientCertificateConfig maps a certificate subject, either its common name
+// or its full distinguished name (e.g. "CN=ops,O=Acme"), to a caller
+type ClientCertificateConfig struct {
+	Subject string   `yaml:"subject"`
+	Name    string   `yaml:"name"`
+	Scopes  []string `yaml:"scopes"`
+}
+
+// JWTConfig ..
+type JWTConfig struct {
+	JWKSFile   string `yaml:"jwks_file"`
+	Issuer     string `yaml:"issuer"`
+	Audience   string `yaml:"audience"`
+	ScopeClaim string `yaml:"scope_claim"`
+}
+
+// Options returns the options to verify JWT bearer tokens with
+func (cfg JWTConfig) Options() auth.Options {
+	return auth.Options{
+		JWKSFile:   cfg.JWKSFile,
+		Issuer:     cfg.Issuer,
+		Audience:   cfg.Audience,
+		ScopeClaim: cfg.ScopeClaim,
+	}
+}
+
+// Options returns the options to authenticate requests with
+func (cfg AuthConfig) Options() (auth.Options, error) {
+	opts := cfg.JWT.Options()
+	for _, key := range cfg.APIKeys {
+		apiKey := auth.APIKey{
+			Name:      key.Name,
+			Key:       key.Key,
+			KeySHA256: key.KeySHA256,
+		}
+		for _, s := range key.Scopes {
+			scope, err := auth.ParseScope(s)
+			if err != nil {
+				return opts, fmt.Errorf("API key %s: %w", key.Name, err)
+			}
+			apiKey.Scopes = append(apiKey.Scopes, scope)
+		}
+		opts.APIKeys = append(opts.APIKeys, apiKey)
+	}
+	for _, cert := range cfg.ClientCertificates {
+		identity := auth.CertIdentity{
+			Subject: cert.Subject,
+			Name:    cert.Name,
+		}
+		for _, s := range cert.Scopes {
+			scope, err := auth.ParseScope(s)
+			if err != nil {
+				return opts, fmt.Errorf("client certificate %s: %w", cert.Subject, err)
+			}
+			identity.Scopes = append(identity.Scopes, scope)
+		}
+		opts.ClientCertificates = append(opts.ClientCertificates, identity)
+	}
+	return opts, opts.Validate()
+}
+
+// parseAPIKeys parses API keys given as comma separated name:key:scopes
+// entries, scopes being separated by +
+func parseAPIKeys(value string, keys *[]APIKeyConfig) error {
+	parsed := make([]APIKeyConfig, 0)
+	for _, entry := range strings.Split(value, ",") {
+		fields := strings.Split(entry, ":")
+		if len(fields) != 3 {
+			return fmt.Errorf("expected name:key:scopes")
+		}
+		parsed = append(parsed, APIKeyConfig{
+			Name:   fields[0],
+			Key:    fields[1],
+			Scopes: strings.Split(fields[2], "+"),
+		})
+	}
+	*keys = parsed
+	return nil
+}
+
+// Default returns the settings used when nothing else is configured
+func Default() *Config {
+	badger := db.DefaultOptions()
+	return &Config{
+		API: ListenConfig{
+			Listen: ":4466",
+		},
+		Metrics: ListenConfig{
+			Listen: ":9104",
+		},
+		Storage: StorageConfig{
+			Backend:   "badger",
+			Dir:       "storage",
+			BackupDir: "backups",
+			Badger: BadgerConfig{
+				SyncWrites:     badger.SyncWrites,
+				MemTableSize:   badger.MemTableSize,
+				ValueThreshold: badger.ValueThreshold,
+				Compression:    badger.Compression,
+				BlockCacheSize: badger.BlockCacheSize,
+				IndexCacheSize: badger.IndexCacheSize,
+				GCInterval:     badger.GCInterval,
+				GCDiscardRatio: badger.GCDiscardRatio,
+			},
+		},
+		List: ListConfig{
+			MaxOffset: db.DefaultMaxListOffset,
+			MaxLimit:  db.DefaultMaxListLimit,
+		},
+		Batch: BatchConfig{
+			MaxSize:     1000,
+			MaxBodySize: 16 << 20,
+		},
+		Counters: CountersConfig{
+			ReconcileInterval: 10 * time.Minute,
+		},
+		Tracing: TracingConfig{
+			Backend: "elastic",
+		},
+		Auth: AuthConfig{
+			AnonymousCheck: true,
+		},
+		ExtAuthz: ExtAuthzConfig{
+			RequestMappingConfig: RequestMappingConfig{
+				Flavor:        "exact",
+				SubjectHeader: "X-User-Id",
+			},
+		},
+		ForwardAuth: ForwardAuthConfig{
+			RequestMappingConfig: RequestMappingConfig{
+				Flavor:        "exact",
+				SubjectHeader: "X-User-Id",
+			},
+		},
+		SubjectAccessReview: SubjectAccessReviewConfig{
+			Flavor:   "exact",
+			Resource: "{namespace}/{resource}/{name}",
+		},
+		Replication: ReplicationConfig{
+			LogSize: 100000,
+			MaxLag:  30 * time.Second,
+		},
+		Compare: CompareConfig{
+			Answer:  "sketo",
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+type envVar struct {
+	name string
+	set  func(cfg *Config, value string) error
+}
+
+// listenEnvVars returns the environment variables overriding the settings of
+// a listener, prefixed with prefix (e.g. API_LISTEN)
+func listenEnvVars(prefix string, listener func(cfg *Config) *ListenConfig) []envVar {
+	return []envVar{
+		{prefix + "_LISTEN", func(cfg *Config, value string) error { listener(cfg).Listen = value; return nil }},
+		{prefix + "_TLS_CERT_FILE", func(cfg *Config, value string) error { listener(cfg).TLS.CertFile = value; return nil }},
+		{prefix + "_TLS_KEY_FILE", func(cfg *Config, value string) error { listener(cfg).TLS.KeyFile = value; return nil }},
+		{prefix + "_TLS_CLIENT_CA_FILE", func(cfg *Config, value string) error { listener(cfg).TLS.ClientCAFile = value; return nil }},
+		{prefix + "_TLS_CLIENT_AUTH", func(cfg *Config, value string) error { listener(cfg).TLS.ClientAuth = value; return nil }},
+		{prefix + "_READ_TIMEOUT", func(cfg *Config, value string) error { return parseDuration(value, &listener(cfg).ReadTimeout) }},
+		{prefix + "_READ_HEADER_TIMEOUT", func(cfg *Config, value string) error { return parseDuration(value, &listener(cfg).ReadHeaderTimeout) }},
+		{prefix + "_WRITE_TIMEOUT", func(cfg *Config, value string) error { return parseDuration(value, &listener(cfg).WriteTimeout) }},
+		{prefix + "_IDLE_TIMEOUT", func(cfg *Config, value string) error { return parseDuration(value, &listener(cfg).IdleTimeout) }},
+		{prefix + "_MAX_CONNECTIONS", func(cfg *Config, value string) error { return parseSmallInt(value, &listener(cfg).MaxConnections) }},
+	}
+}
+
+// envVars maps environment variables to the setting they override
+var envVars = append(append(append(append(
+	listenEnvVars("API", func(cfg *Config) *ListenConfig { return &cfg.API }),
+	listenEnvVars("ADMIN", func(cfg *Config) *ListenConfig { return &cfg.Admin })...),
+	listenEnvVars("GRPC", func(cfg *Config) *ListenConfig { return &cfg.GRPC })...),
+	listenEnvVars("METRICS", func(cfg *Config) *ListenConfig { return &cfg.Metrics })...),
+	[]envVar{
+		{"STORAGE_BACKEND", func(cfg *Config, value string) error { cfg.Storage.Backend = value; return nil }},
+		{"STORAGE_DIR", func(cfg *Config, value string) error { cfg.Storage.Dir = value; return nil }},
+		{"STORAGE_BACKUP_DIR", func(cfg *Config, value string) error { cfg.Storage.BackupDir = value; return nil }},
+		{"STORAGE_DSN", func(cfg *Config, value string) error { cfg.Storage.DSN = value; return nil }},
+		{"BADGER_SYNC_WRITES", func(cfg *Config, value string) error { return parseBool(value, &cfg.Storage.Badger.SyncWrites) }},
+		{"BADGER_MEM_TABLE_SIZE", func(cfg *Config, value string) error { return parseInt(value, &cfg.Storage.Badger.MemTableSize) }},
+		{"BADGER_VALUE_THRESHOLD", func(cfg *Config, value string) error { return parseSmallInt(value, &cfg.Storage.Badger.ValueThreshold) }},
+		{"BADGER_COMPRESSION", func(cfg *Config, value string) error { cfg.Storage.Badger.Compression = value; return nil }},
+		{"BADGER_BLOCK_CACHE_SIZE", func(cfg *Config, value string) error { return parseInt(value, &cfg.Storage.Badger.BlockCacheSize) }},
+		{"BADGER_INDEX_CACHE_SIZE", func(cfg *Config, value string) error { return parseInt(value, &cfg.Storage.Badger.IndexCacheSize) }},
+		{"BADGER_GC_INTERVAL", func(cfg *Config, value string) error { return parseDuration(value, &cfg.Storage.Badger.GCInterval) }},
+		{"BADGER_GC_DISCARD_RATIO", func(cfg *Config, value string) error { return parseFloat(value, &cfg.Storage.Badger.GCDiscardRatio) }},
+		{"LIST_MAX_OFFSET", func(cfg *Config, value string) error { return parseInt(value, &cfg.List.MaxOffset) }},
+		{"LIST_MAX_LIMIT", func(cfg *Config, value string) error { return parseInt(value, &cfg.List.MaxLimit) }},
+		{"BATCH_MAX_SIZE", func(cfg *Config, value string) error { return parseSmallInt(value, &cfg.Batch.MaxSize) }},
+		{"BATCH_MAX_BODY_SIZE", func(cfg *Config, value string) error { return parseInt(value, &cfg.Batch.MaxBodySize) }},
+		{"COUNTER_RECONCILE_INTERVAL", func(cfg *Config, value string) error { return parseDuration(value, &cfg.Counters.ReconcileInterval) }},
+		{"MONITOR_MODE", func(cfg *Config, value string) error { return parseBool(value, &cfg.MonitorMode) }},
+		{"LOG_FILE", func(cfg *Config, value string) error { cfg.Logging.File = value; return nil }},
+		{"LOG_UTC", func(cfg *Config, value string) error { return parseBool(value, &cfg.Logging.UTC) }},
+		{"TRACING_BACKEND", func(cfg *Config, value string) error { cfg.Tracing.Backend = value; return nil }},
+		{"AUTH_ENABLED", func(cfg *Config, value string) error { return parseBool(value, &cfg.Auth.Enabled) }},
+		{"AUTH_ANONYMOUS_CHECK", func(cfg *Config, value string) error { return parseBool(value, &cfg.Auth.AnonymousCheck) }},
+		{"AUTH_API_KEYS", func(cfg *Config, value string) error { return parseAPIKeys(value, &cfg.Auth.APIKeys) }},
+		{"AUTH_JWKS_FILE", func(cfg *Config, value string) error { cfg.Auth.JWT.JWKSFile = value; return nil }},
+		{"AUTH_JWT_ISSUER", func(cfg *Config, value string) error { cfg.Auth.JWT.Issuer = value; return nil }},
+		{"AUTH_JWT_AUDIENCE", func(cfg *Config, value string) error { cfg.Auth.JWT.Audience = value; return nil }},
+		{"AUTH_JWT_SCOPE_CLAIM", func(cfg *Config, value string) error { cfg.Auth.JWT.ScopeClaim = value; return nil }},
+		{"EXT_AUTHZ_ENABLED", func(cfg *Config, value string) error { return parseBool(value, &cfg.ExtAuthz.Enabled) }},
+		{"EXT_AUTHZ_FLAVOR", func(cfg *Config, value string) error { cfg.ExtAuthz.Flavor = value; return nil }},
+		{"EXT_AUTHZ_SUBJECT_HEADER", func(cfg *Config, value string) error { cfg.ExtAuthz.SubjectHeader = value; return nil }},
+		{"FORWARD_AUTH_ENABLED", func(cfg *Config, value string) error { return parseBool(value, &cfg.ForwardAuth.Enabled) }},
+		{"FORWARD_AUTH_FLAVOR", func(cfg *Config, value string) error { cfg.ForwardAuth.Flavor = value; return nil }},
+		{"FORWARD_AUTH_SUBJECT_HEADER", func(cfg *Config, value string) error { cfg.ForwardAuth.SubjectHeader = value; return nil }},
+		{"FORWARD_AUTH_SUBJECT_CLAIM", func(cfg *Config, value string) error { cfg.ForwardAuth.SubjectClaim = value; return nil }},
+		{"FORWARD_AUTH_JWKS_FILE", func(cfg *Config, value string) error { cfg.ForwardAuth.JWT.JWKSFile = value; return nil }},
+		{"FORWARD_AUTH_JWT_ISSUER", func(cfg *Config, value string) error { cfg.ForwardAuth.JWT.Issuer = value; return nil }},
+		{"FORWARD_AUTH_JWT_AUDIENCE", func(cfg *Config, value string) error { cfg.ForwardAuth.JWT.Audience = value; return nil }},
+		{"SUBJECT_ACCESS_REVIEW_ENABLED", func(cfg *Config, value string) error { return parseBool(value, &cfg.SubjectAccessReview.Enabled) }},
+		{"SUBJECT_ACCESS_REVIEW_FLAVOR", func(cfg *Config, value string) error { cfg.SubjectAccessReview.Flavor = value; return nil }},
+		{"SUBJECT_ACCESS_REVIEW_USER_PREFIX", func(cfg *Config, value string) error { cfg.SubjectAccessReview.UserPrefix = value; return nil }},
+		{"SUBJECT_ACCESS_REVIEW_GROUP_PREFIX", func(cfg *Config, value string) error { cfg.SubjectAccessReview.GroupPrefix = value; return nil }},
+		{"SUBJECT_ACCESS_REVIEW_RESOURCE", func(cfg *Config, value string) error { cfg.SubjectAccessReview.Resource = value; return nil }},
+		{"CLUSTER_NODE_ID", func(cfg *Config, value string) error { cfg.Cluster.NodeID = value; return nil }},
+		{"CLUSTER_RAFT_ADDR", func(cfg *Config, value string) error { cfg.Cluster.RaftAddr = value; return nil }},
+		{"CLUSTER_RAFT_ADVERTISE", func(cfg *Config, value string) error { cfg.Cluster.RaftAdvertise = value; return nil }},
+		{"CLUSTER_RAFT_DIR", func(cfg *Config, value string) error { cfg.Cluster.RaftDir = value; return nil }},
+		{"CLUSTER_API_URL", func(cfg *Config, value string) error { cfg.Cluster.APIURL = value; return nil }},
+		{"CLUSTER_BOOTSTRAP", func(cfg *Config, value string) error { return parseBool(value, &cfg.Cluster.Bootstrap) }},
+		{"CLUSTER_JOIN", func(cfg *Config, value string) error { cfg.Cluster.Join = value; return nil }},
+		{"CLUSTER_API_KEY", func(cfg *Config, value string) error { cfg.Cluster.APIKey = value; return nil }},
+		{"REPLICATION_ROLE", func(cfg *Config, value string) error { cfg.Replication.Role = value; return nil }},
+		{"REPLICATION_LOG_SIZE", func(cfg *Config, value string) error { return parseSmallInt(value, &cfg.Replication.LogSize) }},
+		{"REPLICATION_LEADER_URL", func(cfg *Config, value string) error { cfg.Replication.LeaderURL = value; return nil }},
+		{"REPLICATION_FORWARD_WRITES", func(cfg *Config, value string) error { return parseBool(value, &cfg.Replication.ForwardWrites) }},
+		{"REPLICATION_MAX_LAG", func(cfg *Config, value string) error { return parseDuration(value, &cfg.Replication.MaxLag) }},
+		{"REPLICATION_API_KEY", func(cfg *Config, value string) error { cfg.Replication.APIKey = value; return nil }},
+		{"COMPARE_UPSTREAM_URL", func(cfg *Config, value string) error { cfg.Compare.UpstreamURL = value; return nil }},
+		{"COMPARE_ANSWER", func(cfg *Config, value string) error { cfg.Compare.Answer = value; return nil }},
+		{"COMPARE_MISMATCH_LOG", func(cfg *Config, value string) error { cfg.Compare.MismatchLog = value; return nil }},
+		{"COMPARE_TIMEOUT", func(cfg *Config, value string) error { return parseDuration(value, &cfg.Compare.Timeout) }},
+	}...)
+
+func parseBool(value string, b *bool) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*b = parsed
+	return nil
+}
+
+func parseInt(value string, i *int64) error {
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	*i = parsed
+	return nil
+}
+
+func parseSmallInt(value string, i *int) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*i = parsed
+	return nil
+}
+
+func parseFloat(value string, f *float64) error {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	*f = parsed
+	return nil
+}
+
+func parseDuration(value string, d *time.Duration) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Load reads the settings from the YAML file (skipped when empty), then from
+// the environment, then lets flags override them and validates the result
+func Load(file string, flags func(cfg *Config)) (*Config, error) {
+	cfg := Default()
+	if file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		err = yaml.Unmarshal(content, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse %s: %w", file, err)
+		}
+	}
+	for _, envVar := range envVars {
+		if value := os.Getenv(envVar.name); value != "" {
+			err := envVar.set(cfg, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", envVar.name, err)
+			}
+		}
+	}
+	if flags != nil {
+		flags(cfg)
+	}
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks that the settings are usable
+func (cfg *Config) Validate() error {
+	if cfg.API.Listen == "" || cfg.Metrics.Listen == "" {
+		return fmt.Errorf("listen addresses can't be empty")
+	}
+	err := cfg.API.validate("API")
+	if err != nil {
+		return err
+	}
+	if cfg.Admin.Listen != "" {
+		if cfg.Admin.Listen == cfg.API.Listen || cfg.Admin.Listen == cfg.Metrics.Listen {
+			return fmt.Errorf("admin listen address must differ from the API and metrics ones")
+		}
+		err = cfg.Admin.validate("admin")
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.GRPC.Listen != "" {
+		if cfg.GRPC.Listen == cfg.API.Listen || cfg.GRPC.Listen == cfg.Admin.Listen || cfg.GRPC.Listen == cfg.Metrics.Listen {
+			return fmt.Errorf("gRPC listen address must differ from the API, admin and metrics ones")
+		}
+		err = cfg.GRPC.validate("gRPC")
+		if err != nil {
+			return err
+		}
+	}
+	err = cfg.Metrics.validate("metrics")
+	if err != nil {
+		return err
+	}
+	switch cfg.Storage.Backend {
+	case "badger":
+		if cfg.Storage.Dir == "" {
+			return fmt.Errorf("storage dir can't be empty with the badger backend")
+		}
+		err = cfg.Storage.Badger.Options().Validate()
+		if err != nil {
+			return err
+		}
+	case "memory":
+	case "postgres":
+		if cfg.Storage.DSN == "" {
+			return fmt.Errorf("storage DSN can't be empty with the postgres backend")
+		}
+	default:
+		return fmt.Errorf("invalid storage backend '%s' (expected badger, memory or postgres)", cfg.Storage.Backend)
+	}
+	if cfg.Storage.BackupDir == "" {
+		return fmt.Errorf("storage backup dir can't be empty")
+	}
+	if cfg.List.MaxOffset < 0 || cfg.List.MaxLimit <= 0 {
+		return fmt.Errorf("list limits must be positive")
+	}
+	if cfg.Batch.MaxSize <= 0 {
+		return fmt.Errorf("batch max size must be positive")
+	}
+	if cfg.Batch.MaxBodySize <= 0 {
+		return fmt.Errorf("batch max body size must be positive")
+	}
+	if cfg.Counters.ReconcileInterval < 0 {
+		return fmt.Errorf("counter reconcile interval can't be negative")
+	}
+	if cfg.Cluster.NodeID != "" && cfg.Replication.Role != "" {
+		return fmt.Errorf("cluster node ID and replication role can't be used together")
+	}
+	switch cfg.Replication.Role {
+	case "", "leader":
+	case "follower":
+		if cfg.Replication.LeaderURL == "" {
+			return fmt.Errorf("replication followers need a leader URL")
+		}
+	default:
+		return fmt.Errorf("invalid replication role '%s' (expected leader or follower)", cfg.Replication.Role)
+	}
+	if cfg.Replication.LogSize <= 0 || cfg.Replication.MaxLag < 0 {
+		return fmt.Errorf("replication log size must be positive and max lag can't be negative")
+	}
+	switch cfg.Compare.Answer {
+	case "sketo", "upstream":
+	default:
+		return fmt.Errorf("invalid compare answer '%s' (expected sketo or upstream)", cfg.Compare.Answer)
+	}
+	if cfg.Compare.Timeout < 0 {
+		return fmt.Errorf("compare timeout can't be negative")
+	}
+	switch cfg.Tracing.Backend {
+	case "elastic", "otel", "none":
+	default:
+		return fmt.Errorf("invalid tracing backend '%s' (expected elastic, otel or none)", cfg.Tracing.Backend)
+	}
+	if cfg.Auth.Enabled {
+		_, err = cfg.Auth.Options()
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.ExtAuthz.Enabled {
+		err = cfg.ExtAuthz.validate("ext_authz")
+		if err != nil {
+			return err
+		}
+		if cfg.ExtAuthz.SubjectHeader == "" {
+			return fmt.Errorf("ext_authz subject header can't be empty")
+		}
+	}
+	if cfg.ForwardAuth.Enabled {
+		err = cfg.ForwardAuth.validate("forward auth")
+		if err != nil {
+			return err
+		}
+		if cfg.ForwardAuth.SubjectClaim != "" && cfg.ForwardAuth.JWT.JWKSFile == "" {
+			return fmt.Errorf("forward auth subject claim needs a JWKS file")
+		}
+		if cfg.ForwardAuth.SubjectClaim == "" && cfg.ForwardAuth.SubjectHeader == "" {
+			return fmt.Errorf("forward auth needs a subject header or claim")
+		}
+	}
+	if cfg.SubjectAccessReview.Enabled {
+		switch cfg.SubjectAccessReview.Flavor {
+		case "exact", "glob", "regex":
+		default:
+			return fmt.Errorf("invalid subject access review flavor '%s' (expected exact, glob or regex)", cfg.SubjectAccessReview.Flavor)
+		}
+		if cfg.SubjectAccessReview.Resource == "" {
+			return fmt.Errorf("subject access review resource can't be empty")
+		}
+	}
+	return nil
+}
+
+// validate checks the flavor and resource templates of a mapping used by name;
+// path templates are parsed when applied
+func (cfg RequestMappingConfig) validate(name string) error {
+	switch cfg.Flavor {
+	case "exact", "glob", "regex":
+	default:
+		return fmt.Errorf("invalid %s flavor '%s' (expected exact, glob or regex)", name, cfg.Flavor)
+	}
+	for _, template := range cfg.Resources {
+		if !strings.HasPrefix(template.Path, "/") || template.Resource == "" {
+			return fmt.Errorf("%s resource templates need an absolute path and a resource", name)
+		}
+	}
+	return nil
+}
+
+var current atomic.Pointer[Config]
+
+// Get returns the settings in effect, or the defaults until Set is called
+func Get() *Config {
+	if cfg := current.Load(); cfg != nil {
+		return cfg
+	}
+	return Default()
+}
+
+// Set puts cfg in effect; it must not be modified afterwards
+func Set(cfg *Config) {
+	current.Store(cfg)
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "sketo.yaml")
+	err := os.WriteFile(file, []byte("api:\n  listen: \":1000\"\nmetrics:\n  listen: \":2000\"\nlist:\n  max_limit: 50\nmonitor_mode: true\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("METRICS_LISTEN", ":3000")
+	t.Setenv("LIST_MAX_LIMIT", "20")
+
+	cfg, err := Load(file, func(cfg *Config) {
+		cfg.List.MaxLimit = 10
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.API.Listen != ":1000" || cfg.Metrics.Listen != ":3000" || cfg.List.MaxLimit != 10 || !cfg.MonitorMode {
+		t.Error(fmt.Errorf("unexpected config %+v", cfg))
+	}
+	if cfg.List.MaxOffset != Default().List.MaxOffset || cfg.Storage.Backend != "badger" {
+		t.Error(fmt.Errorf("defaults weren't kept in %+v", cfg))
+	}
+
+	// Multi-node settings come from the environment too
+	t.Setenv("CLUSTER_NODE_ID", "n1")
+	t.Setenv("CLUSTER_API_KEY", "secret")
+	t.Setenv("COUNTER_RECONCILE_INTERVAL", "0")
+	cfg, err = Load(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Cluster.NodeID != "n1" || cfg.Cluster.APIKey != "secret" || cfg.Counters.ReconcileInterval != 0 {
+		t.Error(fmt.Errorf("unexpected multi-node config %+v", cfg))
+	}
+	t.Setenv("REPLICATION_ROLE", "leader")
+	_, err = Load(file, nil)
+	if err == nil {
+		t.Error(fmt.Errorf("clustering and replication were accepted together"))
+	}
+
+	t.Setenv("STORAGE_BACKEND", "cassandra")
+	_, err = Load(file, nil)
+	if err == nil {
+		t.Error(fmt.Errorf("an invalid storage backend was accepted"))
+	}
+
+}
+
+func TestReloadRollsBack(t *testing.T) {
+
+	applied := make([]int64, 0)
+	OnReload(func(cfg *Config) error {
+		applied = append(applied, cfg.List.MaxLimit)
+		return nil
+	})
+	defer func() {
+		ResetReloadHooks()
+		current.Store(nil)
+		applyLogging(LoggingConfig{})
+	}()
+
+	err := Apply(Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the reloadable settings change
+	err = Reload(func() (*Config, error) {
+		cfg := Default()
+		cfg.API.Listen = ":1000"
+		cfg.List.MaxLimit = 10
+		cfg.Batch.MaxSize = 5
+		cfg.MonitorMode = true
+		return cfg, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Get().API.Listen != ":4466" || Get().List.MaxLimit != 10 || Get().Batch.MaxSize != 5 || !Get().MonitorMode {
+		t.Error(fmt.Errorf("unexpected reloaded config %+v", Get()))
+	}
+
+	// A log file that can't be opened keeps the config in effect
+	err = Reload(func() (*Config, error) {
+		cfg := Default()
+		cfg.List.MaxLimit = 20
+		cfg.Logging.File = filepath.Join(t.TempDir(), "missing", "sketo.log")
+		return cfg, nil
+	})
+	if err == nil {
+		t.Error(fmt.Errorf("reloading with an unusable log file succeeded"))
+	}
+	if Get().List.MaxLimit != 10 || Get().Logging.File != "" {
+		t.Error(fmt.Errorf("config wasn't kept after a failed reload: %+v", Get()))
+	}
+
+	// A hook failing after others ran rolls them back
+	OnReload(func(cfg *Config) error {
+		if cfg.List.MaxLimit == 30 {
+			return fmt.Errorf("rejected")
+		}
+		return nil
+	})
+	err = Reload(func() (*Config, error) {
+		cfg := Default()
+		cfg.List.MaxLimit = 30
+		return cfg, nil
+	})
+	if err == nil {
+		t.Error(fmt.Errorf("reloading with a failing hook succeeded"))
+	}
+	if last := applied[len(applied)-1]; last != 10 || Get().List.MaxLimit != 10 {
+		t.Error(fmt.Errorf("hooks weren't rolled back (last applied limit %d)", last))
+	}
+
+}
+
+func TestReloadAppliesEveryReloadableSetting(t *testing.T) {
+
+	var seen *Config
+	OnReload(func(cfg *Config) error {
+		seen = cfg
+		return nil
+	})
+	defer func() {
+		ResetReloadHooks()
+		current.Store(nil)
+		applyLogging(LoggingConfig{})
+	}()
+	err := Apply(Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name    string
+		change  func(cfg *Config)
+		applied func(cfg *Config) bool
+	}{
+		{"list", func(cfg *Config) { cfg.List.MaxLimit = 10 }, func(cfg *Config) bool { return cfg.List.MaxLimit == 10 }},
+		{"batch", func(cfg *Config) { cfg.Batch.MaxSize = 5 }, func(cfg *Config) bool { return cfg.Batch.MaxSize == 5 }},
+		{"monitor mode", func(cfg *Config) { cfg.MonitorMode = true }, func(cfg *Config) bool { return cfg.MonitorMode }},
+		{"logging", func(cfg *Config) { cfg.Logging.UTC = true }, func(cfg *Config) bool { return cfg.Logging.UTC }},
+		{"auth", func(cfg *Config) { cfg.Auth.AnonymousCheck = false }, func(cfg *Config) bool { return !cfg.Auth.AnonymousCheck }},
+		{"ext_authz", func(cfg *Config) { cfg.ExtAuthz.Enabled = true }, func(cfg *Config) bool { return cfg.ExtAuthz.Enabled }},
+		{"forward auth", func(cfg *Config) { cfg.ForwardAuth.Enabled = true }, func(cfg *Config) bool { return cfg.ForwardAuth.Enabled }},
+		{"subject access review", func(cfg *Config) { cfg.SubjectAccessReview.Enabled = true }, func(cfg *Config) bool { return cfg.SubjectAccessReview.Enabled }},
+	} {
+		err = Reload(func() (*Config, error) {
+			cfg := Default()
+			cfg.API.Listen = ":1000"
+			c.change(cfg)
+			return cfg, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.applied(seen) || !c.applied(Get()) {
+			t.Error(fmt.Errorf("reloaded %s settings weren't applied", c.name))
+		}
+		if Get().API.Listen != ":4466" {
+			t.Error(fmt.Errorf("reloading %s settings changed the API listen address", c.name))
+		}
+	}
+
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var (
+	reloadMu sync.Mutex
+	hooks    []func(cfg *Config) error
+)
+
+// OnReload registers hook to apply the reloadable settings of every config
+// passed to Apply
+func OnReload(hook func(cfg *Config) error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	hooks = append(hooks, hook)
+}
+
+// ResetReloadHooks drops the hooks registered with OnReload, so that the
+// packages registering them can be initialized again
+func ResetReloadHooks() {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	hooks = nil
+}
+
+// Apply applies the reloadable settings of cfg and puts it in effect. When a
+// hook fails, the settings in effect are applied again and kept
+func Apply(cfg *Config) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	return apply(cfg)
+}
+
+func apply(cfg *Config) error {
+	old := current.Load()
+	err := runHooks(cfg)
+	if err != nil {
+		if old != nil {
+			rollbackErr := runHooks(old)
+			if rollbackErr != nil {
+				log.Printf("Couldn't roll back config: %v\n", rollbackErr)
+			}
+		}
+		return err
+	}
+	current.Store(cfg)
+	return nil
+}
+
+func runHooks(cfg *Config) error {
+	err := applyLogging(cfg.Logging)
+	if err != nil {
+		return fmt.Errorf("can't apply logging config: %w", err)
+	}
+	for _, hook := range hooks {
+		err := hook(cfg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reload loads new settings with load and applies their reloadable subset,
+// keeping the settings in effect when loading or applying fails. Changes to
+// settings that need a restart are logged and ignored
+func Reload(load func() (*Config, error)) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	cfg, err := load()
+	if err != nil {
+		return err
+	}
+	old := Get()
+	reloaded := *old
+	copyReloadable(&reloaded, cfg)
+	copyReloadable(cfg, old)
+	if ignored := changedSettings(old, cfg); len(ignored) > 0 {
+		log.Printf("Ignoring changes to %s settings until restart\n", strings.Join(ignored, ", "))
+	}
+	return apply(&reloaded)
+}
+
+// copyReloadable copies the settings applied again on reload from src to dst
+func copyReloadable(dst *Config, src *Config) {
+	dst.List = src.List
+	dst.Batch = src.Batch
+	dst.MonitorMode = src.MonitorMode
+	dst.Logging = src.Logging
+	dst.Auth = src.Auth
+	dst.ExtAuthz = src.ExtAuthz
+	dst.ForwardAuth = src.ForwardAuth
+	dst.SubjectAccessReview = src.SubjectAccessReview
+}
+
+// changedSettings returns the YAML keys of the top level settings differing
+// between a and b
+func changedSettings(a *Config, b *Config) []string {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	changed := make([]string, 0)
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, va.Type().Field(i).Tag.Get("yaml"))
+		}
+	}
+	return changed
+}
+
+var (
+	logMu   sync.Mutex
+	logFile *os.File
+)
+
+// applyLogging sends the log to the configured file (reopening it, so that it
+// can be rotated) or to stderr
+func applyLogging(cfg LoggingConfig) error {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	var out io.Writer = os.Stderr
+	var f *os.File
+	if cfg.File != "" {
+		var err error
+		f, err = os.OpenFile(cfg.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+	flags := log.LstdFlags
+	if cfg.UTC {
+		flags |= log.LUTC
+	}
+	log.SetOutput(out)
+	log.SetFlags(flags)
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = f
+	return nil
+}
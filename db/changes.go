@@ -0,0 +1,255 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/pb"
+)
+
+// ErrDropped is returned by Subscribe when the whole database has been dropped
+var ErrDropped = errors.New("Database dropped")
+
+// badgerInternalPrefix marks keys badger writes for its own bookkeeping
+var badgerInternalPrefix = []byte("!badger!")
+
+// subscribeMarkerKey is written by Subscribe to find out when the change feed is live
+var subscribeMarkerKey = []byte("_replication/subscribe")
+
+// Change is a single key write or deletion committed to the database
+type Change struct {
+	Key     []byte `json:"key"`
+	Value   []byte `json:"value,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Version uint64 `json:"version"`
+}
+
+type subscription struct {
+	cancel  context.CancelFunc
+	dropped bool
+}
+
+func (db *DB) addSubscription(cancel context.CancelFunc) (uint64, *subscription) {
+	db.subsMu.Lock()
+	defer db.subsMu.Unlock()
+	db.subsID++
+	sub := &subscription{
+		cancel: cancel,
+	}
+	db.subs[db.subsID] = sub
+	return db.subsID, sub
+}
+
+func (db *DB) removeSubscription(id uint64) {
+	db.subsMu.Lock()
+	defer db.subsMu.Unlock()
+	delete(db.subs, id)
+}
+
+func (db *DB) dropSubscriptions() {
+	db.subsMu.Lock()
+	defer db.subsMu.Unlock()
+	for id, sub := range db.subs {
+		sub.dropped = true
+		sub.cancel()
+		delete(db.subs, id)
+	}
+}
+
+// CurrentVersion returns the version of the latest committed write
+func (db *DB) CurrentVersion() uint64 {
+	s := db.acquire()
+	defer s.release()
+	txn := s.b.NewTransaction(false)
+	defer txn.Discard()
+	return txn.ReadTs()
+}
+
+// Subscribe calls startProcessor with the version from which on every committed
+// write is delivered, then changesProcessor with batches of writes in commit order,
+// until ctx is done or the database is dropped (in which case ErrDropped is returned)
+func (db *DB) Subscribe(ctx context.Context, startProcessor func(version uint64) error, changesProcessor func(changes []Change) error) error {
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	id, sub := db.addSubscription(cancel)
+	defer db.removeSubscription(id)
+	// Acquired once subscribed, so that Restore swapping the store cancels us
+	s := db.acquire()
+	defer s.release()
+
+	marker := []byte(fmt.Sprintf("%d/%d", id, time.Now().UnixNano()))
+	started := make(chan struct{})
+	subErr := make(chan error, 1)
+	go func() {
+		isStarted := false
+		subErr <- s.b.Subscribe(subCtx, func(kvs *pb.KVList) error {
+			changes := make([]Change, 0, len(kvs.Kv))
+			for _, kv := range kvs.Kv {
+				if bytes.Equal(kv.Key, subscribeMarkerKey) {
+					if !isStarted && bytes.Equal(kv.Value, marker) {
+						// Writes committed after the marker are all delivered to us
+						isStarted = true
+						close(started)
+						err := startProcessor(kv.Version)
+						if err != nil {
+							return err
+						}
+					}
+					continue
+				}
+				if !isStarted || bytes.HasPrefix(kv.Key, badgerInternalPrefix) {
+					continue
+				}
+				changes = append(changes, Change{
+					Key:     kv.Key,
+					Value:   kv.Value,
+					Deleted: len(kv.Meta) == 0 || kv.Meta[0]&metaValue == 0,
+					Version: kv.Version,
+				})
+			}
+			if len(changes) == 0 {
+				return nil
+			}
+			return changesProcessor(changes)
+		}, []byte{})
+	}()
+
+	// Keep writing the marker until the subscription picks it up
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for isStarted := false; !isStarted; {
+		err := s.b.Update(func(txn *badger.Txn) error {
+			return txn.SetEntry(valueEntry(subscribeMarkerKey, marker))
+		})
+		if err != nil {
+			cancel()
+			<-subErr
+			return err
+		}
+		select {
+		case <-started:
+			isStarted = true
+		case <-ticker.C:
+		case err := <-subErr:
+			return db.subscriptionError(sub, err)
+		}
+	}
+
+	return db.subscriptionError(sub, <-subErr)
+}
+
+func (db *DB) subscriptionError(sub *subscription, err error) error {
+	db.subsMu.Lock()
+	defer db.subsMu.Unlock()
+	if sub.dropped {
+		return ErrDropped
+	}
+	return err
+}
+
+// ApplyChanges writes a batch of changes received from another database
+func (db *DB) ApplyChanges(changes []Change) error {
+	s := db.acquire()
+	defer s.release()
+	wb := s.b.NewWriteBatch()
+	defer wb.Cancel()
+	for _, change := range changes {
+		var err error
+		if change.Deleted {
+			err = wb.Delete(change.Key)
+		} else {
+			value := change.Value
+			if value == nil {
+				value = make([]byte, 0)
+			}
+			err = wb.SetEntry(valueEntry(change.Key, value))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// Backup streams a full snapshot of the database to w
+func (db *DB) Backup(w io.Writer) error {
+	s := db.acquire()
+	defer s.release()
+	_, err := s.b.Backup(w, 0)
+	return err
+}
+
+// restoreSuffix names the folder next to the database's own that snapshots are
+// loaded into; restores alternate between the two
+const restoreSuffix = ".restore"
+
+// Restore replaces the whole content of the database with a snapshot read from
+// r, keeping the entries under ReservedPrefix the snapshot doesn't have. The
+// snapshot is loaded into a new database while the current one keeps serving,
+// and swapped in once complete; the current one is closed and cleared when the
+// operations running on it are done. Restored content isn't reopened after a
+// restart, when followers and cluster members restore their snapshot again
+func (db *DB) Restore(r io.Reader) error {
+	db.restoreMu.Lock()
+	defer db.restoreMu.Unlock()
+
+	old := db.current.Load()
+	dir := db.dir + restoreSuffix
+	if old.dir == dir {
+		dir = db.dir
+	}
+	err := clearDir(dir)
+	if err != nil {
+		return err
+	}
+	b, err := badger.Open(db.opts.WithDir(dir).WithValueDir(dir))
+	if err != nil {
+		return err
+	}
+	keys, values, err := reservedEntries(old.b)
+	if err == nil {
+		err = setMany(b, "", keys, values)
+	}
+	if err == nil {
+		err = b.Load(r, 256)
+	}
+	if err != nil {
+		b.Close()
+		clearDir(dir)
+		return err
+	}
+
+	db.current.Store(newStore(b, dir))
+	db.dropSubscriptions()
+	old.retire()
+	err = old.b.Close()
+	if err != nil {
+		return err
+	}
+	return clearDir(old.dir)
+}
+
+// clearDir removes the content of dir, which may not exist
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = os.RemoveAll(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -6,51 +6,365 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	badger "github.com/dgraph-io/badger/v2"
+	badgeroptions "github.com/dgraph-io/badger/v2/options"
 )
 
 // ErrKeyNotFound ..
 var ErrKeyNotFound = errors.New("Key not found")
 
+// metaValue marks entries written as values (as opposed to deletions) so that
+// the change feed can tell an empty ref apart from a deleted key
+const metaValue byte = 1
+
+// ReservedPrefix holds the keys sketo keeps for itself, such as the cluster
+// membership; dropping the database keeps them
+const ReservedPrefix = "_sketo/"
+
 // DB holds the database
 type DB struct {
-	b *badger.DB
+	// current is the badger database in use, swapped by Restore
+	current atomic.Pointer[store]
+	opts    badger.Options
+	dir     string
+
+	// restoreMu serializes restores
+	restoreMu sync.Mutex
+
+	proposer Proposer
+	// writeMu serializes proposed writes, so that Update can read and
+	// propose without another write of this node in between; writes of
+	// other nodes are kept out by making them all on the leader
+	writeMu sync.Mutex
+
+	subsMu sync.Mutex
+	subsID uint64
+	subs   map[uint64]*subscription
+
+	// gcMu keeps GC and flattening from running concurrently
+	gcMu             sync.Mutex
+	gcDiscardRatio   float64
+	gcRuns           int64
+	gcRewrites       int64
+	gcReclaimedBytes int64
+	flattens         int64
+	stop             chan struct{}
+	stopOnce         sync.Once
+}
+
+// Stats describes the size of the database and its value log GC activity
+type Stats struct {
+	LSMSize          int64
+	VlogSize         int64
+	GCRuns           int64
+	GCRewrites       int64
+	GCReclaimedBytes int64
+	Flattens         int64
+}
+
+// Options tune the badger database
+type Options struct {
+	// SyncWrites syncs every write to disk before acknowledging it
+	SyncWrites bool
+	// MemTableSize is the size of each memtable and of the tables flushed from
+	// them
+	MemTableSize int64
+	// ValueThreshold is the size above which values are kept in the value log
+	// instead of the LSM tree
+	ValueThreshold int
+	// Compression of the LSM tree blocks: none, snappy or zstd
+	Compression string
+	// BlockCacheSize and IndexCacheSize are 0 to not cache blocks and indexes
+	BlockCacheSize int64
+	IndexCacheSize int64
+	// GCInterval is how often value log GC runs (0 disables it)
+	GCInterval time.Duration
+	// GCDiscardRatio is the share of garbage a value log file needs to be
+	// rewritten by GC
+	GCDiscardRatio float64
+}
+
+// DefaultOptions ..
+func DefaultOptions() Options {
+	opts := badger.DefaultOptions("")
+	return Options{
+		SyncWrites:     true,
+		MemTableSize:   opts.MaxTableSize,
+		ValueThreshold: opts.ValueThreshold,
+		Compression:    "none",
+		BlockCacheSize: opts.BlockCacheSize,
+		IndexCacheSize: opts.IndexCacheSize,
+		GCInterval:     5 * time.Minute,
+		GCDiscardRatio: 0.5,
+	}
+}
+
+// Validate checks that options can be used to open a database
+func (options Options) Validate() error {
+	_, err := compression(options.Compression)
+	if err != nil {
+		return err
+	}
+	if options.MemTableSize <= 0 || options.ValueThreshold < 0 || options.BlockCacheSize < 0 || options.IndexCacheSize < 0 || options.GCInterval < 0 {
+		return fmt.Errorf("badger sizes and GC interval can't be negative")
+	}
+	if options.GCDiscardRatio <= 0 || options.GCDiscardRatio >= 1 {
+		return fmt.Errorf("invalid GC discard ratio %v (expected between 0 and 1)", options.GCDiscardRatio)
+	}
+	return nil
+}
+
+func compression(name string) (badgeroptions.CompressionType, error) {
+	switch name {
+	case "", "none":
+		return badgeroptions.None, nil
+	case "snappy":
+		return badgeroptions.Snappy, nil
+	case "zstd":
+		return badgeroptions.ZSTD, nil
+	}
+	return badgeroptions.None, fmt.Errorf("invalid compression '%s' (expected none, snappy or zstd)", name)
 }
 
 // NewDB creates or loads a database at folder dataDir
 func NewDB(dataDir string) (*DB, error) {
+	return NewDBWithOptions(dataDir, DefaultOptions())
+}
+
+// NewDBWithOptions creates or loads a database at folder dataDir tuned by
+// options
+func NewDBWithOptions(dataDir string, options Options) (*DB, error) {
+	err := options.Validate()
+	if err != nil {
+		return nil, err
+	}
 	opts := badger.DefaultOptions(dataDir)
 	opts.NumVersionsToKeep = 0
-	db, err := badger.Open(opts)
+	opts.SyncWrites = options.SyncWrites
+	opts.MaxTableSize = options.MemTableSize
+	opts.ValueThreshold = options.ValueThreshold
+	opts.Compression, _ = compression(options.Compression)
+	opts.BlockCacheSize = options.BlockCacheSize
+	opts.IndexCacheSize = options.IndexCacheSize
+	b, err := badger.Open(opts)
 	if err != nil {
 		return nil, err
 	}
-	go func() {
-		ticker := time.NewTicker(5 * time.Minute)
-		defer ticker.Stop()
-		for range ticker.C {
-		again:
-			err := db.RunValueLogGC(0.5)
-			if err == nil {
-				goto again
+	d := &DB{
+		opts:           opts,
+		dir:            dataDir,
+		subs:           make(map[uint64]*subscription),
+		gcDiscardRatio: options.GCDiscardRatio,
+		stop:           make(chan struct{}),
+	}
+	d.current.Store(newStore(b, dataDir))
+	if options.GCInterval > 0 {
+		go d.runGC(options.GCInterval)
+	}
+	return d, nil
+}
+
+// store is a badger database and its folder. Operations acquire the store
+// they run on, so that Restore can swap in another one and close this one once
+// they're done
+type store struct {
+	b   *badger.DB
+	dir string
+
+	mu         sync.Mutex
+	users      int
+	retired    bool
+	idle       chan struct{}
+	idleClosed bool
+}
+
+func newStore(b *badger.DB, dir string) *store {
+	return &store{
+		b:    b,
+		dir:  dir,
+		idle: make(chan struct{}),
+	}
+}
+
+// acquire returns the store in use, which stays open until released. Once
+// the database is closed, operations get the closed store and badger's error
+func (db *DB) acquire() *store {
+	for {
+		s := db.current.Load()
+		s.mu.Lock()
+		if !s.retired || db.current.Load() == s {
+			s.users++
+			s.mu.Unlock()
+			return s
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *store) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users--
+	s.signalIdle()
+}
+
+// retire waits until the operations running on s are done, once it's no
+// longer in use
+func (s *store) retire() {
+	s.mu.Lock()
+	s.retired = true
+	s.signalIdle()
+	s.mu.Unlock()
+	<-s.idle
+}
+
+func (s *store) signalIdle() {
+	if s.retired && s.users == 0 && !s.idleClosed {
+		s.idleClosed = true
+		close(s.idle)
+	}
+}
+
+// runGC runs value log GC every interval until the database is closed
+func (db *DB) runGC(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-ticker.C:
+			_, err := db.RunGC(db.gcDiscardRatio)
+			if err != nil {
+				log.Printf("Value log GC failed: %v\n", err)
 			}
 		}
-	}()
-	return &DB{
-		b: db,
-	}, nil
+	}
+}
+
+// GCResult describes a value log GC round
+type GCResult struct {
+	Rewrites       int64 `json:"rewrites"`
+	ReclaimedBytes int64 `json:"reclaimedBytes"`
+}
+
+// RunGC rewrites the value log files having at least discardRatio garbage
+// until none is left
+func (db *DB) RunGC(discardRatio float64) (GCResult, error) {
+	db.gcMu.Lock()
+	defer db.gcMu.Unlock()
+
+	s := db.acquire()
+	defer s.release()
+	result := GCResult{}
+	before := s.vlogBytes()
+	var err error
+	for {
+		err = s.b.RunValueLogGC(discardRatio)
+		if err != nil {
+			break
+		}
+		result.Rewrites++
+	}
+	if err == badger.ErrNoRewrite {
+		err = nil
+	}
+	result.ReclaimedBytes = before - s.vlogBytes()
+	if result.ReclaimedBytes < 0 {
+		result.ReclaimedBytes = 0
+	}
+	atomic.AddInt64(&db.gcRuns, 1)
+	atomic.AddInt64(&db.gcRewrites, result.Rewrites)
+	atomic.AddInt64(&db.gcReclaimedBytes, result.ReclaimedBytes)
+	return result, err
+}
+
+// GCDiscardRatio returns the discard ratio of the periodic value log GC
+func (db *DB) GCDiscardRatio() float64 {
+	return db.gcDiscardRatio
+}
+
+// vlogBytes sums the sizes of the value log files on disk, which unlike
+// badger's own size estimate is up to date right after GC
+func (s *store) vlogBytes() int64 {
+	files, _ := filepath.Glob(filepath.Join(s.dir, "*.vlog"))
+	size := int64(0)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// Flatten compacts the LSM tree into a single level using workers concurrent
+// compactions
+func (db *DB) Flatten(workers int) error {
+	db.gcMu.Lock()
+	defer db.gcMu.Unlock()
+
+	s := db.acquire()
+	defer s.release()
+	err := s.b.Flatten(workers)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&db.flattens, 1)
+	return nil
+}
+
+// Stats returns the current size and GC counts of the database
+func (db *DB) Stats() Stats {
+	s := db.acquire()
+	defer s.release()
+	lsm, vlog := s.b.Size()
+	return Stats{
+		LSMSize:          lsm,
+		VlogSize:         vlog,
+		GCRuns:           atomic.LoadInt64(&db.gcRuns),
+		GCRewrites:       atomic.LoadInt64(&db.gcRewrites),
+		GCReclaimedBytes: atomic.LoadInt64(&db.gcReclaimedBytes),
+		Flattens:         atomic.LoadInt64(&db.flattens),
+	}
+}
+
+func valueEntry(key []byte, value []byte) *badger.Entry {
+	return badger.NewEntry(key, value).WithMeta(metaValue)
+}
+
+// Close ..
+func (db *DB) Close() error {
+	db.stopOnce.Do(func() {
+		close(db.stop)
+	})
+	db.gcMu.Lock()
+	defer db.gcMu.Unlock()
+	s := db.current.Load()
+	s.mu.Lock()
+	s.retired = true
+	s.mu.Unlock()
+	return s.b.Close()
 }
 
 // DelEverything ..
 func (db *DB) DelEverything() error {
-	return db.b.DropAll()
+	return db.write(&Op{
+		Type: OpDrop,
+	})
 }
 
 // Get ..
 func (db *DB) Get(prefix string, key string, valueProcessor func(value []byte) error) error {
-	return db.b.View(func(txn *badger.Txn) error {
+	s := db.acquire()
+	defer s.release()
+	return s.b.View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte(prefix + key))
 		if err != nil {
 			if err == badger.ErrKeyNotFound {
@@ -65,43 +379,116 @@ func (db *DB) Get(prefix string, key string, valueProcessor func(value []byte) e
 
 // Set ..
 func (db *DB) Set(prefix string, key string, value interface{}) error {
-	return db.b.Update(func(txn *badger.Txn) error {
-		encoded, err := json.Marshal(value)
-		if err != nil {
-			return err
-		}
-		err = txn.Set([]byte(prefix+key), encoded)
-		if err != nil {
-			return err
-		}
-		return nil
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return db.write(&Op{
+		Type:   OpSet,
+		Prefix: prefix,
+		Keys:   []string{key},
+		Values: [][]byte{encoded},
 	})
 }
 
 // SetMany ..
 func (db *DB) SetMany(prefix string, keys []string, values []interface{}) error {
-	wb := db.b.NewWriteBatch()
-	defer wb.Cancel()
-	for i, key := range keys {
+	encodedValues := make([][]byte, len(keys))
+	for i := range keys {
 		encoded, err := json.Marshal(values[i])
 		if err != nil {
 			return err
 		}
-		err = wb.Set([]byte(prefix+key), encoded) // Will create txns as needed.
-		if err != nil {
-			return err
-		}
+		encodedValues[i] = encoded
 	}
-	return wb.Flush() // Wait for all txns to finish.
+	return db.write(&Op{
+		Type:   OpSet,
+		Prefix: prefix,
+		Keys:   keys,
+		Values: encodedValues,
+	})
 }
 
 // RefMany ..
 func (db *DB) RefMany(prefix string, keys []string) error {
+	return db.write(&Op{
+		Type:   OpSet,
+		Prefix: prefix,
+		Keys:   keys,
+	})
+}
+
+// Del ..
+func (db *DB) Del(prefix string, key string) error {
+	return db.write(&Op{
+		Type:   OpDel,
+		Prefix: prefix,
+		Keys:   []string{key},
+	})
+}
+
+// DelByPrefix ..
+func (db *DB) DelByPrefix(prefix string) error {
+	return db.write(&Op{
+		Type:   OpDelPrefix,
+		Prefix: prefix,
+	})
+}
+
+// DelManyRefs ..
+func (db *DB) DelManyRefs(prefix string, keys []string) error {
+	return db.write(&Op{
+		Type:   OpDel,
+		Prefix: prefix,
+		Keys:   keys,
+	})
+}
+
+func (db *DB) dropAll(b *badger.DB) error {
+	keys, values, err := reservedEntries(b)
+	if err != nil {
+		return err
+	}
+	err = b.DropAll()
+	db.dropSubscriptions()
+	if err != nil {
+		return err
+	}
+	return setMany(b, "", keys, values)
+}
+
+// reservedEntries reads the entries under ReservedPrefix
+func reservedEntries(b *badger.DB) ([]string, [][]byte, error) {
+	var keys []string
+	var values [][]byte
+	err := b.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(ReservedPrefix)
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+		for iter.Seek(opts.Prefix); iter.ValidForPrefix(opts.Prefix); iter.Next() {
+			value, err := iter.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, string(iter.Item().Key()))
+			values = append(values, value)
+		}
+		return nil
+	})
+	return keys, values, err
+}
+
+func setMany(b *badger.DB, prefix string, keys []string, values [][]byte) error {
 	nothing := make([]byte, 0)
-	wb := db.b.NewWriteBatch()
+	wb := b.NewWriteBatch()
 	defer wb.Cancel()
-	for _, key := range keys {
-		err := wb.Set([]byte(prefix+key), nothing) // Will create txns as needed.
+	for i, key := range keys {
+		value := nothing
+		if values != nil && values[i] != nil {
+			value = values[i]
+		}
+		err := wb.SetEntry(valueEntry([]byte(prefix+key), value)) // Will create txns as needed.
 		if err != nil {
 			return err
 		}
@@ -109,29 +496,29 @@ func (db *DB) RefMany(prefix string, keys []string) error {
 	return wb.Flush() // Wait for all txns to finish.
 }
 
-// Del ..
-func (db *DB) Del(prefix string, key string) error {
-	return db.b.Update(func(txn *badger.Txn) error {
-		err := txn.Delete([]byte(prefix + key))
-		if err != nil {
-			if err == badger.ErrKeyNotFound {
-				return nil
+func delMany(b *badger.DB, prefix string, keys []string) error {
+	return b.Update(func(txn *badger.Txn) error {
+		for _, key := range keys {
+			err := txn.Delete([]byte(prefix + key)) // might have to commit manually here
+			if err != nil {
+				if err == badger.ErrKeyNotFound {
+					return nil
+				}
+				return err
 			}
-			return err
 		}
 		return nil
 	})
 }
 
-// DelByPrefix ..
-func (db *DB) DelByPrefix(prefix string) error {
+func delByPrefix(b *badger.DB, prefix string) error {
 
-	return db.b.View(func(txn *badger.Txn) error {
+	return b.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.Prefix = []byte(prefix)
 		iter := txn.NewIterator(opts)
 		defer iter.Close()
-		wb := db.b.NewWriteBatch()
+		wb := b.NewWriteBatch()
 		i := 0
 		for iter.Seek(opts.Prefix); iter.ValidForPrefix(opts.Prefix); iter.Next() {
 			i++
@@ -141,7 +528,7 @@ func (db *DB) DelByPrefix(prefix string) error {
 				if err != nil {
 					return err
 				}
-				wb = db.b.NewWriteBatch()
+				wb = b.NewWriteBatch()
 			}
 			err := wb.Delete(iter.Item().Key())
 			if err != nil {
@@ -154,37 +541,19 @@ func (db *DB) DelByPrefix(prefix string) error {
 
 }
 
-// DelManyRefs ..
-func (db *DB) DelManyRefs(prefix string, keys []string) error {
-	return db.b.Update(func(txn *badger.Txn) error {
-		for _, key := range keys {
-			err := txn.Delete([]byte(prefix + key)) // might have to commit manually here
-			if err != nil {
-				if err == badger.ErrKeyNotFound {
-					return nil
-				}
-				return err
-			}
-		}
-		return nil
-	})
-}
-
 // List ..
 func (db *DB) List(prefix string, filter string, offset int64, limit int64, valuesProcessor func(keys []string, values [][]byte) error) error {
-	return db.b.View(func(txn *badger.Txn) error {
+	s := db.acquire()
+	defer s.release()
+	return s.b.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		prefixBytes := []byte(prefix + filter)
 		opts.Prefix = prefixBytes
 		iter := txn.NewIterator(opts)
 		defer iter.Close()
-		maxOffset := int64(10000)
-		if offset > maxOffset {
-			return errors.New("offset too large (max value is 10000)")
-		}
-		maxLimit := int64(100)
-		if limit == -1 || limit > maxLimit {
-			limit = maxLimit
+		limit, err := listLimit(offset, limit)
+		if err != nil {
+			return err
 		}
 		pos := int64(0)
 		foundKeys := make([]string, 0, limit)
@@ -216,7 +585,9 @@ func (db *DB) List(prefix string, filter string, offset int64, limit int64, valu
 
 // Enumerate ..
 func (db *DB) Enumerate(prefix string, enumProcessor func(key string, value []byte) (bool, error)) error {
-	return db.b.View(func(txn *badger.Txn) error {
+	s := db.acquire()
+	defer s.release()
+	return s.b.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		prefixBytes := []byte(prefix)
 		opts.Prefix = prefixBytes
@@ -242,7 +613,9 @@ func (db *DB) Enumerate(prefix string, enumProcessor func(key string, value []by
 
 // Count ..
 func (db *DB) Count(prefix string, filter string, countProcessor func(cnt int64) error) error {
-	return db.b.View(func(txn *badger.Txn) error {
+	s := db.acquire()
+	defer s.release()
+	return s.b.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		prefixBytes := []byte(prefix + filter)
 		opts.Prefix = prefixBytes
@@ -261,7 +634,9 @@ func (db *DB) Fix() error {
 	i := 0
 	keys := make([][]byte, 10000000)
 	vals := make([][]byte, 10000000)
-	err := db.b.View(func(txn *badger.Txn) error {
+	s := db.acquire()
+	defer s.release()
+	err := s.b.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
 		opts.Prefix = []byte("exact/po/i/")
 		iter := txn.NewIterator(opts)
@@ -289,9 +664,9 @@ func (db *DB) Fix() error {
 
 	log.Printf("Done selecting\n")
 
-	wb := db.b.NewWriteBatch()
+	wb := s.b.NewWriteBatch()
 	for k := 0; k < i; k++ {
-		err := wb.Set(keys[k], vals[k])
+		err := wb.SetEntry(valueEntry(keys[k], vals[k]))
 		if err != nil {
 			wb.Cancel()
 			return err
@@ -303,7 +678,7 @@ func (db *DB) Fix() error {
 				return err
 			}
 			log.Printf("Saved: %d\n", k)
-			wb = db.b.NewWriteBatch()
+			wb = s.b.NewWriteBatch()
 		}
 	}
 	err = wb.Flush()
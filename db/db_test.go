@@ -0,0 +1,207 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+func TestBadgerOptionsAndMaintenance(t *testing.T) {
+
+	options := DefaultOptions()
+	options.Compression = "lz4"
+	_, err := NewDBWithOptions(t.TempDir(), options)
+	if err == nil {
+		t.Error(fmt.Errorf("an invalid compression was accepted"))
+	}
+
+	options = DefaultOptions()
+	options.MemTableSize = 1 << 20
+	options.ValueThreshold = 16
+	options.Compression = "snappy"
+	options.GCInterval = 10 * time.Millisecond
+	acpDB, err := NewDBWithOptions(t.TempDir(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("p%d", i)
+		err = acpDB.Set("exact/po/", "i/"+id+"/", map[string]string{"id": id, "description": "a value larger than the threshold"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := acpDB.RunGC(0.5)
+	if err != nil {
+		t.Error(err)
+	}
+	if result.ReclaimedBytes < 0 {
+		t.Error(fmt.Errorf("reclaimed %d bytes", result.ReclaimedBytes))
+	}
+	err = acpDB.Flatten(1)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Let the periodic GC run too
+	time.Sleep(50 * time.Millisecond)
+	stats := acpDB.Stats()
+	if stats.GCRuns < 2 || stats.Flattens != 1 {
+		t.Error(fmt.Errorf("unexpected stats %+v", stats))
+	}
+
+	err = acpDB.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+}
+
+// applyingProposer applies proposed writes right away, like a single node
+type applyingProposer struct {
+	acpDB *DB
+}
+
+func (p applyingProposer) Propose(op *Op) error {
+	return p.acpDB.ApplyOp(op)
+}
+
+func TestDBUpdateIsIsolated(t *testing.T) {
+
+	for _, proposed := range []bool{false, true} {
+		acpDB, err := NewDB(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proposed {
+			acpDB.SetProposer(applyingProposer{acpDB})
+		}
+		var wg sync.WaitGroup
+		var committed int64
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					err := acpDB.Update(func(txn Txn) error {
+						var n int64
+						err := txn.Get("counter/", "n", func(value []byte) error {
+							return json.Unmarshal(value, &n)
+						})
+						if err != nil && err != ErrKeyNotFound {
+							return err
+						}
+						return txn.Set("counter/", "n", n+1)
+					})
+					if err == nil {
+						atomic.AddInt64(&committed, 1)
+					} else if err != badger.ErrConflict {
+						t.Error(err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		var n int64
+		err = acpDB.Get("counter/", "n", func(value []byte) error {
+			return json.Unmarshal(value, &n)
+		})
+		if err != nil || n != committed || (proposed && n != 80) {
+			t.Error(fmt.Errorf("counter is %d after %d committed increments (proposed: %v, %v)", n, committed, proposed, err))
+		}
+		acpDB.Close()
+	}
+
+}
+
+func TestRestoreSwapsInSnapshots(t *testing.T) {
+
+	source, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	acpDB, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acpDB.Close()
+	for i := 0; i < 100; i++ {
+		err = source.Set("exact/po/", fmt.Sprintf("i/new%d/", i), i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = acpDB.Set("exact/po/", "i/old/", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = acpDB.Set(ReservedPrefix, "member", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot bytes.Buffer
+	err = source.Backup(&snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed restore keeps the database as it was
+	err = acpDB.Restore(bytes.NewReader(snapshot.Bytes()[:snapshot.Len()/2]))
+	if err == nil {
+		t.Error(fmt.Errorf("restoring a truncated snapshot succeeded"))
+	}
+	err = acpDB.Get("exact/po/", "i/old/", func(value []byte) error { return nil })
+	if err != nil {
+		t.Error(fmt.Errorf("a failed restore lost data: %v", err))
+	}
+
+	// Reads see either the old or the new content while a restore runs
+	done := make(chan struct{})
+	var reads sync.WaitGroup
+	reads.Add(1)
+	go func() {
+		defer reads.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			var cnt int64
+			err := acpDB.Count("exact/po/", "i/", func(c int64) error {
+				cnt = c
+				return nil
+			})
+			if err != nil || (cnt != 1 && cnt != 100) {
+				t.Error(fmt.Errorf("read %d policies during a restore (%v)", cnt, err))
+				return
+			}
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		err = acpDB.Restore(bytes.NewReader(snapshot.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	reads.Wait()
+
+	err = acpDB.Get(ReservedPrefix, "member", func(value []byte) error { return nil })
+	if err != nil {
+		t.Error(fmt.Errorf("restoring lost reserved keys: %v", err))
+	}
+	err = acpDB.Get("exact/po/", "i/old/", func(value []byte) error { return nil })
+	if err != ErrKeyNotFound {
+		t.Error(fmt.Errorf("restoring kept keys the snapshot doesn't have: %v", err))
+	}
+
+}
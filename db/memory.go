@@ -0,0 +1,324 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemStore is an in-memory Store keeping its keys ordered, meant for tests and
+// ephemeral environments
+type MemStore struct {
+	mu     sync.RWMutex
+	keys   []string
+	values map[string][]byte
+}
+
+// NewMemStore creates an empty in-memory store
+func NewMemStore() *MemStore {
+	return &MemStore{
+		values: make(map[string][]byte),
+	}
+}
+
+// set must be called with mu held for writing
+func (m *MemStore) set(key string, value []byte) {
+	if _, ok := m.values[key]; !ok {
+		pos := sort.SearchStrings(m.keys, key)
+		m.keys = append(m.keys, "")
+		copy(m.keys[pos+1:], m.keys[pos:])
+		m.keys[pos] = key
+	}
+	m.values[key] = value
+}
+
+// del must be called with mu held for writing
+func (m *MemStore) del(key string) {
+	if _, ok := m.values[key]; !ok {
+		return
+	}
+	pos := sort.SearchStrings(m.keys, key)
+	m.keys = append(m.keys[:pos], m.keys[pos+1:]...)
+	delete(m.values, key)
+}
+
+// keysWithPrefix returns the keys among sorted keys starting with prefix;
+// the result must not be modified
+func keysWithPrefix(keys []string, prefix string) []string {
+	start := sort.SearchStrings(keys, prefix)
+	end := start
+	for end < len(keys) && strings.HasPrefix(keys[end], prefix) {
+		end++
+	}
+	return keys[start:end]
+}
+
+// Close ..
+func (m *MemStore) Close() error {
+	return nil
+}
+
+// Get ..
+func (m *MemStore) Get(prefix string, key string, valueProcessor func(value []byte) error) error {
+	m.mu.RLock()
+	value, ok := m.values[prefix+key]
+	m.mu.RUnlock()
+	if !ok {
+		return ErrKeyNotFound
+	}
+	return valueProcessor(value)
+}
+
+// Set ..
+func (m *MemStore) Set(prefix string, key string, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(prefix+key, encoded)
+	return nil
+}
+
+// SetMany ..
+func (m *MemStore) SetMany(prefix string, keys []string, values []interface{}) error {
+	encodedValues := make([][]byte, len(keys))
+	for i := range keys {
+		encoded, err := json.Marshal(values[i])
+		if err != nil {
+			return err
+		}
+		encodedValues[i] = encoded
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, key := range keys {
+		m.set(prefix+key, encodedValues[i])
+	}
+	return nil
+}
+
+// RefMany ..
+func (m *MemStore) RefMany(prefix string, keys []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		m.set(prefix+key, make([]byte, 0))
+	}
+	return nil
+}
+
+// Del ..
+func (m *MemStore) Del(prefix string, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.del(prefix + key)
+	return nil
+}
+
+// DelByPrefix ..
+func (m *MemStore) DelByPrefix(prefix string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := append([]string(nil), keysWithPrefix(m.keys, prefix)...)
+	for _, key := range keys {
+		m.del(key)
+	}
+	return nil
+}
+
+// DelManyRefs ..
+func (m *MemStore) DelManyRefs(prefix string, keys []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		m.del(prefix + key)
+	}
+	return nil
+}
+
+// DelEverything ..
+func (m *MemStore) DelEverything() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = nil
+	m.values = make(map[string][]byte)
+	return nil
+}
+
+// List ..
+func (m *MemStore) List(prefix string, filter string, offset int64, limit int64, valuesProcessor func(keys []string, values [][]byte) error) error {
+	limit, err := listLimit(offset, limit)
+	if err != nil {
+		return err
+	}
+	prefixLen := len(prefix + filter)
+
+	m.mu.RLock()
+	found := keysWithPrefix(m.keys, prefix+filter)
+	if offset > int64(len(found)) {
+		offset = int64(len(found))
+	}
+	found = found[offset:]
+	if int64(len(found)) > limit {
+		found = found[:limit]
+	}
+	foundKeys := make([]string, 0, len(found))
+	foundValues := make([][]byte, 0, len(found))
+	for _, key := range found {
+		foundKey := key[prefixLen:]
+		value, ok := m.values[prefix+foundKey]
+		if !ok {
+			m.mu.RUnlock()
+			return fmt.Errorf("can get key '%s': %w", foundKey, ErrKeyNotFound)
+		}
+		foundKeys = append(foundKeys, foundKey)
+		foundValues = append(foundValues, value)
+	}
+	m.mu.RUnlock()
+
+	return valuesProcessor(foundKeys, foundValues)
+}
+
+// Enumerate ..
+func (m *MemStore) Enumerate(prefix string, enumProcessor func(key string, value []byte) (bool, error)) error {
+	m.mu.RLock()
+	keys := append([]string(nil), keysWithPrefix(m.keys, prefix)...)
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = m.values[key]
+	}
+	m.mu.RUnlock()
+
+	for i, key := range keys {
+		cont, err := enumProcessor(key, values[i])
+		if err != nil {
+			return err
+		}
+		if !cont {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Count ..
+func (m *MemStore) Count(prefix string, filter string, countProcessor func(cnt int64) error) error {
+	m.mu.RLock()
+	cnt := int64(len(keysWithPrefix(m.keys, prefix+filter)))
+	m.mu.RUnlock()
+	return countProcessor(cnt)
+}
+
+// memTxn reads a snapshot of the store taken by View, or the store itself
+// while Update holds its lock, and buffers writes until Update commits them
+type memTxn struct {
+	keys    []string
+	values  map[string][]byte
+	pending map[string]pendingWrite
+	order   []string
+}
+
+func (t *memTxn) Get(prefix string, key string, valueProcessor func(value []byte) error) error {
+	if pending, ok := t.pending[prefix+key]; ok {
+		if pending.deleted {
+			return ErrKeyNotFound
+		}
+		return valueProcessor(pending.value)
+	}
+	value, ok := t.values[prefix+key]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	return valueProcessor(value)
+}
+
+func (t *memTxn) write(key string, pending pendingWrite) {
+	if _, ok := t.pending[key]; !ok {
+		t.order = append(t.order, key)
+	}
+	t.pending[key] = pending
+}
+
+func (t *memTxn) Set(prefix string, key string, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	t.write(prefix+key, pendingWrite{
+		value: encoded,
+	})
+	return nil
+}
+
+func (t *memTxn) Ref(prefix string, key string) error {
+	t.write(prefix+key, pendingWrite{
+		value: make([]byte, 0),
+	})
+	return nil
+}
+
+func (t *memTxn) Del(prefix string, key string) error {
+	t.write(prefix+key, pendingWrite{
+		deleted: true,
+	})
+	return nil
+}
+
+func (t *memTxn) Enumerate(prefix string, enumProcessor func(key string, value []byte) (bool, error)) error {
+	for _, key := range keysWithPrefix(t.keys, prefix) {
+		cont, err := enumProcessor(key, t.values[key])
+		if err != nil {
+			return err
+		}
+		if !cont {
+			return nil
+		}
+	}
+	return nil
+}
+
+// View runs fn on a snapshot of the store, copied holding its read lock so
+// writes don't wait for fn
+func (m *MemStore) View(fn func(txn Txn) error) error {
+	m.mu.RLock()
+	keys := append([]string(nil), m.keys...)
+	values := make(map[string][]byte, len(m.values))
+	for key, value := range m.values {
+		values[key] = value
+	}
+	m.mu.RUnlock()
+
+	return fn(&memTxn{
+		keys:    keys,
+		values:  values,
+		pending: make(map[string]pendingWrite),
+	})
+}
+
+// Update runs fn holding the store's write lock; fn must not call the store itself
+func (m *MemStore) Update(fn func(txn Txn) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := &memTxn{
+		keys:    m.keys,
+		values:  m.values,
+		pending: make(map[string]pendingWrite),
+	}
+	err := fn(t)
+	if err != nil {
+		return err
+	}
+	for _, key := range t.order {
+		pending := t.pending[key]
+		if pending.deleted {
+			m.del(key)
+		} else {
+			m.set(key, pending.value)
+		}
+	}
+	return nil
+}
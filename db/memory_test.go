@@ -0,0 +1,155 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMemStoreListAndCount(t *testing.T) {
+
+	m := NewMemStore()
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("p%d", i)
+		err := m.Set("exact/po/", "i/"+id+"/", map[string]string{"id": id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.RefMany("exact/po/", []string{"s/alice/r//a//i/" + id + "/", "s//r//a//i/" + id + "/"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := m.List("exact/po/", "s/alice/r//a//", 1, 2, func(keys []string, values [][]byte) error {
+		if len(keys) != 2 || keys[0] != "i/p1/" || keys[1] != "i/p2/" {
+			t.Error(fmt.Errorf("unexpected keys %v", keys))
+		}
+		if string(values[0]) != `{"id":"p1"}` {
+			t.Error(fmt.Errorf("unexpected value %s", values[0]))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = m.Count("exact/po/", "s//r//a//", func(cnt int64) error {
+		if cnt != 5 {
+			t.Error(fmt.Errorf("counted %d instead of 5", cnt))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = m.DelByPrefix("exact/po/s/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enumerated := 0
+	err = m.Enumerate("exact/po/", func(key string, value []byte) (bool, error) {
+		enumerated++
+		return true, nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if enumerated != 5 {
+		t.Error(fmt.Errorf("enumerated %d keys instead of the 5 documents", enumerated))
+	}
+
+}
+
+func TestMemStoreUpdateIsAtomic(t *testing.T) {
+
+	m := NewMemStore()
+	err := m.Set("exact/ro/", "i/r1/", "before")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Update(func(txn Txn) error {
+		err := txn.Set("exact/ro/", "i/r1/", "after")
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("abort")
+	})
+	if err == nil {
+		t.Error(fmt.Errorf("aborted transaction didn't report its error"))
+	}
+	err = m.Get("exact/ro/", "i/r1/", func(value []byte) error {
+		if string(value) != `"before"` {
+			t.Error(fmt.Errorf("aborted transaction was applied: %s", value))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = m.Update(func(txn Txn) error {
+		err := txn.Del("exact/ro/", "i/r1/")
+		if err != nil {
+			return err
+		}
+		if txn.Get("exact/ro/", "i/r1/", func(value []byte) error { return nil }) != ErrKeyNotFound {
+			t.Error(fmt.Errorf("transaction doesn't see its own deletion"))
+		}
+		return txn.Ref("exact/ro/", "m/bob/i/r1/")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Get("exact/ro/", "i/r1/", func(value []byte) error { return nil }) != ErrKeyNotFound {
+		t.Error(fmt.Errorf("committed deletion not applied"))
+	}
+	if m.Get("exact/ro/", "m/bob/i/r1/", func(value []byte) error { return nil }) != nil {
+		t.Error(fmt.Errorf("committed ref not applied"))
+	}
+
+}
+
+func TestMemStoreViewReadsASnapshot(t *testing.T) {
+
+	m := NewMemStore()
+	err := m.Set("exact/ro/", "i/r1/", "before")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes made while a view runs don't wait for it, nor show in it
+	err = m.View(func(txn Txn) error {
+		err := m.Set("exact/ro/", "i/r1/", "after")
+		if err != nil {
+			return err
+		}
+		err = m.Set("exact/ro/", "i/r2/", "added")
+		if err != nil {
+			return err
+		}
+		err = txn.Get("exact/ro/", "i/r1/", func(value []byte) error {
+			if string(value) != `"before"` {
+				t.Error(fmt.Errorf("view read %s instead of its snapshot", value))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		cnt := 0
+		err = txn.Enumerate("exact/ro/", func(key string, value []byte) (bool, error) {
+			cnt++
+			return true, nil
+		})
+		if cnt != 1 {
+			t.Error(fmt.Errorf("view enumerated %d keys instead of 1", cnt))
+		}
+		return err
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// Operation types
+const (
+	OpSet       = "set"
+	OpDel       = "del"
+	OpDelPrefix = "delprefix"
+	OpDrop      = "drop"
+	OpBatch     = "batch"
+)
+
+// Op is a write operation on the database; Values is nil for refs, and Ops
+// holds the set and del operations of a batch applied atomically
+type Op struct {
+	Type   string   `json:"type"`
+	Prefix string   `json:"prefix,omitempty"`
+	Keys   []string `json:"keys,omitempty"`
+	Values [][]byte `json:"values,omitempty"`
+	Ops    []*Op    `json:"ops,omitempty"`
+}
+
+// Proposer sends write operations through a consensus log instead of applying
+// them directly; every node then applies them with ApplyOp
+type Proposer interface {
+	Propose(op *Op) error
+}
+
+// SetProposer routes all subsequent writes through p
+func (db *DB) SetProposer(p Proposer) {
+	db.proposer = p
+}
+
+func (db *DB) write(op *Op) error {
+	if db.proposer != nil {
+		db.writeMu.Lock()
+		defer db.writeMu.Unlock()
+		return db.proposer.Propose(op)
+	}
+	return db.ApplyOp(op)
+}
+
+// ApplyOp applies a write operation to the local database only
+func (db *DB) ApplyOp(op *Op) error {
+	s := db.acquire()
+	defer s.release()
+	switch op.Type {
+	case OpSet:
+		if op.Values != nil && len(op.Values) != len(op.Keys) {
+			return fmt.Errorf("operation has %d keys but %d values", len(op.Keys), len(op.Values))
+		}
+		return setMany(s.b, op.Prefix, op.Keys, op.Values)
+	case OpDel:
+		return delMany(s.b, op.Prefix, op.Keys)
+	case OpDelPrefix:
+		return delByPrefix(s.b, op.Prefix)
+	case OpDrop:
+		return db.dropAll(s.b)
+	case OpBatch:
+		return applyBatch(s.b, op.Ops)
+	}
+	return fmt.Errorf("unknown operation type '%s'", op.Type)
+}
+
+// applyBatch applies set and del operations in a single badger transaction
+func applyBatch(b *badger.DB, ops []*Op) error {
+	return b.Update(func(txn *badger.Txn) error {
+		return applyOps(txn, ops)
+	})
+}
+
+func applyOps(txn *badger.Txn, ops []*Op) error {
+	for _, op := range ops {
+		if op.Values != nil && len(op.Values) != len(op.Keys) {
+			return fmt.Errorf("operation has %d keys but %d values", len(op.Keys), len(op.Values))
+		}
+		for i, key := range op.Keys {
+			var err error
+			switch op.Type {
+			case OpSet:
+				value := make([]byte, 0)
+				if op.Values != nil && op.Values[i] != nil {
+					value = op.Values[i]
+				}
+				err = txn.SetEntry(valueEntry([]byte(op.Prefix+key), value))
+			case OpDel:
+				err = txn.Delete([]byte(op.Prefix + key))
+			default:
+				err = fmt.Errorf("operation type '%s' not allowed in a batch", op.Type)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
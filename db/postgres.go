@@ -0,0 +1,736 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// PostgresStore is a Store keeping policies and roles in the
+// ory_access_control_policy* tables used by Keto v0.4, so sketo can share a
+// database with a running Keto. Index refs aren't stored: exact lookups go
+// through ListFiltered, which joins the subject, resource, action and member
+// tables.
+//
+// Multi-valued fields (subjects, resources, actions, members) are returned
+// sorted, since the tables don't record their original order.
+type PostgresStore struct {
+	sql *sql.DB
+}
+
+// postgresSchema creates the Keto v0.4 tables when they don't exist yet
+var postgresSchema = []string{
+	`CREATE TABLE IF NOT EXISTS ory_access_control_policy (
+		flavor      varchar(16)  NOT NULL,
+		id          varchar(255) NOT NULL,
+		description text         NOT NULL DEFAULT '',
+		effect      varchar(32)  NOT NULL,
+		conditions  text         NOT NULL DEFAULT 'null',
+		PRIMARY KEY (flavor, id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS ory_access_control_policy_subject (
+		flavor  varchar(16)  NOT NULL,
+		policy  varchar(255) NOT NULL,
+		subject text         NOT NULL,
+		PRIMARY KEY (flavor, policy, subject),
+		FOREIGN KEY (flavor, policy) REFERENCES ory_access_control_policy (flavor, id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS ory_access_control_policy_resource (
+		flavor   varchar(16)  NOT NULL,
+		policy   varchar(255) NOT NULL,
+		resource text         NOT NULL,
+		PRIMARY KEY (flavor, policy, resource),
+		FOREIGN KEY (flavor, policy) REFERENCES ory_access_control_policy (flavor, id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS ory_access_control_policy_action (
+		flavor varchar(16)  NOT NULL,
+		policy varchar(255) NOT NULL,
+		action text         NOT NULL,
+		PRIMARY KEY (flavor, policy, action),
+		FOREIGN KEY (flavor, policy) REFERENCES ory_access_control_policy (flavor, id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS ory_access_control_policy_role (
+		flavor      varchar(16)  NOT NULL,
+		id          varchar(255) NOT NULL,
+		description text         NOT NULL DEFAULT '',
+		PRIMARY KEY (flavor, id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS ory_access_control_policy_role_member (
+		flavor varchar(16)  NOT NULL,
+		role   varchar(255) NOT NULL,
+		member text         NOT NULL,
+		PRIMARY KEY (flavor, role, member),
+		FOREIGN KEY (flavor, role) REFERENCES ory_access_control_policy_role (flavor, id)
+	)`,
+}
+
+// sqlTable describes a document table and the tables holding its multi-valued fields
+type sqlTable struct {
+	name     string
+	children []sqlChildTable
+}
+
+type sqlChildTable struct {
+	name   string
+	parent string // column referencing the document ID
+	value  string
+}
+
+var (
+	sqlPolicyTable = sqlTable{
+		name: "ory_access_control_policy",
+		children: []sqlChildTable{
+			{name: "ory_access_control_policy_subject", parent: "policy", value: "subject"},
+			{name: "ory_access_control_policy_resource", parent: "policy", value: "resource"},
+			{name: "ory_access_control_policy_action", parent: "policy", value: "action"},
+		},
+	}
+	sqlRoleTable = sqlTable{
+		name: "ory_access_control_policy_role",
+		children: []sqlChildTable{
+			{name: "ory_access_control_policy_role_member", parent: "role", value: "member"},
+		},
+	}
+)
+
+// sqlPolicy and sqlRole mirror the JSON documents the API stores
+type sqlPolicy struct {
+	Actions     []string        `json:"actions"`
+	Conditions  json.RawMessage `json:"conditions"`
+	Description string          `json:"description"`
+	Effect      string          `json:"effect"`
+	ID          string          `json:"id"`
+	Resources   []string        `json:"resources"`
+	Subjects    []string        `json:"subjects"`
+}
+
+type sqlRole struct {
+	Description string   `json:"description"`
+	ID          string   `json:"id"`
+	Members     []string `json:"members"`
+}
+
+// sqlQuerier is implemented by both *sql.DB and *sql.Tx
+type sqlQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqlKey is a store key split into "<flavor>/<kind>/<rest>"
+type sqlKey struct {
+	flavor string
+	kind   string
+	rest   string
+}
+
+func parseSQLKey(key string) (sqlKey, error) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 || (parts[1] != "po" && parts[1] != "ro") {
+		return sqlKey{}, fmt.Errorf("key '%s' isn't supported by the postgres backend", key)
+	}
+	return sqlKey{
+		flavor: parts[0],
+		kind:   parts[1],
+		rest:   parts[2],
+	}, nil
+}
+
+func (k sqlKey) table() sqlTable {
+	if k.kind == "ro" {
+		return sqlRoleTable
+	}
+	return sqlPolicyTable
+}
+
+func (k sqlKey) docPrefix() string {
+	return k.flavor + "/" + k.kind + "/"
+}
+
+// docID returns the ID of the document addressed by an "i/<id>/" key, or false
+// for index refs
+func (k sqlKey) docID() (string, bool) {
+	if len(k.rest) < 3 || !strings.HasPrefix(k.rest, "i/") || !strings.HasSuffix(k.rest, "/") {
+		return "", false
+	}
+	return k.rest[2 : len(k.rest)-1], true
+}
+
+// NewPostgresStore connects to the database at dsn and creates the tables that
+// don't exist yet
+func NewPostgresStore(dsn string) (*PostgresStore, error) {
+	sqlDB, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = sqlDB.Ping()
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	for _, stmt := range postgresSchema {
+		_, err = sqlDB.Exec(stmt)
+		if err != nil {
+			sqlDB.Close()
+			return nil, fmt.Errorf("can't create schema: %w", err)
+		}
+	}
+	return &PostgresStore{
+		sql: sqlDB,
+	}, nil
+}
+
+// Close ..
+func (s *PostgresStore) Close() error {
+	return s.sql.Close()
+}
+
+// update runs fn in a transaction committed if fn returns nil
+func (s *PostgresStore) update(fn func(tx *sql.Tx) error) error {
+	tx, err := s.sql.Begin()
+	if err != nil {
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadDocs returns the encoded documents with the given IDs that exist
+func loadDocs(q sqlQuerier, k sqlKey, ids []string) (map[string][]byte, error) {
+	table := k.table()
+	policies := make(map[string]*sqlPolicy)
+	roles := make(map[string]*sqlRole)
+
+	var rows *sql.Rows
+	var err error
+	if k.kind == "ro" {
+		rows, err = q.Query(`SELECT id, description FROM `+table.name+` WHERE flavor = $1 AND id = ANY($2)`, k.flavor, pq.Array(ids))
+	} else {
+		rows, err = q.Query(`SELECT id, description, effect, conditions FROM `+table.name+` WHERE flavor = $1 AND id = ANY($2)`, k.flavor, pq.Array(ids))
+	}
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		if k.kind == "ro" {
+			var role sqlRole
+			err = rows.Scan(&role.ID, &role.Description)
+			roles[role.ID] = &role
+		} else {
+			var policy sqlPolicy
+			var conditions string
+			err = rows.Scan(&policy.ID, &policy.Description, &policy.Effect, &conditions)
+			policy.Conditions = json.RawMessage(conditions)
+			policies[policy.ID] = &policy
+		}
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, child := range table.children {
+		rows, err := q.Query(`SELECT `+child.parent+`, `+child.value+` FROM `+child.name+` WHERE flavor = $1 AND `+child.parent+` = ANY($2) ORDER BY `+child.value+` COLLATE "C"`, k.flavor, pq.Array(ids))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id, value string
+			err = rows.Scan(&id, &value)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			switch child.value {
+			case "subject":
+				if policy, ok := policies[id]; ok {
+					policy.Subjects = append(policy.Subjects, value)
+				}
+			case "resource":
+				if policy, ok := policies[id]; ok {
+					policy.Resources = append(policy.Resources, value)
+				}
+			case "action":
+				if policy, ok := policies[id]; ok {
+					policy.Actions = append(policy.Actions, value)
+				}
+			case "member":
+				if role, ok := roles[id]; ok {
+					role.Members = append(role.Members, value)
+				}
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	docs := make(map[string][]byte, len(policies)+len(roles))
+	for id, policy := range policies {
+		docs[id], err = json.Marshal(policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for id, role := range roles {
+		docs[id], err = json.Marshal(role)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+func getDoc(q sqlQuerier, key string, valueProcessor func(value []byte) error) error {
+	k, err := parseSQLKey(key)
+	if err != nil {
+		return err
+	}
+	id, ok := k.docID()
+	if !ok {
+		// Index refs are derived from the documents
+		return ErrKeyNotFound
+	}
+	docs, err := loadDocs(q, k, []string{id})
+	if err != nil {
+		return err
+	}
+	value, ok := docs[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	return valueProcessor(value)
+}
+
+func setDoc(q sqlQuerier, key string, value interface{}) error {
+	k, err := parseSQLKey(key)
+	if err != nil {
+		return err
+	}
+	id, ok := k.docID()
+	if !ok {
+		return fmt.Errorf("key '%s' isn't a document", key)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	table := k.table()
+	var values [][]string
+	if k.kind == "ro" {
+		var role sqlRole
+		err = json.Unmarshal(encoded, &role)
+		if err != nil {
+			return err
+		}
+		_, err = q.Exec(`INSERT INTO `+table.name+` (flavor, id, description) VALUES ($1, $2, $3)
+			ON CONFLICT (flavor, id) DO UPDATE SET description = EXCLUDED.description`, k.flavor, id, role.Description)
+		values = [][]string{role.Members}
+	} else {
+		var policy sqlPolicy
+		err = json.Unmarshal(encoded, &policy)
+		if err != nil {
+			return err
+		}
+		conditions := "null"
+		if len(policy.Conditions) > 0 {
+			conditions = string(policy.Conditions)
+		}
+		_, err = q.Exec(`INSERT INTO `+table.name+` (flavor, id, description, effect, conditions) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (flavor, id) DO UPDATE SET description = EXCLUDED.description, effect = EXCLUDED.effect, conditions = EXCLUDED.conditions`,
+			k.flavor, id, policy.Description, policy.Effect, conditions)
+		values = [][]string{policy.Subjects, policy.Resources, policy.Actions}
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, child := range table.children {
+		_, err = q.Exec(`DELETE FROM `+child.name+` WHERE flavor = $1 AND `+child.parent+` = $2`, k.flavor, id)
+		if err != nil {
+			return err
+		}
+		for _, value := range values[i] {
+			_, err = q.Exec(`INSERT INTO `+child.name+` (flavor, `+child.parent+`, `+child.value+`) VALUES ($1, $2, $3)
+				ON CONFLICT DO NOTHING`, k.flavor, id, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func delDoc(q sqlQuerier, key string) error {
+	k, err := parseSQLKey(key)
+	if err != nil {
+		return err
+	}
+	id, ok := k.docID()
+	if !ok {
+		return nil
+	}
+	table := k.table()
+	for _, child := range table.children {
+		_, err = q.Exec(`DELETE FROM `+child.name+` WHERE flavor = $1 AND `+child.parent+` = $2`, k.flavor, id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = q.Exec(`DELETE FROM `+table.name+` WHERE flavor = $1 AND id = $2`, k.flavor, id)
+	return err
+}
+
+// delDocs deletes every document of table, restricted to flavor when it isn't empty
+func delDocs(q sqlQuerier, table sqlTable, flavor string) error {
+	where := ""
+	args := []interface{}{}
+	if flavor != "" {
+		where = ` WHERE flavor = $1`
+		args = append(args, flavor)
+	}
+	for _, child := range table.children {
+		_, err := q.Exec(`DELETE FROM `+child.name+where, args...)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := q.Exec(`DELETE FROM `+table.name+where, args...)
+	return err
+}
+
+// Get ..
+func (s *PostgresStore) Get(prefix string, key string, valueProcessor func(value []byte) error) error {
+	return getDoc(s.sql, prefix+key, valueProcessor)
+}
+
+// Set ..
+func (s *PostgresStore) Set(prefix string, key string, value interface{}) error {
+	return s.update(func(tx *sql.Tx) error {
+		return setDoc(tx, prefix+key, value)
+	})
+}
+
+// SetMany ..
+func (s *PostgresStore) SetMany(prefix string, keys []string, values []interface{}) error {
+	return s.update(func(tx *sql.Tx) error {
+		for i, key := range keys {
+			err := setDoc(tx, prefix+key, values[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RefMany is a no-op, index refs being derived from the documents
+func (s *PostgresStore) RefMany(prefix string, keys []string) error {
+	_, err := parseSQLKey(prefix)
+	return err
+}
+
+// Del ..
+func (s *PostgresStore) Del(prefix string, key string) error {
+	return s.update(func(tx *sql.Tx) error {
+		return delDoc(tx, prefix+key)
+	})
+}
+
+// DelByPrefix deletes the documents under prefix; prefixes of index refs are
+// ignored, since refs are derived from the documents
+func (s *PostgresStore) DelByPrefix(prefix string) error {
+	if prefix == "" {
+		return s.DelEverything()
+	}
+	if strings.Count(prefix, "/") == 1 && strings.HasSuffix(prefix, "/") {
+		flavor := strings.TrimSuffix(prefix, "/")
+		return s.update(func(tx *sql.Tx) error {
+			err := delDocs(tx, sqlPolicyTable, flavor)
+			if err != nil {
+				return err
+			}
+			return delDocs(tx, sqlRoleTable, flavor)
+		})
+	}
+	k, err := parseSQLKey(prefix)
+	if err != nil {
+		return err
+	}
+	switch {
+	case k.rest == "" || k.rest == "i/":
+		return s.update(func(tx *sql.Tx) error {
+			return delDocs(tx, k.table(), k.flavor)
+		})
+	case strings.HasPrefix(k.rest, "s/") || strings.HasPrefix(k.rest, "m/"):
+		return nil
+	}
+	return fmt.Errorf("prefix '%s' isn't supported by the postgres backend", prefix)
+}
+
+// DelManyRefs is a no-op, index refs being derived from the documents
+func (s *PostgresStore) DelManyRefs(prefix string, keys []string) error {
+	_, err := parseSQLKey(prefix)
+	return err
+}
+
+// DelEverything ..
+func (s *PostgresStore) DelEverything() error {
+	return s.update(func(tx *sql.Tx) error {
+		err := delDocs(tx, sqlPolicyTable, "")
+		if err != nil {
+			return err
+		}
+		return delDocs(tx, sqlRoleTable, "")
+	})
+}
+
+// IndexFilter selects documents like the exact indexes of the other stores:
+// policies by subject, resource and action, roles by member. Empty fields
+// match any value
+type IndexFilter struct {
+	Subject  string
+	Resource string
+	Action   string
+	Member   string
+}
+
+// sqlFilter turns filter into joins restricting the documents of k
+func sqlFilter(k sqlKey, filter IndexFilter) (string, []interface{}) {
+	args := []interface{}{k.flavor}
+	joins := ""
+	join := func(child sqlChildTable, value string) {
+		if value == "" {
+			return
+		}
+		args = append(args, value)
+		alias := child.value[:1]
+		joins += fmt.Sprintf(` JOIN %s %s ON %s.flavor = d.flavor AND %s.%s = d.id AND %s.%s = $%d`,
+			child.name, alias, alias, alias, child.parent, alias, child.value, len(args))
+	}
+
+	if k.kind == "ro" {
+		join(sqlRoleTable.children[0], filter.Member)
+	} else {
+		join(sqlPolicyTable.children[0], filter.Subject)
+		join(sqlPolicyTable.children[1], filter.Resource)
+		join(sqlPolicyTable.children[2], filter.Action)
+	}
+	return ` FROM ` + k.table().name + ` d` + joins + ` WHERE d.flavor = $1`, args
+}
+
+// checkAllFilter rejects the filters of index refs, which aren't stored: only
+// "i/" (all documents) is supported
+func checkAllFilter(filter string) error {
+	if filter != "i/" {
+		return fmt.Errorf("filter '%s' isn't supported by the postgres backend", filter)
+	}
+	return nil
+}
+
+// List lists all documents under prefix; filter must be "i/", as lookups in
+// the indexes go through ListFiltered
+func (s *PostgresStore) List(prefix string, filter string, offset int64, limit int64, valuesProcessor func(keys []string, values [][]byte) error) error {
+	err := checkAllFilter(filter)
+	if err != nil {
+		return err
+	}
+	return s.ListFiltered(prefix, IndexFilter{}, offset, limit, valuesProcessor)
+}
+
+// ListFiltered lists the documents under prefix matching filter, with keys
+// relative to prefix like List
+func (s *PostgresStore) ListFiltered(prefix string, filter IndexFilter, offset int64, limit int64, valuesProcessor func(keys []string, values [][]byte) error) error {
+	limit, err := listLimit(offset, limit)
+	if err != nil {
+		return err
+	}
+	k, err := parseSQLKey(prefix)
+	if err != nil {
+		return err
+	}
+	from, args := sqlFilter(k, filter)
+	args = append(args, limit, offset)
+
+	rows, err := s.sql.Query(fmt.Sprintf(`SELECT d.id%s ORDER BY d.id COLLATE "C" LIMIT $%d OFFSET $%d`, from, len(args)-1, len(args)), args...)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, limit)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	docs, err := loadDocs(s.sql, k, ids)
+	if err != nil {
+		return err
+	}
+	foundKeys := make([]string, 0, len(ids))
+	foundValues := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		value, ok := docs[id]
+		if !ok {
+			// Deleted since it was listed
+			continue
+		}
+		foundKeys = append(foundKeys, "i/"+id+"/")
+		foundValues = append(foundValues, value)
+	}
+	return valuesProcessor(foundKeys, foundValues)
+}
+
+// Enumerate goes through the documents under prefix
+func (s *PostgresStore) Enumerate(prefix string, enumProcessor func(key string, value []byte) (bool, error)) error {
+	return enumerateDocs(s.sql, prefix, enumProcessor)
+}
+
+// enumeratePageSize is how many IDs enumerateDocs fetches at a time
+const enumeratePageSize = 100
+
+func enumerateDocs(q sqlQuerier, prefix string, enumProcessor func(key string, value []byte) (bool, error)) error {
+	k, err := parseSQLKey(prefix)
+	if err != nil {
+		return err
+	}
+	if k.rest != "" && k.rest != "i/" {
+		return nil
+	}
+
+	after := ""
+	for {
+		rows, err := q.Query(`SELECT id FROM `+k.table().name+` WHERE flavor = $1 AND id COLLATE "C" > $2 ORDER BY id COLLATE "C" LIMIT $3`, k.flavor, after, enumeratePageSize)
+		if err != nil {
+			return err
+		}
+		ids := make([]string, 0, enumeratePageSize)
+		for rows.Next() {
+			var id string
+			err = rows.Scan(&id)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		after = ids[len(ids)-1]
+
+		docs, err := loadDocs(q, k, ids)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			value, ok := docs[id]
+			if !ok {
+				continue
+			}
+			cont, err := enumProcessor(k.docPrefix()+"i/"+id+"/", value)
+			if err != nil {
+				return err
+			}
+			if !cont {
+				return nil
+			}
+		}
+	}
+}
+
+// Count counts all documents under prefix; filter must be "i/"
+func (s *PostgresStore) Count(prefix string, filter string, countProcessor func(cnt int64) error) error {
+	err := checkAllFilter(filter)
+	if err != nil {
+		return err
+	}
+	k, err := parseSQLKey(prefix)
+	if err != nil {
+		return err
+	}
+	from, args := sqlFilter(k, IndexFilter{})
+	var cnt int64
+	err = s.sql.QueryRow(`SELECT COUNT(*)`+from, args...).Scan(&cnt)
+	if err != nil {
+		return err
+	}
+	return countProcessor(cnt)
+}
+
+// postgresTxn runs reads and writes in a SQL transaction; refs are no-ops as
+// everywhere else in this backend
+type postgresTxn struct {
+	tx *sql.Tx
+}
+
+func (t *postgresTxn) Get(prefix string, key string, valueProcessor func(value []byte) error) error {
+	return getDoc(t.tx, prefix+key, valueProcessor)
+}
+
+func (t *postgresTxn) Set(prefix string, key string, value interface{}) error {
+	return setDoc(t.tx, prefix+key, value)
+}
+
+func (t *postgresTxn) Ref(prefix string, key string) error {
+	_, err := parseSQLKey(prefix + key)
+	return err
+}
+
+func (t *postgresTxn) Del(prefix string, key string) error {
+	return delDoc(t.tx, prefix+key)
+}
+
+func (t *postgresTxn) Enumerate(prefix string, enumProcessor func(key string, value []byte) (bool, error)) error {
+	return enumerateDocs(t.tx, prefix, enumProcessor)
+}
+
+// View runs fn in a read-only repeatable read transaction
+func (s *PostgresStore) View(fn func(txn Txn) error) error {
+	tx, err := s.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY`)
+	if err != nil {
+		return err
+	}
+	return fn(&postgresTxn{
+		tx: tx,
+	})
+}
+
+// Update ..
+func (s *PostgresStore) Update(fn func(txn Txn) error) error {
+	return s.update(func(tx *sql.Tx) error {
+		return fn(&postgresTxn{
+			tx: tx,
+		})
+	})
+}
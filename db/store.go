@@ -0,0 +1,74 @@
+package db
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Store is a storage backend for documents and their index refs. Keys are
+// addressed as a prefix (e.g. "exact/po/") followed by a key.
+type Store interface {
+	Get(prefix string, key string, valueProcessor func(value []byte) error) error
+	Set(prefix string, key string, value interface{}) error
+	SetMany(prefix string, keys []string, values []interface{}) error
+	RefMany(prefix string, keys []string) error
+	Del(prefix string, key string) error
+	DelByPrefix(prefix string) error
+	DelManyRefs(prefix string, keys []string) error
+	DelEverything() error
+	List(prefix string, filter string, offset int64, limit int64, valuesProcessor func(keys []string, values [][]byte) error) error
+	Enumerate(prefix string, enumProcessor func(key string, value []byte) (bool, error)) error
+	Count(prefix string, filter string, countProcessor func(cnt int64) error) error
+
+	// View runs fn with a consistent read-only view of the store
+	View(fn func(txn Txn) error) error
+	// Update runs fn and applies all its writes atomically if it returns nil
+	Update(fn func(txn Txn) error) error
+
+	Close() error
+}
+
+// Txn reads from a consistent view of the store and buffers writes until commit
+type Txn interface {
+	Get(prefix string, key string, valueProcessor func(value []byte) error) error
+	Set(prefix string, key string, value interface{}) error
+	Ref(prefix string, key string) error
+	Del(prefix string, key string) error
+	// Enumerate doesn't see the transaction's own pending writes
+	Enumerate(prefix string, enumProcessor func(key string, value []byte) (bool, error)) error
+}
+
+// Default limits applied by List
+const (
+	DefaultMaxListOffset = int64(10000)
+	DefaultMaxListLimit  = int64(100)
+)
+
+var (
+	maxListOffset = DefaultMaxListOffset
+	maxListLimit  = DefaultMaxListLimit
+)
+
+// SetListLimits changes the limits applied by List
+func SetListLimits(maxOffset int64, maxLimit int64) {
+	atomic.StoreInt64(&maxListOffset, maxOffset)
+	atomic.StoreInt64(&maxListLimit, maxLimit)
+}
+
+// listLimit checks offset against the List limits and returns the limit to
+// apply
+func listLimit(offset int64, limit int64) (int64, error) {
+	maxOffset := atomic.LoadInt64(&maxListOffset)
+	if offset > maxOffset {
+		return 0, fmt.Errorf("offset too large (max value is %d)", maxOffset)
+	}
+	maxLimit := atomic.LoadInt64(&maxListLimit)
+	if limit == -1 || limit > maxLimit {
+		limit = maxLimit
+	}
+	return limit, nil
+}
+
+var _ Store = (*DB)(nil)
+var _ Store = (*MemStore)(nil)
+var _ Store = (*PostgresStore)(nil)
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"encoding/json"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+type pendingWrite struct {
+	value   []byte
+	deleted bool
+}
+
+// txn reads from a badger snapshot and buffers writes into a batch operation,
+// so that transactions also work when writes go through a Proposer
+type txn struct {
+	view    *badger.Txn
+	ops     []*Op
+	pending map[string]pendingWrite
+}
+
+func (t *txn) Get(prefix string, key string, valueProcessor func(value []byte) error) error {
+	if pending, ok := t.pending[prefix+key]; ok {
+		if pending.deleted {
+			return ErrKeyNotFound
+		}
+		return valueProcessor(pending.value)
+	}
+	item, err := t.view.Get([]byte(prefix + key))
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return ErrKeyNotFound
+		}
+		return err
+	}
+	return item.Value(valueProcessor)
+}
+
+func (t *txn) Set(prefix string, key string, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	t.ops = append(t.ops, &Op{
+		Type:   OpSet,
+		Prefix: prefix,
+		Keys:   []string{key},
+		Values: [][]byte{encoded},
+	})
+	t.pending[prefix+key] = pendingWrite{
+		value: encoded,
+	}
+	return nil
+}
+
+func (t *txn) Ref(prefix string, key string) error {
+	t.ops = append(t.ops, &Op{
+		Type:   OpSet,
+		Prefix: prefix,
+		Keys:   []string{key},
+	})
+	t.pending[prefix+key] = pendingWrite{
+		value: make([]byte, 0),
+	}
+	return nil
+}
+
+func (t *txn) Del(prefix string, key string) error {
+	t.ops = append(t.ops, &Op{
+		Type:   OpDel,
+		Prefix: prefix,
+		Keys:   []string{key},
+	})
+	t.pending[prefix+key] = pendingWrite{
+		deleted: true,
+	}
+	return nil
+}
+
+func (t *txn) Enumerate(prefix string, enumProcessor func(key string, value []byte) (bool, error)) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(prefix)
+	iter := t.view.NewIterator(opts)
+	defer iter.Close()
+	for iter.Seek(opts.Prefix); iter.ValidForPrefix(opts.Prefix); iter.Next() {
+		var cont bool
+		err := iter.Item().Value(func(val []byte) error {
+			var err error
+			cont, err = enumProcessor(string(iter.Item().Key()), val)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !cont {
+			return nil
+		}
+	}
+	return nil
+}
+
+// View ..
+func (db *DB) View(fn func(txn Txn) error) error {
+	s := db.acquire()
+	defer s.release()
+	return s.b.View(func(view *badger.Txn) error {
+		return fn(&txn{
+			view:    view,
+			pending: make(map[string]pendingWrite),
+		})
+	})
+}
+
+// maxConflictRetries bounds how many times Update runs again after a
+// concurrent write conflicted with it
+const maxConflictRetries = 10
+
+// Update runs fn in a transaction and applies its writes atomically. Without a
+// Proposer fn runs in a badger transaction, again if a concurrent write
+// conflicts with what it read; with one, proposed writes of this node wait
+// until fn ran and its writes were proposed
+func (db *DB) Update(fn func(txn Txn) error) error {
+	if db.proposer != nil {
+		db.writeMu.Lock()
+		defer db.writeMu.Unlock()
+		t := &txn{
+			pending: make(map[string]pendingWrite),
+		}
+		// The store is released before proposing: a snapshot restored while
+		// the proposal is applied waits for its users
+		s := db.acquire()
+		err := s.b.View(func(view *badger.Txn) error {
+			t.view = view
+			return fn(t)
+		})
+		s.release()
+		if err != nil {
+			return err
+		}
+		if len(t.ops) == 0 {
+			return nil
+		}
+		return db.proposer.Propose(&Op{
+			Type: OpBatch,
+			Ops:  t.ops,
+		})
+	}
+	s := db.acquire()
+	defer s.release()
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		err = s.b.Update(func(update *badger.Txn) error {
+			t := &txn{
+				view:    update,
+				pending: make(map[string]pendingWrite),
+			}
+			err := fn(t)
+			if err != nil {
+				return err
+			}
+			return applyOps(update, t.ops)
+		})
+		if err != badger.ErrConflict {
+			return err
+		}
+	}
+	return err
+}
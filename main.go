@@ -6,24 +6,183 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 
 	"github.com/adi/sketo/api"
+	"github.com/adi/sketo/config"
+	"github.com/adi/sketo/db"
 	"github.com/adi/sketo/metrics"
+	"github.com/adi/sketo/tracing"
 	"github.com/adi/sketo/util"
+	"github.com/gorilla/mux"
 )
 
+// configFlags registers the flags overriding the config on flags and returns
+// a loader of the config that applies the flags set explicitly
+func configFlags(flags *flag.FlagSet) func() (*config.Config, error) {
+	file := flags.String("config", os.Getenv("SKETO_CONFIG"), "YAML config file")
+	apiListen := flags.String("api-listen", "", "Address the API server listens on")
+	adminListen := flags.String("admin-listen", "", "Address the admin server listens on (default: served by the API server)")
+	grpcListen := flags.String("grpc-listen", "", "Address the gRPC server listens on (default: disabled)")
+	metricsListen := flags.String("metrics-listen", "", "Address the metrics server listens on")
+	storageBackend := flags.String("storage-backend", "", "Storage backend (badger, memory or postgres)")
+	storageDir := flags.String("storage-dir", "", "Folder of the badger storage")
+	monitorMode := flags.Bool("justallow", false, "Allows everything (monitor mode)")
+
+	return func() (*config.Config, error) {
+		return config.Load(*file, func(cfg *config.Config) {
+			flags.Visit(func(f *flag.Flag) {
+				switch f.Name {
+				case "api-listen":
+					cfg.API.Listen = *apiListen
+				case "admin-listen":
+					cfg.Admin.Listen = *adminListen
+				case "grpc-listen":
+					cfg.GRPC.Listen = *grpcListen
+				case "metrics-listen":
+					cfg.Metrics.Listen = *metricsListen
+				case "storage-backend":
+					cfg.Storage.Backend = *storageBackend
+				case "storage-dir":
+					cfg.Storage.Dir = *storageDir
+				case "justallow":
+					cfg.MonitorMode = *monitorMode
+				}
+			})
+		})
+	}
+}
+
+// initConfig loads and applies the config, registering what applies its
+// reloadable settings
+func initConfig(load func() (*config.Config, error)) error {
+	config.OnReload(func(cfg *config.Config) error {
+		db.SetListLimits(cfg.List.MaxOffset, cfg.List.MaxLimit)
+		return nil
+	})
+	cfg, err := load()
+	if err != nil {
+		return err
+	}
+	return config.Apply(cfg)
+}
+
+// startServer starts a named HTTP server on a configured listener
+func startServer(ctx context.Context, wg *sync.WaitGroup, name string, listener config.ListenConfig) (*mux.Router, error) {
+	opts, err := listener.ServerOptions()
+	if err != nil {
+		return nil, err
+	}
+	return util.StartHTTPServer(ctx, wg, name, opts)
+}
+
+// startGRPCServer starts the gRPC server of the API on a configured listener
+func startGRPCServer(ctx context.Context, wg *sync.WaitGroup, name string, listener config.ListenConfig) error {
+	opts, err := listener.ServerOptions()
+	if err != nil {
+		return err
+	}
+	srv, err := api.NewGRPCServer(util.GRPCServerOptions(opts)...)
+	if err != nil {
+		return err
+	}
+	return util.ServeGRPC(ctx, wg, name, srv, opts)
+}
+
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	ketoURL := flags.String("keto-url", "", "Base URL of the Keto server to import from")
+	file := flags.String("file", "", "Keto JSON dump to import from")
+	flavors := flags.String("flavors", "exact,glob,regex", "Comma separated flavors to import")
+	pageSize := flags.Int64("page-size", 100, "Items fetched and written at a time")
+	stateFile := flags.String("state", "sketo-import.state", "File recording the import progress")
+	resume := flags.Bool("resume", false, "Resume from the progress recorded in the state file")
+	dryRun := flags.Bool("dry-run", false, "Read everything without writing")
+	load := configFlags(flags)
+	flags.Parse(args)
+
+	err := initConfig(load)
+	if err != nil {
+		return err
+	}
+
+	return api.Import(api.ImportOptions{
+		KetoURL:   *ketoURL,
+		File:      *file,
+		Flavors:   strings.Split(*flavors, ","),
+		PageSize:  *pageSize,
+		StateFile: *stateFile,
+		Resume:    *resume,
+		DryRun:    *dryRun,
+	})
+}
+
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "-", "File to write NDJSON to (- for stdout)")
+	flavors := flags.String("flavors", "exact,glob,regex", "Comma separated flavors to export")
+	kind := flags.String("kind", "", "Export only policies or roles")
+	subject := flags.String("subject", "", "Export only policies matching this subject")
+	resource := flags.String("resource", "", "Export only policies matching this resource")
+	member := flags.String("member", "", "Export only roles matching this member")
+	compress := flags.Bool("gzip", false, "Gzip the output")
+	load := configFlags(flags)
+	flags.Parse(args)
+
+	err := initConfig(load)
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return api.Export(w, *compress, api.ExportOptions{
+		Flavors:  strings.Split(*flavors, ","),
+		Kind:     *kind,
+		Subject:  *subject,
+		Resource: *resource,
+		Member:   *member,
+	})
+}
+
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		err := runImport(os.Args[2:])
+		if err != nil {
+			log.Panicf("Couldn't import: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		err := runExport(os.Args[2:])
+		if err != nil {
+			log.Panicf("Couldn't export: %v", err)
+		}
+		os.Exit(0)
+	}
+
 	fix := flag.Bool("fix", false, "Fix")
-	justAllow := flag.Bool("justallow", false, "Allows everything")
 	test := flag.Bool("test", false, "Adds one million documents")
+	load := configFlags(flag.CommandLine)
 	flag.Parse()
 
-	if justAllow != nil && *justAllow {
-		api.JustAllow = *justAllow
+	err := initConfig(load)
+	if err != nil {
+		log.Panicf("Couldn't load config: %v", err)
 	}
+	cfg := config.Get()
 
 	if test != nil && *test {
 		api.TestPolicies()
@@ -44,26 +203,45 @@ func main() {
 
 	wg := &sync.WaitGroup{}
 
-	var err error
-
 	// Start metrics server
-	metricsMux := util.StartHTTPServer(ctx, wg, "Metrics Server", ":9104")
+	metricsMux, err := startServer(ctx, wg, "Metrics Server", cfg.Metrics)
+	if err != nil {
+		log.Panicf("Couldn't start Metrics Server: %v", err)
+	}
 	err = metrics.Init(metricsMux)
 	if err != nil {
 		log.Panicf("Couldn't initialize Metrics Server: %v", err)
 	}
 
-	// Start sketo API server
-	apiMux := util.StartHTTPServer(ctx, wg, "API Server", ":4466")
-	err = api.Init(apiMux)
+	// Start sketo API server, and a separate admin server when configured
+	apiMux, err := startServer(ctx, wg, "API Server", cfg.API)
+	if err != nil {
+		log.Panicf("Couldn't start API Server: %v", err)
+	}
+	adminMux := apiMux
+	if cfg.Admin.Listen != "" {
+		adminMux, err = startServer(ctx, wg, "Admin Server", cfg.Admin)
+		if err != nil {
+			log.Panicf("Couldn't start Admin Server: %v", err)
+		}
+	}
+	err = api.Init(apiMux, adminMux)
 	if err != nil {
 		log.Panicf("Couldn't initialize API Server: %v", err)
 	}
 
+	// Start gRPC server when configured
+	if cfg.GRPC.Listen != "" {
+		err = startGRPCServer(ctx, wg, "gRPC Server", cfg.GRPC)
+		if err != nil {
+			log.Panicf("Couldn't start gRPC Server: %v", err)
+		}
+	}
+
 	log.Printf("Started")
 
 	// React properly to signals
-	sg := make(chan os.Signal)
+	sg := make(chan os.Signal, 1)
 	signal.Notify(sg, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	for {
 		select {
@@ -73,10 +251,21 @@ func main() {
 				log.Printf("Received signal=%s. Allowing HTTP servers to gracefully shut down...", signal.String())
 				cancel()
 				wg.Wait()
+				api.WaitComparisons()
+				err := tracing.Shutdown(context.Background())
+				if err != nil {
+					log.Printf("Couldn't flush traces: %v", err)
+				}
 				log.Printf("Exiting")
 				os.Exit(0)
 			case syscall.SIGHUP:
 				log.Printf("Reload config triggered by signal=%s", signal.String())
+				err := config.Reload(load)
+				if err != nil {
+					log.Printf("Couldn't reload config, keeping the current one: %v", err)
+					continue
+				}
+				log.Printf("Reloaded config")
 			}
 		}
 	}
@@ -1,30 +1,158 @@
 package metrics
 
 import (
-	"fmt"
-	"net/http"
+	"sync/atomic"
 
 	"github.com/adi/sketo/api"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+func newDesc(name string, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(name, help, labels, nil)
+}
+
+var (
+	policiesDesc           = newDesc("sketo_policies_total", "Policies stored, by flavor.", "flavor")
+	rolesDesc              = newDesc("sketo_roles_total", "Roles stored, by flavor.", "flavor")
+	allowRequestsDesc      = newDesc("sketo_allow_requests_since_start", "Allowed requests received since start.")
+	allowAcceptedDesc      = newDesc("sketo_allow_accepted_since_start", "Allowed requests answered with allowed=true since start.")
+	allowRefusedDesc       = newDesc("sketo_allow_refused_since_start", "Allowed requests answered with allowed=false since start.")
+	allowFailuresDesc      = newDesc("sketo_allow_failures_since_start", "Allowed requests that failed since start.")
+	reconciliationsDesc    = newDesc("sketo_counter_reconciliations_total", "Periodic recounts of the stored documents.")
+	driftCorrectedDesc     = newDesc("sketo_counter_drift_corrected_total", "Sum of the differences between the document counters and storage found by recounts.")
+	compareRequestsDesc    = newDesc("sketo_compare_requests_total", "Allowed decisions compared against the upstream Keto.")
+	compareMismatchDesc    = newDesc("sketo_compare_mismatch_total", "Allowed decisions that differed from the upstream Keto.")
+	compareErrorsDesc      = newDesc("sketo_compare_errors_total", "Allowed decisions that couldn't be compared against the upstream Keto.")
+	clusterLeaderDesc      = newDesc("sketo_cluster_leader", "Whether this node is the raft leader.")
+	clusterAppliedDesc     = newDesc("sketo_cluster_applied_index", "Last raft log index applied by this node.")
+	replFollowersDesc      = newDesc("sketo_replication_followers", "Followers connected to this replication leader.")
+	replLagDesc            = newDesc("sketo_replication_lag_seconds", "How far this follower is behind its leader.")
+	replAppliedVersionDesc = newDesc("sketo_replication_applied_version", "Last leader version applied by this follower.")
+	replConnectedDesc      = newDesc("sketo_replication_connected", "Whether this follower is connected to its leader.")
+	badgerLSMSizeDesc      = newDesc("sketo_badger_lsm_size_bytes", "Size of the badger LSM tree.")
+	badgerVlogSizeDesc     = newDesc("sketo_badger_vlog_size_bytes", "Size of the badger value log.")
+	badgerGCRunsDesc       = newDesc("sketo_badger_vlog_gc_runs_total", "Value log GC rounds run.")
+	badgerGCRewritesDesc   = newDesc("sketo_badger_vlog_gc_rewrites_total", "Value log files rewritten by GC.")
+	badgerGCReclaimedDesc  = newDesc("sketo_badger_vlog_gc_reclaimed_bytes_total", "Value log bytes reclaimed by GC.")
+	badgerFlattensDesc     = newDesc("sketo_badger_flattens_total", "LSM tree flattens run.")
+)
+
+var (
+	allDescs = []*prometheus.Desc{
+		policiesDesc, rolesDesc,
+		allowRequestsDesc, allowAcceptedDesc, allowRefusedDesc, allowFailuresDesc,
+		reconciliationsDesc, driftCorrectedDesc,
+		compareRequestsDesc, compareMismatchDesc, compareErrorsDesc,
+		clusterLeaderDesc, clusterAppliedDesc,
+		replFollowersDesc, replLagDesc, replAppliedVersionDesc, replConnectedDesc,
+		badgerLSMSizeDesc, badgerVlogSizeDesc, badgerGCRunsDesc, badgerGCRewritesDesc,
+		badgerGCReclaimedDesc, badgerFlattensDesc,
+	}
+	flavorPolicyCounters    = map[string]*int64{"regex": &api.CntRegexPolicies, "glob": &api.CntGlobPolicies, "exact": &api.CntExactPolicies}
+	flavorRoleCounters      = map[string]*int64{"regex": &api.CntRegexRoles, "glob": &api.CntGlobRoles, "exact": &api.CntExactRoles}
+	allowRequestsSinceStart = []struct {
+		desc *prometheus.Desc
+		cnt  *int64
+	}{
+		{allowRequestsDesc, &api.CntAllowRequestsSinceStart},
+		{allowAcceptedDesc, &api.CntAllowAcceptedSinceStart},
+		{allowRefusedDesc, &api.CntAllowRefusedSinceStart},
+		{allowFailuresDesc, &api.CntAllowFailuresSinceStart},
+	}
+)
+
+// sketoCollector reads the counters and state kept by the API when scraped;
+// metrics of features that aren't enabled are left out
+type sketoCollector struct{}
+
+func (c sketoCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range allDescs {
+		ch <- desc
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (c sketoCollector) Collect(ch chan<- prometheus.Metric) {
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+	counter := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value, labels...)
+	}
+
+	for flavor, cnt := range flavorPolicyCounters {
+		gauge(policiesDesc, float64(atomic.LoadInt64(cnt)), flavor)
+	}
+	for flavor, cnt := range flavorRoleCounters {
+		gauge(rolesDesc, float64(atomic.LoadInt64(cnt)), flavor)
+	}
+	for _, c := range allowRequestsSinceStart {
+		counter(c.desc, float64(atomic.LoadInt64(c.cnt)))
+	}
+	counter(reconciliationsDesc, float64(atomic.LoadInt64(&api.CntReconciliations)))
+	counter(driftCorrectedDesc, float64(atomic.LoadInt64(&api.CntDriftCorrected)))
+	if api.CompareUpstreamURL != "" {
+		counter(compareRequestsDesc, float64(atomic.LoadInt64(&api.CntCompareRequests)))
+		counter(compareMismatchDesc, float64(atomic.LoadInt64(&api.CntCompareMismatches)))
+		counter(compareErrorsDesc, float64(atomic.LoadInt64(&api.CntCompareErrors)))
+	}
+	if api.ClusterNode != nil {
+		gauge(clusterLeaderDesc, boolValue(api.ClusterNode.IsLeader()))
+		gauge(clusterAppliedDesc, float64(api.ClusterNode.AppliedIndex()))
+	}
+	if api.ReplicationLeader != nil {
+		gauge(replFollowersDesc, float64(api.ReplicationLeader.Followers()))
+	}
+	if api.ReplicationFollower != nil {
+		lag, _ := api.ReplicationFollower.Lag()
+		gauge(replLagDesc, lag.Seconds())
+		gauge(replAppliedVersionDesc, float64(api.ReplicationFollower.AppliedVersion()))
+		gauge(replConnectedDesc, boolValue(api.ReplicationFollower.Connected()))
+	}
+	if api.BadgerDB != nil {
+		stats := api.BadgerDB.Stats()
+		gauge(badgerLSMSizeDesc, float64(stats.LSMSize))
+		gauge(badgerVlogSizeDesc, float64(stats.VlogSize))
+		counter(badgerGCRunsDesc, float64(stats.GCRuns))
+		counter(badgerGCRewritesDesc, float64(stats.GCRewrites))
+		counter(badgerGCReclaimedDesc, float64(stats.GCReclaimedBytes))
+		counter(badgerFlattensDesc, float64(stats.Flattens))
+	}
+}
+
 // Init sets up the metrics HTTP endpoints
 func Init(metricsMux *mux.Router) error {
 
-	metricsMux.HandleFunc("/metrics", func(rw http.ResponseWriter, r *http.Request) {
-		rw.Header().Set("Content-Type", "text/plain")
-		rw.WriteHeader(200)
-		rw.Write([]byte(fmt.Sprintf("sketo_policies_total{flavor=\"regex\"} %v\n", api.CntRegexPolicies)))
-		rw.Write([]byte(fmt.Sprintf("sketo_policies_total{flavor=\"glob\"} %v\n", api.CntGlobPolicies)))
-		rw.Write([]byte(fmt.Sprintf("sketo_policies_total{flavor=\"exact\"} %v\n", api.CntExactPolicies)))
-		rw.Write([]byte(fmt.Sprintf("sketo_roles_total{flavor=\"regex\"} %v\n", api.CntRegexRoles)))
-		rw.Write([]byte(fmt.Sprintf("sketo_roles_total{flavor=\"glob\"} %v\n", api.CntGlobRoles)))
-		rw.Write([]byte(fmt.Sprintf("sketo_roles_total{flavor=\"exact\"} %v\n", api.CntExactRoles)))
-		rw.Write([]byte(fmt.Sprintf("sketo_allow_requests_since_start %v\n", api.CntAllowRequestsSinceStart)))
-		rw.Write([]byte(fmt.Sprintf("sketo_allow_accepted_since_start %v\n", api.CntAllowAcceptedSinceStart)))
-		rw.Write([]byte(fmt.Sprintf("sketo_allow_refused_since_start %v\n", api.CntAllowRefusedSinceStart)))
-		rw.Write([]byte(fmt.Sprintf("sketo_allow_failures_since_start %v\n", api.CntAllowFailuresSinceStart)))
-	})
+	registry := prometheus.NewRegistry()
+	err := registry.Register(collectors.NewGoCollector())
+	if err != nil {
+		return err
+	}
+	err = registry.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	if err != nil {
+		return err
+	}
+	err = registry.Register(sketoCollector{})
+	if err != nil {
+		return err
+	}
+	for _, collector := range api.Collectors() {
+		err = registry.Register(collector)
+		if err != nil {
+			return err
+		}
+	}
+
+	metricsMux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
 
 	return nil
 
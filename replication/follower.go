@@ -0,0 +1,272 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+// errResetRequested means the follower has to bootstrap again from a fresh snapshot
+var errResetRequested = errors.New("leader requested a new snapshot")
+
+// Follower keeps a local database in sync with a leader
+type Follower struct {
+	acpDB     *db.DB
+	leaderURL *url.URL
+	client    *http.Client
+
+	// OnBootstrap is called after the local database was replaced with a snapshot
+	OnBootstrap func() error
+	// WriteError sends the error responses of the middleware
+	WriteError ErrorWriter
+
+	mu           sync.Mutex
+	started      time.Time
+	bootstrapped bool
+	connected    bool
+	applied      uint64
+	caughtUpAt   time.Time
+}
+
+// NewFollower creates a follower replicating from the sketo API at leaderURL
+func NewFollower(acpDB *db.DB, leaderURL string) (*Follower, error) {
+	u, err := url.Parse(leaderURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid leader URL '%s'", leaderURL)
+	}
+	return &Follower{
+		acpDB:     acpDB,
+		leaderURL: u,
+		client:    &http.Client{},
+		started:   time.Now(),
+	}, nil
+}
+
+// SetTransport changes how requests are sent to the leader
+func (f *Follower) SetTransport(transport http.RoundTripper) {
+	f.client.Transport = transport
+}
+
+// LeaderRequest sends a request to the API of the leader like the writes
+// forwarded by Middleware, with the credentials in header rather than the
+// replication API key, and a JSON body unless nil
+func (f *Follower) LeaderRequest(ctx context.Context, method string, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(f.leaderURL.String(), "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// Run bootstraps the local database and tails the leader's change log until ctx is done
+func (f *Follower) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		f.mu.Lock()
+		bootstrapped := f.bootstrapped
+		f.mu.Unlock()
+
+		var err error
+		if !bootstrapped {
+			err = f.bootstrap(ctx)
+		} else {
+			err = f.tail(ctx)
+		}
+		f.setConnected(false)
+		if err == errResetRequested {
+			log.Printf("Replication: %v\n", err)
+			f.mu.Lock()
+			f.bootstrapped = false
+			f.mu.Unlock()
+			continue
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Replication from %s interrupted: %v\n", f.leaderURL, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(1 * time.Second):
+			}
+		}
+	}
+}
+
+// AppliedVersion returns the leader version the local database is consistent with
+func (f *Follower) AppliedVersion() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.applied
+}
+
+// Connected tells whether the follower is currently streaming from the leader
+func (f *Follower) Connected() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connected
+}
+
+// Lag returns for how long the follower has not been caught up with the leader;
+// caughtUp is false if it never was since start
+func (f *Follower) Lag() (lag time.Duration, caughtUp bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.caughtUpAt.IsZero() {
+		return time.Since(f.started), false
+	}
+	return time.Since(f.caughtUpAt), true
+}
+
+// Middleware rejects writes on the follower, or forwards them to the leader
+func (f *Follower) Middleware(forward bool) mux.MiddlewareFunc {
+	proxy := httputil.NewSingleHostReverseProxy(f.leaderURL)
+	proxy.ErrorHandler = func(rw http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("Error forwarding write to the replication leader: %v\n", err)
+		writeError(f.WriteError, rw, r, 502, "Couldn't forward the write to the leader")
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if IsRead(r) {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			if forward {
+				proxy.ServeHTTP(rw, r)
+				return
+			}
+			writeError(f.WriteError, rw, r, 503, "Read-only replica; send writes to the leader")
+		})
+	}
+}
+
+// IsRead tells whether a request of the API only reads the database
+func IsRead(r *http.Request) bool {
+	// ext_authz checks keep the method of the request they ask about
+	if strings.HasPrefix(r.URL.Path, "/ext_authz/") {
+		return true
+	}
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	case "POST":
+		return strings.HasSuffix(r.URL.Path, "/allowed") || r.URL.Path == "/k8s/subjectaccessreview"
+	}
+	return false
+}
+
+func (f *Follower) setConnected(connected bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.connected = connected
+}
+
+func (f *Follower) endpoint(path string, query url.Values) string {
+	u := *f.leaderURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (f *Follower) bootstrap(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", f.endpoint("/replication/snapshot", nil), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("snapshot request returned status %d", resp.StatusCode)
+	}
+	version, err := strconv.ParseUint(resp.Header.Get(VersionHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("snapshot has invalid version: %w", err)
+	}
+
+	log.Printf("Replication: loading snapshot at version %d from %s\n", version, f.leaderURL)
+	err = f.acpDB.Restore(resp.Body)
+	if err != nil {
+		return fmt.Errorf("couldn't load snapshot: %w", err)
+	}
+	if f.OnBootstrap != nil {
+		err = f.OnBootstrap()
+		if err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.applied = version
+	f.bootstrapped = true
+	log.Printf("Replication: snapshot loaded\n")
+	return nil
+}
+
+func (f *Follower) tail(ctx context.Context) error {
+	query := url.Values{}
+	query.Set("since", strconv.FormatUint(f.AppliedVersion(), 10))
+	req, err := http.NewRequestWithContext(ctx, "GET", f.endpoint("/replication/changes", query), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 410 {
+		return errResetRequested
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("changes request returned status %d", resp.StatusCode)
+	}
+	f.setConnected(true)
+
+	jsonDec := json.NewDecoder(resp.Body)
+	for {
+		var fr frame
+		err := jsonDec.Decode(&fr)
+		if err != nil {
+			return err
+		}
+		switch fr.Type {
+		case frameReset:
+			return errResetRequested
+		case frameChanges:
+			err = f.acpDB.ApplyChanges(fr.Changes)
+			if err != nil {
+				return fmt.Errorf("couldn't apply changes: %w", err)
+			}
+		}
+		f.mu.Lock()
+		if fr.Version > f.applied {
+			f.applied = fr.Version
+		}
+		if f.applied >= fr.Latest {
+			f.caughtUpAt = time.Now()
+		}
+		f.mu.Unlock()
+	}
+}
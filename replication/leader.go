@@ -0,0 +1,261 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+// VersionHeader carries the version a snapshot is consistent with
+const VersionHeader = "X-Sketo-Replication-Version"
+
+const heartbeatInterval = 1 * time.Second
+
+const maxFrameChanges = 10000
+
+const (
+	frameChanges   = "changes"
+	frameHeartbeat = "heartbeat"
+	frameReset     = "reset"
+)
+
+// frame is one line of the NDJSON change stream sent to followers
+type frame struct {
+	Type    string      `json:"type"`
+	Version uint64      `json:"version"`
+	Latest  uint64      `json:"latest"`
+	Changes []db.Change `json:"changes,omitempty"`
+}
+
+// ErrorWriter sends an error response with code, explaining its cause in reason
+type ErrorWriter func(rw http.ResponseWriter, r *http.Request, code int, reason string)
+
+// writeError sends an error response with write, or as plain text when unset
+func writeError(write ErrorWriter, rw http.ResponseWriter, r *http.Request, code int, reason string) {
+	if write != nil {
+		write(rw, r, code, reason)
+		return
+	}
+	if reason == "" {
+		reason = http.StatusText(code)
+	}
+	http.Error(rw, reason, code)
+}
+
+// Leader keeps an in-memory log of recent writes and serves it to followers
+type Leader struct {
+	acpDB   *db.DB
+	logSize int
+
+	// WriteError sends the error responses of the replication endpoints
+	WriteError ErrorWriter
+
+	mu      sync.Mutex
+	live    bool
+	epoch   uint64
+	floor   uint64
+	latest  uint64
+	entries []db.Change
+	notify  chan struct{}
+
+	followers int64
+}
+
+// NewLeader creates a leader keeping at most logSize changes for followers to catch up from
+func NewLeader(acpDB *db.DB, logSize int) *Leader {
+	return &Leader{
+		acpDB:   acpDB,
+		logSize: logSize,
+		notify:  make(chan struct{}),
+	}
+}
+
+// Followers returns the number of currently connected followers
+func (l *Leader) Followers() int64 {
+	return atomic.LoadInt64(&l.followers)
+}
+
+// Run records database writes into the change log until ctx is done
+func (l *Leader) Run(ctx context.Context) {
+	for {
+		err := l.acpDB.Subscribe(ctx, l.start, l.append)
+		l.reset()
+		if ctx.Err() != nil {
+			return
+		}
+		if err == db.ErrDropped {
+			log.Printf("Database dropped, restarting replication log\n")
+			continue
+		}
+		log.Printf("Replication log interrupted: %v\n", err)
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// Register adds the replication endpoints to the API router
+func (l *Leader) Register(apiMux *mux.Router) {
+	apiMux.HandleFunc("/replication/snapshot", l.snapshot).Methods("GET")
+	apiMux.HandleFunc("/replication/changes", l.changes).Methods("GET")
+}
+
+func (l *Leader) start(version uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.live = true
+	l.epoch++
+	l.floor = version
+	l.latest = version
+	l.entries = nil
+	l.broadcast()
+	return nil
+}
+
+func (l *Leader) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.live = false
+	l.epoch++
+	l.entries = nil
+	l.broadcast()
+}
+
+func (l *Leader) append(changes []db.Change) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, change := range changes {
+		l.entries = append(l.entries, change)
+		if change.Version > l.latest {
+			l.latest = change.Version
+		}
+	}
+	// Evict whole versions so that floor always separates complete commits
+	if len(l.entries) > l.logSize {
+		evict := len(l.entries) - l.logSize
+		for evict < len(l.entries) && l.entries[evict].Version == l.entries[evict-1].Version {
+			evict++
+		}
+		l.floor = l.entries[evict-1].Version
+		l.entries = append([]db.Change(nil), l.entries[evict:]...)
+	}
+	l.broadcast()
+	return nil
+}
+
+// broadcast wakes up all change streams; must be called with mu held
+func (l *Leader) broadcast() {
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// since returns the changes committed after version, or ok=false if they are no longer in the log
+func (l *Leader) since(epoch uint64, version uint64) (changes []db.Change, latest uint64, notify chan struct{}, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.live || l.epoch != epoch || version < l.floor {
+		return nil, 0, nil, false
+	}
+	pos := sort.Search(len(l.entries), func(i int) bool {
+		return l.entries[i].Version > version
+	})
+	return l.entries[pos:], l.latest, l.notify, true
+}
+
+func (l *Leader) snapshot(rw http.ResponseWriter, r *http.Request) {
+	version := l.acpDB.CurrentVersion()
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set(VersionHeader, strconv.FormatUint(version, 10))
+	rw.WriteHeader(200)
+	err := l.acpDB.Backup(rw)
+	if err != nil {
+		// Headers are already sent; the follower detects the truncated stream
+		log.Printf("Error streaming snapshot: %v\n", err)
+		return
+	}
+}
+
+func (l *Leader) changes(rw http.ResponseWriter, r *http.Request) {
+	version, err := strconv.ParseUint(r.FormValue("since"), 10, 64)
+	if err != nil {
+		writeError(l.WriteError, rw, r, 400, "Invalid since query param")
+		return
+	}
+
+	l.mu.Lock()
+	epoch := l.epoch
+	l.mu.Unlock()
+	if _, _, _, ok := l.since(epoch, version); !ok {
+		writeError(l.WriteError, rw, r, 410, "Version no longer in replication log")
+		return
+	}
+
+	atomic.AddInt64(&l.followers, 1)
+	defer atomic.AddInt64(&l.followers, -1)
+
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.WriteHeader(200)
+	flusher, _ := rw.(http.Flusher)
+	jsonEnc := json.NewEncoder(rw)
+	send := func(f frame) bool {
+		err := jsonEnc.Encode(f)
+		if err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		changes, latest, notify, ok := l.since(epoch, version)
+		if !ok {
+			send(frame{
+				Type: frameReset,
+			})
+			return
+		}
+		if len(changes) > 0 {
+			if len(changes) > maxFrameChanges {
+				cut := maxFrameChanges
+				for cut < len(changes) && changes[cut].Version == changes[cut-1].Version {
+					cut++
+				}
+				changes = changes[:cut]
+			}
+			version = changes[len(changes)-1].Version
+			if !send(frame{
+				Type:    frameChanges,
+				Version: version,
+				Latest:  latest,
+				Changes: changes,
+			}) {
+				return
+			}
+			continue
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-notify:
+		case <-heartbeat.C:
+			if !send(frame{
+				Type:    frameHeartbeat,
+				Version: version,
+				Latest:  latest,
+			}) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package replication
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adi/sketo/db"
+	"github.com/gorilla/mux"
+)
+
+func newTestDB(t *testing.T) *db.DB {
+	dir, err := ioutil.TempDir("", "sketo-replication")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	acpDB, err := db.NewDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return acpDB
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func hasKey(acpDB *db.DB, prefix string, key string) bool {
+	return acpDB.Get(prefix, key, func(value []byte) error { return nil }) == nil
+}
+
+func TestFollowerReplicatesLeader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leaderDB := newTestDB(t)
+	err := leaderDB.Set("exact/po/", "i/before/", map[string]string{"id": "before"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leader := NewLeader(leaderDB, 1000)
+	go leader.Run(ctx)
+	router := mux.NewRouter()
+	leader.Register(router)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	defer cancel()
+
+	followers := make([]*Follower, 2)
+	followerDBs := make([]*db.DB, 2)
+	for i := range followers {
+		followerDBs[i] = newTestDB(t)
+		followers[i], err = NewFollower(followerDBs[i], srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go followers[i].Run(ctx)
+	}
+
+	for i := range followers {
+		waitFor(t, "snapshot", func() bool {
+			return hasKey(followerDBs[i], "exact/po/", "i/before/")
+		})
+	}
+
+	// Writes after the snapshot arrive through the change log
+	err = leaderDB.Set("exact/po/", "i/after/", map[string]string{"id": "after"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = leaderDB.RefMany("exact/po/", []string{"s/a/r/b/a/c/i/after/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = leaderDB.Del("exact/po/", "i/before/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range followers {
+		waitFor(t, "changes", func() bool {
+			return hasKey(followerDBs[i], "exact/po/", "i/after/") &&
+				hasKey(followerDBs[i], "exact/po/", "s/a/r/b/a/c/i/after/") &&
+				!hasKey(followerDBs[i], "exact/po/", "i/before/")
+		})
+		waitFor(t, "caught up", func() bool {
+			lag, caughtUp := followers[i].Lag()
+			return caughtUp && lag < 5*time.Second
+		})
+	}
+
+	// Dropping the leader's data forces followers to bootstrap again
+	err = leaderDB.DelEverything()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = leaderDB.Set("exact/po/", "i/fresh/", map[string]string{"id": "fresh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range followers {
+		waitFor(t, "re-bootstrap", func() bool {
+			return hasKey(followerDBs[i], "exact/po/", "i/fresh/") &&
+				!hasKey(followerDBs[i], "exact/po/", "i/after/")
+		})
+	}
+}
+
+func TestFollowerRejectsWrites(t *testing.T) {
+	follower, err := NewFollower(newTestDB(t), "http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/engines/acp/ory/{flavor}/allowed", func(rw http.ResponseWriter, r *http.Request) {}).Methods("POST")
+	router.HandleFunc("/engines/acp/ory/{flavor}/policies", func(rw http.ResponseWriter, r *http.Request) {}).Methods("GET", "PUT")
+	router.Use(follower.Middleware(false))
+
+	cases := map[string]int{
+		"POST /engines/acp/ory/exact/allowed": 200,
+		"GET /engines/acp/ory/exact/policies": 200,
+		"PUT /engines/acp/ory/exact/policies": 503,
+	}
+	for request, status := range cases {
+		parts := strings.SplitN(request, " ", 2)
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, httptest.NewRequest(parts[0], parts[1], nil))
+		if rw.Code != status {
+			t.Errorf("%s returned %d, expected %d", request, rw.Code, status)
+		}
+	}
+
+	// Refused and failed forwards are answered by the error writer
+	reasons := make(map[int]string)
+	follower.WriteError = func(rw http.ResponseWriter, r *http.Request, code int, reason string) {
+		reasons[code] = reason
+		rw.WriteHeader(code)
+	}
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/engines/acp/ory/exact/policies", nil))
+	forwarding := mux.NewRouter()
+	forwarding.HandleFunc("/engines/acp/ory/{flavor}/policies", func(rw http.ResponseWriter, r *http.Request) {}).Methods("PUT")
+	forwarding.Use(follower.Middleware(true))
+	forwarding.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/engines/acp/ory/exact/policies", nil))
+	if reasons[503] == "" || reasons[502] == "" {
+		t.Errorf("error writer wasn't used (%v)", reasons)
+	}
+}
@@ -0,0 +1,4 @@
+// Package sketopb is the gRPC API of sketo, generated from sketo.proto
+package sketopb
+
+//go:generate protoc --proto_path=.. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative sketopb/sketo.proto
@@ -0,0 +1,5 @@
+// Package extauthzpb is the part of Envoy's external authorization gRPC API
+// served by sketo, generated from external_auth.proto
+package extauthzpb
+
+//go:generate protoc --proto_path=../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative sketopb/extauthzpb/external_auth.proto
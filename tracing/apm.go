@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/module/apmgorilla"
+	"go.elastic.co/apm/module/apmhttp"
+)
+
+// apmTracer traces with Elastic APM, which reads both its own and the W3C
+// traceparent headers
+type apmTracer struct {
+	tracer *apm.Tracer
+}
+
+func newAPMTracer() (*apmTracer, error) {
+	tracer, err := apm.NewTracer(apm.DefaultTracer.Service.Name, apm.DefaultTracer.Service.Version)
+	if err != nil {
+		return nil, err
+	}
+	tracer.SetCaptureBody(apm.CaptureBodyAll)
+	return &apmTracer{
+		tracer: tracer,
+	}, nil
+}
+
+func (t *apmTracer) Middleware(ignore func(r *http.Request) bool) mux.MiddlewareFunc {
+	defaultIgnorer := apmhttp.DefaultServerRequestIgnorer()
+	return apmgorilla.Middleware(apmgorilla.WithTracer(t.tracer), apmgorilla.WithRequestIgnorer(func(r *http.Request) bool {
+		return ignore(r) || defaultIgnorer(r)
+	}))
+}
+
+type apmSpan struct {
+	span *apm.Span
+}
+
+func (s apmSpan) SetAttribute(key string, value interface{}) {
+	s.span.Context.SetLabel(key, value)
+}
+
+func (s apmSpan) End() {
+	s.span.End()
+}
+
+func (t *apmTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	span, ctx := apm.StartSpan(ctx, name, "app")
+	return ctx, apmSpan{
+		span: span,
+	}
+}
+
+func (t *apmTracer) SetRequestAttribute(ctx context.Context, key string, value interface{}) {
+	if tran := apm.TransactionFromContext(ctx); tran != nil {
+		tran.Context.SetLabel(key, value)
+	}
+}
+
+func (t *apmTracer) Shutdown(ctx context.Context) error {
+	t.tracer.Flush(ctx.Done())
+	t.tracer.Close()
+	return nil
+}
@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestOTelMiddlewareContinuesTraceContext(t *testing.T) {
+
+	recorder := tracetest.NewSpanRecorder()
+	tracer := newOTelTracerWithProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	router := mux.NewRouter()
+	router.Use(tracer.Middleware(func(r *http.Request) bool {
+		return r.URL.Path == "/ignored"
+	}))
+	router.HandleFunc("/items/{id}", func(rw http.ResponseWriter, r *http.Request) {
+		_, span := tracer.StartSpan(r.Context(), "store.get")
+		span.End()
+		tracer.SetRequestAttribute(r.Context(), "found", false)
+		rw.WriteHeader(404)
+	})
+	router.HandleFunc("/ignored", func(rw http.ResponseWriter, r *http.Request) {})
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/items/1", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ignored", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatal(fmt.Errorf("recorded %d spans instead of 2", len(spans)))
+	}
+	child, server := spans[0], spans[1]
+	if server.Name() != "GET /items/{id}" || server.SpanContext().TraceID().String() != traceID {
+		t.Error(fmt.Errorf("server span %s in trace %s", server.Name(), server.SpanContext().TraceID()))
+	}
+	if child.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error(fmt.Errorf("%s isn't a child of the request's span", child.Name()))
+	}
+	attributes := make(map[string]string)
+	for _, kv := range server.Attributes() {
+		attributes[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attributes["http.status_code"] != "404" || attributes["found"] != "false" {
+		t.Error(fmt.Errorf("unexpected server span attributes %v", attributes))
+	}
+
+}
@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Span is a traced unit of work
+type Span interface {
+	SetAttribute(key string, value interface{})
+	End()
+}
+
+// Tracer is a tracing backend
+type Tracer interface {
+	// Middleware traces the requests of a router that aren't ignored,
+	// continuing the trace context propagated by the caller
+	Middleware(ignore func(r *http.Request) bool) mux.MiddlewareFunc
+	// StartSpan starts a child of the span or request traced in ctx
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+	// SetRequestAttribute annotates the request traced in ctx
+	SetRequestAttribute(ctx context.Context, key string, value interface{})
+	// Shutdown sends the spans still buffered
+	Shutdown(ctx context.Context) error
+}
+
+var current Tracer = noopTracer{}
+
+// Init sets up a tracing backend: elastic (default, configured through the
+// ELASTIC_APM_* variables), otel (configured through the OTEL_* variables,
+// exporting with OTLP over HTTP) or none
+func Init(backend string) (Tracer, error) {
+	var err error
+	switch backend {
+	case "", "elastic":
+		current, err = newAPMTracer()
+	case "otel":
+		current, err = newOTelTracer()
+	case "none":
+		current = noopTracer{}
+	default:
+		return nil, fmt.Errorf("invalid tracing backend '%s' (expected elastic, otel or none)", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// StartSpan starts a span with the tracer set up by Init
+func StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	return current.StartSpan(ctx, name)
+}
+
+// SetRequestAttribute annotates a request with the tracer set up by Init
+func SetRequestAttribute(ctx context.Context, key string, value interface{}) {
+	current.SetRequestAttribute(ctx, key, value)
+}
+
+// Shutdown flushes the tracer set up by Init
+func Shutdown(ctx context.Context) error {
+	return current.Shutdown(ctx)
+}
+
+type noopSpan struct{}
+
+func (s noopSpan) SetAttribute(key string, value interface{}) {}
+func (s noopSpan) End()                                       {}
+
+type noopTracer struct{}
+
+func (t noopTracer) Middleware(ignore func(r *http.Request) bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return next
+	}
+}
+
+func (t noopTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (t noopTracer) SetRequestAttribute(ctx context.Context, key string, value interface{}) {}
+
+func (t noopTracer) Shutdown(ctx context.Context) error {
+	return nil
+}
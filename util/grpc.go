@@ -0,0 +1,81 @@
+package util
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/netutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+// grpcStopTimeout is how long calls in progress, such as watches, are given to
+// end when a gRPC server stops
+const grpcStopTimeout = 5 * time.Second
+
+// GRPCServerOptions returns the options of a gRPC server matching opts: TLS,
+// the idle timeout and the read header timeout, bounding the handshake. Read
+// and write timeouts don't apply to gRPC calls
+func GRPCServerOptions(opts ServerOptions) []grpc.ServerOption {
+	var serverOpts []grpc.ServerOption
+	if opts.TLSConfig != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(serverTLSConfig(opts.TLSConfig, "h2"))))
+	}
+	if opts.IdleTimeout > 0 {
+		serverOpts = append(serverOpts, grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle: opts.IdleTimeout,
+		}))
+	}
+	if opts.ReadHeaderTimeout > 0 {
+		serverOpts = append(serverOpts, grpc.ConnectionTimeout(opts.ReadHeaderTimeout))
+	}
+	return serverOpts
+}
+
+// ServeGRPC serves a named gRPC server on the address of opts until ctx is
+// done
+func ServeGRPC(ctx context.Context, wg *sync.WaitGroup, name string, srv *grpc.Server, opts ServerOptions) error {
+
+	ln, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return err
+	}
+	if opts.MaxConnections > 0 {
+		ln = netutil.LimitListener(ln, opts.MaxConnections)
+	}
+
+	wg.Add(1)
+	go func() {
+		if opts.TLSConfig != nil {
+			log.Printf("%s serving TLS on %s\n", name, opts.Addr)
+		} else {
+			log.Printf("%s serving on %s\n", name, opts.Addr)
+		}
+		err := srv.Serve(ln)
+		if err != nil {
+			log.Printf("%s ended with error: %v\n", name, err)
+		}
+		log.Printf("%s exited normally\n", name)
+	}()
+
+	go func() {
+		<-ctx.Done()
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(grpcStopTimeout):
+			srv.Stop()
+		}
+		wg.Done()
+	}()
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSOptions ..
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is the CA bundle client certificates are verified against
+	ClientCAFile string
+	// ClientAuth is none, request (verify certificates when given) or require
+	ClientAuth string
+}
+
+// Validate ..
+func (opts TLSOptions) Validate() error {
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return fmt.Errorf("TLS needs both a certificate and a key file")
+	}
+	switch opts.ClientAuth {
+	case "", "none":
+	case "request", "require":
+		if opts.CertFile == "" || opts.ClientCAFile == "" {
+			return fmt.Errorf("client certificate verification needs TLS and a client CA file")
+		}
+	default:
+		return fmt.Errorf("invalid client auth '%s' (expected none, request or require)", opts.ClientAuth)
+	}
+	return nil
+}
+
+// certReloadInterval is how often certificate files are checked for changes
+const certReloadInterval = 1 * time.Second
+
+// certLoader serves the certificate and client CAs of its files, loading them
+// again when they change on disk
+type certLoader struct {
+	opts TLSOptions
+
+	mu        sync.Mutex
+	checked   time.Time
+	modTimes  []time.Time
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func (l *certLoader) files() []string {
+	files := []string{l.opts.CertFile, l.opts.KeyFile}
+	if l.opts.ClientCAFile != "" {
+		files = append(files, l.opts.ClientCAFile)
+	}
+	return files
+}
+
+// load reads the files if they changed since they were last read
+func (l *certLoader) load() error {
+	modTimes := make([]time.Time, 0, 3)
+	changed := l.cert == nil
+	for i, file := range l.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes = append(modTimes, info.ModTime())
+		if i >= len(l.modTimes) || !info.ModTime().Equal(l.modTimes[i]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(l.opts.CertFile, l.opts.KeyFile)
+	if err != nil {
+		return err
+	}
+	var clientCAs *x509.CertPool
+	if l.opts.ClientCAFile != "" {
+		content, err := os.ReadFile(l.opts.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(content) {
+			return fmt.Errorf("no certificate in client CA file %s", l.opts.ClientCAFile)
+		}
+	}
+	l.cert, l.clientCAs, l.modTimes = &cert, clientCAs, modTimes
+	return nil
+}
+
+// current returns the certificate and client CAs, keeping the ones loaded last
+// when the files can't be read again
+func (l *certLoader) current() (*tls.Certificate, *x509.CertPool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.checked) >= certReloadInterval {
+		l.checked = time.Now()
+		err := l.load()
+		if err != nil {
+			log.Printf("Couldn't reload TLS certificate %s: %v\n", l.opts.CertFile, err)
+		}
+	}
+	return l.cert, l.clientCAs
+}
+
+// NewTLSConfig returns a TLS config serving the certificate of opts, which is
+// reloaded when its files change, or nil when opts has no certificate
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	err := opts.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if opts.CertFile == "" {
+		return nil, nil
+	}
+	l := &certLoader{
+		opts:    opts,
+		checked: time.Now(),
+	}
+	err = l.load()
+	if err != nil {
+		return nil, err
+	}
+	clientAuth := tls.NoClientCert
+	switch opts.ClientAuth {
+	case "request":
+		clientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: clientAuth,
+	}
+	base.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, _ := l.current()
+		return cert, nil
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		_, clientCAs := l.current()
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = clientCAs
+		return cfg, nil
+	}
+	return base, nil
+}
+
+// serverTLSConfig returns a copy of cfg offering protos over ALPN. Servers add
+// the protocols they serve to their own copy, which the config returned for
+// each client by NewTLSConfig doesn't see, so it's given protos too
+func serverTLSConfig(cfg *tls.Config, protos ...string) *tls.Config {
+	server := cfg.Clone()
+	server.NextProtos = protos
+	if forClient := cfg.GetConfigForClient; forClient != nil {
+		server.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			clientConfig, err := forClient(hello)
+			if clientConfig != nil {
+				clientConfig.NextProtos = server.NextProtos
+			}
+			return clientConfig, err
+		}
+	}
+	return server
+}
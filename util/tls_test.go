@@ -0,0 +1,190 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, server bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	signer, signerKey := tpl, key
+	if parent == nil {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+		tpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+		tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		if server {
+			tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+			tpl.DNSNames = []string{"localhost"}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) write(t *testing.T, certFile string, keyFile string) {
+	err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyFile != "" {
+		keyDER, _ := x509.MarshalECPrivateKey(c.key)
+		err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTLSConfigVerifiesClientsAndReloads(t *testing.T) {
+
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil, false)
+	opts := TLSOptions{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+		ClientAuth:   "require",
+	}
+	ca.write(t, opts.ClientCAFile, "")
+	newTestCert(t, "server-1", ca, true).write(t, opts.CertFile, opts.KeyFile)
+
+	tlsConfig, err := NewTLSConfig(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := newTestCert(t, "ops", ca, false)
+	get := func(withCert bool) (string, error) {
+		clientTLS := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+		if withCert {
+			clientTLS.Certificates = []tls.Certificate{{Certificate: [][]byte{client.der}, PrivateKey: client.key}}
+		}
+		conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), clientTLS)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		// TLS 1.3 reports a missing client certificate on the first read
+		_, err = conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		if err != nil {
+			return "", err
+		}
+		buf := make([]byte, 1)
+		_, err = conn.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	served, err := get(true)
+	if err != nil || served != "server-1" {
+		t.Fatal(fmt.Errorf("served %s (%v)", served, err))
+	}
+	_, err = get(false)
+	if err == nil {
+		t.Error(fmt.Errorf("a client without certificate was accepted"))
+	}
+
+	newTestCert(t, "server-2", ca, true).write(t, opts.CertFile, opts.KeyFile)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(opts.CertFile, future, future)
+	time.Sleep(certReloadInterval + 100*time.Millisecond)
+	served, err = get(true)
+	if err != nil || served != "server-2" {
+		t.Error(fmt.Errorf("served %s (%v) after the certificate changed", served, err))
+	}
+
+}
+
+func TestTLSListenersNegotiateHTTP2(t *testing.T) {
+
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil, false)
+	opts := TLSOptions{
+		CertFile: filepath.Join(dir, "server.crt"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+	}
+	newTestCert(t, "server", ca, true).write(t, opts.CertFile, opts.KeyFile)
+	tlsConfig, err := NewTLSConfig(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpSrv := newHTTPServer(http.NotFoundHandler(), ServerOptions{TLSConfig: tlsConfig})
+	go httpSrv.ServeTLS(httpLn, "", "")
+	defer httpSrv.Close()
+	grpcLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcSrv := grpc.NewServer(GRPCServerOptions(ServerOptions{TLSConfig: tlsConfig})...)
+	go grpcSrv.Serve(grpcLn)
+	defer grpcSrv.Stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	for name, addr := range map[string]string{"HTTPS": httpLn.Addr().String(), "gRPC": grpcLn.Addr().String()} {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, ServerName: "localhost", NextProtos: []string{"h2"}})
+		if err != nil {
+			t.Error(fmt.Errorf("%s handshake failed: %v", name, err))
+			continue
+		}
+		if protocol := conn.ConnectionState().NegotiatedProtocol; protocol != "h2" {
+			t.Error(fmt.Errorf("%s negotiated protocol '%s' instead of h2", name, protocol))
+		}
+		conn.Close()
+	}
+
+}
@@ -2,31 +2,77 @@ package util
 
 import (
 	"context"
+	"crypto/tls"
 	"log"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/net/netutil"
 )
 
+// ServerOptions ..
+type ServerOptions struct {
+	Addr string
+	// TLSConfig enables HTTPS when not nil
+	TLSConfig *tls.Config
+	// Timeouts are disabled when 0
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// MaxConnections limits the connections served at once when positive
+	MaxConnections int
+}
+
+// newHTTPServer returns a server of handler matching opts, offering HTTP/2
+// over TLS
+func newHTTPServer(handler http.Handler, opts ServerOptions) *http.Server {
+	srv := &http.Server{
+		Addr:              opts.Addr,
+		Handler:           handler,
+		ReadTimeout:       opts.ReadTimeout,
+		ReadHeaderTimeout: opts.ReadHeaderTimeout,
+		WriteTimeout:      opts.WriteTimeout,
+		IdleTimeout:       opts.IdleTimeout,
+	}
+	if opts.TLSConfig != nil {
+		srv.TLSConfig = serverTLSConfig(opts.TLSConfig, "h2", "http/1.1")
+	}
+	return srv
+}
+
 // StartHTTPServer starts a generic named HTTP Server
-func StartHTTPServer(ctx context.Context, wg *sync.WaitGroup, name string, addr string) *mux.Router {
+func StartHTTPServer(ctx context.Context, wg *sync.WaitGroup, name string, opts ServerOptions) (*mux.Router, error) {
 
 	gorillaMux := mux.NewRouter()
 
 	srvMux := http.NewServeMux()
 	srvMux.Handle("/", gorillaMux)
 
-	srv := &http.Server{
-		Addr:    addr,
-		Handler: srvMux,
+	srv := newHTTPServer(srvMux, opts)
+
+	ln, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxConnections > 0 {
+		ln = netutil.LimitListener(ln, opts.MaxConnections)
 	}
 
+	wg.Add(1)
 	go func() {
-		wg.Add(1)
-		log.Printf("%s serving on %s\n", name, addr)
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		var err error
+		if opts.TLSConfig != nil {
+			log.Printf("%s serving TLS on %s\n", name, opts.Addr)
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			log.Printf("%s serving on %s\n", name, opts.Addr)
+			err = srv.Serve(ln)
+		}
+		if err != http.ErrServerClosed {
 			log.Printf("%s ended with error: %v\n", name, err)
 		}
 		log.Printf("%s exited normally\n", name)
@@ -43,7 +89,48 @@ func StartHTTPServer(ctx context.Context, wg *sync.WaitGroup, name string, addr
 		wg.Done()
 	}()
 
-	time.Sleep(1 * time.Second)
+	return gorillaMux, nil
+}
+
+// StatusRecorder keeps the status code written through it; Unwrap lets
+// http.ResponseController reach the underlying writer
+type StatusRecorder struct {
+	http.ResponseWriter
+	Code int
+}
+
+// WriteHeader ..
+func (sr *StatusRecorder) WriteHeader(code int) {
+	if sr.Code == 0 {
+		sr.Code = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+// Write ..
+func (sr *StatusRecorder) Write(b []byte) (int, error) {
+	if sr.Code == 0 {
+		sr.Code = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
 
-	return gorillaMux
+// Flush ..
+func (sr *StatusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap ..
+func (sr *StatusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// Status returns the status code sent, 200 if nothing was written
+func (sr *StatusRecorder) Status() int {
+	if sr.Code == 0 {
+		return http.StatusOK
+	}
+	return sr.Code
 }